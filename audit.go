@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"map-generator/mapgen"
+)
+
+// generationAudit appends a line per completed /generate call to -audit-file;
+// nil when the flag is empty, which records nothing.
+var generationAudit *auditLog
+
+// auditFlushInterval bounds how long a record may sit in the buffer.
+const auditFlushInterval = time.Second
+
+// auditRecord is one line of the audit file: what was asked for, after presets
+// and config defaults, and what it produced. Image data is never recorded.
+type auditRecord struct {
+	Time       time.Time       `json:"time"`
+	Tenant     string          `json:"tenant,omitempty"`
+	Request    json.RawMessage `json:"request"`
+	Seed       int64           `json:"seed"`
+	Mode       string          `json:"mode"`
+	Width      int             `json:"width"`
+	Height     int             `json:"height"`
+	Format     string          `json:"format"`
+	Batches    int             `json:"batches"`
+	Placements int             `json:"placements"`
+	Saturation float64         `json:"saturation"`
+	Timing     string          `json:"timing"`
+	DurationMs float64         `json:"durationMs"`
+}
+
+// auditLog writes records as JSON lines through a buffer that is flushed
+// every auditFlushInterval. On SIGHUP the file is reopened by name, so log
+// rotation can move it away first. Write errors are logged and otherwise
+// ignored: auditing never fails a request.
+type auditLog struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+}
+
+// openAuditLog opens path for appending and starts the flush and reopen
+// loops.
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	go a.flushPeriodically()
+	go a.reopenOnSignal()
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	a.file, a.buf = f, bufio.NewWriter(f)
+	return nil
+}
+
+// record appends one generation, tagged with the tenant that asked for it if
+// any. It is a no-op on a nil log.
+func (a *auditLog) record(tenant string, req generateRequest, params mapgen.Params, result *mapgen.Result, stats *mapgen.Stats, start time.Time, duration time.Duration) {
+	if a == nil {
+		return
+	}
+	raw, err := canonicalJSON(req)
+	if err != nil {
+		log.Printf("audit: encode request: %v", err)
+		return
+	}
+	line, err := json.Marshal(auditRecord{
+		Time:       start.UTC(),
+		Tenant:     tenant,
+		Request:    raw,
+		Seed:       result.Seed,
+		Mode:       params.Mode,
+		Width:      result.Width,
+		Height:     result.Height,
+		Format:     params.Format,
+		Batches:    result.Batches,
+		Placements: result.TotalPlacements,
+		Saturation: result.Saturation,
+		Timing:     stats.Header(),
+		DurationMs: float64(duration.Microseconds()) / 1000,
+	})
+	if err != nil {
+		log.Printf("audit: encode record: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.buf == nil {
+		return
+	}
+	if _, err := a.buf.Write(append(line, '\n')); err != nil {
+		log.Printf("audit: write %s: %v", a.path, err)
+	}
+}
+
+// flush writes out buffered records.
+func (a *auditLog) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.buf == nil {
+		return
+	}
+	if err := a.buf.Flush(); err != nil {
+		log.Printf("audit: flush %s: %v", a.path, err)
+		// A failed bufio.Writer keeps its error; start over so later
+		// records are not lost to one bad write.
+		a.buf.Reset(a.file)
+	}
+}
+
+func (a *auditLog) flushPeriodically() {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.flush()
+	}
+}
+
+// reopen flushes and closes the current file and opens path afresh. When the
+// reopen fails the log stays closed and records are dropped until the next
+// SIGHUP succeeds.
+func (a *auditLog) reopen() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.buf != nil {
+		if err := a.buf.Flush(); err != nil {
+			log.Printf("audit: flush %s: %v", a.path, err)
+		}
+		a.file.Close()
+		a.file, a.buf = nil, nil
+	}
+	return a.open()
+}
+
+func (a *auditLog) reopenOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		if err := a.reopen(); err != nil {
+			log.Printf("audit file reopen failed, dropping records until the next SIGHUP: %v", err)
+			continue
+		}
+		log.Printf("audit file reopened at %s", a.path)
+	}
+}
+
+// close flushes and closes the file at shutdown. It is a no-op on a nil log.
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.buf == nil {
+		return
+	}
+	if err := a.buf.Flush(); err != nil {
+		log.Printf("audit: flush %s: %v", a.path, err)
+	}
+	a.file.Close()
+	a.file, a.buf = nil, nil
+}
+
+// readAuditRecords parses an audit file back into records, for tooling that
+// analyzes it. Blank lines are skipped; a malformed line fails with its line
+// number.
+func readAuditRecords(r io.Reader) ([]auditRecord, error) {
+	var records []auditRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("audit line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAuditLog opens an audit file in a temporary directory without the
+// flush and signal loops, installs it for the handlers and returns it.
+func testAuditLog(t *testing.T) *auditLog {
+	t.Helper()
+	a := &auditLog{path: filepath.Join(t.TempDir(), "audit.jsonl")}
+	if err := a.open(); err != nil {
+		t.Fatal(err)
+	}
+	generationAudit = a
+	t.Cleanup(func() {
+		generationAudit = nil
+		a.close()
+	})
+	return a
+}
+
+func readAuditFile(t *testing.T, path string) []auditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := readAuditRecords(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestAuditRoundTrip(t *testing.T) {
+	setupServer(t, Config{})
+	a := testAuditLog(t)
+	handler := withTenants(http.HandlerFunc(handleGenerate))
+	for _, body := range []string{
+		`{"w": 30, "h": 20, "tiles": "2x2*20", "seed": "one"}`,
+		`{"w": 40, "h": 10, "tiles": "1x1*30", "seed": "two", "mode": "adalar", "format": "json"}`,
+	} {
+		if w := postJSON(handler, "/generate", body, nil); w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+	}
+	a.flush()
+
+	records := readAuditFile(t, a.path)
+	if len(records) != 2 {
+		t.Fatalf("read %d records, want 2", len(records))
+	}
+	first, second := records[0], records[1]
+	if first.Mode != "merkez" || first.Width != 30 || first.Height != 20 || first.Format != "png" || first.Placements != 20 {
+		t.Errorf("first record %+v", first)
+	}
+	if second.Mode != "adalar" || second.Format != "json" || second.Placements != 30 {
+		t.Errorf("second record %+v", second)
+	}
+	var req struct {
+		Seed string `json:"seed"`
+	}
+	if err := json.Unmarshal(second.Request, &req); err != nil || req.Seed != "two" {
+		t.Errorf("second request %s, want seed two", second.Request)
+	}
+	if first.Seed == second.Seed || first.Time.IsZero() || first.Timing == "" {
+		t.Errorf("records lack their seed, time or timing: %+v", records)
+	}
+}
+
+// TestAuditReopen moves the file away as log rotation would and checks that
+// records before and after the reopen land in the old and new file.
+func TestAuditReopen(t *testing.T) {
+	setupServer(t, Config{})
+	a := testAuditLog(t)
+	body := `{"w": 20, "h": 20, "tiles": "1x1*10"}`
+	postJSON(http.HandlerFunc(handleGenerate), "/generate", body, nil)
+	rotated := a.path + ".1"
+	if err := os.Rename(a.path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.reopen(); err != nil {
+		t.Fatal(err)
+	}
+	postJSON(http.HandlerFunc(handleGenerate), "/generate", body, nil)
+	postJSON(http.HandlerFunc(handleGenerate), "/generate", body, nil)
+	a.flush()
+
+	if n := len(readAuditFile(t, rotated)); n != 1 {
+		t.Errorf("rotated file holds %d records, want 1", n)
+	}
+	if n := len(readAuditFile(t, a.path)); n != 2 {
+		t.Errorf("new file holds %d records, want 2", n)
+	}
+}
+
+func TestReadAuditRecordsReportsLine(t *testing.T) {
+	_, err := readAuditRecords(strings.NewReader("{\"mode\": \"merkez\"}\n\n{broken\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("err = %v, want it to name line 3", err)
+	}
+}
@@ -0,0 +1,254 @@
+// Command mapgen renders maps from the command line using the same parameters
+// as the HTTP API. Every JSON field of the request is available as a flag of
+// the same name, and -json reads a full request from stdin.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"map-generator/mapgen"
+)
+
+const (
+	exitOK         = 0
+	exitGeneration = 1
+	exitValidation = 2
+)
+
+// requestFlag records the raw value of a request field flag so it can be
+// applied on top of a request read from stdin.
+type requestFlag struct {
+	name  string
+	index int
+	raw   string
+}
+
+func (f *requestFlag) String() string { return f.raw }
+
+func (f *requestFlag) Set(raw string) error {
+	var probe mapgen.Request
+	if err := setRequestField(reflect.ValueOf(&probe).Elem().Field(f.index), raw); err != nil {
+		return err
+	}
+	f.raw = raw
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mapgen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	readJSON := fs.Bool("json", false, "read a JSON map request from stdin; field flags override it")
+	output := fs.String("o", "-", "output file, or - for stdout")
+	count := fs.Int("count", 1, "number of maps to generate; outputs are numbered and seeds derived")
+	chunk := fs.Int("chunk", 0, "render in chunks of this size, written as <o>-<col>-<row>.png, without holding the full map in memory")
+	replayPath := fs.String("replay", "", "paint a replay written by -format replay with the render options of the other flags instead of generating")
+	fieldFlags := registerRequestFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return exitValidation
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "mapgen: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return exitValidation
+	}
+	if *count < 1 {
+		fmt.Fprintln(stderr, "mapgen: -count must be at least 1")
+		return exitValidation
+	}
+	if *count > 1 && *output == "-" {
+		fmt.Fprintln(stderr, "mapgen: -count > 1 requires -o with a file name")
+		return exitValidation
+	}
+	if *chunk > 0 && *output == "-" {
+		fmt.Fprintln(stderr, "mapgen: -chunk requires -o with a file name")
+		return exitValidation
+	}
+
+	var replay *mapgen.Replay
+	if *replayPath != "" {
+		if *count > 1 || *chunk > 0 {
+			fmt.Fprintln(stderr, "mapgen: -replay cannot be combined with -count or -chunk")
+			return exitValidation
+		}
+		data, err := os.ReadFile(*replayPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "mapgen: %v\n", err)
+			return exitValidation
+		}
+		if replay, err = mapgen.ParseReplay(data); err != nil {
+			fmt.Fprintf(stderr, "mapgen: %v\n", err)
+			return exitValidation
+		}
+	}
+
+	var req mapgen.Request
+	if *readJSON {
+		decoder := json.NewDecoder(stdin)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			fmt.Fprintf(stderr, "mapgen: invalid JSON: %v\n", err)
+			return exitValidation
+		}
+	}
+
+	target := reflect.ValueOf(&req).Elem()
+	visited := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { visited[f.Name] = true })
+	for _, ff := range fieldFlags {
+		if !visited[ff.name] {
+			continue
+		}
+		if err := setRequestField(target.Field(ff.index), ff.raw); err != nil {
+			fmt.Fprintf(stderr, "mapgen: -%s: %v\n", ff.name, err)
+			return exitValidation
+		}
+	}
+
+	baseSeed, baseSeeds := req.Seed, req.Seeds
+	for i := 1; i <= *count; i++ {
+		if *count > 1 && len(baseSeeds) > 0 {
+			req.Seeds = append(append([]string(nil), baseSeeds...), strconv.Itoa(i))
+		} else if *count > 1 && baseSeed != "" {
+			req.Seed = fmt.Sprintf("%s-%d", baseSeed, i)
+		}
+
+		params, err := req.Normalize()
+		if err != nil {
+			fmt.Fprintf(stderr, "mapgen: %v\n", err)
+			return exitValidation
+		}
+
+		name := *output
+		if *count > 1 {
+			name = numberedPath(*output, i)
+		}
+
+		if *chunk > 0 {
+			if code := writeChunks(params, *chunk, name, stderr); code != exitOK {
+				return code
+			}
+			continue
+		}
+
+		start := time.Now()
+		var result mapgen.Result
+		if replay != nil {
+			result, err = mapgen.RenderReplay(replay, params, nil)
+		} else {
+			result, err = mapgen.Generate(params, nil)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "mapgen: %v\n", err)
+			return exitGeneration
+		}
+
+		if err := writeResult(&result, name, stdout); err != nil {
+			fmt.Fprintf(stderr, "mapgen: %v\n", err)
+			return exitGeneration
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(stderr, "mapgen: warning: %s\n", warning)
+		}
+
+		fmt.Fprintf(stderr, "%s: %dx%d mode=%s seed=%d placements=%d batches=%d duration=%s\n",
+			name, result.Width, result.Height, result.Mode, result.Seed, result.TotalPlacements, result.Batches, time.Since(start))
+	}
+
+	return exitOK
+}
+
+// registerRequestFlags adds one flag per JSON field of mapgen.Request so the
+// CLI stays in step with the API without a hand-maintained flag list.
+func registerRequestFlags(fs *flag.FlagSet) []*requestFlag {
+	t := reflect.TypeOf(mapgen.Request{})
+	flags := make([]*requestFlag, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		ff := &requestFlag{name: name, index: i}
+		fs.Var(ff, name, fmt.Sprintf("request field %q (%s)", name, t.Field(i).Type))
+		flags = append(flags, ff)
+	}
+	return flags
+}
+
+func setRequestField(field reflect.Value, raw string) error {
+	kind := field.Type()
+	if kind.Kind() == reflect.Pointer {
+		kind = kind.Elem()
+	}
+	if kind.Kind() == reflect.String {
+		encoded, err := json.Marshal(raw)
+		if err != nil {
+			return err
+		}
+		raw = string(encoded)
+	}
+	return json.Unmarshal([]byte(raw), field.Addr().Interface())
+}
+
+func numberedPath(path string, index int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), index, ext)
+}
+
+func writeResult(result *mapgen.Result, name string, stdout io.Writer) error {
+	if name == "-" {
+		_, err := result.WriteTo(stdout)
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := result.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return f.Close()
+}
+
+// writeChunks renders params chunk by chunk into <name>-<col>-<row> files.
+func writeChunks(params mapgen.Params, size int, name string, stderr io.Writer) int {
+	start := time.Now()
+	chunked, err := mapgen.NewChunkedMap(params, size)
+	if err != nil {
+		fmt.Fprintf(stderr, "mapgen: %v\n", err)
+		return exitValidation
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for row := 0; row < chunked.Rows; row++ {
+		for col := 0; col < chunked.Cols; col++ {
+			chunk, err := chunked.Render(col, row)
+			if err != nil {
+				fmt.Fprintf(stderr, "mapgen: %v\n", err)
+				return exitGeneration
+			}
+			path := fmt.Sprintf("%s-%d-%d%s", base, col, row, ext)
+			if err := os.WriteFile(path, chunk.Data, 0o644); err != nil {
+				fmt.Fprintf(stderr, "mapgen: %v\n", err)
+				return exitGeneration
+			}
+		}
+	}
+	fmt.Fprintf(stderr, "%s: %dx%d in %dx%d chunks mode=%s seed=%d placements=%d batches=%d duration=%s\n",
+		name, chunked.Width, chunked.Height, chunked.Cols, chunked.Rows, params.Mode, chunked.Seed, chunked.Placements, chunked.Batches, time.Since(start))
+	return exitOK
+}
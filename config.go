@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"map-generator/mapgen"
+)
+
+// Config holds the server settings that can change while the server runs.
+// Reloads swap in a whole new snapshot, and every handler loads the snapshot
+// once, so a request sees one consistent set of values even when a reload
+// lands halfway through it.
+type Config struct {
+	// SlowThreshold is the generation duration above which per-stage timings
+	// are logged. Zero disables slow-request logging.
+	SlowThreshold jsonDuration `json:"slowThreshold"`
+	// FilenameTemplate names /generate downloads that do not set a filename.
+	FilenameTemplate string `json:"filenameTemplate"`
+	// MaxWidth and MaxHeight cap the canvas of every request; zero is
+	// unlimited.
+	MaxWidth  int `json:"maxWidth"`
+	MaxHeight int `json:"maxHeight"`
+	// StatsCache is how many recent seeded generations keep their stats for
+	// GET /stats/{etag}; zero disables the sidecar.
+	StatsCache int `json:"statsCache"`
+	// RateLimit is the number of generations per second the server accepts
+	// across all clients, with bursts of up to RateBurst; zero is unlimited.
+	RateLimit float64 `json:"rateLimit"`
+	RateBurst int     `json:"rateBurst"`
+	// LandColor, PeakColor and WaterColor are the default palette used by
+	// requests that set no color and no autoPalette.
+	LandColor  string `json:"landColor,omitempty"`
+	PeakColor  string `json:"peakColor,omitempty"`
+	WaterColor string `json:"waterColor,omitempty"`
+	// Lenient skips unknown request fields with a warning instead of
+	// rejecting the request, for requests without a lenient query parameter.
+	Lenient bool `json:"lenient"`
+	// MaxPlacements caps the placements one generation may plan after cap
+	// and ka, a backstop against requests that would never finish; zero is
+	// unlimited.
+	MaxPlacements int `json:"maxPlacements"`
+	// GenerateTimeout caps how long a /generate call may run, and with it any
+	// budget the caller asks for; zero is unlimited.
+	GenerateTimeout jsonDuration `json:"generateTimeout"`
+	// Tenants maps tenant names to the limits of their requests, and
+	// TenantFallback names the profile of tenants missing from it; empty
+	// rejects them.
+	Tenants        map[string]TenantProfile `json:"tenants,omitempty"`
+	TenantFallback string                   `json:"tenantFallback,omitempty"`
+
+	// tenant is set on the per-request copies withTenants makes.
+	tenant *tenant
+}
+
+// jsonDuration is a time.Duration written as a string such as "2s".
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"2s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+// validate rejects settings the handlers could not apply.
+func (c *Config) validate() error {
+	switch {
+	case c.SlowThreshold < 0:
+		return errors.New("slowThreshold must not be negative")
+	case strings.TrimSpace(c.FilenameTemplate) == "":
+		return errors.New("filenameTemplate must not be empty")
+	case c.MaxWidth < 0 || c.MaxHeight < 0:
+		return errors.New("maxWidth and maxHeight must not be negative")
+	case c.MaxPlacements < 0:
+		return errors.New("maxPlacements must not be negative")
+	case c.StatsCache < 0:
+		return errors.New("statsCache must not be negative")
+	case !(c.RateLimit >= 0) || math.IsInf(c.RateLimit, 0):
+		return errors.New("rateLimit must be a non-negative number")
+	case c.RateBurst < 0:
+		return errors.New("rateBurst must not be negative")
+	case c.GenerateTimeout < 0:
+		return errors.New("generateTimeout must not be negative")
+	}
+	for name, t := range c.Tenants {
+		if !validTenantName(name) {
+			return fmt.Errorf("tenant %q: names are 1 to %d letters, digits, '.', '_' or '-'", name, maxTenantName)
+		}
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("tenant %q: %w", name, err)
+		}
+		c.Tenants[name] = t
+	}
+	if _, ok := c.Tenants[c.TenantFallback]; c.TenantFallback != "" && !ok {
+		return fmt.Errorf("tenantFallback %q is not one of the tenants", c.TenantFallback)
+	}
+	// The colors are checked by the same parser requests go through.
+	probe := mapgen.Request{W: 1, H: 1, Tiles: "1x1*1", LandColor: c.LandColor, PeakColor: c.PeakColor, WaterColor: c.WaterColor}
+	if _, err := probe.Normalize(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// applyDefaults fills the default palette into a request that picks no colors
+// of its own. It runs before the request is hashed for its ETag, so a palette
+// change also changes the tag.
+func (c *Config) applyDefaults(req *mapgen.Request) {
+	if req.AutoPalette != nil && *req.AutoPalette {
+		return
+	}
+	if req.LandColor != "" || req.PeakColor != "" || req.WaterColor != "" {
+		return
+	}
+	req.LandColor, req.PeakColor, req.WaterColor = c.LandColor, c.PeakColor, c.WaterColor
+}
+
+// applyLimits enforces MaxWidth and MaxHeight, then the tenant's limits, on a
+// normalized request, and hands MaxPlacements to the generator.
+func (c *Config) applyLimits(p *mapgen.Params) error {
+	p.MaxPlacements = c.MaxPlacements
+	if c.MaxWidth > 0 && p.Width > c.MaxWidth {
+		return fmt.Errorf("width %d exceeds the server limit of %d", p.Width, c.MaxWidth)
+	}
+	if c.MaxHeight > 0 && p.Height > c.MaxHeight {
+		return fmt.Errorf("height %d exceeds the server limit of %d", p.Height, c.MaxHeight)
+	}
+	return c.tenant.check(p)
+}
+
+// serverConfig is the live configuration; handlers read it through
+// currentConfig.
+var serverConfig atomic.Pointer[Config]
+
+func currentConfig() *Config {
+	return serverConfig.Load()
+}
+
+// configLoader rebuilds the configuration from the flag values and the -config
+// file. Reloads are serialized so two of them cannot race on the file.
+type configLoader struct {
+	mu   sync.Mutex
+	path string
+	base Config
+}
+
+// load reads the config file over the flag values and validates the result.
+// Fields missing from the file keep their flag values.
+func (l *configLoader) load() (*Config, error) {
+	cfg := l.base
+	if l.path == "" {
+		if err := cfg.validate(); err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		return &cfg, nil
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := decodeStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("config %s: %w", l.path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", l.path, err)
+	}
+	return &cfg, nil
+}
+
+// reload swaps in a freshly loaded configuration. An invalid file leaves the
+// running configuration in place.
+func (l *configLoader) reload() (*Config, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		return nil, errors.New("no config file to reload; start the server with -config")
+	}
+	cfg, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	serverConfig.Store(cfg)
+	return cfg, nil
+}
+
+// reloadOnSignal reloads the configuration on every SIGHUP.
+func (l *configLoader) reloadOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		if _, err := l.reload(); err != nil {
+			log.Printf("config reload rejected, keeping the running config: %v", err)
+			continue
+		}
+		log.Printf("config reloaded from %s", l.path)
+	}
+}
+
+// handleReload serves POST /admin/reload for callers presenting the admin
+// token as a bearer token, answering with the configuration now in effect.
+func (l *configLoader) handleReload(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		if !adminAuthorized(w, r, token) {
+			return
+		}
+		cfg, err := l.reload()
+		if err != nil {
+			log.Printf("config reload rejected, keeping the running config: %v", err)
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("config reloaded from %s via /admin/reload", l.path)
+		writeJSON(w, http.StatusOK, cfg)
+	}
+}
+
+// adminAuthorized reports whether r presents token as a bearer token,
+// answering 401 itself when it does not.
+func adminAuthorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid admin token"})
+		return false
+	}
+	return true
+}
+
+// rateLimiter is a token bucket shared by every generating endpoint. Its rate
+// and burst come from the request's config snapshot, so a reload takes effect
+// on the next request without resetting the bucket.
+type rateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+var generationLimiter rateLimiter
+
+// allow takes one token from a bucket refilling at rate per second with bursts
+// of up to burst, reporting whether the request may proceed.
+func (l *rateLimiter) allow(rate float64, burstSize int) bool {
+	if rate <= 0 {
+		return true
+	}
+	burst := float64(burstSize)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(rate))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	}
+	l.tokens = math.Min(l.tokens, burst)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// refund returns the token allow took, for a request a later check turned
+// away.
+func (l *rateLimiter) refund(rate float64) {
+	if rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+}
+
+// admit applies the tenant's rate limit and then the server's, answering 429
+// when the request must wait; a request the server turns away gets its
+// tenant token refunded. With tenants configured and no fallback, a request
+// naming no tenant is refused with 403.
+func admit(w http.ResponseWriter, cfg *Config) bool {
+	t := cfg.tenant
+	if t == nil && len(cfg.Tenants) > 0 {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("name a tenant with %s or a /t/{tenant}/ path prefix", tenantHeader)})
+		return false
+	}
+	if t != nil && !tenantLimiter(t.profile).allow(t.limits.RateLimit, t.limits.RateBurst) {
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": fmt.Sprintf("rate limit of tenant %q exceeded; retry later", t.name)})
+		return false
+	}
+	if generationLimiter.allow(cfg.RateLimit, cfg.RateBurst) {
+		return true
+	}
+	if t != nil {
+		tenantLimiter(t.profile).refund(t.limits.RateLimit)
+	}
+	w.Header().Set("Retry-After", "1")
+	writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "generation rate limit exceeded; retry later"})
+	return false
+}
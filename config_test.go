@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConfigSwapUnderLoad reloads between two configurations while requests
+// run, and checks that every request saw one whole snapshot: the small one
+// rejects the 100-pixel canvas, the large one accepts it and names the file
+// after itself. Run it with -race.
+func TestConfigSwapUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	configs := []string{
+		`{"maxWidth": 64, "filenameTemplate": "small"}`,
+		`{"maxWidth": 128, "filenameTemplate": "large"}`,
+	}
+	if err := os.WriteFile(path, []byte(configs[0]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setupServer(t, Config{})
+	loader := &configLoader{path: path, base: *currentConfig()}
+	if _, err := loader.reload(); err != nil {
+		t.Fatal(err)
+	}
+	reload := loader.handleReload("secret")
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// Writing under the loader's lock keeps a reload from reading a
+			// half-written file.
+			loader.mu.Lock()
+			err := os.WriteFile(path, []byte(configs[i%2]), 0o644)
+			loader.mu.Unlock()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if i%2 == 0 {
+				if _, err := loader.reload(); err != nil {
+					t.Error(err)
+					return
+				}
+			} else if w := postJSON(reload, "/admin/reload", "", admin); w.Code != http.StatusOK {
+				t.Errorf("reload: %d %s", w.Code, w.Body)
+				return
+			}
+		}
+	}()
+
+	var requests sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+			for i := 0; i < 20; i++ {
+				w := postJSON(http.HandlerFunc(handleGenerate), "/generate", `{"w": 100, "h": 20, "tiles": "1x1*50", "seed": "swap"}`, nil)
+				switch w.Code {
+				case http.StatusOK:
+					if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, "large") {
+						t.Errorf("accepted under the large limit but named %q", disposition)
+					}
+				case http.StatusBadRequest:
+					if body := w.Body.String(); !strings.Contains(body, "server limit of 64") {
+						t.Errorf("rejected with %s", body)
+					}
+				default:
+					t.Errorf("status %d: %s", w.Code, w.Body)
+				}
+			}
+		}()
+	}
+	requests.Wait()
+	close(stop)
+	wg.Wait()
+}
+
+func TestConfigReloadKeepsRunningConfigOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"maxWidth": 64}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setupServer(t, Config{})
+	loader := &configLoader{path: path, base: *currentConfig()}
+	if _, err := loader.reload(); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{`{"maxWidth": -1}`, `{"maxWidht": 10}`, `{`} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loader.reload(); err == nil {
+			t.Errorf("reload accepted %s", bad)
+		}
+		if got := currentConfig().MaxWidth; got != 64 {
+			t.Errorf("after rejecting %s, maxWidth = %d, want 64", bad, got)
+		}
+	}
+}
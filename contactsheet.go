@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// contactSheetRequest is a /contactsheet body: a base request and how many
+// seeds to try. Seeds are the decimal numbers firstSeed, firstSeed+1, and so
+// on, so any cell's label can be pasted back as the seed of a request.
+type contactSheetRequest struct {
+	Request   json.RawMessage `json:"request"`
+	Count     int             `json:"count"`
+	FirstSeed *int64          `json:"firstSeed"`
+	Columns   int             `json:"columns"`
+	CellSize  int             `json:"cellSize"`
+}
+
+// handleContactSheet renders the base request once per seed and composites
+// the thumbnails into one contact-sheet PNG labeled with each seed, for
+// picking a seed at a glance. It shares the limits of /sweep.
+func handleContactSheet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST with JSON body"})
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("read body: %v", err)})
+		return
+	}
+
+	cfg := requestConfig(r)
+	lenient, err := lenientRequest(r, cfg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	var req contactSheetRequest
+	warnings, err := decodeRequest(body, &req, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(fmt.Errorf("invalid JSON: %w", err)))
+		return
+	}
+	if !admit(w, cfg) {
+		return
+	}
+
+	start := time.Now()
+	cells, cols, seedWarnings, err := runContactSheet(cfg, req, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
+	}
+	cellSize := req.CellSize
+	if cellSize == 0 {
+		cellSize = defaultSweepCell
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, contactSheet(cells, cols, cellSize)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("encode png: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Sweep-Grid", fmt.Sprintf("%dx%d", cols, (len(cells)+cols-1)/cols))
+	setWarnings(w, appendWarnings(warnings, seedWarnings...))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("write response: %v", err)
+	}
+
+	log.Printf("contact sheet of %d seeds duration=%s%s", len(cells), time.Since(start), cfg.tenantTag())
+}
+
+// runContactSheet validates the sheet and generates the base request with
+// every seed, replacing its seed or seeds the way /diff does. Cells come in
+// seed order with the column count; columns default to a near-square grid.
+func runContactSheet(cfg *Config, req contactSheetRequest, lenient bool) ([]sweepCell, int, []string, error) {
+	switch {
+	case req.Count < 1 || req.Count > maxSweepVariants:
+		return nil, 0, nil, fmt.Errorf("count must be between 1 and %d", maxSweepVariants)
+	case req.Columns < 0:
+		return nil, 0, nil, errors.New("columns must be positive")
+	case req.CellSize < 0 || req.CellSize > maxSweepCell:
+		return nil, 0, nil, fmt.Errorf("cellSize must be between 1 and %d", maxSweepCell)
+	}
+	first := int64(1)
+	if req.FirstSeed != nil {
+		first = *req.FirstSeed
+	}
+	if first > math.MaxInt64-int64(req.Count-1) {
+		return nil, 0, nil, errors.New("firstSeed leaves no room for count seeds")
+	}
+	cols := req.Columns
+	if cols == 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(req.Count))))
+	}
+	cols = min(cols, req.Count)
+
+	base := map[string]json.RawMessage{}
+	if len(req.Request) > 0 {
+		if err := json.Unmarshal(req.Request, &base); err != nil {
+			return nil, 0, nil, fmt.Errorf("request: %v", err)
+		}
+	}
+	delete(base, "seeds")
+
+	var cells []sweepCell
+	var warnings []string
+	pixels := 0
+	for i := 0; i < req.Count; i++ {
+		seed := strconv.FormatInt(first+int64(i), 10)
+		base["seed"], _ = json.Marshal(seed)
+		img, _, seedWarnings, err := generateVariant(cfg, base, lenient, &pixels)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("seed %s: %w", seed, err)
+		}
+		warnings = appendWarnings(warnings, seedWarnings...)
+		cells = append(cells, sweepCell{img: img, label: []string{"seed=" + seed}})
+	}
+	return cells, cols, warnings, nil
+}
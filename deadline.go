@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"map-generator/mapgen"
+)
+
+// deadlineHeader carries a caller's budget for one request in milliseconds,
+// as gateways that split a deadline across downstream calls send it.
+const deadlineHeader = "X-Deadline-Ms"
+
+// generationDeadline is the budget of a /generate call: the smallest of the
+// X-Deadline-Ms header, the deadlineMs field and the generateTimeout of cfg,
+// leaving out those that are unset. Zero means no deadline.
+func generationDeadline(r *http.Request, fieldMs int, cfg *Config) (time.Duration, error) {
+	var budget time.Duration
+	tighten := func(d time.Duration) {
+		if budget == 0 || d < budget {
+			budget = d
+		}
+	}
+	if raw := strings.TrimSpace(r.Header.Get(deadlineHeader)); raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms <= 0 {
+			return 0, fmt.Errorf("%s must be a positive number of milliseconds, got %q", deadlineHeader, raw)
+		}
+		tighten(time.Duration(ms) * time.Millisecond)
+	}
+	if fieldMs < 0 {
+		return 0, errors.New("deadlineMs must be a positive number of milliseconds")
+	}
+	if fieldMs > 0 {
+		tighten(time.Duration(fieldMs) * time.Millisecond)
+	}
+	if cfg.GenerateTimeout > 0 {
+		tighten(time.Duration(cfg.GenerateTimeout))
+	}
+	return budget, nil
+}
+
+// deadlineError is the 503 body of a generation its deadline interrupted:
+// the stage that was running and the placements completed by then.
+func deadlineError(err *mapgen.DeadlineError, budget time.Duration) map[string]any {
+	code := "deadline_exceeded"
+	if !errors.Is(err, context.DeadlineExceeded) {
+		code = "canceled"
+	}
+	return map[string]any{
+		"error":      err.Error(),
+		"code":       code,
+		"stage":      err.Stage,
+		"placements": err.Placements,
+		"deadlineMs": budget.Milliseconds(),
+	}
+}
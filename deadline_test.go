@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowBody takes most of a second to generate without a deadline.
+const slowBody = `{"w": 2000, "h": 2000, "tiles": "5x5*500000,2x2*500000", "seed": "slow"%s}`
+
+func TestDeadlineAnswers503Quickly(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		header http.Header
+	}{
+		{"header", "", http.Header{deadlineHeader: {"1"}}},
+		{"field", `, "deadlineMs": 1`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupServer(t, Config{})
+			start := time.Now()
+			w := postJSON(http.HandlerFunc(handleGenerate), "/generate", fmt.Sprintf(slowBody, tt.field), tt.header)
+			elapsed := time.Since(start)
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status %d, want 503: %s", w.Code, w.Body)
+			}
+			if elapsed > 100*time.Millisecond {
+				t.Errorf("answered after %s, want well under the generation time", elapsed)
+			}
+			var body struct {
+				Code       string `json:"code"`
+				Stage      string `json:"stage"`
+				DeadlineMs int64  `json:"deadlineMs"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != "deadline_exceeded" || body.Stage == "" || body.DeadlineMs != 1 {
+				t.Errorf("body %s, want deadline_exceeded naming a stage and deadlineMs 1", w.Body)
+			}
+		})
+	}
+}
+
+func TestGenerationDeadlineTakesSmallest(t *testing.T) {
+	tests := []struct {
+		header  string
+		field   int
+		timeout time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, 0, 0, false},
+		{"50", 0, 0, 50 * time.Millisecond, false},
+		{"", 70, 0, 70 * time.Millisecond, false},
+		{"", 0, time.Second, time.Second, false},
+		{"50", 30, time.Second, 30 * time.Millisecond, false},
+		{"500", 900, 100 * time.Millisecond, 100 * time.Millisecond, false},
+		{"0", 0, 0, 0, true},
+		{"soon", 0, 0, 0, true},
+		{"", -1, 0, 0, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/generate", nil)
+		if tt.header != "" {
+			r.Header.Set(deadlineHeader, tt.header)
+		}
+		got, err := generationDeadline(r, tt.field, &Config{GenerateTimeout: jsonDuration(tt.timeout)})
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("header %q, field %d, timeout %s: got %s, %v; want %s, error %v", tt.header, tt.field, tt.timeout, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"map-generator/mapgen"
+)
+
+// diffRequest is a /diff body: either two requests a and b, or one request
+// and the two seeds to generate it with.
+type diffRequest struct {
+	A       json.RawMessage `json:"a"`
+	B       json.RawMessage `json:"b"`
+	Request json.RawMessage `json:"request"`
+	Seeds   []string        `json:"seeds"`
+}
+
+// handleDiff generates two maps and reports how similar their coverage is,
+// so variety tests can assert that different seeds differ.
+func handleDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST with JSON body"})
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("read body: %v", err)})
+		return
+	}
+
+	cfg := requestConfig(r)
+	lenient, err := lenientRequest(r, cfg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	var req diffRequest
+	warnings, err := decodeRequest(body, &req, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(fmt.Errorf("invalid JSON: %w", err)))
+		return
+	}
+
+	a, b, sideWarnings, err := diffParams(cfg, req, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
+	}
+	if !admit(w, cfg) {
+		return
+	}
+
+	start := time.Now()
+	similarity, err := mapgen.Compare(a, b)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	setWarnings(w, appendWarnings(warnings, sideWarnings...))
+	writeJSON(w, http.StatusOK, similarity)
+
+	log.Printf("diffed %dx%d maps seeds=%d,%d identical=%.4f duration=%s%s",
+		similarity.Width, similarity.Height, similarity.SeedA, similarity.SeedB, similarity.IdenticalFraction, time.Since(start), cfg.tenantTag())
+}
+
+// diffParams resolves the two sides of a diff. With seeds, each side is the
+// request with its seed replaced, the way /sweep replaces a swept field.
+// Lenient-mode warnings of both sides are returned once each.
+func diffParams(cfg *Config, req diffRequest, lenient bool) (mapgen.Params, mapgen.Params, []string, error) {
+	sides := [2]json.RawMessage{req.A, req.B}
+	switch {
+	case len(req.Request) > 0 || len(req.Seeds) > 0:
+		if len(req.A) > 0 || len(req.B) > 0 {
+			return mapgen.Params{}, mapgen.Params{}, nil, errors.New("use either a and b or request and seeds, not both")
+		}
+		if len(req.Seeds) != 2 {
+			return mapgen.Params{}, mapgen.Params{}, nil, errors.New("seeds must list exactly two seeds")
+		}
+		base := map[string]json.RawMessage{}
+		if len(req.Request) > 0 {
+			if err := json.Unmarshal(req.Request, &base); err != nil {
+				return mapgen.Params{}, mapgen.Params{}, nil, fmt.Errorf("request: %v", err)
+			}
+		}
+		delete(base, "seeds")
+		for i, seed := range req.Seeds {
+			base["seed"], _ = json.Marshal(seed)
+			raw, err := json.Marshal(base)
+			if err != nil {
+				return mapgen.Params{}, mapgen.Params{}, nil, err
+			}
+			sides[i] = raw
+		}
+	case len(req.A) == 0 || len(req.B) == 0:
+		return mapgen.Params{}, mapgen.Params{}, nil, errors.New("a and b, or request and seeds, are required")
+	}
+
+	var params [2]mapgen.Params
+	var warnings []string
+	for i, raw := range sides {
+		name := [2]string{"a", "b"}[i]
+		side, sideWarnings, err := presets.resolve(raw, lenient)
+		if err != nil {
+			return mapgen.Params{}, mapgen.Params{}, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		cfg.applyDefaults(&side.Request)
+		p, err := side.Normalize()
+		if err == nil {
+			err = cfg.applyLimits(&p)
+		}
+		if err != nil {
+			return mapgen.Params{}, mapgen.Params{}, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		params[i] = p
+		warnings = appendWarnings(warnings, sideWarnings...)
+	}
+	return params[0], params[1], warnings, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"map-generator/mapgen"
+)
+
+// maxFilenameLen keeps download names well inside common filesystem limits.
+const maxFilenameLen = 128
+
+// defaultFilenameTemplate names /generate downloads that do not set a filename
+// unless -filename-template or the config file picks another template.
+const defaultFilenameTemplate = "map_{mode}_{w}x{h}_{seed}"
+
+// extensions maps response content types to the download file extension.
+var extensions = map[string]string{
+	"image/png":        ".png",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"application/json": ".json",
+	"application/zip":  ".zip",
+	"application/gzip": ".replay.gz",
+	"text/csv":         ".csv",
+	"text/plain":       ".txt",
+
+	"application/vnd.mapgen.mask-rle":      ".rle",
+	"application/vnd.mapgen.mask-rle+gzip": ".rle.gz",
+}
+
+// contentDisposition builds the Content-Disposition header for a generated
+// map. name may use the {mode}, {w}, {h}, {seed} and {format} placeholders;
+// an empty name falls back to template. The extension, and {format},
+// follow the content type actually returned.
+func contentDisposition(name, template string, inline bool, result *mapgen.Result) string {
+	if strings.TrimSpace(name) == "" {
+		name = template
+	}
+	mediaType, _, _ := strings.Cut(result.ContentType, ";")
+	ext := extensions[mediaType]
+	name = strings.NewReplacer(
+		"{mode}", result.Mode,
+		"{w}", strconv.Itoa(result.Width),
+		"{h}", strconv.Itoa(result.Height),
+		"{seed}", strconv.FormatInt(result.Seed, 10),
+		"{format}", strings.TrimPrefix(ext, "."),
+	).Replace(name)
+
+	// A name written for another format ("world.png" on a GIF) loses its
+	// extension so the download is not named "world.png.gif".
+	for _, known := range extensions {
+		name = strings.TrimSuffix(name, known)
+	}
+	name = sanitizeFilename(name)
+	if name == "" {
+		name = "map"
+	}
+	if len(name)+len(ext) > maxFilenameLen {
+		name = name[:maxFilenameLen-len(ext)]
+	}
+
+	kind := "attachment"
+	if inline {
+		kind = "inline"
+	}
+	return fmt.Sprintf("%s; filename=%q", kind, name+ext)
+}
+
+// sanitizeFilename keeps letters, digits, '.', '-' and '_' and replaces
+// everything else with '_', so the name can neither break out of the header
+// nor carry a path. Leading dots are dropped to avoid hidden files.
+func sanitizeFilename(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return strings.TrimLeft(b.String(), ".")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unknownField is a top-level body field no request field matches, with the
+// known field it most likely meant.
+type unknownField struct {
+	Field      string `json:"field"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (f unknownField) message() string {
+	if f.Suggestion == "" {
+		return fmt.Sprintf("unknown field %q", f.Field)
+	}
+	return fmt.Sprintf("unknown field %q (did you mean %q?)", f.Field, f.Suggestion)
+}
+
+// unknownFieldsError rejects a strict body, listing every unknown field at
+// once rather than only the first one the decoder meets.
+type unknownFieldsError struct {
+	Fields []unknownField
+}
+
+func (e *unknownFieldsError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.message()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// lenientRequest reports whether r asked for lenient parsing with the lenient
+// query parameter, falling back to the lenient setting of cfg.
+func lenientRequest(r *http.Request, cfg *Config) (bool, error) {
+	raw := r.URL.Query().Get("lenient")
+	if raw == "" {
+		return cfg.Lenient, nil
+	}
+	lenient, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("lenient query parameter must be a boolean, got %q", raw)
+	}
+	return lenient, nil
+}
+
+// decodeRequest decodes a JSON body into v. Strict decoding rejects a body
+// with unknown top-level fields, naming all of them with the closest known
+// field; lenient decoding skips them and returns a warning for each. Field
+// names match case-insensitively in both modes, as encoding/json does. An
+// empty body leaves v untouched.
+func decodeRequest(data []byte, v any, lenient bool) ([]string, error) {
+	unknown := unknownFields(data, v)
+	if !lenient {
+		if len(unknown) > 0 {
+			return nil, &unknownFieldsError{Fields: unknown}
+		}
+		return nil, decodeStrict(data, v)
+	}
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	var warnings []string
+	for _, f := range unknown {
+		warnings = append(warnings, "ignored "+f.message())
+	}
+	return warnings, nil
+}
+
+// unknownFields lists, in sorted order, the keys of a JSON object that match
+// no json field of v. Bodies that are not objects yield nothing and are left
+// for the decoder to reject.
+func unknownFields(data []byte, v any) []unknownField {
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(data, &keys) != nil || len(keys) == 0 {
+		return nil
+	}
+	known := jsonFieldNames(reflect.TypeOf(v))
+	lower := map[string]bool{}
+	for _, name := range known {
+		lower[strings.ToLower(name)] = true
+	}
+
+	var unknown []unknownField
+	for key := range keys {
+		if !lower[strings.ToLower(key)] {
+			unknown = append(unknown, unknownField{Field: key, Suggestion: closestField(key, known)})
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Field < unknown[j].Field })
+	return unknown
+}
+
+// jsonFieldNames returns the JSON names of t's fields, following embedded
+// structs the way encoding/json flattens them.
+func jsonFieldNames(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			names = append(names, jsonFieldNames(field.Type)...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// closestField suggests the known name with the smallest edit distance to
+// key, ignoring case, when the distance is small enough for a typo: at most
+// two edits and fewer than half the key's length.
+func closestField(key string, known []string) string {
+	best, bestDist := "", 3
+	for _, name := range known {
+		d := editDistance(strings.ToLower(key), strings.ToLower(name))
+		if d < bestDist || (d == bestDist && best != "" && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	if best == "" || 2*bestDist >= len(key) {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and swaps of adjacent bytes each cost
+// one, so "moed" is one edit from "mode".
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// setWarnings reports lenient-mode and generation warnings in the X-Warnings
+// header.
+func setWarnings(w http.ResponseWriter, warnings []string) {
+	if len(warnings) > 0 {
+		w.Header().Set("X-Warnings", strings.Join(warnings, "; "))
+	}
+}
+
+// appendWarnings adds the warnings not already in list, keeping their order.
+func appendWarnings(list []string, warnings ...string) []string {
+	for _, warning := range warnings {
+		seen := false
+		for _, have := range list {
+			if have == warning {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			list = append(list, warning)
+		}
+	}
+	return list
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRequestStrictNamesEveryTypo(t *testing.T) {
+	var req generateRequest
+	_, err := decodeRequest([]byte(`{"w": 40, "tilse": "1x1*5", "sead": "x", "zzzzzzzzzz": 1}`), &req, false)
+	var fieldsErr *unknownFieldsError
+	if !errors.As(err, &fieldsErr) {
+		t.Fatalf("err = %v, want an unknownFieldsError", err)
+	}
+	want := map[string]string{"tilse": "tiles", "sead": "seed", "zzzzzzzzzz": ""}
+	if len(fieldsErr.Fields) != len(want) {
+		t.Fatalf("fields = %+v, want %d entries", fieldsErr.Fields, len(want))
+	}
+	for _, f := range fieldsErr.Fields {
+		suggestion, ok := want[f.Field]
+		if !ok || f.Suggestion != suggestion {
+			t.Errorf("field %q suggested %q, want %q", f.Field, f.Suggestion, suggestion)
+		}
+	}
+	if req.W != 0 {
+		t.Errorf("strict decoding filled w = %d from a rejected body", req.W)
+	}
+}
+
+func TestDecodeRequestLenientWarns(t *testing.T) {
+	var req generateRequest
+	warnings, err := decodeRequest([]byte(`{"W": 40, "tilse": "1x1*5"}`), &req, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.W != 40 {
+		t.Errorf("w = %d, want 40 (field names match case-insensitively)", req.W)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `"tilse"`) || !strings.Contains(warnings[0], `"tiles"`) {
+		t.Errorf("warnings = %q, want one naming tilse and tiles", warnings)
+	}
+}
+
+func TestGenerateTypoedField(t *testing.T) {
+	body := `{"w": 20, "h": 20, "tiles": "1x1*10", "seeed": "x"}`
+	handler := http.HandlerFunc(handleGenerate)
+
+	setupServer(t, Config{})
+	w := postJSON(handler, "/generate", body, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("strict: status %d, want 400", w.Code)
+	}
+	var resp struct {
+		Code   string         `json:"code"`
+		Fields []unknownField `json:"fields"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != "unknown_fields" || len(resp.Fields) != 1 || resp.Fields[0].Suggestion != "seed" {
+		t.Errorf("strict: body %s, want unknown_fields suggesting seed", w.Body)
+	}
+
+	w = postJSON(handler, "/generate?lenient=true", body, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("lenient query: status %d: %s", w.Code, w.Body)
+	}
+	if warnings := w.Header().Get("X-Warnings"); !strings.Contains(warnings, `"seeed"`) {
+		t.Errorf("lenient query: X-Warnings %q, want it to name seeed", warnings)
+	}
+
+	setupServer(t, Config{Lenient: true})
+	if w := postJSON(handler, "/generate", body, nil); w.Code != http.StatusOK {
+		t.Errorf("lenient config: status %d: %s", w.Code, w.Body)
+	}
+	if w := postJSON(handler, "/generate?lenient=false", body, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("lenient config, lenient=false: status %d, want 400", w.Code)
+	}
+	if w := postJSON(handler, "/generate?lenient=maybe", body, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("lenient=maybe: status %d, want 400", w.Code)
+	}
+}
@@ -1,1015 +1,651 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	_ "embed"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"image"
-	"image/color"
-	"image/draw"
-	"image/png"
 	"io"
 	"log"
-	"math"
-	"math/rand"
+	"mime/multipart"
 	"net/http"
-	"sort"
+	"net/http/pprof"
+	"net/textproto"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
-)
-
-type tileSpec struct {
-	W     int
-	H     int
-	Count float64
-}
 
-type tileBatch struct {
-	W     int
-	H     int
-	Count int
-}
+	"map-generator/mapgen"
+)
 
-type generator struct {
-	width            int
-	height           int
-	mode             string
-	rings            int
-	ringStartFrac    float64
-	ringEndFrac      float64
-	islands          int
-	islandRFrac      float64
-	rnd              *rand.Rand
-	islandCenters    []image.Point
-	continentCenters []image.Point
-	ringBoundaries   []float64
-	totalArea        float64
-	sumX             float64
-	sumY             float64
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
 }
 
-type mapRequest struct {
-	W           int      `json:"w"`
-	H           int      `json:"h"`
-	Tiles       string   `json:"tiles"`
-	Ka          *float64 `json:"ka"`
-	Cap         *int     `json:"cap"`
-	Mode        string   `json:"mode"`
-	Rings       *int     `json:"rings"`
-	RingStart   *float64 `json:"ringStart"`
-	RingEnd     *float64 `json:"ringEnd"`
-	Seed        string   `json:"seed"`
-	LogTone     *int     `json:"logTone"`
-	BrownCap    *int     `json:"brownCap"`
-	BgAlpha     *int     `json:"bgA"`
-	Islands     *int     `json:"islands"`
-	IslandRFrac *float64 `json:"islandRFrac"`
-	Rotate      *int     `json:"rot"`
-	N22         *int     `json:"n22"`
-	N21         *int     `json:"n21"`
-	N11         *int     `json:"n11"`
+// requestError is the JSON body of a rejected request. A malformed tiles
+// string also gets code "invalid_tiles" and every rejected segment, so forms
+// can highlight them all; unknown fields get code "unknown_fields" with the
+// closest known name of each.
+func requestError(err error) any {
+	var tilesErr *mapgen.TileListError
+	if errors.As(err, &tilesErr) {
+		return map[string]any{"error": err.Error(), "code": "invalid_tiles", "segments": tilesErr.Segments}
+	}
+	var fieldsErr *unknownFieldsError
+	if errors.As(err, &fieldsErr) {
+		return map[string]any{"error": err.Error(), "code": "unknown_fields", "fields": fieldsErr.Fields}
+	}
+	return map[string]string{"error": err.Error()}
 }
 
-type generationParams struct {
-	width       int
-	height      int
-	tileString  string
-	ka          float64
-	cap         int
-	mode        string
-	rings       int
-	ringStart   float64
-	ringEnd     float64
-	seed        string
-	logTone     bool
-	brownCap    int
-	bgAlpha     int
-	islands     int
-	islandRFrac float64
-	rotate      bool
-	n22         int
-	n21         int
-	n11         int
-}
+func handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST with JSON body"})
+		return
+	}
 
-type generationResult struct {
-	imageData       []byte
-	batches         int
-	totalPlacements int
-	seedValue       int64
-}
+	received := time.Now()
+	defer r.Body.Close()
 
-func newGenerator(width, height int, mode string, rings int, ringStartFrac, ringEndFrac float64, islands int, islandRFrac float64, rnd *rand.Rand) *generator {
-	g := &generator{
-		width:         width,
-		height:        height,
-		mode:          strings.ToLower(mode),
-		rings:         rings,
-		ringStartFrac: ringStartFrac,
-		ringEndFrac:   ringEndFrac,
-		islands:       islands,
-		islandRFrac:   islandRFrac,
-		rnd:           rnd,
-	}
-
-	switch g.mode {
-	case "adalar":
-		g.initIslands()
-	case "iki-kita":
-		g.initContinents()
-	case "merkez":
-		g.initMerkezRings()
-	}
-
-	return g
-}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("read body: %v", err)})
+		return
+	}
 
-func (g *generator) initIslands() {
-	count := g.islands
-	if count <= 0 {
-		count = 3
+	cfg := requestConfig(r)
+	lenient, err := lenientRequest(r, cfg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
-	g.islandCenters = make([]image.Point, 0, count)
-	minDim := float64(min(g.width, g.height))
-	margin := int(minDim * 0.1)
-	for i := 0; i < count; i++ {
-		x := margin + g.rnd.Intn(max(1, g.width-2*margin))
-		y := margin + g.rnd.Intn(max(1, g.height-2*margin))
-		g.islandCenters = append(g.islandCenters, image.Point{X: x, Y: y})
+	req, warnings, err := presets.resolve(body, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
 	}
-}
 
-func (g *generator) initContinents() {
-	g.continentCenters = []image.Point{
-		{X: g.width / 4, Y: g.height / 2},
-		{X: (3 * g.width) / 4, Y: g.height / 2},
+	cfg.applyDefaults(&req.Request)
+	params, err := req.Normalize()
+	if err == nil {
+		err = cfg.applyLimits(&params)
 	}
-}
-
-func (g *generator) initMerkezRings() {
-	start := clampFloat(g.ringStartFrac, 0, 1)
-	end := clampFloat(g.ringEndFrac, 0, 1)
-	if end <= start {
-		if end >= 1 {
-			start = clampFloat(end-0.1, 0, 1)
-		} else {
-			end = clampFloat(start+0.1, 0, 1)
-		}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
 	}
-
-	segments := max(1, g.rings)
-	g.ringStartFrac = start
-	g.ringEndFrac = end
-	g.ringBoundaries = make([]float64, segments+1)
-	g.ringBoundaries[0] = 0
-
-	if segments == 1 {
-		g.ringBoundaries[1] = clampFloat(end, 0, 1)
-		if g.ringBoundaries[1] < start {
-			g.ringBoundaries[1] = start
-		}
+	budget, err := generationDeadline(r, req.DeadlineMs, cfg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
-
-	span := end - start
-	if span <= 0 {
-		span = 0.1
-		end = clampFloat(start+span, start, 1)
+	if !admit(w, cfg) {
+		return
 	}
-	step := span / float64(segments-1)
 
-	prev := 0.0
-	for i := 1; i <= segments; i++ {
-		var val float64
-		if i == 1 {
-			val = start
-		} else if i == segments {
-			val = end
-		} else {
-			val = start + float64(i-1)*step
+	// The budget counts from the request's arrival, as the caller's does.
+	ctx := r.Context()
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, received.Add(budget))
+		defer cancel()
+	}
+	start := time.Now()
+	var stats mapgen.Stats
+	result, err := mapgen.GenerateContext(ctx, params, &stats)
+	if err != nil {
+		var deadlineErr *mapgen.DeadlineError
+		if errors.As(err, &deadlineErr) {
+			log.Printf("interrupted %dx%d map mode=%s stage=%s placements=%d budget=%s%s",
+				params.Width, params.Height, params.Mode, deadlineErr.Stage, deadlineErr.Placements, budget, cfg.tenantTag())
+			writeJSON(w, http.StatusServiceUnavailable, deadlineError(deadlineErr, budget))
+			return
+		}
+		var partialErr *mapgen.PartialError
+		if errors.As(err, &partialErr) {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error(), "code": "partial", "tiles": partialErr.Tiles, "warnings": partialErr.Warnings})
+			return
 		}
-		val = clampFloat(val, prev, 1)
-		g.ringBoundaries[i] = val
-		prev = val
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
-}
 
-func (g *generator) positionForTile(tw, th int) (int, int) {
-	if tw >= g.width || th >= g.height {
-		return 0, 0
+	if cfg.StatsCache > 0 {
+		if etag := statsETag(req); etag != "" {
+			generationStats.put(etag, &result, &stats, cfg.StatsCache)
+			w.Header().Set("ETag", `"`+etag+`"`)
+			w.Header().Set("Link", `</stats/`+etag+`>; rel="describedby"`)
+		}
 	}
+	setWarnings(w, appendWarnings(warnings, result.Warnings...))
+	signResult(w, &result, req.Request)
+	writeResult(w, cfg, &result, &stats, req.Filename, req.Inline)
 
-	switch g.mode {
-	case "merkez":
-		return g.positionMerkez(tw, th)
-	case "agirlik":
-		return g.positionAgirlik(tw, th)
-	case "adalar":
-		return g.positionAdalar(tw, th)
-	case "iki-kita":
-		return g.positionIkiKita(tw, th)
-	default:
-		return g.positionAgirlik(tw, th)
+	duration := time.Since(start)
+	log.Printf("generated %dx%d map mode=%s placements=%d batches=%d seed=%d duration=%s%s",
+		result.Width, result.Height, params.Mode, result.TotalPlacements, result.Batches, result.Seed, duration, cfg.tenantTag())
+	if slow := time.Duration(cfg.SlowThreshold); slow > 0 && duration > slow {
+		log.Printf("slow generation %dx%d mode=%s seed=%d duration=%s stages=%s%s",
+			result.Width, result.Height, params.Mode, result.Seed, duration, stats.Header(), cfg.tenantTag())
 	}
+	generationAudit.record(cfg.tenantName(), req, params, &result, &stats, start, duration)
 }
 
-func (g *generator) randomPlacement(tw, th int) (int, int) {
-	spanX := g.width - tw
-	spanY := g.height - th
-	if spanX < 0 {
-		spanX = 0
+// writeResult sends a generated or rendered map with its metadata headers.
+func writeResult(w http.ResponseWriter, cfg *Config, result *mapgen.Result, stats *mapgen.Stats, filename string, inline bool) {
+	w.Header().Set("Content-Type", result.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Tile-Batches", strconv.Itoa(result.Batches))
+	w.Header().Set("X-Tile-Count", strconv.Itoa(result.TotalPlacements))
+	w.Header().Set("X-Seed", strconv.FormatInt(result.Seed, 10))
+	w.Header().Set("X-Timing", stats.Header())
+	w.Header().Set("X-Content-SHA256", mapgen.ContentSHA256(result.Data))
+	w.Header().Set("X-Canvas-Size", fmt.Sprintf("%dx%d", result.Width, result.Height))
+	w.Header().Set("X-Palette", result.Palette.String())
+	w.Header().Set("X-Saturation", strconv.FormatFloat(result.Saturation, 'f', 4, 64))
+	if result.Preview {
+		w.Header().Set("X-Preview", "true")
 	}
-	if spanY < 0 {
-		spanY = 0
+	if result.Partial {
+		w.Header().Set("X-Partial", "true")
 	}
-
-	x := 0
-	y := 0
-	if spanX > 0 {
-		x = g.rnd.Intn(spanX + 1)
+	if len(result.ModeMix) > 0 {
+		w.Header().Set("X-Mode-Mix", modeMixHeader(result.ModeMix))
 	}
-	if spanY > 0 {
-		y = g.rnd.Intn(spanY + 1)
+	if len(result.Islands) > 0 {
+		w.Header().Set("X-Islands", islandsHeader(result.Islands))
 	}
-	return x, y
-}
-
-func (g *generator) selectMerkezSegment() (int, bool) {
-	segments := len(g.ringBoundaries) - 1
-	if segments <= 0 {
-		return -1, false
+	if b := result.LandBounds; !b.Empty() {
+		w.Header().Set("X-Land-Bounds", fmt.Sprintf("%d,%d,%d,%d", b.Min.X, b.Min.Y, b.Dx(), b.Dy()))
 	}
-
-	baseProbs := []float64{0.40, 0.20, 0.10, 0.05}
-	limit := min(segments, len(baseProbs))
-
-	totalAssigned := 0.0
-	r := g.rnd.Float64()
-	cumulative := 0.0
-	for i := 0; i < limit; i++ {
-		cumulative += baseProbs[i]
-		totalAssigned += baseProbs[i]
-		if r < cumulative {
-			return i, true
-		}
+	if !result.Crop.Empty() {
+		w.Header().Set("X-Crop-Offset", fmt.Sprintf("%d,%d", result.Crop.Min.X, result.Crop.Min.Y))
 	}
-
-	if totalAssigned >= 1.0 {
-		if segments > limit {
-			return segments - 1, true
-		}
-		if limit > 0 {
-			return limit - 1, true
-		}
-		return 0, true
+	if b := result.Balance; b != nil {
+		w.Header().Set("X-Land-Centroid", fmt.Sprintf("%.2f,%.2f", b.CentroidX, b.CentroidY))
+		w.Header().Set("X-Balance-Score", strconv.FormatFloat(b.Score, 'f', 4, 64))
 	}
-
-	return -1, false
-}
-
-func (g *generator) positionMerkez(tw, th int) (int, int) {
-	minDim := float64(min(g.width, g.height))
-	radiusMax := minDim / 2
-
-	for attempt := 0; attempt < 12; attempt++ {
-		segment, useRing := g.selectMerkezSegment()
-		if !useRing {
-			return g.randomPlacement(tw, th)
-		}
-		if segment < 0 || segment+1 >= len(g.ringBoundaries) {
-			continue
-		}
-
-		innerFrac := g.ringBoundaries[segment]
-		outerFrac := g.ringBoundaries[segment+1]
-		if outerFrac <= innerFrac {
-			continue
-		}
-
-		radiusFrac := innerFrac + g.rnd.Float64()*(outerFrac-innerFrac)
-		theta := g.rnd.Float64() * 2 * math.Pi
-		radius := radiusFrac * radiusMax
-		cx := float64(g.width)/2 + math.Cos(theta)*radius
-		cy := float64(g.height)/2 + math.Sin(theta)*radius
-		x := clampInt(int(math.Round(cx))-tw/2, 0, g.width-tw)
-		y := clampInt(int(math.Round(cy))-th/2, 0, g.height-th)
-		return x, y
+	if result.RequestedArea > 0 {
+		w.Header().Set("X-Requested-Area", strconv.Itoa(result.RequestedArea))
 	}
-
-	return g.randomPlacement(tw, th)
-}
-
-func (g *generator) positionAgirlik(tw, th int) (int, int) {
-	targetX := float64(g.width) / 2
-	targetY := float64(g.height) / 2
-
-	centerX := clampInt(int(math.Round(targetX))-tw/2, 0, g.width-tw)
-	centerY := clampInt(int(math.Round(targetY))-th/2, 0, g.height-th)
-	bestX := centerX
-	bestY := centerY
-	bestScore := g.distanceAfterPlacement(centerX, centerY, tw, th, targetX, targetY)
-
-	currentDist := math.Inf(1)
-	if cx, cy, ok := g.centerOfMass(); ok {
-		currentDist = math.Hypot(cx-targetX, cy-targetY)
-		mirrorCenterX := targetX*2 - cx
-		mirrorCenterY := targetY*2 - cy
-		mirrorX := clampInt(int(math.Round(mirrorCenterX))-tw/2, 0, g.width-tw)
-		mirrorY := clampInt(int(math.Round(mirrorCenterY))-th/2, 0, g.height-th)
-		mirrorScore := g.distanceAfterPlacement(mirrorX, mirrorY, tw, th, targetX, targetY)
-		if mirrorScore < bestScore {
-			bestScore = mirrorScore
-			bestX = mirrorX
-			bestY = mirrorY
-		}
+	if result.LandCells > 0 {
+		w.Header().Set("X-Land-Cells", strconv.Itoa(result.LandCells))
+		w.Header().Set("X-Overlap-Factor", strconv.FormatFloat(result.OverlapFactor, 'f', 4, 64))
 	}
-
-	attempts := 24
-	for attempt := 0; attempt < attempts; attempt++ {
-		x, y := g.randomPlacement(tw, th)
-		score := g.distanceAfterPlacement(x, y, tw, th, targetX, targetY)
-		if score < bestScore {
-			bestScore = score
-			bestX = x
-			bestY = y
-			if currentDist != math.Inf(1) && score <= currentDist*0.7 {
-				break
-			}
-		}
+	w.Header().Set("Content-Disposition", contentDisposition(filename, cfg.FilenameTemplate, inline, result))
+	w.WriteHeader(http.StatusOK)
+	if _, err := result.WriteTo(w); err != nil {
+		log.Printf("write response: %v", err)
 	}
-
-	return bestX, bestY
 }
 
-func (g *generator) recordPlacement(x, y, tw, th int) {
-	area := float64(tw * th)
-	if area <= 0 {
-		return
+// modeMixHeader formats per-mode placement counts as "merkez=700,adalar=300".
+func modeMixHeader(counts []mapgen.ModeCount) string {
+	parts := make([]string, len(counts))
+	for i, c := range counts {
+		parts[i] = fmt.Sprintf("%s=%d", c.Mode, c.Placements)
 	}
-	centerX := float64(x) + float64(tw)/2
-	centerY := float64(y) + float64(th)/2
-	g.totalArea += area
-	g.sumX += centerX * area
-	g.sumY += centerY * area
+	return strings.Join(parts, ",")
 }
 
-func (g *generator) centerOfMass() (float64, float64, bool) {
-	if g.totalArea <= 0 {
-		return 0, 0, false
+// islandsHeader formats per-island placement counts as "0=600,1=250,2=150".
+func islandsHeader(islands []mapgen.IslandShare) string {
+	parts := make([]string, len(islands))
+	for i, island := range islands {
+		parts[i] = fmt.Sprintf("%d=%d", island.Island, island.Placements)
 	}
-	return g.sumX / g.totalArea, g.sumY / g.totalArea, true
+	return strings.Join(parts, ",")
 }
 
-func (g *generator) distanceAfterPlacement(x, y, tw, th int, targetX, targetY float64) float64 {
-	area := float64(tw * th)
-	if area <= 0 {
-		if cx, cy, ok := g.centerOfMass(); ok {
-			return math.Hypot(cx-targetX, cy-targetY)
-		}
-		return 0
-	}
-	total := g.totalArea + area
-	tileCenterX := float64(x) + float64(tw)/2
-	tileCenterY := float64(y) + float64(th)/2
-	newCx := (g.sumX + tileCenterX*area) / total
-	newCy := (g.sumY + tileCenterY*area) / total
-	dx := newCx - targetX
-	dy := newCy - targetY
-	return math.Hypot(dx, dy)
+// renderRequest is a /render body: a replay recorded with format "replay"
+// plus the render options of a /generate request. Placement fields are
+// accepted but have no effect.
+type renderRequest struct {
+	mapgen.Request
+	Replay   []byte                   `json:"replay"`
+	Layout   []mapgen.LayoutPlacement `json:"layout"`
+	Filename string                   `json:"filename"`
+	Inline   bool                     `json:"inline"`
 }
 
-func (g *generator) positionAdalar(tw, th int) (int, int) {
-	if len(g.islandCenters) == 0 {
-		return g.positionMerkez(tw, th)
-	}
-	center := g.islandCenters[g.rnd.Intn(len(g.islandCenters))]
-	radiusFrac := g.islandRFrac
-	if radiusFrac <= 0 {
-		radiusFrac = 0.25
+// handleRender paints a replay, or a layout exported by format "json", without
+// running placement, so a saved layout can be re-colored or re-encoded later.
+func handleRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST with JSON body"})
+		return
 	}
-	maxRadius := radiusFrac * float64(min(g.width, g.height))
-	radius := g.rnd.Float64() * maxRadius
-	theta := g.rnd.Float64() * 2 * math.Pi
-
-	cx := float64(center.X) + math.Cos(theta)*radius
-	cy := float64(center.Y) + math.Sin(theta)*radius
-	x := clampInt(int(math.Round(cx))-tw/2, 0, g.width-tw)
-	y := clampInt(int(math.Round(cy))-th/2, 0, g.height-th)
-	return x, y
-}
 
-func (g *generator) positionIkiKita(tw, th int) (int, int) {
-	if len(g.continentCenters) == 0 {
-		return g.positionMerkez(tw, th)
-	}
-	center := g.continentCenters[g.rnd.Intn(len(g.continentCenters))]
-	sigmaX := float64(g.width) / 10
-	sigmaY := float64(g.height) / 6
-	for attempt := 0; attempt < 6; attempt++ {
-		x := int(math.Round(float64(center.X) + g.rnd.NormFloat64()*sigmaX))
-		y := int(math.Round(float64(center.Y) + g.rnd.NormFloat64()*sigmaY))
-		if x >= 0 && x <= g.width-tw && y >= 0 && y <= g.height-th {
-			return x, y
-		}
-	}
-	return g.positionMerkez(tw, th)
-}
+	defer r.Body.Close()
 
-func parseTileList(input string) ([]tileSpec, error) {
-	if strings.TrimSpace(input) == "" {
-		return []tileSpec{
-			{W: 2, H: 2, Count: 400},
-			{W: 2, H: 1, Count: 300},
-			{W: 1, H: 1, Count: 100},
-		}, nil
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("read body: %v", err)})
+		return
 	}
 
-	raw := strings.Split(input, ",")
-	specs := make([]tileSpec, 0, len(raw))
-
-	for _, part := range raw {
-		part = strings.TrimSpace(part)
-		if part == "" {
-			continue
-		}
-
-		dimCount := strings.SplitN(part, "*", 2)
-		dims := dimCount[0]
-		count := 1.0
-		if len(dimCount) == 2 {
-			clean := strings.TrimSpace(dimCount[1])
-			if clean != "" {
-				v, err := strconv.ParseFloat(clean, 64)
-				if err != nil {
-					return nil, fmt.Errorf("invalid tile count in %q: %w", part, err)
-				}
-				count = v
-			}
-		}
-
-		dParts := strings.SplitN(dims, "x", 2)
-		if len(dParts) != 2 {
-			return nil, fmt.Errorf("invalid tile dimensions in %q", part)
-		}
-
-		w, err := strconv.Atoi(strings.TrimSpace(dParts[0]))
-		if err != nil {
-			return nil, fmt.Errorf("invalid tile width in %q: %w", part, err)
-		}
-		h, err := strconv.Atoi(strings.TrimSpace(dParts[1]))
-		if err != nil {
-			return nil, fmt.Errorf("invalid tile height in %q: %w", part, err)
-		}
-		if w <= 0 || h <= 0 {
-			return nil, fmt.Errorf("tile dimensions must be positive in %q", part)
-		}
-		if count <= 0 {
-			continue
-		}
-
-		specs = append(specs, tileSpec{W: w, H: h, Count: count})
+	cfg := requestConfig(r)
+	lenient, err := lenientRequest(r, cfg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
-
-	if len(specs) == 0 {
-		return nil, errors.New("no valid tile definitions found")
+	var req renderRequest
+	warnings, err := decodeRequest(body, &req, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(fmt.Errorf("invalid JSON: %w", err)))
+		return
 	}
-
-	return specs, nil
-}
-
-func applyLegacyTiles(specs []tileSpec, n22, n21, n11 int) []tileSpec {
-	legacy := []struct {
-		W, H int
-		N    int
-	}{
-		{2, 2, n22},
-		{2, 1, n21},
-		{1, 1, n11},
+	if (len(req.Replay) == 0) == (req.Layout == nil) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "exactly one of replay and layout is required"})
+		return
 	}
 
-	for _, entry := range legacy {
-		if entry.N <= 0 {
-			continue
-		}
-		found := false
-		for i := range specs {
-			if specs[i].W == entry.W && specs[i].H == entry.H {
-				specs[i].Count += float64(entry.N)
-				found = true
-				break
-			}
-		}
-		if !found {
-			specs = append(specs, tileSpec{
-				W:     entry.W,
-				H:     entry.H,
-				Count: float64(entry.N),
-			})
-		}
+	cfg.applyDefaults(&req.Request)
+	params, err := req.Normalize()
+	if err == nil {
+		err = cfg.applyLimits(&params)
 	}
-
-	return specs
-}
-
-func activateMultiplier(specs []tileSpec, ka float64) {
-	if ka == 0 {
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
 		return
 	}
-	if ka < 0 {
-		ka = 0
+
+	var replay *mapgen.Replay
+	if req.Layout != nil {
+		replay, err = mapgen.LayoutReplay(params, req.Layout)
+	} else {
+		replay, err = mapgen.ParseReplay(req.Replay)
 	}
-	if ka == 1 {
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
-	for i := range specs {
-		specs[i].Count *= ka
-	}
-}
-
-func finalizeTileBatches(specs []tileSpec, capLimit int) []tileBatch {
-	type fractional struct {
-		index int
-		frac  float64
-	}
 
-	sumCounts := 0.0
-	for _, s := range specs {
-		sumCounts += s.Count
+	if !admit(w, cfg) {
+		return
 	}
 
-	if sumCounts == 0 {
-		return nil
+	start := time.Now()
+	var stats mapgen.Stats
+	result, err := mapgen.RenderReplay(replay, params, &stats)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
 
-	scale := 1.0
-	if capLimit > 0 && sumCounts > float64(capLimit) {
-		scale = float64(capLimit) / sumCounts
-	}
+	setWarnings(w, appendWarnings(warnings, result.Warnings...))
+	signResult(w, &result, req.Request)
+	writeResult(w, cfg, &result, &stats, req.Filename, req.Inline)
 
-	scaledTotals := make([]float64, len(specs))
-	floors := make([]int, len(specs))
-	fractions := make([]fractional, 0, len(specs))
-	totalFloors := 0
+	log.Printf("rendered %dx%d replay mode=%s placements=%d seed=%d duration=%s%s",
+		result.Width, result.Height, result.Mode, result.TotalPlacements, result.Seed, time.Since(start), cfg.tenantTag())
+}
 
-	for i, s := range specs {
-		adjusted := s.Count * scale
-		if adjusted <= 0 {
-			continue
-		}
-		scaledTotals[i] = adjusted
-		base := int(math.Floor(adjusted))
-		floors[i] = base
-		totalFloors += base
-
-		f := adjusted - float64(base)
-		if f > 0 {
-			fractions = append(fractions, fractional{index: i, frac: f})
-		}
+// handleChunks streams a map in size×size PNG chunks as a multipart/mixed
+// body, so canvases larger than memory never exist as a single image. Each
+// part is flushed as soon as it is encoded.
+func handleChunks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST with JSON body"})
+		return
 	}
 
-	targetTotal := 0
-	sumScaled := 0.0
-	for _, v := range scaledTotals {
-		sumScaled += v
-	}
+	defer r.Body.Close()
 
-	if capLimit > 0 {
-		if scale < 1 {
-			targetTotal = capLimit
-		} else {
-			targetTotal = min(capLimit, int(math.Round(sumScaled)))
-		}
-	} else {
-		targetTotal = int(math.Round(sumScaled))
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "size query parameter must be an integer chunk size"})
+		return
 	}
 
-	if targetTotal < totalFloors {
-		// Reduce counts from smallest fractional values first
-		sort.Slice(fractions, func(i, j int) bool {
-			if fractions[i].frac == fractions[j].frac {
-				return fractions[i].index < fractions[j].index
-			}
-			return fractions[i].frac < fractions[j].frac
-		})
-		diff := totalFloors - targetTotal
-		for k := 0; k < diff && k < len(fractions); k++ {
-			idx := fractions[k].index
-			if floors[idx] > 0 {
-				floors[idx]--
-			}
-		}
-		totalFloors -= min(diff, len(fractions))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("read body: %v", err)})
+		return
 	}
 
-	if totalFloors < targetTotal {
-		remaining := targetTotal - totalFloors
-		sort.Slice(fractions, func(i, j int) bool {
-			if fractions[i].frac == fractions[j].frac {
-				return fractions[i].index < fractions[j].index
-			}
-			return fractions[i].frac > fractions[j].frac
-		})
-		for k := 0; k < remaining && k < len(fractions); k++ {
-			floors[fractions[k].index]++
-		}
+	cfg := requestConfig(r)
+	lenient, err := lenientRequest(r, cfg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
-
-	batches := make([]tileBatch, 0, len(specs))
-	for i, s := range specs {
-		count := floors[i]
-		if count <= 0 {
-			continue
-		}
-		batches = append(batches, tileBatch{
-			W:     s.W,
-			H:     s.H,
-			Count: count,
-		})
+	req, warnings, err := presets.resolve(body, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
 	}
 
-	return batches
-}
-
-func coverageToColor(coverage int, brownCap int, logTone bool, green, brown color.RGBA) color.RGBA {
-	if coverage <= 0 {
-		return color.RGBA{R: 0, G: 0, B: 0, A: 0}
+	cfg.applyDefaults(&req.Request)
+	params, err := req.Normalize()
+	if err == nil {
+		err = cfg.applyLimits(&params)
 	}
-	if coverage == 1 {
-		return green
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
 	}
-
-	if brownCap <= 0 {
-		brownCap = 1
+	if !admit(w, cfg) {
+		return
 	}
 
-	var ratio float64
-	if logTone {
-		ratio = math.Log(float64(coverage)) / math.Log(float64(brownCap)+1)
-	} else {
-		ratio = float64(coverage-1) / float64(brownCap)
-	}
-	if ratio < 0 {
-		ratio = 0
-	}
-	if ratio > 1 {
-		ratio = 1
+	start := time.Now()
+	chunked, err := mapgen.NewChunkedMap(params, size)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
 
-	return blendColor(green, brown, ratio)
-}
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Tile-Batches", strconv.Itoa(chunked.Batches))
+	w.Header().Set("X-Tile-Count", strconv.Itoa(chunked.Placements))
+	w.Header().Set("X-Seed", strconv.FormatInt(chunked.Seed, 10))
+	w.Header().Set("X-Canvas-Size", fmt.Sprintf("%dx%d", chunked.Width, chunked.Height))
+	w.Header().Set("X-Chunk-Grid", fmt.Sprintf("%dx%d", chunked.Cols, chunked.Rows))
+	w.Header().Set("X-Palette", chunked.Palette.String())
+	setWarnings(w, warnings)
+	w.WriteHeader(http.StatusOK)
 
-func blendColor(a, b color.RGBA, t float64) color.RGBA {
-	clamp := func(v float64) uint8 {
-		if v < 0 {
-			return 0
-		}
-		if v > 255 {
-			return 255
+	flusher, _ := w.(http.Flusher)
+	for row := 0; row < chunked.Rows; row++ {
+		for col := 0; col < chunked.Cols; col++ {
+			if r.Context().Err() != nil {
+				log.Printf("chunk stream aborted by client at %d,%d", col, row)
+				return
+			}
+			chunk, err := chunked.Render(col, row)
+			if err != nil {
+				// Headers are gone; ending without the closing boundary
+				// tells the client the stream is incomplete.
+				log.Printf("render chunk %d,%d: %v", col, row, err)
+				return
+			}
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", "image/png")
+			header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chunk-%d-%d.png\"", col, row))
+			header.Set("X-Chunk-Bounds", fmt.Sprintf("%d,%d,%d,%d", chunk.X, chunk.Y, chunk.W, chunk.H))
+			part, err := mw.CreatePart(header)
+			if err == nil {
+				_, err = part.Write(chunk.Data)
+			}
+			if err != nil {
+				log.Printf("write chunk %d,%d: %v", col, row, err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
 		}
-		return uint8(math.Round(v))
 	}
-
-	ai := float64(a.A)
-	bi := float64(b.A)
-	alpha := (1-t)*ai + t*bi
-	if alpha == 0 {
-		alpha = 1
+	if err := mw.Close(); err != nil {
+		log.Printf("write chunk stream: %v", err)
 	}
 
-	return color.RGBA{
-		R: clamp((1-t)*float64(a.R) + t*float64(b.R)),
-		G: clamp((1-t)*float64(a.G) + t*float64(b.G)),
-		B: clamp((1-t)*float64(a.B) + t*float64(b.B)),
-		A: clamp(alpha),
-	}
+	log.Printf("streamed %dx%d map in %dx%d chunks of %d mode=%s placements=%d seed=%d duration=%s%s",
+		chunked.Width, chunked.Height, chunked.Cols, chunked.Rows, size, params.Mode, chunked.Placements, chunked.Seed, time.Since(start), cfg.tenantTag())
 }
 
-func seedFromString(seed string) int64 {
-	if seed == "" {
-		return time.Now().UnixNano()
-	}
-	h := int64(1469598103934665603)
-	const prime = 1099511628211
-	for i := 0; i < len(seed); i++ {
-		h ^= int64(seed[i])
-		h *= prime
-	}
-	return h
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func clampInt(v, minVal, maxVal int) int {
-	if v < minVal {
-		return minVal
+// handleReady runs a tiny real generation so readiness probes fail when the
+// renderer is broken even though the process is still up.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	probe := mapgen.Request{W: 10, H: 10, Tiles: "2x2*10,1x1*10", Seed: "readiness"}
+	params, err := probe.Normalize()
+	if err == nil {
+		_, err = mapgen.Generate(params, nil)
 	}
-	if v > maxVal {
-		return maxVal
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+		return
 	}
-	return v
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
+// presets holds the user-saved parameter presets referenced by /generate.
+var presets *presetStore
 
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
+//go:embed playground.html
+var playgroundHTML []byte
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": "POST a JSON payload to /generate to receive a PNG map",
+	})
 }
 
-func clampFloat(v, minVal, maxVal float64) float64 {
-	if v < minVal {
-		return minVal
+// handlePlayground serves the embedded HTML form at / and falls back to the
+// JSON index for API clients that ask for JSON.
+func handlePlayground(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		handleIndex(w, r)
+		return
 	}
-	if v > maxVal {
-		return maxVal
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(playgroundHTML); err != nil {
+		log.Printf("write playground: %v", err)
 	}
-	return v
 }
 
-func (req *mapRequest) normalize() (generationParams, error) {
-	p := generationParams{
-		width:      req.W,
-		height:     req.H,
-		tileString: req.Tiles,
-		mode:       req.Mode,
-		seed:       req.Seed,
-	}
-
-	if p.width <= 0 {
-		if req.W == 0 {
-			p.width = 100
-		} else {
-			return generationParams{}, fmt.Errorf("width must be positive")
+func main() {
+	playground := flag.Bool("playground", true, "serve the built-in web playground at /")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to drain in-flight requests on SIGINT/SIGTERM")
+	enablePprof := flag.Bool("pprof", false, "expose net/http/pprof under /debug/pprof/ (trusted environments only)")
+	slowThreshold := flag.Duration("slow-threshold", 2*time.Second, "log per-stage timings for generations slower than this (0 disables)")
+	presetsFile := flag.String("presets-file", "", "JSON file persisting saved presets (empty keeps them in memory)")
+	maxPresets := flag.Int("max-presets", 100, "maximum number of saved presets (0 means unlimited)")
+	seedsFile := flag.String("seeds-file", "", "JSON file persisting the /seeds catalog (empty keeps it in memory)")
+	maxSeeds := flag.Int("max-seeds", 1000, "maximum number of catalog seeds (0 means unlimited)")
+	corsOrigin := flag.String("cors-origin", "", "allow browser requests from these origins (comma-separated, or *); empty disables CORS")
+	filenameTemplate := flag.String("filename-template", defaultFilenameTemplate, "default download name for /generate; {mode}, {w}, {h}, {seed} and {format} are replaced and the extension follows the format")
+	statsCache := flag.Int("stats-cache", 256, "stats of this many recent seeded generations are kept for GET /stats/{etag} (0 disables)")
+	lenient := flag.Bool("lenient", false, "ignore unknown request fields with an X-Warnings header instead of rejecting the request; ?lenient= overrides it per request")
+	configFile := flag.String("config", "", "JSON file of runtime settings layered over the flags; re-read on SIGHUP and POST /admin/reload")
+	adminToken := flag.String("admin-token", "", "bearer token for POST /admin/reload and /admin/selftest (empty disables both), also required to record or delete /seeds entries")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file; with -tls-key serves HTTPS, re-read on SIGHUP")
+	tlsKey := flag.String("tls-key", "", "PEM private key file of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM CA bundle enabling mutual TLS: client certificates are verified against it")
+	tlsClientAuth := flag.String("tls-client-auth", clientAuthRequire, "with -tls-client-ca, \"require\" a client certificate or accept clients without one (\"optional\")")
+	maxPlacements := flag.Int("max-placements", 5_000_000, "reject generations planning more placements than this after cap and ka with 400 (0 is unlimited)")
+	generateTimeout := flag.Duration("generate-timeout", 0, "longest a /generate call may run before it answers 503; caps X-Deadline-Ms and deadlineMs (0 is unlimited)")
+	signingKeyFile := flag.String("signing-key-file", "", "file holding the HMAC key that signs /generate and /render output in X-Signature (default $"+signingKeyEnv+"; unset disables signing)")
+	tenantFallback := flag.String("tenant-fallback", "", "tenants profile that requests from tenants missing from the config's tenants run under (empty rejects them with 403)")
+	auditFile := flag.String("audit-file", "", "append a JSON line per completed /generate call to this file (no image data); reopened on SIGHUP for log rotation")
+	check := flag.Bool("check", false, "run the self-test battery against the embedded output hashes, print a summary and exit 0 or 1 instead of serving")
+	checkWrite := flag.String("check-write", "", "regenerate the self-test battery and write its hashes to this file, then exit (used by go generate)")
+	flag.Parse()
+
+	if *checkWrite != "" {
+		if err := writeSelfTestHashes(*checkWrite); err != nil {
+			log.Fatalf("%v", err)
 		}
+		return
 	}
-	if p.height <= 0 {
-		if req.H == 0 {
-			p.height = 100
-		} else {
-			return generationParams{}, fmt.Errorf("height must be positive")
-		}
+	if *check {
+		os.Exit(runCheck(os.Stdout))
 	}
 
-	if req.Ka != nil {
-		p.ka = *req.Ka
-	} else {
-		p.ka = 1.0
+	tlsConfig, certs, err := newTLSConfig(*tlsCert, *tlsKey, *tlsClientCA, *tlsClientAuth)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if certs != nil {
+		go certs.reloadOnSignal()
+	}
+
+	loader := &configLoader{path: *configFile, base: Config{
+		SlowThreshold:    jsonDuration(*slowThreshold),
+		FilenameTemplate: *filenameTemplate,
+		StatsCache:       *statsCache,
+		Lenient:          *lenient,
+		MaxPlacements:    *maxPlacements,
+		GenerateTimeout:  jsonDuration(*generateTimeout),
+		TenantFallback:   *tenantFallback,
+	}}
+	cfg, err := loader.load()
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
-
-	if req.Cap != nil {
-		p.cap = *req.Cap
-		if p.cap < 0 {
-			p.cap = 0
-		}
-	} else {
-		p.cap = 0
+	serverConfig.Store(cfg)
+	if *configFile != "" {
+		go loader.reloadOnSignal()
 	}
 
-	if strings.TrimSpace(p.mode) == "" {
-		p.mode = "merkez"
+	store, err := newPresetStore(*presetsFile, *maxPresets)
+	if err != nil {
+		log.Fatalf("presets: %v", err)
 	}
-	p.mode = strings.ToLower(p.mode)
-	switch p.mode {
-	case "merkez", "agirlik", "adalar", "iki-kita":
-	default:
-		return generationParams{}, fmt.Errorf("unsupported mode %q", p.mode)
+	presets = store
+	if seedCatalog, err = newSeedStore(*seedsFile, *maxSeeds); err != nil {
+		log.Fatalf("seeds: %v", err)
 	}
+	seedCatalog.adminToken = *adminToken
 
-	if req.Rings != nil {
-		p.rings = *req.Rings
-	} else {
-		p.rings = 10
-	}
-	if p.rings <= 0 {
-		p.rings = 10
+	if signingKey, err = loadSigningKey(*signingKeyFile); err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	if req.RingStart != nil {
-		p.ringStart = clampFloat(*req.RingStart, 0, 1)
-	} else {
-		p.ringStart = 0.1
-	}
-	if req.RingEnd != nil {
-		p.ringEnd = clampFloat(*req.RingEnd, 0, 1)
-	} else {
-		p.ringEnd = 0.8
-	}
-	if p.ringEnd <= p.ringStart {
-		adjustedEnd := clampFloat(p.ringStart+0.05, p.ringStart, 1)
-		if adjustedEnd == p.ringStart {
-			return generationParams{}, fmt.Errorf("ringEnd must be greater than ringStart")
+	if *auditFile != "" {
+		audit, err := openAuditLog(*auditFile)
+		if err != nil {
+			log.Fatalf("%v", err)
 		}
-		p.ringEnd = adjustedEnd
-	}
-
-	if req.LogTone != nil {
-		p.logTone = *req.LogTone != 0
-	} else {
-		p.logTone = true
-	}
-
-	if req.BrownCap != nil {
-		p.brownCap = *req.BrownCap
-	} else {
-		p.brownCap = 8
-	}
-	if p.brownCap < 1 {
-		p.brownCap = 1
-	}
-
-	if req.BgAlpha != nil {
-		p.bgAlpha = *req.BgAlpha
-	} else {
-		p.bgAlpha = 0
+		generationAudit = audit
 	}
 
-	if req.Islands != nil {
-		p.islands = *req.Islands
+	mux := http.NewServeMux()
+	if *playground {
+		mux.HandleFunc("/", handlePlayground)
 	} else {
-		p.islands = 4
+		mux.HandleFunc("/", handleIndex)
 	}
-
-	if req.IslandRFrac != nil {
-		p.islandRFrac = *req.IslandRFrac
-	} else {
-		p.islandRFrac = 0.25
+	mux.HandleFunc("/api", handleIndex)
+	mux.HandleFunc("/generate", handleGenerate)
+	mux.HandleFunc("/chunks", handleChunks)
+	mux.HandleFunc("/render", handleRender)
+	mux.HandleFunc("/sweep", handleSweep)
+	mux.HandleFunc("/contactsheet", handleContactSheet)
+	mux.HandleFunc("/diff", handleDiff)
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/ready", handleReady)
+	mux.HandleFunc("/presets", presets.handle)
+	mux.HandleFunc("/seeds", seedCatalog.handle)
+	mux.HandleFunc("/seeds/", seedCatalog.handleEntry)
+	generationStats = newStatsStore()
+	mux.HandleFunc("/stats/", generationStats.handle)
+	if *adminToken != "" {
+		mux.HandleFunc("/admin/reload", loader.handleReload(*adminToken))
+		mux.HandleFunc("/admin/selftest", handleSelfTest(*adminToken))
 	}
-	if p.islandRFrac <= 0 {
-		p.islandRFrac = 0.25
+	if *enablePprof {
+		registerPprof(mux)
+		log.Printf("pprof profiling endpoints enabled under /debug/pprof/")
 	}
 
-	if req.Rotate != nil {
-		p.rotate = *req.Rotate != 0
+	addr := "127.0.0.1:8080"
+	var handler http.Handler = withTenants(mux)
+	if *corsOrigin != "" {
+		handler = withCORS(*corsOrigin, handler)
+		log.Printf("CORS enabled for origin %s", *corsOrigin)
+	}
+	srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+	done := make(chan struct{})
+	go shutdownOnSignal(srv, *shutdownTimeout, done)
+
+	if tlsConfig != nil {
+		log.Printf("map generator server listening on https://%s", addr)
+		err = srv.ListenAndServeTLS("", "")
 	} else {
-		p.rotate = true
-	}
-
-	if req.N22 != nil {
-		p.n22 = *req.N22
-	}
-	if req.N21 != nil {
-		p.n21 = *req.N21
+		log.Printf("map generator server listening on http://%s", addr)
+		err = srv.ListenAndServe()
 	}
-	if req.N11 != nil {
-		p.n11 = *req.N11
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("server error: %v", err)
 	}
-
-	return p, nil
+	<-done
+	generationAudit.close()
 }
 
-func generateMap(p generationParams) (generationResult, error) {
-	specs, err := parseTileList(p.tileString)
-	if err != nil {
-		return generationResult{}, err
-	}
-
-	specs = applyLegacyTiles(specs, p.n22, p.n21, p.n11)
-	activateMultiplier(specs, p.ka)
-	batches := finalizeTileBatches(specs, p.cap)
-	if len(batches) == 0 {
-		return generationResult{}, fmt.Errorf("no tiles to place after cap adjustment")
-	}
-
-	seed := seedFromString(p.seed)
-	rnd := rand.New(rand.NewSource(seed))
-	gen := newGenerator(p.width, p.height, p.mode, p.rings, p.ringStart, p.ringEnd, p.islands, p.islandRFrac, rnd)
-
-	img := image.NewRGBA(image.Rect(0, 0, p.width, p.height))
-	bgAlphaClamped := clampInt(p.bgAlpha, 0, 255)
-	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{0, 0, 0, uint8(bgAlphaClamped)}}, image.Point{}, draw.Src)
+// exposedHeaders are the response headers browser scripts may read under
+// CORS; without them fetch() only sees the basic safelisted headers.
+const exposedHeaders = "Content-Disposition, X-Tile-Batches, X-Tile-Count, X-Seed, X-Timing, X-Canvas-Size, X-Chunk-Grid, X-Palette, X-Saturation, X-Mode-Mix, X-Islands, X-Preview, X-Partial, X-Sweep-Grid, X-Warnings, X-Land-Bounds, X-Land-Centroid, X-Balance-Score, X-Crop-Offset, X-Requested-Area, X-Land-Cells, X-Overlap-Factor, X-Content-SHA256, X-Signature, X-Signed-Params, ETag, Link"
 
-	coverage := make([]int, p.width*p.height)
-	totalPlacements := 0
-
-	for _, batch := range batches {
-		totalPlacements += batch.Count
-		for i := 0; i < batch.Count; i++ {
-			tw, th := batch.W, batch.H
-			if p.rotate && tw != th && rnd.Intn(2) == 0 {
-				tw, th = th, tw
-			}
-			if tw <= 0 || th <= 0 || tw > p.width || th > p.height {
-				continue
-			}
-			x, y := gen.positionForTile(tw, th)
-			gen.recordPlacement(x, y, tw, th)
-			for yy := y; yy < y+th; yy++ {
-				rowOffset := yy * p.width
-				for xx := x; xx < x+tw; xx++ {
-					idx := rowOffset + xx
-					if idx >= 0 && idx < len(coverage) {
-						coverage[idx]++
-					}
-				}
-			}
+// withCORS answers preflight requests and adds CORS headers for requests from
+// an allowed origin. origins is "*" or a comma-separated list of origins.
+func withCORS(origins string, next http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			allowed[o] = true
 		}
 	}
 
-	green := color.RGBA{R: 34, G: 139, B: 34, A: 255}
-	brown := color.RGBA{R: 139, G: 69, B: 19, A: 255}
-
-	for y := 0; y < p.height; y++ {
-		for x := 0; x < p.width; x++ {
-			idx := y*p.width + x
-			c := coverage[idx]
-			if c <= 0 {
-				continue
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (allowed["*"] || allowed[origin]) {
+			h := w.Header()
+			if allowed["*"] {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Add("Vary", "Origin")
+			}
+			h.Set("Access-Control-Expose-Headers", exposedHeaders)
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+				h.Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-Deadline-Ms, X-Tenant")
+				h.Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
 			}
-			col := coverageToColor(c, p.brownCap, p.logTone, green, brown)
-			img.Set(x, y, col)
 		}
-	}
-
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, img); err != nil {
-		return generationResult{}, fmt.Errorf("encode png: %w", err)
-	}
-
-	return generationResult{
-		imageData:       buf.Bytes(),
-		batches:         len(batches),
-		totalPlacements: totalPlacements,
-		seedValue:       seed,
-	}, nil
+		next.ServeHTTP(w, r)
+	})
 }
 
-func writeJSON(w http.ResponseWriter, status int, payload any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(payload)
+// registerPprof mounts the runtime profiling handlers. They reveal internals
+// and can consume CPU on demand, so they are only enabled via -pprof.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 }
 
-func handleGenerate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.Header().Set("Allow", http.MethodPost)
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST with JSON body"})
-		return
-	}
-
-	defer r.Body.Close()
-
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-
-	var req mapRequest
-	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid JSON: %v", err)})
-		return
-	}
+// shutdownOnSignal waits for SIGINT or SIGTERM and drains in-flight requests
+// for up to timeout before the listener is torn down.
+func shutdownOnSignal(srv *http.Server, timeout time.Duration, done chan<- struct{}) {
+	defer close(done)
 
-	params, err := req.normalize()
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
-		return
-	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	received := <-sig
+	signal.Stop(sig)
 
-	start := time.Now()
-	result, err := generateMap(params)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	log.Printf("received %s, draining in-flight requests (timeout %s)", received, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Cache-Control", "no-store")
-	w.Header().Set("X-Tile-Batches", strconv.Itoa(result.batches))
-	w.Header().Set("X-Tile-Count", strconv.Itoa(result.totalPlacements))
-	w.Header().Set("X-Seed", strconv.FormatInt(result.seedValue, 10))
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(result.imageData); err != nil {
-		log.Printf("write response: %v", err)
-	}
-
-	log.Printf("generated %dx%d map mode=%s placements=%d batches=%d seed=%d duration=%s",
-		params.width, params.height, params.mode, result.totalPlacements, result.batches, result.seedValue, time.Since(start))
-}
-
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
-}
-
-func handleIndex(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{
-		"message": "POST a JSON payload to /generate to receive a PNG map",
-	})
-}
-
-func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handleIndex)
-	mux.HandleFunc("/generate", handleGenerate)
-	mux.HandleFunc("/healthz", handleHealth)
-
-	addr := "127.0.0.1:8080"
-	log.Printf("map generator server listening on http://%s", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatalf("server error: %v", err)
-	}
+	log.Printf("server stopped")
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setupServer installs the package-level stores main would create, empty and
+// without files, and cfg as the live configuration.
+func setupServer(t testing.TB, cfg Config) {
+	t.Helper()
+	if cfg.FilenameTemplate == "" {
+		cfg.FilenameTemplate = defaultFilenameTemplate
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	store, err := newPresetStore("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	presets = store
+	generationStats = newStatsStore()
+	serverConfig.Store(&cfg)
+	generationLimiter = rateLimiter{}
+}
+
+// postJSON sends body to handler as a POST and returns the recorded response.
+func postJSON(handler http.Handler, path, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
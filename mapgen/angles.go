@@ -0,0 +1,87 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// maxAngleSectors bounds angleBias; one-degree sectors are already finer than
+// any ring shows.
+const maxAngleSectors = 360
+
+// normalizeAngleBias validates angleBias, the weights of equal angular sectors
+// merkez samples its angle from: non-negative with at least one positive. Only
+// merkez reads them, so they are rejected elsewhere; without them the angle
+// stays uniform.
+func normalizeAngleBias(req *Request, p *Params) error {
+	if len(req.AngleBias) == 0 {
+		return nil
+	}
+	if !p.placesMode("merkez") {
+		return errors.New(`angleBias requires mode "merkez"`)
+	}
+	if len(req.AngleBias) > maxAngleSectors {
+		return fmt.Errorf("angleBias has more than %d sectors", maxAngleSectors)
+	}
+	total := 0.0
+	for i, w := range req.AngleBias {
+		if !(w >= 0) || math.IsInf(w, 0) {
+			return fmt.Errorf("angleBias[%d] must be a non-negative number", i)
+		}
+		total += w
+	}
+	if total <= 0 {
+		return errors.New("angleBias must have at least one positive weight")
+	}
+	p.AngleBias = req.AngleBias
+	return nil
+}
+
+// angleTable is the sampling table of angleBias: the weight of every sector
+// and their running sum, both scaled so the sum ends at 1.
+type angleTable struct {
+	weights    []float64
+	cumulative []float64
+}
+
+// setAngleBias builds the angle table of weights.
+func (g *generator) setAngleBias(weights []float64) {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	table := &angleTable{weights: make([]float64, len(weights)), cumulative: make([]float64, len(weights))}
+	sum := 0.0
+	for i, w := range weights {
+		table.weights[i] = w / total
+		sum += table.weights[i]
+		table.cumulative[i] = sum
+	}
+	g.angles = table
+}
+
+// merkezAngle maps u, uniform in [0, 1), to a merkez angle in radians. Without
+// angleBias the angle is uniform over the circle. With it u is inverted
+// through the piecewise-constant distribution of the sectors: sector i spans
+// [i, i+1) × 2π/len(weights), measured from the positive x axis toward the
+// bottom of the canvas, and is picked by its weight with the angle uniform
+// inside it. Either way one draw makes one angle, so a bias leaves the rest of
+// the random stream where it was.
+func (g *generator) merkezAngle(u float64) float64 {
+	if g.angles == nil {
+		return u * 2 * math.Pi
+	}
+	t := g.angles
+	i := min(sort.SearchFloat64s(t.cumulative, u), len(t.cumulative)-1)
+	// Step past zero-weight sectors when u lands exactly on a shared boundary.
+	for i < len(t.cumulative)-1 && t.weights[i] <= 0 {
+		i++
+	}
+	within := 0.0
+	if t.weights[i] > 0 {
+		within = clampFloat((u-(t.cumulative[i]-t.weights[i]))/t.weights[i], 0, 1)
+	}
+	return (float64(i) + within) * 2 * math.Pi / float64(len(t.weights))
+}
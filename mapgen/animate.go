@@ -0,0 +1,141 @@
+package mapgen
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	animateDrift = "drift"
+
+	// maxAnimationFrames and maxAnimationPixels bound the work of one
+	// animated request: every frame repeats placement and coloring.
+	maxAnimationFrames = 120
+	maxAnimationPixels = 64 << 20
+
+	// driftFrameDelay is the GIF frame delay in hundredths of a second.
+	driftFrameDelay = 10
+
+	// driftSalt separates the velocity stream from the placement stream so
+	// enabling drift does not change where frame 0 puts its tiles.
+	driftSalt = 0x5deece66d
+)
+
+// gifPalette reserves index 0 for full transparency so transparent
+// backgrounds survive the conversion from RGBA.
+var gifPalette = append(color.Palette{color.RGBA{}}, palette.Plan9[:255]...)
+
+// generateDrift renders frames in which the island or continent centers move
+// along seeded velocity vectors. Each frame re-runs placement from the same
+// seed on the already finalized batches, so only the centers differ.
+func generateDrift(p Params, batches []tileBatch, scaling []TileScaling, scale float64, seed int64, stats *Stats) (Result, error) {
+	if pixels := p.Frames * p.Width * p.Height; pixels > maxAnimationPixels {
+		return Result{}, fmt.Errorf("animation of %d frames at %dx%d exceeds the %d pixel budget", p.Frames, p.Width, p.Height, maxAnimationPixels)
+	}
+
+	anim := &gif.GIF{LoopCount: 0}
+	var velocities []vector
+	placements, landCells := 0, 0
+	saturation := 0.0
+	for i := 0; i < p.Frames; i++ {
+		rnd, gen := newSeededGenerator(p, seed)
+		if velocities == nil {
+			velocities = driftVelocities(seed, len(gen.islandCenters)+len(gen.continentCenters))
+		}
+		gen.applyDrift(velocities, float64(i)*p.DriftPerFrame)
+
+		f := renderFrame(p, batches, rnd, gen, stats)
+		placements, landCells = f.placements, f.landCells
+		saturation += f.saturation / float64(p.Frames)
+
+		stageStart := time.Now()
+		paletted := image.NewPaletted(f.img.Bounds(), gifPalette)
+		draw.Draw(paletted, paletted.Bounds(), f.img, image.Point{}, draw.Src)
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, driftFrameDelay)
+		stats.track(StageEncoding, stageStart)
+	}
+
+	stageStart := time.Now()
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return Result{}, fmt.Errorf("encode gif: %w", err)
+	}
+	stats.track(StageEncoding, stageStart)
+
+	return Result{
+		Data:            buf.Bytes(),
+		ContentType:     "image/gif",
+		Width:           p.Width,
+		Height:          p.Height,
+		Mode:            p.Mode,
+		Batches:         len(batches),
+		TotalPlacements: placements,
+		Seed:            seed,
+		Scale:           scale,
+		Tiles:           scaling,
+		Saturation:      saturation,
+		RequestedArea:   requestedArea(batches),
+		LandCells:       landCells,
+		OverlapFactor:   overlapFactor(requestedArea(batches), landCells),
+	}, nil
+}
+
+type vector struct{ X, Y float64 }
+
+// driftVelocities returns n seeded unit vectors, one per drifting center.
+func driftVelocities(seed int64, n int) []vector {
+	rnd := rand.New(rand.NewSource(seed ^ driftSalt))
+	velocities := make([]vector, n)
+	for i := range velocities {
+		theta := rnd.Float64() * 2 * math.Pi
+		velocities[i] = vector{X: math.Cos(theta), Y: math.Sin(theta)}
+	}
+	return velocities
+}
+
+// applyDrift moves the island centers, then the continent centers, distance
+// pixels along their velocities. Centers bounce off the same 10% margin that
+// initIslands uses, so they never leave the canvas.
+func (g *generator) applyDrift(velocities []vector, distance float64) {
+	margin := float64(int(float64(min(g.width, g.height)) * 0.1))
+	loX, hiX := margin, float64(g.width-1)-margin
+	loY, hiY := margin, float64(g.height-1)-margin
+
+	move := func(c image.Point, v vector) image.Point {
+		return image.Point{
+			X: int(math.Round(reflectCoord(float64(c.X)+v.X*distance, loX, hiX))),
+			Y: int(math.Round(reflectCoord(float64(c.Y)+v.Y*distance, loY, hiY))),
+		}
+	}
+	for i := range g.islandCenters {
+		g.islandCenters[i] = move(g.islandCenters[i], velocities[i])
+	}
+	for i := range g.continentCenters {
+		g.continentCenters[i] = move(g.continentCenters[i], velocities[len(g.islandCenters)+i])
+	}
+}
+
+// reflectCoord folds v into [lo, hi] as if it bounced off both ends.
+func reflectCoord(v, lo, hi float64) float64 {
+	span := hi - lo
+	if span <= 0 {
+		return lo
+	}
+	v = math.Mod(v-lo, 2*span)
+	if v < 0 {
+		v += 2 * span
+	}
+	if v > span {
+		v = 2*span - v
+	}
+	return lo + v
+}
@@ -0,0 +1,150 @@
+package mapgen
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// defaultAAFactor is the supersampling factor landShare reproduces when the
+// request names none.
+const defaultAAFactor = 2
+
+// normalizeAntialias validates antialias and aaFactor. The land shares are
+// taken from the whole coverage grid, so the sparse path does not support it.
+func normalizeAntialias(req *Request, p *Params) error {
+	if req.Antialias == nil || !*req.Antialias {
+		if req.AAFactor != nil {
+			return errors.New("aaFactor requires antialias")
+		}
+		return nil
+	}
+	if req.Sparse != nil && *req.Sparse {
+		return errors.New("sparse does not support antialias")
+	}
+	p.Antialias, p.AAFactor = true, defaultAAFactor
+	if req.AAFactor != nil {
+		if *req.AAFactor != 2 && *req.AAFactor != 3 {
+			return errors.New("aaFactor must be 2 or 3")
+		}
+		p.AAFactor = *req.AAFactor
+	}
+	return nil
+}
+
+// RenderedPixels is the number of pixels rendering p holds at once, the
+// canvas; antialias works at canvas resolution and adds none.
+func (p Params) RenderedPixels() int {
+	return p.Width * p.Height
+}
+
+// antialiasCoast smooths the staircase coastline of img. Every pixel gets the
+// share of land around it from landShare. Land pixels then fade toward the
+// water by the water share, and water pixels take on the mean color of the
+// land next to them by the land share; pixels away from the coast keep their
+// color. Coverage itself is untouched, so coverage exports keep the canvas
+// resolution.
+func antialiasCoast(img *image.RGBA, coverage []float64, factor int) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if len(coverage) != width*height {
+		// Previews color a smaller grid than the canvas.
+		return
+	}
+	share := landShare(coverage, width, height, factor)
+
+	src := image.NewRGBA(img.Bounds())
+	copy(src.Pix, img.Pix)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			idx := y*width + x
+			s := float64(share[idx])
+			land := coverage[idx] > 0
+			switch {
+			case land && s < 1:
+				water := waterNeighbor(src, coverage, width, height, x, y)
+				img.SetRGBA(x, y, blendColor(water, src.RGBAAt(x, y), s))
+			case !land && s > 0:
+				img.SetRGBA(x, y, blendColor(src.RGBAAt(x, y), landNeighbor(src, coverage, width, height, x, y), s))
+			}
+		}
+	}
+}
+
+// landShare is the share of land around every cell: the land mask blurred
+// with the separable kernel [w, 1-2w, w], w = (factor-1)/(2(2·factor-1)).
+// Away from the canvas edge that is exactly what drawing the mask at factor
+// times the resolution, box blurring it over 2·factor-1 supersampled pixels
+// and averaging every factor×factor block back down gives: tiles cover whole
+// cells, so the supersampled mask holds nothing the grid does not. At the
+// edge the weight of the missing neighbor is dropped.
+func landShare(coverage []float64, width, height, factor int) []float32 {
+	w := float32(factor-1) / float32(2*(2*factor-1))
+	share := make([]float32, width*height)
+	for i, c := range coverage {
+		if c > 0 {
+			share[i] = 1
+		}
+	}
+	line := make([]float32, max(width, height))
+	for y := 0; y < height; y++ {
+		blur3(share[y*width:], 1, width, w, line)
+	}
+	for x := 0; x < width; x++ {
+		blur3(share[x:], width, height, w, line)
+	}
+	return share
+}
+
+// blur3 convolves n values stride apart with [w, 1-2w, w], renormalizing
+// where the kernel runs off either end. A value equal to its neighbors is
+// left alone, so cells away from the coast stay at exactly 0 and 1. line is
+// scratch space of at least n values.
+func blur3(values []float32, stride, n int, w float32, line []float32) {
+	for i := 0; i < n; i++ {
+		line[i] = values[i*stride]
+	}
+	for i := 0; i < n; i++ {
+		v := line[i]
+		sum, weight := (1-2*w)*v, 1-2*w
+		same := true
+		if i > 0 {
+			sum, weight = sum+w*line[i-1], weight+w
+			same = same && line[i-1] == v
+		}
+		if i+1 < n {
+			sum, weight = sum+w*line[i+1], weight+w
+			same = same && line[i+1] == v
+		}
+		if !same {
+			values[i*stride] = sum / weight
+		}
+	}
+}
+
+// landNeighbor is the mean color of the land among the 8 neighbors of (x, y).
+func landNeighbor(img *image.RGBA, coverage []float64, width, height, x, y int) color.RGBA {
+	return neighborMean(img, coverage, width, height, x, y, true)
+}
+
+// waterNeighbor is the mean color of the water among the 8 neighbors of
+// (x, y).
+func waterNeighbor(img *image.RGBA, coverage []float64, width, height, x, y int) color.RGBA {
+	return neighborMean(img, coverage, width, height, x, y, false)
+}
+
+func neighborMean(img *image.RGBA, coverage []float64, width, height, x, y int, land bool) color.RGBA {
+	var r, g, b, a, n int
+	for ny := max(y-1, 0); ny <= min(y+1, height-1); ny++ {
+		for nx := max(x-1, 0); nx <= min(x+1, width-1); nx++ {
+			if (coverage[ny*width+nx] > 0) != land || nx == x && ny == y {
+				continue
+			}
+			c := img.RGBAAt(nx, ny)
+			r, g, b, a, n = r+int(c.R), g+int(c.G), b+int(c.B), a+int(c.A), n+1
+		}
+	}
+	if n == 0 {
+		return img.RGBAAt(x, y)
+	}
+	return color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n)}
+}
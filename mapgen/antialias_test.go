@@ -0,0 +1,108 @@
+package mapgen
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// supersampledShare is the land share antialias used to build: the land mask
+// drawn at factor times the resolution, box blurred over 2·factor-1 pixels
+// and averaged back down block by block.
+func supersampledShare(land []bool, width, height, factor int) []float64 {
+	sw, sh := width*factor, height*factor
+	mask := make([]float64, sw*sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			if land[(y/factor)*width+x/factor] {
+				mask[y*sw+x] = 1
+			}
+		}
+	}
+	blurred := make([]float64, sw*sh)
+	r := factor - 1
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			sum, n := 0.0, 0
+			for dy := -r; dy <= r; dy++ {
+				for dx := -r; dx <= r; dx++ {
+					if xx, yy := x+dx, y+dy; xx >= 0 && xx < sw && yy >= 0 && yy < sh {
+						sum += mask[yy*sw+xx]
+						n++
+					}
+				}
+			}
+			blurred[y*sw+x] = sum / float64(n)
+		}
+	}
+	share := make([]float64, width*height)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			share[(y/factor)*width+x/factor] += blurred[y*sw+x] / float64(factor*factor)
+		}
+	}
+	return share
+}
+
+func TestLandShareMatchesSupersampling(t *testing.T) {
+	const width, height = 40, 30
+	rnd := rand.New(rand.NewSource(1))
+	coverage := make([]float64, width*height)
+	land := make([]bool, width*height)
+	for i := 0; i < 12; i++ {
+		x0, y0 := rnd.Intn(width), rnd.Intn(height)
+		w, h := 1+rnd.Intn(10), 1+rnd.Intn(8)
+		for y := y0; y < min(y0+h, height); y++ {
+			for x := x0; x < min(x0+w, width); x++ {
+				coverage[y*width+x]++
+				land[y*width+x] = true
+			}
+		}
+	}
+	for _, factor := range []int{2, 3} {
+		got := landShare(coverage, width, height, factor)
+		want := supersampledShare(land, width, height, factor)
+		// The reference renormalizes its blur at the edge on the supersampled
+		// grid, so only interior cells agree exactly.
+		for y := 1; y < height-1; y++ {
+			for x := 1; x < width-1; x++ {
+				i := y*width + x
+				if math.Abs(float64(got[i])-want[i]) > 1e-6 {
+					t.Fatalf("factor %d: share at (%d,%d) = %v, want %v", factor, x, y, got[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestLandShareKeepsInteriorExact(t *testing.T) {
+	// A 5x3 island at (2,2)-(6,4): its inner row stays land, the ring of
+	// cells around its edge blends and everything further out stays water.
+	const width, height = 9, 7
+	coverage := make([]float64, width*height)
+	for y := 2; y <= 4; y++ {
+		for x := 2; x <= 6; x++ {
+			coverage[y*width+x] = 3
+		}
+	}
+	share := landShare(coverage, width, height, defaultAAFactor)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			s := share[y*width+x]
+			switch {
+			case x >= 3 && x <= 5 && y == 3:
+				if s != 1 {
+					t.Errorf("inland share at (%d,%d) = %v, want 1", x, y, s)
+				}
+			case x >= 1 && x <= 7 && y >= 1 && y <= 5:
+				if s <= 0 || s >= 1 {
+					t.Errorf("coast share at (%d,%d) = %v, want strictly between 0 and 1", x, y, s)
+				}
+			default:
+				if s != 0 {
+					t.Errorf("open water share at (%d,%d) = %v, want 0", x, y, s)
+				}
+			}
+		}
+	}
+}
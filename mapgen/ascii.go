@@ -0,0 +1,81 @@
+package mapgen
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// ASCII output bounds: the default and largest number of columns.
+const (
+	defaultASCIIWidth = 80
+	maxASCIIWidth     = 1000
+)
+
+// asciiRamp shades a character cell from water, a space, to '@' where the
+// image turns fully brown.
+const asciiRamp = " .:-=+*#%@"
+
+// normalizeASCII validates asciiWidth, the column count of format ascii.
+func normalizeASCII(req *Request, p *Params) error {
+	if req.AsciiWidth != nil && p.Format != formatASCII {
+		return fmt.Errorf("asciiWidth requires format %q", formatASCII)
+	}
+	if p.Format != formatASCII {
+		return nil
+	}
+	p.AsciiWidth = defaultASCIIWidth
+	if req.AsciiWidth != nil {
+		if *req.AsciiWidth < 1 || *req.AsciiWidth > maxASCIIWidth {
+			return fmt.Errorf("asciiWidth must be between 1 and %d", maxASCIIWidth)
+		}
+		p.AsciiWidth = *req.AsciiWidth
+	}
+	return nil
+}
+
+// encodeASCII box-averages the window of the width-wide coverage grid down to
+// a character grid and shades every cell from asciiRamp, one line per row.
+// Terminal cells are about twice as tall as wide, so rows are half the columns
+// times the aspect ratio. There are never more columns than window pixels.
+func encodeASCII(coverage []float64, width int, window image.Rectangle, p Params) []byte {
+	winW, winH := window.Dx(), window.Dy()
+	if winW <= 0 || winH <= 0 {
+		return nil
+	}
+	grid := coverage
+	if winW != width || winH != len(coverage)/width {
+		grid = make([]float64, winW*winH)
+		for y := 0; y < winH; y++ {
+			copy(grid[y*winW:(y+1)*winW], coverage[(window.Min.Y+y)*width+window.Min.X:])
+		}
+	}
+	cols := min(p.AsciiWidth, winW)
+	rows := max(1, int(math.Round(float64(cols*winH)/float64(winW)/2)))
+	cells := resampleCoverage(grid, winW, winH, cols, rows)
+
+	brownCap := p.brownCap()
+	data := make([]byte, 0, (cols+1)*rows)
+	for y := 0; y < rows; y++ {
+		for _, c := range cells[y*cols : (y+1)*cols] {
+			data = append(data, asciiRamp[asciiShade(c, brownCap, p.LogTone)])
+		}
+		data = append(data, '\n')
+	}
+	return data
+}
+
+// asciiShade is the index into asciiRamp of a cell with averaged coverage c.
+// Partial land shows as '.'; from coverage 1 the rest of the ramp follows
+// toneRatio, the green-to-brown ramp of the image, up to '@' where brown
+// saturates.
+func asciiShade(c, brownCap float64, logTone bool) int {
+	switch {
+	case c <= 0:
+		return 0
+	case c < 1:
+		return 1
+	}
+	steps := len(asciiRamp) - 3
+	return 2 + min(int(toneRatio(c, brownCap, logTone)*float64(steps)), steps)
+}
@@ -0,0 +1,43 @@
+package mapgen
+
+import "testing"
+
+func TestASCIIShadeFollowsToneRatio(t *testing.T) {
+	tests := []struct {
+		c       float64
+		logTone bool
+		want    byte
+	}{
+		{0, false, ' '},
+		{0.1, false, '.'},
+		{0.99, false, '.'},
+		{1, false, ':'},
+		{1, true, ':'},
+		{5, false, '@'},
+		{50, false, '@'},
+		{5, true, '@'},
+		// Halfway up the linear ramp of brownCap 4.
+		{3, false, '+'},
+	}
+	for _, tt := range tests {
+		if got := asciiRamp[asciiShade(tt.c, 4, tt.logTone)]; got != tt.want {
+			t.Errorf("asciiShade(%v, 4, %v) = %q, want %q", tt.c, tt.logTone, got, tt.want)
+		}
+	}
+
+	// Each step of the image's ramp maps to a character no lighter than the
+	// step before.
+	for _, logTone := range []bool{false, true} {
+		prev := 0
+		for c := 0.0; c <= 8; c += 0.01 {
+			shade := asciiShade(c, 4, logTone)
+			if shade < prev {
+				t.Fatalf("logTone %v: coverage %v shades %d after %d", logTone, c, shade, prev)
+			}
+			if c >= 1 && (toneRatio(c, 4, logTone) == 1) != (asciiRamp[shade] == '@') {
+				t.Fatalf("logTone %v: coverage %v has tone ratio %v but shades %q", logTone, c, toneRatio(c, 4, logTone), asciiRamp[shade])
+			}
+			prev = shade
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/big"
+)
+
+// statsColor is the ink drawStats marks the centroid and principal axes with.
+var statsColor = color.RGBA{R: 220, G: 40, B: 40, A: 255}
+
+// LandBalance describes how the painted land of a map is spread over the
+// canvas, for scoring how balanced a map is. Coordinates are canvas pixels
+// measured at cell centers.
+type LandBalance struct {
+	CentroidX float64 `json:"centroidX"`
+	CentroidY float64 `json:"centroidY"`
+	// Mxx, Myy and Mxy are the central second moments of the land cells.
+	Mxx float64 `json:"mxx"`
+	Myy float64 `json:"myy"`
+	Mxy float64 `json:"mxy"`
+	// Major and Minor are the standard deviations of the land along its
+	// principal axes; Angle is the direction of the major axis in radians,
+	// clockwise from +x since y grows downward.
+	Major float64 `json:"major"`
+	Minor float64 `json:"minor"`
+	Angle float64 `json:"angle"`
+	// Quadrants is the land fraction of the top-left, top-right, bottom-left
+	// and bottom-right quadrants of the canvas.
+	Quadrants [4]float64 `json:"quadrants"`
+	// Score is 0 for land centered on the canvas and spread evenly over its
+	// quadrants, growing to 1 as the centroid moves to a corner and the land
+	// gathers in one quadrant. It averages the centroid's distance from the
+	// center, over half the diagonal, and the variance of the quadrant
+	// shares of the land, over its largest possible value.
+	Score float64 `json:"score"`
+}
+
+// landMoments accumulates the moments of the land cells of a width×height
+// canvas one cell at a time. The sums are integers, so they are exact in any
+// order, and balance forms the central moments from them in big integers,
+// rounding only the final quotient.
+type landMoments struct {
+	width, height       int
+	n, sumX, sumY       int64
+	sumXX, sumYY, sumXY int64
+	quadrants           [4]int64
+}
+
+func newLandMoments(width, height int) *landMoments {
+	return &landMoments{width: width, height: height}
+}
+
+// add records the land cell (x, y).
+func (m *landMoments) add(x, y int) {
+	X, Y := int64(x), int64(y)
+	m.n++
+	m.sumX, m.sumY = m.sumX+X, m.sumY+Y
+	m.sumXX, m.sumYY, m.sumXY = m.sumXX+X*X, m.sumYY+Y*Y, m.sumXY+X*Y
+	q := 0
+	if x >= m.width/2 {
+		q++
+	}
+	if y >= m.height/2 {
+		q += 2
+	}
+	m.quadrants[q]++
+}
+
+// balance summarizes the recorded cells, or returns nil without land.
+func (m *landMoments) balance() *LandBalance {
+	if m.n == 0 {
+		return nil
+	}
+	n := float64(m.n)
+	meanX, meanY := float64(m.sumX)/n, float64(m.sumY)/n
+	b := &LandBalance{
+		CentroidX: meanX + 0.5,
+		CentroidY: meanY + 0.5,
+		Mxx:       centralMoment(m.n, m.sumX, m.sumX, m.sumXX),
+		Myy:       centralMoment(m.n, m.sumY, m.sumY, m.sumYY),
+		Mxy:       centralMoment(m.n, m.sumX, m.sumY, m.sumXY),
+	}
+	mean := (b.Mxx + b.Myy) / 2
+	spread := math.Hypot((b.Mxx-b.Myy)/2, b.Mxy)
+	b.Major = math.Sqrt(mean + spread)
+	b.Minor = math.Sqrt(math.Max(mean-spread, 0))
+	b.Angle = math.Atan2(2*b.Mxy, b.Mxx-b.Myy) / 2
+
+	left, top := m.width/2, m.height/2
+	areas := [4]int{left * top, (m.width - left) * top, left * (m.height - top), (m.width - left) * (m.height - top)}
+	total := 0.0
+	for i, cells := range m.quadrants {
+		if areas[i] > 0 {
+			b.Quadrants[i] = float64(cells) / float64(areas[i])
+		}
+		total += b.Quadrants[i]
+	}
+
+	w, h := float64(m.width), float64(m.height)
+	offset := math.Hypot(b.CentroidX-w/2, b.CentroidY-h/2) / (math.Hypot(w, h) / 2)
+	// Shares of 1/4 each are even; all land in one quadrant gives the largest
+	// variance, 3/16.
+	variance := 0.0
+	for _, f := range b.Quadrants {
+		d := f/total - 0.25
+		variance += d * d / 4
+	}
+	b.Score = (math.Min(offset, 1) + variance/(3.0/16)) / 2
+	return b
+}
+
+// centralMoment is (n·sumAB − sumA·sumB) / n², the central moment of two
+// coordinates from their raw sums over n cells. Subtracting the squared mean
+// in floating point would cancel catastrophically once the sums pass 2^53,
+// so the difference is taken exactly.
+func centralMoment(n, sumA, sumB, sumAB int64) float64 {
+	num := new(big.Int).Mul(big.NewInt(n), big.NewInt(sumAB))
+	num.Sub(num, new(big.Int).Mul(big.NewInt(sumA), big.NewInt(sumB)))
+	den := new(big.Int).Mul(big.NewInt(n), big.NewInt(n))
+	f, _ := new(big.Rat).SetFrac(num, den).Float64()
+	return f
+}
+
+// measureLand is the LandBalance of the cells of a width×height coverage grid
+// with positive coverage, or nil without land.
+func measureLand(coverage []float64, width, height int) *LandBalance {
+	m := newLandMoments(width, height)
+	for i, c := range coverage {
+		if c > 0 {
+			m.add(i%width, i/width)
+		}
+	}
+	return m.balance()
+}
+
+// normalizeDrawStats validates drawStats, which marks the land statistics on
+// one finished image in a raster format.
+func normalizeDrawStats(req *Request, p *Params) error {
+	if req.DrawStats == nil || !*req.DrawStats {
+		return nil
+	}
+	switch {
+	case p.Format != formatPNG && p.Format != formatWebP:
+		return fmt.Errorf("drawStats requires format %q or %q", formatPNG, formatWebP)
+	case p.Pyramid > 0 || len(p.Sizes) > 0:
+		return errors.New("drawStats does not support pyramid or sizes")
+	case p.Animate != "":
+		return errors.New("drawStats cannot be animated")
+	case p.Preview:
+		return errors.New("drawStats does not support preview, which reports no land statistics")
+	}
+	p.DrawStats = true
+	return nil
+}
+
+// drawBalance marks the centroid of b on img with a cross and draws both
+// principal axes through it, two standard deviations to each side.
+func drawBalance(img *image.RGBA, b *LandBalance) {
+	if b == nil {
+		return
+	}
+	cx, cy := b.CentroidX, b.CentroidY
+	arm := math.Max(3, float64(min(img.Bounds().Dx(), img.Bounds().Dy()))/100)
+	drawLine(img, cx-arm, cy-arm, cx+arm, cy+arm, statsColor)
+	drawLine(img, cx-arm, cy+arm, cx+arm, cy-arm, statsColor)
+
+	sin, cos := math.Sincos(b.Angle)
+	for _, axis := range []struct{ length, dx, dy float64 }{
+		{2 * b.Major, cos, sin},
+		{2 * b.Minor, -sin, cos},
+	} {
+		dx, dy := axis.dx*axis.length, axis.dy*axis.length
+		drawLine(img, cx-dx, cy-dy, cx+dx, cy+dy, statsColor)
+	}
+}
+
+// drawLine sets the pixels along the segment from (x0, y0) to (x1, y1),
+// skipping those off img.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		pt := image.Pt(int(math.Floor(x0+(x1-x0)*t)), int(math.Floor(y0+(y1-y0)*t)))
+		if pt.In(img.Bounds()) {
+			img.SetRGBA(pt.X, pt.Y, c)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package mapgen
+
+import (
+	"math"
+	"testing"
+)
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+}
+
+// landGrid is a width×height coverage grid covering the cells for which
+// cells is true.
+func landGrid(width, height int, cells func(x, y int) bool) []float64 {
+	coverage := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if cells(x, y) {
+				coverage[y*width+x] = 1
+			}
+		}
+	}
+	return coverage
+}
+
+func TestMeasureLand(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		cells         func(x, y int) bool
+		want          LandBalance
+	}{
+		{
+			// The whole canvas: centered, even, perfectly balanced.
+			name: "full canvas", width: 8, height: 6,
+			cells: func(x, y int) bool { return true },
+			want: LandBalance{
+				CentroidX: 4, CentroidY: 3, Mxx: 63.0 / 12, Myy: 35.0 / 12,
+				Major: math.Sqrt(63.0 / 12), Minor: math.Sqrt(35.0 / 12),
+				Quadrants: [4]float64{1, 1, 1, 1},
+			},
+		},
+		{
+			// A 4×2 block in the top-left quadrant of an 8×8 canvas.
+			name: "corner block", width: 8, height: 8,
+			cells: func(x, y int) bool { return x < 4 && y < 2 },
+			want: LandBalance{
+				CentroidX: 2, CentroidY: 1, Mxx: 15.0 / 12, Myy: 3.0 / 12,
+				Major: math.Sqrt(15.0 / 12), Minor: math.Sqrt(3.0 / 12),
+				Quadrants: [4]float64{0.5, 0, 0, 0},
+			},
+		},
+		{
+			// The main diagonal of a 5×5 canvas lies along 45°.
+			name: "diagonal", width: 5, height: 5,
+			cells: func(x, y int) bool { return x == y },
+			want: LandBalance{
+				CentroidX: 2.5, CentroidY: 2.5, Mxx: 2, Myy: 2, Mxy: 2,
+				Major: 2, Minor: 0, Angle: math.Pi / 4,
+				Quadrants: [4]float64{2.0 / 4, 0, 0, 3.0 / 9},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := measureLand(landGrid(tt.width, tt.height, tt.cells), tt.width, tt.height)
+			if got == nil {
+				t.Fatal("no balance for a map with land")
+			}
+			w := tt.want
+			for _, f := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"centroidX", got.CentroidX, w.CentroidX}, {"centroidY", got.CentroidY, w.CentroidY},
+				{"mxx", got.Mxx, w.Mxx}, {"myy", got.Myy, w.Myy}, {"mxy", got.Mxy, w.Mxy},
+				{"major", got.Major, w.Major}, {"minor", got.Minor, w.Minor}, {"angle", got.Angle, w.Angle},
+				{"quadrant 0", got.Quadrants[0], w.Quadrants[0]}, {"quadrant 1", got.Quadrants[1], w.Quadrants[1]},
+				{"quadrant 2", got.Quadrants[2], w.Quadrants[2]}, {"quadrant 3", got.Quadrants[3], w.Quadrants[3]},
+			} {
+				if !closeTo(f.got, f.want) {
+					t.Errorf("%s = %v, want %v", f.name, f.got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMeasureLandWithoutLand(t *testing.T) {
+	if got := measureLand(make([]float64, 12), 4, 3); got != nil {
+		t.Errorf("balance = %+v for an empty map, want nil", got)
+	}
+}
+
+func TestMeasureLandScore(t *testing.T) {
+	full := measureLand(landGrid(10, 10, func(x, y int) bool { return true }), 10, 10)
+	corner := measureLand(landGrid(10, 10, func(x, y int) bool { return x == 0 && y == 0 }), 10, 10)
+	if full.Score != 0 {
+		t.Errorf("full canvas scores %v, want 0", full.Score)
+	}
+	// The centroid of the corner cell sits half a cell from the corner.
+	if want := (math.Hypot(4.5, 4.5)/math.Hypot(5, 5) + 1) / 2; !closeTo(corner.Score, want) {
+		t.Errorf("corner cell scores %v, want %v", corner.Score, want)
+	}
+}
+
+// TestLandMomentsFarFromOrigin checks the central moments of a small cluster
+// whose raw sums pass 2^53, where subtracting the squared mean in floating
+// point would lose every digit.
+func TestLandMomentsFarFromOrigin(t *testing.T) {
+	const far = 1 << 24
+	m := newLandMoments(1<<25, 1<<25)
+	for i := 0; i < 3000; i++ {
+		m.add(far+i%3, far+i%2)
+	}
+	b := m.balance()
+	// x takes 0, 1 and 2 equally often and y 0 and 1, independently.
+	if !closeTo(b.Mxx, 2.0/3) || !closeTo(b.Myy, 0.25) || !closeTo(b.Mxy, 0) {
+		t.Errorf("moments %v, %v, %v, want 2/3, 1/4, 0", b.Mxx, b.Myy, b.Mxy)
+	}
+	if !closeTo(b.CentroidX, far+1.5) || !closeTo(b.CentroidY, far+1) {
+		t.Errorf("centroid (%v, %v), want (%v, %v)", b.CentroidX, b.CentroidY, far+1.5, far+1.0)
+	}
+}
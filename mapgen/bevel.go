@@ -0,0 +1,74 @@
+package mapgen
+
+import (
+	"errors"
+	"image/color"
+	"math"
+)
+
+// defaultBevelStrength is how far a coast cell lit or shaded from two sides
+// moves toward white or black.
+const defaultBevelStrength = 0.5
+
+// normalizeBevel validates bevel and bevelStrength. The shading looks at the
+// neighbors of every cell, so like antialias it needs the dense grid.
+func normalizeBevel(req *Request, p *Params) error {
+	if req.Bevel == nil || !*req.Bevel {
+		if req.BevelStrength != nil {
+			return errors.New("bevelStrength requires bevel")
+		}
+		return nil
+	}
+	switch {
+	case req.Sparse != nil && *req.Sparse:
+		return errors.New("sparse does not support bevel")
+	case p.Invert:
+		return errors.New("bevel does not support invert, which paints land transparent")
+	}
+	p.Bevel, p.BevelStrength = true, defaultBevelStrength
+	if req.BevelStrength != nil {
+		if !(*req.BevelStrength > 0 && *req.BevelStrength <= 1) {
+			return errors.New("bevelStrength must be greater than 0 and at most 1")
+		}
+		p.BevelStrength = *req.BevelStrength
+	}
+	return nil
+}
+
+// bevelLight is the net light of the covered cell at (x, y): +1 for water
+// above and for water to the left, -1 for water below and for water to the
+// right, so a cell with water on opposite sides nets out. The canvas edge is
+// not water.
+func bevelLight(coverage []float64, width, height, x, y int) int {
+	water := func(nx, ny int) bool {
+		return nx >= 0 && nx < width && ny >= 0 && ny < height && coverage[ny*width+nx] <= 0
+	}
+	light := 0
+	if water(x, y-1) {
+		light++
+	}
+	if water(x-1, y) {
+		light++
+	}
+	if water(x, y+1) {
+		light--
+	}
+	if water(x+1, y) {
+		light--
+	}
+	return light
+}
+
+// bevelColor lightens c toward white for positive light and darkens it toward
+// black for negative light, by strength at a light of ±2 and half of it at ±1.
+// Alpha is kept.
+func bevelColor(c color.RGBA, light int, strength float64) color.RGBA {
+	if light == 0 {
+		return c
+	}
+	target := color.RGBA{A: c.A}
+	if light > 0 {
+		target.R, target.G, target.B = 255, 255, 255
+	}
+	return blendColor(c, target, strength*math.Abs(float64(light))/2)
+}
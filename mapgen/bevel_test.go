@@ -0,0 +1,135 @@
+package mapgen
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestBevelGolden pins bevel output alone and combined with palettes. Update
+// the hashes only with a change meant to alter output.
+func TestBevelGolden(t *testing.T) {
+	yes := true
+	strength := 1.0
+	base := Request{W: 64, H: 48, Tiles: "3x3*40,2x1*60,1x1*80", Seed: "bevel", Bevel: &yes}
+	palettes := base
+	palettes.Palettes = []PaletteEntry{{Low: "#2e7d32", High: "#1b5e20"}, {Low: "#c2b280", High: "#8d6e63"}}
+	strong := base
+	strong.BevelStrength = &strength
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"bevel", base, "c6dc3c8c98b443c29a0a401c9859c2ecf9f8afa15972596278fdfd04f0a167b0"},
+		{"strength 1", strong, "a7f16367503570da824009bf662945acc0c8a3b3b1eef3108f80ae23ceca7e0f"},
+		{"palettes", palettes, "8fd0e70d2c02523bdcce3a4a8fcf35dc1aad86ae21aa3a8f0b0913475674e4c9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ContentSHA256(generate(t, tt.req).Data); got != tt.want {
+				t.Errorf("output hash %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBevelOnlyShadesCoast renders a map with and without bevel and checks
+// that only coast cells change, lightened with net water above and left and
+// darkened with net water below and right.
+func TestBevelOnlyShadesCoast(t *testing.T) {
+	yes := true
+	req := Request{W: 64, H: 48, Tiles: "3x3*40,2x1*60,1x1*80", Seed: "bevel"}
+	cells := coverageCells(t, req)
+	flat := decodePNG(t, generate(t, req).Data)
+	req.Bevel = &yes
+	beveled := decodePNG(t, generate(t, req).Data)
+
+	water := func(x, y int) bool {
+		_, covered := cells[image.Point{X: x, Y: y}]
+		return x >= 0 && x < req.W && y >= 0 && y < req.H && !covered
+	}
+	lit, shaded := 0, 0
+	for y := 0; y < req.H; y++ {
+		for x := 0; x < req.W; x++ {
+			before := color.NRGBAModel.Convert(flat.At(x, y)).(color.NRGBA)
+			after := color.NRGBAModel.Convert(beveled.At(x, y)).(color.NRGBA)
+			light := 0
+			if !water(x, y) {
+				for _, n := range []struct{ dx, dy, light int }{{0, -1, 1}, {-1, 0, 1}, {0, 1, -1}, {1, 0, -1}} {
+					if water(x+n.dx, y+n.dy) {
+						light += n.light
+					}
+				}
+			}
+			brightness := func(c color.NRGBA) int { return int(c.R) + int(c.G) + int(c.B) }
+			switch {
+			case light == 0 && after != before:
+				t.Fatalf("cell (%d, %d) with net light 0 changed from %v to %v", x, y, before, after)
+			case light > 0 && brightness(after) <= brightness(before):
+				t.Fatalf("cell (%d, %d) with light %d went from %v to %v, want lighter", x, y, light, before, after)
+			case light < 0 && brightness(after) >= brightness(before):
+				t.Fatalf("cell (%d, %d) with light %d went from %v to %v, want darker", x, y, light, before, after)
+			case light > 0:
+				lit++
+			case light < 0:
+				shaded++
+			}
+		}
+	}
+	if lit == 0 || shaded == 0 {
+		t.Errorf("%d cells lit and %d shaded, want both", lit, shaded)
+	}
+}
+
+// TestBevelOppositeSidesNetZero paints hand-made grids and checks one cell of
+// each: land with water on opposite sides is left alone, one side shades it
+// by half the strength and two adjacent sides by all of it.
+func TestBevelOppositeSidesNetZero(t *testing.T) {
+	yes := true
+	params, err := (&Request{W: 5, H: 5, Tiles: "1x1*1", Bevel: &yes}).Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	land := cellColor(1, -1, 0, tileJitter{}, params)
+	half, full := params.BevelStrength/2, params.BevelStrength
+	white, black := color.RGBA{255, 255, 255, 255}, color.RGBA{A: 255}
+
+	tests := []struct {
+		name string
+		grid []string
+		at   image.Point
+		want color.RGBA
+	}{
+		{"interior", []string{"#####", "#####", "#####", "#####", "#####"}, image.Pt(2, 2), land},
+		{"vertical strip", []string{"..#..", "..#..", "..#..", "..#..", "..#.."}, image.Pt(2, 2), land},
+		{"horizontal strip", []string{".....", ".....", "#####", ".....", "....."}, image.Pt(2, 2), land},
+		{"island", []string{".....", ".....", "..#..", ".....", "....."}, image.Pt(2, 2), land},
+		{"three sides", []string{".....", ".....", "..###", ".....", "....."}, image.Pt(2, 2), blendColor(land, white, half)},
+		{"lake above", []string{"#####", "#####", "##.##", "#####", "#####"}, image.Pt(2, 3), blendColor(land, white, half)},
+		{"coast above", []string{".....", ".....", "#####", "#####", "#####"}, image.Pt(2, 2), blendColor(land, white, half)},
+		{"coast above and left", []string{".....", ".....", "..###", "..###", "..###"}, image.Pt(2, 2), blendColor(land, white, full)},
+		{"coast below", []string{"#####", "#####", "#####", ".....", "....."}, image.Pt(2, 2), blendColor(land, black, half)},
+		{"coast below and right", []string{"###..", "###..", "###..", ".....", "....."}, image.Pt(2, 2), blendColor(land, black, full)},
+		{"above and right net zero", []string{".....", ".....", "###..", "###..", "###.."}, image.Pt(2, 2), land},
+		// The canvas edge is not water.
+		{"canvas corner", []string{"#####", "#####", "#####", "#####", "#####"}, image.Pt(0, 0), land},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coverage := make([]float64, 25)
+			for y, row := range tt.grid {
+				for x, c := range row {
+					if c == '#' {
+						coverage[y*5+x] = 1
+					}
+				}
+			}
+			img := image.NewRGBA(image.Rect(0, 0, 5, 5))
+			colorCoverage(img, coverage, nil, 0, nil, params)
+			if got := img.RGBAAt(tt.at.X, tt.at.Y); got != tt.want {
+				t.Errorf("cell %v = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Defaults and limits of mode "magara", the cellular-automaton landmass.
+const (
+	defaultCaveFill       = 0.45
+	defaultCaveIterations = 5
+	defaultCaveBirth      = 5
+	defaultCaveSurvive    = 4
+	maxCaveIterations     = 100
+)
+
+// normalizeCave validates the magara parameters. The mode paints no tiles, so
+// tile definitions are rejected rather than silently ignored.
+func normalizeCave(req *Request, p *Params) error {
+	if strings.TrimSpace(req.Tiles) != "" || len(req.TileList) > 0 || req.N22 != nil || req.N21 != nil || req.N11 != nil {
+		return errors.New("mode magara does not place tiles: remove tiles, tileList, n22, n21 and n11")
+	}
+	if p.Format == formatReplay {
+		return errors.New("mode magara does not place tiles and cannot be recorded as a replay")
+	}
+
+	p.CaFill = defaultCaveFill
+	if req.CaFill != nil {
+		if !(*req.CaFill >= 0 && *req.CaFill <= 1) {
+			return errors.New("caFill must be between 0 and 1")
+		}
+		p.CaFill = *req.CaFill
+	}
+	p.CaIterations = defaultCaveIterations
+	if req.CaIterations != nil {
+		if *req.CaIterations < 0 || *req.CaIterations > maxCaveIterations {
+			return fmt.Errorf("caIterations must be between 0 and %d", maxCaveIterations)
+		}
+		p.CaIterations = *req.CaIterations
+	}
+	p.CaBirth = defaultCaveBirth
+	if req.CaBirth != nil {
+		if *req.CaBirth < 0 || *req.CaBirth > 8 {
+			return errors.New("caBirth must be between 0 and 8")
+		}
+		p.CaBirth = *req.CaBirth
+	}
+	p.CaSurvive = defaultCaveSurvive
+	if req.CaSurvive != nil {
+		if *req.CaSurvive < 0 || *req.CaSurvive > 8 {
+			return errors.New("caSurvive must be between 0 and 8")
+		}
+		p.CaSurvive = *req.CaSurvive
+	}
+	if req.CaDepth != nil {
+		p.CaDepth = *req.CaDepth
+	}
+	return nil
+}
+
+// renderCave replaces tile placement in mode magara: every cell starts as
+// land with probability CaFill, then CaIterations rounds of the
+// birth/survive rule smooth the noise into landmasses. Land cells get
+// coverage 1, or with CaDepth their distance to the nearest water so the
+// interior ramps toward the peak color. The only randomness is the initial
+// fill, drawn row by row from rnd.
+func renderCave(p Params, rnd *rand.Rand, stats *Stats) frame {
+	stageStart := time.Now()
+
+	width, height := p.Width, p.Height
+	land := make([]bool, width*height)
+	for i := range land {
+		land[i] = rnd.Float64() < p.CaFill
+	}
+	next := make([]bool, len(land))
+	for iter := 0; iter < p.CaIterations; iter++ {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				n := landNeighbors(land, width, height, x, y)
+				if land[y*width+x] {
+					next[y*width+x] = n >= p.CaSurvive
+				} else {
+					next[y*width+x] = n >= p.CaBirth
+				}
+			}
+		}
+		land, next = next, land
+	}
+
+	coverage := make([]float64, len(land))
+	var depth []int
+	if p.CaDepth {
+		depth = waterDistance(land, width, height)
+	}
+	for i, isLand := range land {
+		switch {
+		case !isLand:
+		case depth != nil:
+			coverage[i] = float64(depth[i])
+		default:
+			coverage[i] = 1
+		}
+	}
+
+	stats.track(StagePlacement, stageStart)
+	stageStart = time.Now()
+
+	f := colorFrame(p, nil, frame{coverage: coverage})
+
+	stats.track(StageColoring, stageStart)
+	return f
+}
+
+// landNeighbors counts the land cells among the eight neighbors of (x, y).
+// Cells beyond the canvas count as water, so land never clings to the edges.
+func landNeighbors(land []bool, width, height, x, y int) int {
+	n := 0
+	for dy := -1; dy <= 1; dy++ {
+		ny := y + dy
+		if ny < 0 || ny >= height {
+			continue
+		}
+		for dx := -1; dx <= 1; dx++ {
+			nx := x + dx
+			if (dx == 0 && dy == 0) || nx < 0 || nx >= width {
+				continue
+			}
+			if land[ny*width+nx] {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// waterDistance returns, for every land cell, its chessboard distance to the
+// nearest water cell, counting the area beyond the canvas as water: coast
+// cells are 1. Water cells are 0. It runs the classic two-pass distance
+// transform.
+func waterDistance(land []bool, width, height int) []int {
+	dist := make([]int, len(land))
+	at := func(x, y int) int {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return 0
+		}
+		return dist[y*width+x]
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !land[y*width+x] {
+				continue
+			}
+			d := min(min(at(x-1, y), at(x, y-1)), min(at(x-1, y-1), at(x+1, y-1)))
+			dist[y*width+x] = d + 1
+		}
+	}
+	for y := height - 1; y >= 0; y-- {
+		for x := width - 1; x >= 0; x-- {
+			if !land[y*width+x] {
+				continue
+			}
+			d := min(min(at(x+1, y), at(x, y+1)), min(at(x+1, y+1), at(x-1, y+1)))
+			dist[y*width+x] = min(dist[y*width+x], d+1)
+		}
+	}
+	return dist
+}
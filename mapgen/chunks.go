@@ -0,0 +1,242 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+)
+
+// maxChunkSize bounds the side of one chunk, which is the largest grid a
+// chunked generation ever allocates.
+const maxChunkSize = 4096
+
+// Chunk is one rendered square of a chunked map. X and Y are its offset on the
+// full canvas; edge chunks may be smaller than the chunk size.
+type Chunk struct {
+	Col  int
+	Row  int
+	X    int
+	Y    int
+	W    int
+	H    int
+	Data []byte
+}
+
+// chunkPlacement is a placed tile kept between the placement pass and
+// rendering, with everything needed to paint it into any chunk.
+type chunkPlacement struct {
+	Placement
+	weight  float64
+	segment int
+}
+
+// ChunkedMap renders a canvas too large to allocate at once, one chunk at a
+// time. Placement never reads the coverage grid, so a single placement pass
+// records every tile and each chunk is then painted from the tiles that
+// overlap it. A chunk is byte-identical to the same area cropped from a full
+// render with the same parameters.
+type ChunkedMap struct {
+	Width      int
+	Height     int
+	ChunkSize  int
+	Cols       int
+	Rows       int
+	Seed       int64
+	Batches    int
+	Placements int
+	Palette    Palette
+
+	p          Params
+	gen        *generator
+	placements []chunkPlacement
+	// buckets lists, per chunk in row-major order, the indices of the
+	// placements overlapping it (plus the morphology halo), in placement order.
+	buckets [][]int32
+	halo    int
+}
+
+// NewChunkedMap runs the placement pass for p and prepares chunks of
+// size×size pixels. Options that need the whole canvas in memory (density
+// maps, pyramids, animation) or a non-PNG format are rejected.
+func NewChunkedMap(p Params, size int) (*ChunkedMap, error) {
+	if size <= 0 || size > maxChunkSize {
+		return nil, fmt.Errorf("chunk size must be between 1 and %d", maxChunkSize)
+	}
+	switch {
+	case p.Format != formatPNG:
+		return nil, fmt.Errorf("chunked generation only supports format %q", formatPNG)
+	case p.Density != nil:
+		return nil, errors.New("chunked generation does not support density maps")
+	case p.PreferVirgin > 0:
+		return nil, errors.New("chunked generation does not support preferVirgin")
+	case p.Pyramid > 0:
+		return nil, errors.New("chunked generation does not support pyramid")
+	case len(p.Sizes) > 0:
+		return nil, errors.New("chunked generation does not support sizes")
+	case len(p.Palettes) > 0:
+		return nil, errors.New("chunked generation does not support palettes")
+	case p.Bevel:
+		return nil, errors.New("chunked generation does not support bevel")
+	case p.Animate != "":
+		return nil, errors.New("chunked generation does not support animate")
+	case p.Mode == "magara":
+		return nil, errors.New("chunked generation does not support mode magara")
+	case p.BrownPercentile > 0:
+		return nil, errors.New("chunked generation does not support brownPercentile")
+	case p.Preview:
+		return nil, errors.New("chunked generation does not support preview")
+	case p.AutoCrop:
+		return nil, errors.New("chunked generation does not support autoCrop")
+	case p.Render != "":
+		return nil, fmt.Errorf("chunked generation does not support render %q", p.Render)
+	}
+
+	seed := paramsSeed(p)
+
+	batches, _, _, err := planBatches(&p, seed)
+	if err != nil {
+		return nil, err
+	}
+
+	palette := resolvePalette(&p, seed)
+
+	m := &ChunkedMap{
+		Width:     p.Width,
+		Height:    p.Height,
+		ChunkSize: size,
+		Cols:      (p.Width + size - 1) / size,
+		Rows:      (p.Height + size - 1) / size,
+		Seed:      seed,
+		Batches:   len(batches),
+		Palette:   palette,
+		p:         p,
+		// Morphology and erosion read one neighbor per iteration, so a chunk
+		// needs that many cells of context to match the full render at its
+		// edges.
+		halo: p.Erode + p.Dilate + p.Erosion,
+	}
+	m.buckets = make([][]int32, m.Cols*m.Rows)
+
+	rnd, gen := newSeededGenerator(p, seed)
+	m.gen = gen
+	m.Placements = placeTiles(p, batches, rnd, gen, func(pl Placement, weight float64, segment int) {
+		index := int32(len(m.placements))
+		m.placements = append(m.placements, chunkPlacement{Placement: pl, weight: weight, segment: segment})
+
+		// Buckets follow the painted rectangle, which bleed may grow.
+		x, y, tw, th := padRect(pl.X, pl.Y, pl.W, pl.H, p.TilePadding)
+		col0 := max(x-m.halo, 0) / size
+		col1 := min(x+tw-1+m.halo, p.Width-1) / size
+		row0 := max(y-m.halo, 0) / size
+		row1 := min(y+th-1+m.halo, p.Height-1) / size
+		for row := row0; row <= row1; row++ {
+			for col := col0; col <= col1; col++ {
+				m.buckets[row*m.Cols+col] = append(m.buckets[row*m.Cols+col], index)
+			}
+		}
+	})
+
+	return m, nil
+}
+
+// Render paints and encodes the chunk at col, row.
+func (m *ChunkedMap) Render(col, row int) (Chunk, error) {
+	if col < 0 || col >= m.Cols || row < 0 || row >= m.Rows {
+		return Chunk{}, fmt.Errorf("chunk %d,%d is outside the %dx%d grid", col, row, m.Cols, m.Rows)
+	}
+	p := m.p
+	x0, y0 := col*m.ChunkSize, row*m.ChunkSize
+	cw, ch := min(m.ChunkSize, p.Width-x0), min(m.ChunkSize, p.Height-y0)
+
+	// The working window is the chunk grown by the halo, clipped to the canvas.
+	wx0, wy0 := max(x0-m.halo, 0), max(y0-m.halo, 0)
+	ww := min(x0+cw+m.halo, p.Width) - wx0
+	wh := min(y0+ch+m.halo, p.Height) - wy0
+
+	coverage := make([]float64, ww*wh)
+	var ringOf []int
+	colorByRing := p.ColorByRing && p.placesMode("merkez")
+	if colorByRing {
+		ringOf = make([]int, len(coverage))
+		for i := range ringOf {
+			ringOf[i] = -1
+		}
+	}
+	var jitter []tileJitter
+	if p.ColorJitter > 0 {
+		jitter = make([]tileJitter, len(coverage))
+	}
+	for _, index := range m.buckets[row*m.Cols+col] {
+		pl := m.placements[index]
+		x, y, tw, th := padRect(pl.X-wx0, pl.Y-wy0, pl.W, pl.H, p.TilePadding)
+		fillCoverage(coverage, ww, wh, x, y, tw, th, pl.weight)
+		if colorByRing {
+			fillCells(ringOf, ww, wh, x, y, tw, th, pl.segment)
+		}
+		if jitter != nil {
+			fillCells(jitter, ww, wh, x, y, tw, th, placementJitter(m.Seed, int(index), p.ColorJitter))
+		}
+	}
+	if p.Erode > 0 || p.Dilate > 0 {
+		applyMorphology(coverage, ww, wh, p.Erode, p.Dilate)
+	}
+	if p.Erosion > 0 {
+		applyErosion(coverage, ww, wh, p.Erosion, p.ErosionTalus)
+	}
+	clipOcean(coverage, ww, wh, wx0, wy0, p)
+	clipPolygon(coverage, ww, wh, wx0, wy0, p)
+
+	// Crop the window back to the chunk.
+	offX, offY := x0-wx0, y0-wy0
+	cropped := make([]float64, cw*ch)
+	var croppedRings []int
+	if ringOf != nil {
+		croppedRings = make([]int, cw*ch)
+	}
+	var croppedJitter []tileJitter
+	if jitter != nil {
+		croppedJitter = make([]tileJitter, cw*ch)
+	}
+	for y := 0; y < ch; y++ {
+		src := (y+offY)*ww + offX
+		copy(cropped[y*cw:(y+1)*cw], coverage[src:src+cw])
+		if ringOf != nil {
+			copy(croppedRings[y*cw:(y+1)*cw], ringOf[src:src+cw])
+		}
+		if jitter != nil {
+			copy(croppedJitter[y*cw:(y+1)*cw], jitter[src:src+cw])
+		}
+	}
+
+	segments := p.Rings
+	if p.Mode == "voronoi" {
+		croppedRings = make([]int, cw*ch)
+		index := m.gen.newVoronoiIndex()
+		for y := 0; y < ch; y++ {
+			for x := 0; x < cw; x++ {
+				croppedRings[y*cw+x] = index.regionAt(x0+x, y0+y)
+			}
+		}
+		segments = len(m.gen.voronoiSites)
+	}
+
+	img := newCanvas(cw, ch, p)
+	colorCoverage(img, cropped, croppedRings, segments, croppedJitter, p)
+
+	if p.Outline {
+		var local []Placement
+		for _, index := range m.buckets[row*m.Cols+col] {
+			pl := m.placements[index].Placement
+			pl.X -= x0
+			pl.Y -= y0
+			local = append(local, pl)
+		}
+		drawOutlines(img, local, outlineColor(p), coveredIn(cropped, cw))
+	}
+
+	data, err := encodePNG(img, p.Dpi, png.DefaultCompression)
+	if err != nil {
+		return Chunk{}, err
+	}
+	return Chunk{Col: col, Row: row, X: x0, Y: y0, W: cw, H: ch, Data: data}, nil
+}
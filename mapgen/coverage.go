@@ -0,0 +1,109 @@
+package mapgen
+
+// fillCoverage adds weight to every cell of the tw×th rectangle at (x, y),
+// clipped to the width×height grid, and returns how many of those cells were
+// empty before. The span is clipped once per rectangle so the inner loop runs
+// over a plain row slice without per-cell bounds checks; 1- and 2-wide tiles,
+// the most common sizes, skip the inner loop entirely.
+func fillCoverage(coverage []float64, width, height, x, y, tw, th int, weight float64) int {
+	x0, x1 := max(x, 0), min(x+tw, width)
+	y0, y1 := max(y, 0), min(y+th, height)
+	if x0 >= x1 || y0 >= y1 || weight == 0 {
+		return 0
+	}
+
+	fresh := 0
+	switch x1 - x0 {
+	case 1:
+		for idx := y0*width + x0; y0 < y1; y0, idx = y0+1, idx+width {
+			fresh += newlyCovered(coverage[idx])
+			coverage[idx] += weight
+		}
+	case 2:
+		for idx := y0*width + x0; y0 < y1; y0, idx = y0+1, idx+width {
+			fresh += newlyCovered(coverage[idx]) + newlyCovered(coverage[idx+1])
+			coverage[idx] += weight
+			coverage[idx+1] += weight
+		}
+	default:
+		for row := y0; row < y1; row++ {
+			fresh += addSpan(coverage[row*width+x0:row*width+x1], weight)
+		}
+	}
+	return fresh
+}
+
+// addSpan adds weight to every cell of span and counts the cells that were
+// empty before.
+func addSpan(span []float64, weight float64) int {
+	fresh := 0
+	for i := range span {
+		fresh += newlyCovered(span[i])
+		span[i] += weight
+	}
+	return fresh
+}
+
+// newlyCovered is 1 for a cell that a positive weight turns into land.
+func newlyCovered(c float64) int {
+	if c == 0 {
+		return 1
+	}
+	return 0
+}
+
+// requestedArea is the cell area the batches ask for, Σ count×W×H, counting
+// the tiles that will not fit too.
+func requestedArea(batches []tileBatch) int {
+	area := 0
+	for _, batch := range batches {
+		area += batch.Count * batch.W * batch.H
+	}
+	return area
+}
+
+// overlapFactor is the requested area per painted land cell; 1 means no cell
+// was painted twice. It is 0 when nothing was painted.
+func overlapFactor(requested, landCells int) float64 {
+	if landCells == 0 {
+		return 0
+	}
+	return float64(requested) / float64(landCells)
+}
+
+// fillCells stamps v over the same clipped rectangle as fillCoverage; it
+// keeps per-cell labels such as the merkez ring of the latest placement.
+func fillCells[T any](cells []T, width, height, x, y, tw, th int, v T) {
+	x0, x1 := max(x, 0), min(x+tw, width)
+	y0, y1 := max(y, 0), min(y+th, height)
+	for row := y0; row < y1; row++ {
+		span := cells[row*width+x0 : row*width+x1]
+		for i := range span {
+			span[i] = v
+		}
+	}
+}
+
+// maxTilePadding bounds tilePadding either way; wider bleed covers any canvas
+// the server accepts.
+const maxTilePadding = 4096
+
+// padRect applies tilePadding to the tw×th rectangle at (x, y): positive
+// padding shrinks it by pad cells on each side and negative padding grows it,
+// leaving clipping to the fill. A side too short for its padding keeps its
+// middle cell, see paddingWarnings.
+func padRect(x, y, tw, th, pad int) (int, int, int, int) {
+	if pad == 0 {
+		return x, y, tw, th
+	}
+	x, tw = padSpan(x, tw, pad)
+	y, th = padSpan(y, th, pad)
+	return x, y, tw, th
+}
+
+func padSpan(start, size, pad int) (int, int) {
+	if size-2*pad < 1 {
+		return start + (size-1)/2, 1
+	}
+	return start + pad, size - 2*pad
+}
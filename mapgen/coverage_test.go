@@ -0,0 +1,80 @@
+package mapgen
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// fillCoverageCells is the per-cell loop fillCoverage replaced, kept as the
+// reference it must agree with.
+func fillCoverageCells(coverage []float64, width, x, y, tw, th int, weight float64) {
+	for yy := y; yy < y+th; yy++ {
+		rowOffset := yy * width
+		for xx := x; xx < x+tw; xx++ {
+			idx := rowOffset + xx
+			if idx >= 0 && idx < len(coverage) {
+				coverage[idx] += weight
+			}
+		}
+	}
+}
+
+func TestFillCoverageMatchesPerCellLoop(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		width, height := 1+rnd.Intn(64), 1+rnd.Intn(64)
+		got := make([]float64, width*height)
+		want := make([]float64, width*height)
+		for i := 0; i < 50; i++ {
+			tw, th := 1+rnd.Intn(min(width, 9)), 1+rnd.Intn(12)
+			// The old loop wrapped columns past the right edge into the next
+			// row, so placements stay within the canvas horizontally; rows may
+			// hang off the top or bottom edge.
+			x := rnd.Intn(width - tw + 1)
+			y := rnd.Intn(height+th) - th + 1
+			weight := float64(1 + rnd.Intn(3))
+			fillCoverage(got, width, height, x, y, tw, th, weight)
+			fillCoverageCells(want, width, x, y, tw, th, weight)
+		}
+		for idx := range want {
+			if got[idx] != want[idx] {
+				t.Fatalf("trial %d (%dx%d): cell %d = %v, want %v", trial, width, height, idx, got[idx], want[idx])
+			}
+		}
+	}
+}
+
+func TestFillCoverageClipsOffCanvas(t *testing.T) {
+	coverage := make([]float64, 4*3)
+	fillCoverage(coverage, 4, 3, -2, -1, 4, 3, 1)
+	fillCoverage(coverage, 4, 3, 3, 2, 5, 5, 1)
+	fillCoverage(coverage, 4, 3, 10, 10, 2, 2, 1)
+	want := []float64{
+		1, 1, 0, 0,
+		1, 1, 0, 0,
+		0, 0, 0, 1,
+	}
+	for idx := range want {
+		if coverage[idx] != want[idx] {
+			t.Fatalf("coverage = %v, want %v", coverage, want)
+		}
+	}
+}
+
+func benchmarkFill(b *testing.B, tw, th int) {
+	const width, height = 1024, 1024
+	coverage := make([]float64, width*height)
+	rnd := rand.New(rand.NewSource(1))
+	xs, ys := make([]int, 1024), make([]int, 1024)
+	for i := range xs {
+		xs[i], ys[i] = rnd.Intn(width-tw+1), rnd.Intn(height-th+1)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fillCoverage(coverage, width, height, xs[i%len(xs)], ys[i%len(ys)], tw, th, 1)
+	}
+}
+
+func BenchmarkFillSmall(b *testing.B) { benchmarkFill(b, 2, 2) }
+
+func BenchmarkFillLarge(b *testing.B) { benchmarkFill(b, 64, 64) }
@@ -0,0 +1,97 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// normalizeAutoCrop validates autoCrop and cropPadding. Cropping cuts a single
+// finished image, so it rules out the outputs that are not one.
+func normalizeAutoCrop(req *Request, p *Params) error {
+	if req.AutoCrop != nil {
+		p.AutoCrop = *req.AutoCrop
+	}
+	if req.CropPadding != nil {
+		if !p.AutoCrop {
+			return errors.New("cropPadding requires autoCrop")
+		}
+		if *req.CropPadding < 0 {
+			return errors.New("cropPadding must not be negative")
+		}
+		p.CropPadding = *req.CropPadding
+	}
+	if !p.AutoCrop {
+		return nil
+	}
+	switch {
+	case p.Format == formatReplay:
+		return fmt.Errorf("autoCrop does not support format %q, which paints nothing", formatReplay)
+	case p.Pyramid > 0:
+		return errors.New("autoCrop does not support pyramid")
+	case p.Animate != "":
+		return errors.New("autoCrop cannot be animated")
+	}
+	return nil
+}
+
+// growBounds extends r to cover the cell at x, y.
+func growBounds(r image.Rectangle, x, y int) image.Rectangle {
+	if r.Empty() {
+		return image.Rect(x, y, x+1, y+1)
+	}
+	return image.Rect(min(r.Min.X, x), min(r.Min.Y, y), max(r.Max.X, x+1), max(r.Max.Y, y+1))
+}
+
+// coverageBounds is the bounding box of the covered cells of a width-wide
+// grid, empty when no cell is covered.
+func coverageBounds(coverage []float64, width int) image.Rectangle {
+	var r image.Rectangle
+	for i, c := range coverage {
+		if c > 0 {
+			r = growBounds(r, i%width, i/width)
+		}
+	}
+	return r
+}
+
+// cropFrame applies autoCrop to f: it cuts f.img down to the land bounds grown
+// by cropPadding and moves the layout into the cropped image. It returns the
+// window of the canvas the output shows, and the same window as the crop when
+// autoCrop is on. A map without land keeps the whole canvas and adds a
+// warning.
+func cropFrame(f *frame, p Params, warnings []string) (image.Rectangle, image.Rectangle, []string) {
+	canvas := image.Rect(0, 0, p.Width, p.Height)
+	if !p.AutoCrop {
+		return canvas, image.Rectangle{}, warnings
+	}
+	if f.bounds.Empty() {
+		return canvas, canvas, append(warnings, "autoCrop found no land and kept the whole canvas")
+	}
+	window := f.bounds.Inset(-p.CropPadding).Intersect(canvas)
+	img := image.NewRGBA(image.Rect(0, 0, window.Dx(), window.Dy()))
+	draw.Draw(img, img.Bounds(), f.img, window.Min, draw.Src)
+	f.img = img
+	for i := range f.layout {
+		f.layout[i].X -= window.Min.X
+		f.layout[i].Y -= window.Min.Y
+	}
+	return window, window, warnings
+}
+
+// canvasRect is a rectangle in canvas pixels for the JSON metadata.
+type canvasRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// toCanvasRect converts r for the metadata, nil when it is empty.
+func toCanvasRect(r image.Rectangle) *canvasRect {
+	if r.Empty() {
+		return nil
+	}
+	return &canvasRect{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy()}
+}
@@ -0,0 +1,34 @@
+package mapgen
+
+import (
+	"image"
+	"strconv"
+)
+
+// csvHeader names the columns of format csv.
+const csvHeader = "x,y,coverage\n"
+
+// encodeCSV lists every covered cell of the window of the width-wide
+// coverage grid as an x,y,coverage row in row-major order, after a header
+// row. Coordinates are relative to the window, which is the whole canvas
+// unless autoCrop cut it. Empty cells are left out, which keeps mostly-empty
+// maps small; coverage is written in the shortest form that parses back to
+// the same float64.
+func encodeCSV(coverage []float64, width int, window image.Rectangle) []byte {
+	data := []byte(csvHeader)
+	for y := window.Min.Y; y < window.Max.Y; y++ {
+		for x := window.Min.X; x < window.Max.X; x++ {
+			v := coverage[y*width+x]
+			if v == 0 {
+				continue
+			}
+			data = strconv.AppendInt(data, int64(x-window.Min.X), 10)
+			data = append(data, ',')
+			data = strconv.AppendInt(data, int64(y-window.Min.Y), 10)
+			data = append(data, ',')
+			data = strconv.AppendFloat(data, v, 'g', -1, 64)
+			data = append(data, '\n')
+		}
+	}
+	return data
+}
@@ -0,0 +1,49 @@
+package mapgen
+
+import (
+	"context"
+	"fmt"
+)
+
+// contextCheckInterval is how many placements run between context checks,
+// keeping the check off the per-tile cost.
+const contextCheckInterval = 256
+
+// DeadlineError reports a generation stopped because its context was done:
+// the stage that was running and the placements completed by then. Err is
+// the context's error, so errors.Is(err, context.DeadlineExceeded) holds for
+// an expired deadline.
+type DeadlineError struct {
+	Stage      string
+	Placements int
+	Err        error
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("generation interrupted during %s after %d placements: %v", e.Stage, e.Placements, e.Err)
+}
+
+func (e *DeadlineError) Unwrap() error {
+	return e.Err
+}
+
+// interrupted returns a DeadlineError for stage once ctx is done, nil before.
+func interrupted(ctx context.Context, stage string, placements int) error {
+	if err := ctx.Err(); err != nil {
+		return &DeadlineError{Stage: stage, Placements: placements, Err: err}
+	}
+	return nil
+}
+
+// stopped reports whether the placement pass should end early, checking the
+// generator's context every contextCheckInterval placements. Once it reports
+// true it keeps doing so.
+func (g *generator) stopped() bool {
+	if g.ctx == nil {
+		return false
+	}
+	if g.ctxErr == nil && g.placed%contextCheckInterval == 0 {
+		g.ctxErr = g.ctx.Err()
+	}
+	return g.ctxErr != nil
+}
@@ -0,0 +1,99 @@
+package mapgen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+type densityMap struct {
+	width      int
+	height     int
+	values     []float64
+	cumulative []float64
+}
+
+func (g *generator) positionDensity(tw, th int) (int, int, bool) {
+	total := g.density.total()
+	if total <= 0 {
+		return 0, 0, false
+	}
+	r := g.rnd.Float64() * total
+	idx := sort.SearchFloat64s(g.density.cumulative, r)
+	if idx >= len(g.density.cumulative) {
+		idx = len(g.density.cumulative) - 1
+	}
+	// Step past zero-weight cells when r lands exactly on a shared boundary.
+	for idx < len(g.density.cumulative)-1 && g.density.values[idx] <= 0 {
+		idx++
+	}
+	cx := idx % g.density.width
+	cy := idx / g.density.width
+	x := clampInt(cx-tw/2, 0, g.width-tw)
+	y := clampInt(cy-th/2, 0, g.height-th)
+	return x, y, true
+}
+
+func newDensityMap(src image.Image, width, height int, strict bool) *densityMap {
+	d := &densityMap{
+		width:  width,
+		height: height,
+		values: make([]float64, width*height),
+	}
+	b := src.Bounds()
+	for y := 0; y < height; y++ {
+		sy := b.Min.Y + y*b.Dy()/height
+		for x := 0; x < width; x++ {
+			sx := b.Min.X + x*b.Dx()/width
+			gray := color.GrayModel.Convert(src.At(sx, sy)).(color.Gray)
+			d.values[y*width+x] = float64(gray.Y) / 255
+		}
+	}
+	if strict {
+		d.cumulative = make([]float64, len(d.values))
+		sum := 0.0
+		for i, v := range d.values {
+			sum += v
+			d.cumulative[i] = sum
+		}
+	}
+	return d
+}
+
+func (d *densityMap) at(x, y int) float64 {
+	if x < 0 || y < 0 || x >= d.width || y >= d.height {
+		return 0
+	}
+	return d.values[y*d.width+x]
+}
+
+func (d *densityMap) total() float64 {
+	if len(d.cumulative) == 0 {
+		return 0
+	}
+	return d.cumulative[len(d.cumulative)-1]
+}
+
+func decodeDensityImage(input string) (image.Image, error) {
+	data := strings.TrimSpace(input)
+	if i := strings.Index(data, ","); strings.HasPrefix(data, "data:") && i >= 0 {
+		data = data[i+1:]
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid density image encoding: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid density image: %w", err)
+	}
+	if b := img.Bounds(); b.Dx() <= 0 || b.Dy() <= 0 {
+		return nil, errors.New("density image must not be empty")
+	}
+	return img, nil
+}
@@ -0,0 +1,95 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Similarity compares the final coverage grids of two generations, after
+// morphology and ocean clipping, cell by cell.
+type Similarity struct {
+	Width  int   `json:"width"`
+	Height int   `json:"height"`
+	SeedA  int64 `json:"seedA"`
+	SeedB  int64 `json:"seedB"`
+	// IdenticalFraction is the fraction of cells with exactly equal coverage,
+	// empty cells included.
+	IdenticalFraction float64 `json:"identicalFraction"`
+	// MeanAbsDiff is the mean absolute coverage difference over all cells.
+	MeanAbsDiff float64 `json:"meanAbsDiff"`
+	// LandOverlap is the intersection over union of the covered cells; two
+	// empty maps overlap fully.
+	LandOverlap float64 `json:"landOverlap"`
+}
+
+// Compare generates a and b and measures how similar their coverage is. Both
+// must end up with the same canvas size. Only coverage is compared, so colors
+// and output options play no part.
+func Compare(a, b Params) (Similarity, error) {
+	covA, width, height, seedA, err := finalCoverage(a)
+	if err != nil {
+		return Similarity{}, fmt.Errorf("a: %w", err)
+	}
+	covB, widthB, heightB, seedB, err := finalCoverage(b)
+	if err != nil {
+		return Similarity{}, fmt.Errorf("b: %w", err)
+	}
+	if width != widthB || height != heightB {
+		return Similarity{}, fmt.Errorf("maps must have the same size, got %dx%d and %dx%d", width, height, widthB, heightB)
+	}
+
+	identical, union, intersection := 0, 0, 0
+	absDiff := 0.0
+	for i, ca := range covA {
+		cb := covB[i]
+		if ca == cb {
+			identical++
+		}
+		absDiff += math.Abs(ca - cb)
+		if ca > 0 || cb > 0 {
+			union++
+			if ca > 0 && cb > 0 {
+				intersection++
+			}
+		}
+	}
+	cells := float64(len(covA))
+	overlap := 1.0
+	if union > 0 {
+		overlap = float64(intersection) / float64(union)
+	}
+	return Similarity{
+		Width:             width,
+		Height:            height,
+		SeedA:             seedA,
+		SeedB:             seedB,
+		IdenticalFraction: float64(identical) / cells,
+		MeanAbsDiff:       absDiff / cells,
+		LandOverlap:       overlap,
+	}, nil
+}
+
+// finalCoverage runs a generation on the dense path, which is the only one
+// that keeps a full coverage grid, and returns the grid colorFrame left with
+// the canvas size, which autoFit may have grown, and the seed.
+func finalCoverage(p Params) ([]float64, int, int, int64, error) {
+	switch {
+	case p.Animate != "":
+		return nil, 0, 0, 0, errors.New("animated maps cannot be compared")
+	case p.Preview:
+		return nil, 0, 0, 0, errors.New("previews cannot be compared; their coverage is approximate")
+	}
+	seed := paramsSeed(p)
+	batches, _, _, err := planTiles(&p, seed)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	resolvePalette(&p, seed)
+	dense := false
+	p.Sparse = &dense
+
+	rnd, gen := newSeededGenerator(p, seed)
+	f := renderMap(p, batches, rnd, gen, nil)
+	return f.coverage, p.Width, p.Height, seed, nil
+}
@@ -0,0 +1,61 @@
+package mapgen
+
+import (
+	"image"
+	"testing"
+)
+
+// TestFinalCoverageMatchesGenerate checks that Compare sees the coverage
+// Generate renders, for tile modes and for magara.
+func TestFinalCoverageMatchesGenerate(t *testing.T) {
+	erode := 1
+	for _, req := range []Request{
+		{W: 60, H: 40, Tiles: "2x2*80,1x1*60", Mode: "merkez", Seed: "diff"},
+		{W: 60, H: 40, Tiles: "2x2*80,1x1*60", Mode: "adalar", Seeds: []string{"a", "b"}, Erode: &erode},
+		{W: 60, H: 40, Mode: "magara", Seed: "diff"},
+	} {
+		t.Run(req.Mode, func(t *testing.T) {
+			cells := coverageCells(t, req)
+			params, err := req.Normalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			coverage, width, height, _, err := finalCoverage(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if width != req.W || height != req.H {
+				t.Fatalf("size %dx%d, want %dx%d", width, height, req.W, req.H)
+			}
+			for i, c := range coverage {
+				if want := cells[image.Point{X: i % width, Y: i / width}]; c != want {
+					t.Fatalf("cell %d: coverage %v, Generate wrote %v", i, c, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareSameAndDifferentSeeds(t *testing.T) {
+	normalize := func(seed string) Params {
+		p, err := (&Request{W: 60, H: 40, Tiles: "2x2*80", Seed: seed}).Normalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	same, err := Compare(normalize("x"), normalize("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.IdenticalFraction != 1 || same.MeanAbsDiff != 0 || same.LandOverlap != 1 {
+		t.Errorf("same request compares as %+v", same)
+	}
+	other, err := Compare(normalize("x"), normalize("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.IdenticalFraction == 1 || other.LandOverlap == 1 {
+		t.Errorf("different seeds compare as %+v", other)
+	}
+}
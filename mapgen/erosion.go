@@ -0,0 +1,94 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxErosion bounds the erosion passes; each one is a full-grid sweep.
+const maxErosion = 256
+
+// defaultErosionTalus is the coverage step a slope may keep without eroding:
+// one stacked tile.
+const defaultErosionTalus = 1.0
+
+// normalizeErosion validates the thermal erosion options. Like erode and
+// dilate, erosion needs the whole grid, so it is rejected on the sparse path.
+func normalizeErosion(req *Request, p *Params) error {
+	if req.Erosion != nil {
+		if *req.Erosion < 0 || *req.Erosion > maxErosion {
+			return fmt.Errorf("erosion must be between 0 and %d", maxErosion)
+		}
+		p.Erosion = *req.Erosion
+	}
+	p.ErosionTalus = defaultErosionTalus
+	if req.ErosionTalus != nil {
+		if p.Erosion == 0 {
+			return errors.New("erosionTalus requires erosion")
+		}
+		if !(*req.ErosionTalus >= 0) || math.IsInf(*req.ErosionTalus, 0) {
+			return errors.New("erosionTalus must be a non-negative number")
+		}
+		p.ErosionTalus = *req.ErosionTalus
+	}
+	return nil
+}
+
+// erosionNeighbors are the 4-neighbors in the fixed order every pass visits
+// them, so the result does not depend on anything but the grid.
+var erosionNeighbors = [4][2]int{{0, -1}, {-1, 0}, {1, 0}, {0, 1}}
+
+// applyErosion runs passes of thermal erosion over the grid. In each pass
+// every cell whose coverage exceeds a neighbor's by more than talus sends
+// coverage downhill: up to one unit, and never more than half its steepest
+// excess, split among the lower neighbors in proportion to their excess. All
+// cells read the previous pass and write the next, so the order of cells
+// does not matter. Coverage only moves between cells of the grid, so its
+// total is conserved up to rounding, and since a cell sends at most half its
+// own coverage it never goes negative.
+func applyErosion(coverage []float64, width, height, passes int, talus float64) {
+	next := make([]float64, len(coverage))
+	cur := coverage
+	for pass := 0; pass < passes; pass++ {
+		copy(next, cur)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				idx := y*width + x
+				c := cur[idx]
+				if c <= talus {
+					continue
+				}
+				var excess [4]float64
+				total, steepest := 0.0, 0.0
+				for i, d := range erosionNeighbors {
+					nx, ny := x+d[0], y+d[1]
+					if nx < 0 || nx >= width || ny < 0 || ny >= height {
+						continue
+					}
+					if e := c - cur[ny*width+nx] - talus; e > 0 {
+						excess[i] = e
+						total += e
+						steepest = math.Max(steepest, e)
+					}
+				}
+				if total == 0 {
+					continue
+				}
+				move := math.Min(1, steepest/2)
+				for i, d := range erosionNeighbors {
+					if excess[i] == 0 {
+						continue
+					}
+					share := move * excess[i] / total
+					next[idx] -= share
+					next[(y+d[1])*width+x+d[0]] += share
+				}
+			}
+		}
+		cur, next = next, cur
+	}
+	if passes%2 == 1 {
+		copy(coverage, cur)
+	}
+}
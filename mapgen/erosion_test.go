@@ -0,0 +1,99 @@
+package mapgen
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func sumCoverage(coverage []float64) float64 {
+	total := 0.0
+	for _, c := range coverage {
+		total += c
+	}
+	return total
+}
+
+// steepestSlope is the largest coverage difference between 4-neighbors.
+func steepestSlope(coverage []float64, width, height int) float64 {
+	steepest := 0.0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := coverage[y*width+x]
+			if x+1 < width {
+				steepest = math.Max(steepest, math.Abs(c-coverage[y*width+x+1]))
+			}
+			if y+1 < height {
+				steepest = math.Max(steepest, math.Abs(c-coverage[(y+1)*width+x]))
+			}
+		}
+	}
+	return steepest
+}
+
+func TestErosionConservesMass(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		width, height := 1+rnd.Intn(40), 1+rnd.Intn(40)
+		coverage := make([]float64, width*height)
+		for i := range coverage {
+			if rnd.Intn(3) == 0 {
+				coverage[i] = float64(rnd.Intn(12))
+			}
+		}
+		before := sumCoverage(coverage)
+		applyErosion(coverage, width, height, 1+rnd.Intn(20), rnd.Float64()*2)
+		if after := sumCoverage(coverage); math.Abs(after-before) > 1e-9*math.Max(1, before) {
+			t.Fatalf("trial %d (%dx%d): mass %v became %v", trial, width, height, before, after)
+		}
+		for i, c := range coverage {
+			if c < 0 {
+				t.Fatalf("trial %d: cell %d went negative: %v", trial, i, c)
+			}
+		}
+	}
+}
+
+func TestErosionSmoothsSpikes(t *testing.T) {
+	const width, height = 21, 21
+	spikes := make([]float64, width*height)
+	spikes[10*width+10] = 12
+	spikes[3*width+4] = 6
+	spikes[17*width+15] = 9
+
+	peak, slope := 12.0, steepestSlope(spikes, width, height)
+	for passes := 1; passes <= 40; passes++ {
+		coverage := append([]float64(nil), spikes...)
+		applyErosion(coverage, width, height, passes, defaultErosionTalus)
+		p, s := 0.0, steepestSlope(coverage, width, height)
+		for _, c := range coverage {
+			p = math.Max(p, c)
+		}
+		if p > peak+1e-12 {
+			t.Fatalf("after %d passes the peak rose from %v to %v", passes, peak, p)
+		}
+		if s > slope+1e-12 {
+			t.Fatalf("after %d passes the steepest slope rose from %v to %v", passes, slope, s)
+		}
+		peak, slope = p, s
+	}
+	if slope > 2*defaultErosionTalus {
+		t.Errorf("steepest slope %v after 40 passes, want it worn down near talus %v", slope, defaultErosionTalus)
+	}
+}
+
+func TestErosionLeavesGentleSlopes(t *testing.T) {
+	// No step exceeds the talus, so nothing moves.
+	coverage := []float64{
+		0, 1, 2,
+		1, 2, 3,
+		2, 3, 3.5,
+	}
+	want := append([]float64(nil), coverage...)
+	applyErosion(coverage, 3, 3, 10, 1)
+	for i := range want {
+		if coverage[i] != want[i] {
+			t.Fatalf("coverage = %v, want it unchanged %v", coverage, want)
+		}
+	}
+}
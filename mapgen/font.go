@@ -0,0 +1,68 @@
+package mapgen
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// glyphs is a 3×5 bitmap font covering what sweep and legend labels need: digits,
+// lowercase letters and a little punctuation. Each row holds three bits, the
+// highest one leftmost. Uppercase letters render as lowercase and unknown
+// runes as '?'.
+var glyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7}, '1': {2, 6, 2, 2, 7}, '2': {7, 1, 7, 4, 7}, '3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1}, '5': {7, 4, 7, 1, 7}, '6': {7, 4, 7, 5, 7}, '7': {7, 1, 2, 2, 2},
+	'8': {7, 5, 7, 5, 7}, '9': {7, 5, 7, 1, 7},
+	'a': {2, 5, 7, 5, 5}, 'b': {6, 5, 6, 5, 6}, 'c': {3, 4, 4, 4, 3}, 'd': {6, 5, 5, 5, 6},
+	'e': {7, 4, 6, 4, 7}, 'f': {7, 4, 6, 4, 4}, 'g': {3, 4, 5, 5, 3}, 'h': {5, 5, 7, 5, 5},
+	'i': {7, 2, 2, 2, 7}, 'j': {1, 1, 1, 5, 2}, 'k': {5, 5, 6, 5, 5}, 'l': {4, 4, 4, 4, 7},
+	'm': {5, 7, 7, 5, 5}, 'n': {6, 5, 5, 5, 5}, 'o': {2, 5, 5, 5, 2}, 'p': {6, 5, 6, 4, 4},
+	'q': {2, 5, 5, 6, 3}, 'r': {6, 5, 6, 5, 5}, 's': {3, 4, 2, 1, 6}, 't': {7, 2, 2, 2, 2},
+	'u': {5, 5, 5, 5, 7}, 'v': {5, 5, 5, 5, 2}, 'w': {5, 5, 7, 7, 5}, 'x': {5, 5, 2, 5, 5},
+	'y': {5, 5, 2, 2, 2}, 'z': {7, 1, 2, 4, 7},
+	' ': {}, '.': {0, 0, 0, 0, 2}, ',': {0, 0, 0, 2, 4}, '-': {0, 0, 7, 0, 0},
+	'+': {0, 2, 7, 2, 0}, '=': {0, 7, 0, 7, 0}, '_': {0, 0, 0, 0, 7}, ':': {0, 2, 0, 2, 0},
+	'#': {5, 7, 5, 7, 5}, '/': {1, 1, 2, 4, 4}, '[': {6, 4, 4, 4, 6}, ']': {3, 1, 1, 1, 3},
+	'?': {7, 1, 2, 0, 2},
+}
+
+const (
+	glyphWidth = 3
+	// GlyphHeight is the height of a line of text at scale 1.
+	GlyphHeight  = 5
+	glyphAdvance = glyphWidth + 1
+)
+
+// TextWidth is the width of s drawn at scale, without trailing spacing.
+func TextWidth(s string, scale int) int {
+	n := len([]rune(s))
+	if n == 0 {
+		return 0
+	}
+	return (n*glyphAdvance - 1) * scale
+}
+
+// DrawText draws s with its top-left corner at (x, y), each font pixel
+// scaled to a scale×scale square.
+func DrawText(img *image.RGBA, x, y int, s string, scale int, c color.RGBA) {
+	for _, r := range strings.ToLower(s) {
+		glyph, ok := glyphs[r]
+		if !ok {
+			glyph = glyphs['?']
+		}
+		for row, bits := range glyph {
+			for col := 0; col < glyphWidth; col++ {
+				if bits&(1<<(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						img.SetRGBA(x+col*scale+dx, y+row*scale+dy, c)
+					}
+				}
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
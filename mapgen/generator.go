@@ -0,0 +1,881 @@
+package mapgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+type generator struct {
+	width         int
+	height        int
+	mode          string
+	rings         int
+	ringStartFrac float64
+	ringEndFrac   float64
+	islands       int
+	islandRFrac   float64
+	// islandAspect stretches adalar clusters into ellipses, elongated along
+	// islandAngles, the per-island orientation. 1 keeps them round.
+	islandAspect float64
+	islandAngles []float64
+	// islandFill is how adalar spreads tiles over an island: islandFillRadial
+	// or islandFillUniform.
+	islandFill       string
+	rnd              *rand.Rand
+	islandCenters    []image.Point
+	continentCenters []image.Point
+	voronoiSites     []image.Point
+	ringBoundaries   []float64
+	attractors       []attractor
+	nextAttractor    int
+	snap             int
+	seed             int64
+	density          *densityMap
+	densityStrict    bool
+	ringShape        string
+	areaCorrect      bool
+	// lastSegment is the merkez ring chosen by the latest positionForTile call,
+	// or -1 when the placement did not come from a ring.
+	lastSegment int
+	// lastAttractor is the agirlik attractor used by the latest
+	// positionForTile call, or -1 when agirlik did not choose the position.
+	lastAttractor int
+	// mix holds the modes of mode karma with their normalized weights, and
+	// mixCounts the placements each of them positioned.
+	mix       []ModeWeight
+	mixCounts []int
+	mixDraws  int
+	// lastMix is the mix entry that chose the latest position, or -1.
+	lastMix int
+	// islandShares is the planned placement share of every adalar island and
+	// islandCounts the placements each received; islandCumulative and
+	// islandScales, the sampling table and radius multipliers, are nil for
+	// equal islands. lastIsland is the island of the latest position, or -1.
+	islandShares     []float64
+	islandCounts     []int
+	islandCumulative []float64
+	islandScales     []float64
+	lastIsland       int
+	// preview cuts every retry budget to previewAttempts.
+	preview bool
+	// anchor is the part of a tile that lands on a sampled point; empty keeps
+	// each mode's historical anchoring.
+	anchor      string
+	anchorFrac  [2]float64
+	anchorDraws int
+	// regions is the regionWeights table of mode bolge.
+	regions *regionGrid
+	// petek is the lattice of mode petek; lastSite is the site of the latest
+	// position, or -1.
+	petek    *hexLattice
+	lastSite int
+	// ringBias shifts the merkez ring probabilities, see selectMerkezSegment.
+	ringBias string
+	// angles weights the merkez angle by sector, nil for a uniform angle.
+	angles *angleTable
+	// mask is the polygonMask placements are retried against, nil without
+	// one; crossings is scratch space for its row crossings.
+	mask      [][2]int
+	maskFit   string
+	crossings []float64
+	// virgin tracks covered cells for preferVirgin, nil without it.
+	virgin       *virginGrid
+	preferVirgin float64
+	// aspectWeight adds the agirlik orientation term, see agirlikScore.
+	aspectWeight float64
+	// preciseCOM accumulates the attractor centers of mass with kahanAdd.
+	// Tile areas and doubled tile centers are whole numbers, so the plain
+	// sums are exact until they pass 2^53; only beyond that do the two
+	// differ.
+	preciseCOM bool
+	// skips counts, per TileScaling entry and skip reason, the tiles that
+	// were not placed, see skip.
+	skips map[int]map[string]int
+	// ctx stops the placement pass early, see stopped; placed counts the
+	// tiles placed so far and ctxErr is the context error that ended it.
+	ctx    context.Context
+	placed int
+	ctxErr error
+}
+
+// Merkez ring biases: which rings the fixed ring probabilities favor.
+const (
+	ringBiasInner   = "inner"
+	ringBiasOuter   = "outer"
+	ringBiasUniform = "uniform"
+)
+
+// Tile anchors: the part of the tile placed on the sampled point.
+const (
+	anchorCenter  = "center"
+	anchorTopLeft = "topleft"
+	anchorRandom  = "random"
+	// anchorFraction marks an "fx,fy" anchor, the fractions of the tile width
+	// and height from its top-left corner that land on the point.
+	anchorFraction = "fraction"
+)
+
+// anchorSalt separates the random anchor offsets from the other seed-derived
+// streams.
+const anchorSalt = 0xc2b2ae3d27d4eb4f
+
+// anchorTile places a tw×th tile on the sampled point (px, py) by the
+// generator's anchor, centered by default, and clamps it onto the canvas.
+func (g *generator) anchorTile(px, py float64, tw, th int) (int, int) {
+	anchor := g.anchor
+	if anchor == "" {
+		anchor = anchorCenter
+	}
+	x, y := g.anchorOffset(anchor, px, py, tw, th)
+	return clampInt(x, 0, g.width-tw), clampInt(y, 0, g.height-th)
+}
+
+// anchorOffset returns the unclamped corner of a tile whose anchor lands on
+// (px, py). Random anchors pick a cell of the tile from a seed-derived stream,
+// so they never shift the placement stream.
+func (g *generator) anchorOffset(anchor string, px, py float64, tw, th int) (int, int) {
+	x, y := int(math.Round(px)), int(math.Round(py))
+	switch anchor {
+	case anchorTopLeft:
+		return x, y
+	case anchorRandom:
+		h := splitmix64(uint64(g.seed) ^ anchorSalt + uint64(g.anchorDraws))
+		g.anchorDraws++
+		return x - int((h>>32)%uint64(tw)), y - int((h&0xffffffff)%uint64(th))
+	case anchorFraction:
+		return x - int(g.anchorFrac[0]*float64(tw)), y - int(g.anchorFrac[1]*float64(th))
+	default:
+		return x - tw/2, y - th/2
+	}
+}
+
+// parseAnchorFraction parses an "fx,fy" anchor; both fractions must lie in
+// [0, 1], so "0.5,0.5" is the center and "0,0" the top-left corner.
+func parseAnchorFraction(s string) ([2]float64, error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	if !ok {
+		return [2]float64{}, errors.New(`want center, topleft, random or "fx,fy"`)
+	}
+	var frac [2]float64
+	for i, part := range []string{xs, ys} {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || !(v >= 0 && v <= 1) {
+			return [2]float64{}, fmt.Errorf("fraction %q must be a number between 0 and 1", strings.TrimSpace(part))
+		}
+		frac[i] = v
+	}
+	return frac, nil
+}
+
+const (
+	ringShapeCircle  = "circle"
+	ringShapeEllipse = "ellipse"
+)
+
+const (
+	islandFillRadial  = "radial"
+	islandFillUniform = "uniform"
+)
+
+// maxTargets bounds the agirlik attractors; each placement only consults one,
+// so the limit just keeps requests reasonable.
+const maxTargets = 64
+
+func newGenerator(width, height int, mode string, rings int, ringStartFrac, ringEndFrac float64, islands int, islandRFrac float64, rnd *rand.Rand) *generator {
+	g := &generator{
+		width:         width,
+		height:        height,
+		mode:          strings.ToLower(mode),
+		rings:         rings,
+		ringStartFrac: ringStartFrac,
+		ringEndFrac:   ringEndFrac,
+		islands:       islands,
+		islandRFrac:   islandRFrac,
+		rnd:           rnd,
+		lastMix:       -1,
+		lastIsland:    -1,
+		lastSite:      -1,
+		attractors:    []attractor{{targetX: float64(width) / 2, targetY: float64(height) / 2}},
+	}
+
+	g.initMode(g.mode)
+	return g
+}
+
+// initMode sets up the state mode positions tiles with.
+func (g *generator) initMode(mode string) {
+	switch mode {
+	case "adalar":
+		g.initIslands()
+	case "iki-kita":
+		g.initContinents()
+	case "merkez":
+		g.initMerkezRings()
+	case "voronoi":
+		g.initVoronoiSites()
+	}
+}
+
+func (g *generator) initIslands() {
+	count := g.islands
+	if count <= 0 {
+		count = 3
+	}
+	g.islandCenters = make([]image.Point, 0, count)
+	minDim := float64(min(g.width, g.height))
+	margin := int(minDim * 0.1)
+	for i := 0; i < count; i++ {
+		x := margin + g.rnd.Intn(max(1, g.width-2*margin))
+		y := margin + g.rnd.Intn(max(1, g.height-2*margin))
+		g.islandCenters = append(g.islandCenters, image.Point{X: x, Y: y})
+	}
+}
+
+// initVoronoiSites scatters the region sites over the whole canvas; unlike
+// island centers they keep no margin so edge regions are as likely as inner
+// ones.
+func (g *generator) initVoronoiSites() {
+	count := max(1, g.islands)
+	g.voronoiSites = make([]image.Point, 0, count)
+	for i := 0; i < count; i++ {
+		g.voronoiSites = append(g.voronoiSites, image.Point{X: g.rnd.Intn(g.width), Y: g.rnd.Intn(g.height)})
+	}
+}
+
+func (g *generator) initContinents() {
+	g.continentCenters = []image.Point{
+		{X: g.width / 4, Y: g.height / 2},
+		{X: (3 * g.width) / 4, Y: g.height / 2},
+	}
+}
+
+func (g *generator) initMerkezRings() {
+	start := clampFloat(g.ringStartFrac, 0, 1)
+	end := clampFloat(g.ringEndFrac, 0, 1)
+	if end <= start {
+		if end >= 1 {
+			start = clampFloat(end-0.1, 0, 1)
+		} else {
+			end = clampFloat(start+0.1, 0, 1)
+		}
+	}
+
+	segments := max(1, g.rings)
+	g.ringStartFrac = start
+	g.ringEndFrac = end
+	g.ringBoundaries = make([]float64, segments+1)
+	g.ringBoundaries[0] = 0
+
+	if segments == 1 {
+		g.ringBoundaries[1] = clampFloat(end, 0, 1)
+		if g.ringBoundaries[1] < start {
+			g.ringBoundaries[1] = start
+		}
+		return
+	}
+
+	span := end - start
+	if span <= 0 {
+		span = 0.1
+		end = clampFloat(start+span, start, 1)
+	}
+	step := span / float64(segments-1)
+
+	prev := 0.0
+	for i := 1; i <= segments; i++ {
+		var val float64
+		if i == 1 {
+			val = start
+		} else if i == segments {
+			val = end
+		} else {
+			val = start + float64(i-1)*step
+		}
+		val = clampFloat(val, prev, 1)
+		g.ringBoundaries[i] = val
+		prev = val
+	}
+}
+
+// positionForTile picks the tile position for the current mode and, when a
+// lattice is set, rounds it down onto the lattice.
+func (g *generator) positionForTile(tw, th int) (int, int) {
+	x, y := g.positionUnsnapped(tw, th)
+	if g.snap > 1 {
+		// Modes return in-bounds corners, and rounding a non-negative corner
+		// down only moves it toward the origin, so no clamp is needed.
+		x -= x % g.snap
+		y -= y % g.snap
+	}
+	return x, y
+}
+
+func (g *generator) positionUnsnapped(tw, th int) (int, int) {
+	if tw >= g.width || th >= g.height {
+		g.lastSegment, g.lastAttractor, g.lastMix, g.lastIsland, g.lastSite = -1, -1, -1, -1, -1
+		return 0, 0
+	}
+	if g.mask == nil && g.virgin == nil {
+		return g.positionCandidate(tw, th)
+	}
+
+	// Candidates outside the polygon mask, or rejected by preferVirgin, are
+	// retried; when the budget runs out the last one stands and the mask
+	// clips what it paints.
+	var x, y int
+	for attempt := 0; attempt < g.attempts(16); attempt++ {
+		x, y = g.positionCandidate(tw, th)
+		if (g.mask == nil || g.maskAccepts(x, y, tw, th)) && g.virginAccepts(x, y, tw, th) {
+			break
+		}
+	}
+	return x, y
+}
+
+// positionCandidate proposes a position for a tw×th tile that fits on the
+// canvas, honoring the density map.
+func (g *generator) positionCandidate(tw, th int) (int, int) {
+	g.lastSegment = -1
+	g.lastAttractor = -1
+	g.lastMix = -1
+	g.lastIsland = -1
+	g.lastSite = -1
+
+	if g.density != nil {
+		if g.densityStrict {
+			if x, y, ok := g.positionDensity(tw, th); ok {
+				return x, y
+			}
+			return g.positionForMode(tw, th)
+		}
+		for attempt := 0; attempt < g.attempts(16); attempt++ {
+			x, y := g.positionForMode(tw, th)
+			if g.rnd.Float64() < g.density.at(x+tw/2, y+th/2) {
+				return x, y
+			}
+		}
+	}
+
+	return g.positionForMode(tw, th)
+}
+
+// attempts is the retry budget of a placement strategy, cut down for
+// previews.
+func (g *generator) attempts(full int) int {
+	if g.preview {
+		return min(full, previewAttempts)
+	}
+	return full
+}
+
+func (g *generator) positionForMode(tw, th int) (int, int) {
+	mode := g.mode
+	if mode == modeKarma {
+		mode = g.sampleMixMode()
+	}
+	switch mode {
+	case "merkez":
+		return g.positionMerkez(tw, th)
+	case "agirlik":
+		return g.positionAgirlik(tw, th)
+	case "adalar":
+		return g.positionAdalar(tw, th)
+	case "iki-kita":
+		return g.positionIkiKita(tw, th)
+	case "voronoi":
+		return g.positionVoronoi(tw, th)
+	case modeBolge:
+		return g.positionRegion(tw, th)
+	case modePetek:
+		return g.positionPetek(tw, th)
+	default:
+		return g.positionAgirlik(tw, th)
+	}
+}
+
+func (g *generator) randomPlacement(tw, th int) (int, int) {
+	spanX := g.width - tw
+	spanY := g.height - th
+	if spanX < 0 {
+		spanX = 0
+	}
+	if spanY < 0 {
+		spanY = 0
+	}
+
+	x := 0
+	y := 0
+	if spanX > 0 {
+		x = g.rnd.Intn(spanX + 1)
+	}
+	if spanY > 0 {
+		y = g.rnd.Intn(spanY + 1)
+	}
+	return x, y
+}
+
+// selectMerkezSegment picks the ring of the next merkez placement, or reports
+// that it is placed at random. The ring probabilities favor the inner rings;
+// ringBias outer hands them to the outermost rings instead and uniform spreads
+// their sum evenly over all rings. Every bias draws one number.
+func (g *generator) selectMerkezSegment() (int, bool) {
+	segments := len(g.ringBoundaries) - 1
+	if segments <= 0 {
+		return -1, false
+	}
+
+	baseProbs := []float64{0.40, 0.20, 0.10, 0.05}
+	limit := min(segments, len(baseProbs))
+
+	totalAssigned := 0.0
+	r := g.rnd.Float64()
+	if g.ringBias == ringBiasUniform {
+		for _, p := range baseProbs[:limit] {
+			totalAssigned += p
+		}
+		if r < totalAssigned {
+			return min(int(r/totalAssigned*float64(segments)), segments-1), true
+		}
+		return -1, false
+	}
+	cumulative := 0.0
+	for i := 0; i < limit; i++ {
+		cumulative += baseProbs[i]
+		totalAssigned += baseProbs[i]
+		if r < cumulative {
+			if g.ringBias == ringBiasOuter {
+				return segments - 1 - i, true
+			}
+			return i, true
+		}
+	}
+
+	if totalAssigned >= 1.0 {
+		if segments > limit {
+			return segments - 1, true
+		}
+		if limit > 0 {
+			return limit - 1, true
+		}
+		return 0, true
+	}
+
+	return -1, false
+}
+
+func (g *generator) positionMerkez(tw, th int) (int, int) {
+	minDim := float64(min(g.width, g.height))
+	radiusMax := minDim / 2
+
+	for attempt := 0; attempt < g.attempts(12); attempt++ {
+		segment, useRing := g.selectMerkezSegment()
+		if !useRing {
+			return g.randomPlacement(tw, th)
+		}
+		if segment < 0 || segment+1 >= len(g.ringBoundaries) {
+			continue
+		}
+
+		innerFrac := g.ringBoundaries[segment]
+		outerFrac := g.ringBoundaries[segment+1]
+		if outerFrac <= innerFrac {
+			continue
+		}
+
+		radiusFrac := innerFrac + g.rnd.Float64()*(outerFrac-innerFrac)
+		if g.areaCorrect {
+			// Sample r² uniformly so density is even per unit area within
+			// the annulus instead of crowding its inner edge.
+			inner2, outer2 := innerFrac*innerFrac, outerFrac*outerFrac
+			radiusFrac = math.Sqrt(inner2 + (radiusFrac-innerFrac)/(outerFrac-innerFrac)*(outer2-inner2))
+		}
+		theta := g.merkezAngle(g.rnd.Float64())
+		radiusX := radiusFrac * radiusMax
+		radiusY := radiusX
+		if g.ringShape == ringShapeEllipse {
+			radiusX = radiusFrac * float64(g.width) / 2
+			radiusY = radiusFrac * float64(g.height) / 2
+		}
+		cx := float64(g.width)/2 + math.Cos(theta)*radiusX
+		cy := float64(g.height)/2 + math.Sin(theta)*radiusY
+		x, y := g.anchorTile(cx, cy, tw, th)
+		g.lastSegment = segment
+		return x, y
+	}
+
+	return g.randomPlacement(tw, th)
+}
+
+// attractor is an agirlik target together with the center of mass of the
+// tiles placed toward it.
+type attractor struct {
+	targetX   float64
+	targetY   float64
+	totalArea float64
+	sumX      float64
+	sumY      float64
+	// compArea, compX and compY carry the rounding error of the sums above
+	// under preciseCOM, see kahanAdd.
+	compArea float64
+	compX    float64
+	compY    float64
+	// skewX and skewY sum each tile's area times its orientation, see
+	// tileOrientation, times its offset from the target. They stay near zero
+	// while wide and tall tiles are spread evenly around the target.
+	skewX float64
+	skewY float64
+}
+
+// setTargets replaces the default canvas-center attractor with one per target,
+// given as [x, y] fractions of the canvas.
+func (g *generator) setTargets(targets [][2]float64) {
+	g.attractors = g.attractors[:0]
+	for _, t := range targets {
+		g.attractors = append(g.attractors, attractor{targetX: t[0] * float64(g.width), targetY: t[1] * float64(g.height)})
+	}
+}
+
+// positionAgirlik takes the attractors in turn and places the tile where it
+// moves that attractor's center of mass closest to its target.
+func (g *generator) positionAgirlik(tw, th int) (int, int) {
+	g.lastAttractor = g.nextAttractor
+	g.nextAttractor = (g.nextAttractor + 1) % len(g.attractors)
+	a := &g.attractors[g.lastAttractor]
+	targetX, targetY := a.targetX, a.targetY
+
+	centerX := clampInt(int(math.Round(targetX))-tw/2, 0, g.width-tw)
+	centerY := clampInt(int(math.Round(targetY))-th/2, 0, g.height-th)
+	bestX := centerX
+	bestY := centerY
+	bestScore := g.agirlikScore(a, centerX, centerY, tw, th)
+
+	currentDist := math.Inf(1)
+	if cx, cy, ok := a.centerOfMass(); ok {
+		currentDist = math.Hypot(cx-targetX, cy-targetY)
+		mirrorCenterX := targetX*2 - cx
+		mirrorCenterY := targetY*2 - cy
+		mirrorX := clampInt(int(math.Round(mirrorCenterX))-tw/2, 0, g.width-tw)
+		mirrorY := clampInt(int(math.Round(mirrorCenterY))-th/2, 0, g.height-th)
+		mirrorScore := g.agirlikScore(a, mirrorX, mirrorY, tw, th)
+		if mirrorScore < bestScore {
+			bestScore = mirrorScore
+			bestX = mirrorX
+			bestY = mirrorY
+		}
+	}
+
+	// Previews still score a couple of random candidates: with the centered
+	// and mirrored positions alone every tile would stack on the target.
+	for attempt := 0; attempt < g.attempts(24); attempt++ {
+		x, y := g.randomPlacement(tw, th)
+		score := g.agirlikScore(a, x, y, tw, th)
+		if score < bestScore {
+			bestScore = score
+			bestX = x
+			bestY = y
+			if currentDist != math.Inf(1) && score <= currentDist*0.7 {
+				break
+			}
+		}
+	}
+
+	return bestX, bestY
+}
+
+// recordPlacement adds the tile's mass to the attractor it was placed toward,
+// or to the attractor with the nearest target when another strategy chose
+// the position. In mode karma it also counts the placement for the mixed mode
+// that positioned it.
+func (g *generator) recordPlacement(x, y, tw, th int) {
+	if g.lastMix >= 0 {
+		g.mixCounts[g.lastMix]++
+	}
+	if g.lastIsland >= 0 {
+		g.islandCounts[g.lastIsland]++
+	}
+	if g.lastSite >= 0 && g.petek.oneTile {
+		g.petek.take(g.lastSite)
+	}
+	if g.virgin != nil {
+		g.virgin.cover(x, y, tw, th)
+	}
+	area := float64(tw * th)
+	if area <= 0 {
+		return
+	}
+	centerX := float64(x) + float64(tw)/2
+	centerY := float64(y) + float64(th)/2
+
+	index := g.lastAttractor
+	if index < 0 {
+		index = 0
+		best := math.Inf(1)
+		for i, a := range g.attractors {
+			if d := math.Hypot(centerX-a.targetX, centerY-a.targetY); d < best {
+				index, best = i, d
+			}
+		}
+	}
+	a := &g.attractors[index]
+	if g.preciseCOM {
+		kahanAdd(&a.totalArea, &a.compArea, area)
+		kahanAdd(&a.sumX, &a.compX, centerX*area)
+		kahanAdd(&a.sumY, &a.compY, centerY*area)
+	} else {
+		a.totalArea += area
+		a.sumX += centerX * area
+		a.sumY += centerY * area
+	}
+	skew := area * tileOrientation(tw, th)
+	a.skewX += skew * (centerX - a.targetX)
+	a.skewY += skew * (centerY - a.targetY)
+}
+
+// kahanAdd adds v to *sum with Kahan summation: *comp holds the low-order
+// part lost by the previous addition and is subtracted from the next one.
+func kahanAdd(sum, comp *float64, v float64) {
+	y := v - *comp
+	t := *sum + y
+	*comp = (t - *sum) - y
+	*sum = t
+}
+
+// agirlikScore is what agirlik minimizes: how far the attractor's center of
+// mass lands from its target and, weighted by agirlikAspectWeight, how
+// lopsided its tile orientations become.
+func (g *generator) agirlikScore(a *attractor, x, y, tw, th int) float64 {
+	score := a.distanceAfterPlacement(x, y, tw, th)
+	if g.aspectWeight > 0 {
+		score += g.aspectWeight * a.skewAfterPlacement(x, y, tw, th)
+	}
+	return score
+}
+
+// tileOrientation is (tw-th)/(tw+th): positive for wide tiles, negative for
+// tall ones and 0 for squares.
+func tileOrientation(tw, th int) float64 {
+	return float64(tw-th) / float64(tw+th)
+}
+
+// skewAfterPlacement is the length of the orientation moment per unit of
+// area once the tile is placed, in pixels like distanceAfterPlacement: how
+// far wide tiles sit to one side of the target and tall tiles to the other.
+func (a *attractor) skewAfterPlacement(x, y, tw, th int) float64 {
+	area := float64(tw * th)
+	total := a.totalArea + area
+	if total <= 0 {
+		return 0
+	}
+	skew := area * tileOrientation(tw, th)
+	sx := a.skewX + skew*(float64(x)+float64(tw)/2-a.targetX)
+	sy := a.skewY + skew*(float64(y)+float64(th)/2-a.targetY)
+	return math.Hypot(sx, sy) / total
+}
+
+func (a *attractor) centerOfMass() (float64, float64, bool) {
+	if a.totalArea <= 0 {
+		return 0, 0, false
+	}
+	return a.sumX / a.totalArea, a.sumY / a.totalArea, true
+}
+
+func (a *attractor) distanceAfterPlacement(x, y, tw, th int) float64 {
+	area := float64(tw * th)
+	if area <= 0 {
+		if cx, cy, ok := a.centerOfMass(); ok {
+			return math.Hypot(cx-a.targetX, cy-a.targetY)
+		}
+		return 0
+	}
+	total := a.totalArea + area
+	tileCenterX := float64(x) + float64(tw)/2
+	tileCenterY := float64(y) + float64(th)/2
+	newCx := (a.sumX + tileCenterX*area) / total
+	newCy := (a.sumY + tileCenterY*area) / total
+	dx := newCx - a.targetX
+	dy := newCy - a.targetY
+	return math.Hypot(dx, dy)
+}
+
+func (g *generator) positionAdalar(tw, th int) (int, int) {
+	if len(g.islandCenters) == 0 {
+		return g.positionMerkez(tw, th)
+	}
+	i := g.pickIsland()
+	g.lastIsland = i
+	uniform := g.islandFill == islandFillUniform
+	if g.islandAspect > 0 && g.islandAspect != 1 {
+		return g.positionNearEllipse(g.islandCenters[i], g.islandAngle(i), g.islandScale(i), uniform, tw, th)
+	}
+	return g.positionNear(g.islandCenters[i], g.islandScale(i), uniform, tw, th)
+}
+
+// islandOrientationSalt separates the island orientations from the other
+// seed-derived streams.
+const islandOrientationSalt = 0x94d049bb133111eb
+
+// islandAngle is the orientation of island i, derived from the seed alone so
+// stretching islands draws nothing from the placement stream and keeps their
+// centers where round islands would have them.
+func (g *generator) islandAngle(i int) float64 {
+	for len(g.islandAngles) <= i {
+		h := splitmix64(uint64(g.seed) ^ islandOrientationSalt + uint64(len(g.islandAngles)))
+		g.islandAngles = append(g.islandAngles, float64(h>>11)/(1<<53)*math.Pi)
+	}
+	return g.islandAngles[i]
+}
+
+func (g *generator) positionVoronoi(tw, th int) (int, int) {
+	if len(g.voronoiSites) == 0 {
+		return g.randomPlacement(tw, th)
+	}
+	return g.positionNear(g.voronoiSites[g.rnd.Intn(len(g.voronoiSites))], 1, false, tw, th)
+}
+
+// positionNear places a tile at a random radius (up to islandRFrac of the
+// shorter side, times scale) around center. The radius is uniform, which
+// crowds the center, unless uniform spreads positions evenly over the disc.
+func (g *generator) positionNear(center image.Point, scale float64, uniform bool, tw, th int) (int, int) {
+	radiusFrac := g.islandRFrac
+	if radiusFrac <= 0 {
+		radiusFrac = 0.25
+	}
+	maxRadius := radiusFrac * float64(min(g.width, g.height)) * scale
+	radius := g.clusterRadius(maxRadius, uniform)
+	theta := g.rnd.Float64() * 2 * math.Pi
+
+	cx := float64(center.X) + math.Cos(theta)*radius
+	cy := float64(center.Y) + math.Sin(theta)*radius
+	return g.anchorTile(cx, cy, tw, th)
+}
+
+// positionNearEllipse is positionNear on an ellipse of the same area whose
+// major axis, islandAspect times the minor one, points along angle.
+func (g *generator) positionNearEllipse(center image.Point, angle, scale float64, uniform bool, tw, th int) (int, int) {
+	radiusFrac := g.islandRFrac
+	if radiusFrac <= 0 {
+		radiusFrac = 0.25
+	}
+	maxRadius := radiusFrac * float64(min(g.width, g.height)) * scale
+	radius := g.clusterRadius(maxRadius, uniform)
+	theta := g.rnd.Float64() * 2 * math.Pi
+
+	stretch := math.Sqrt(g.islandAspect)
+	u := math.Cos(theta) * radius * stretch
+	v := math.Sin(theta) * radius / stretch
+	sin, cos := math.Sincos(angle)
+	cx := float64(center.X) + u*cos - v*sin
+	cy := float64(center.Y) + u*sin + v*cos
+	return g.anchorTile(cx, cy, tw, th)
+}
+
+// clusterRadius draws the distance of a cluster position from its center. Both
+// fills take one draw, so switching islandFill keeps the placement stream
+// aligned.
+func (g *generator) clusterRadius(maxRadius float64, uniform bool) float64 {
+	u := g.rnd.Float64()
+	if uniform {
+		// The area within radius r grows with r², so uniform area takes the
+		// square root.
+		return math.Sqrt(u) * maxRadius
+	}
+	return u * maxRadius
+}
+
+func (g *generator) positionIkiKita(tw, th int) (int, int) {
+	if len(g.continentCenters) == 0 {
+		return g.positionMerkez(tw, th)
+	}
+	center := g.continentCenters[g.rnd.Intn(len(g.continentCenters))]
+	sigmaX := float64(g.width) / 10
+	sigmaY := float64(g.height) / 6
+	for attempt := 0; attempt < g.attempts(6); attempt++ {
+		px := float64(center.X) + g.rnd.NormFloat64()*sigmaX
+		py := float64(center.Y) + g.rnd.NormFloat64()*sigmaY
+		// iki-kita has always treated the sample as the tile corner.
+		anchor := g.anchor
+		if anchor == "" {
+			anchor = anchorTopLeft
+		}
+		x, y := g.anchorOffset(anchor, px, py, tw, th)
+		if x >= 0 && x <= g.width-tw && y >= 0 && y <= g.height-th {
+			return x, y
+		}
+	}
+	return g.positionMerkez(tw, th)
+}
+
+// voronoiRegions assigns every cell to its nearest site so regions can be
+// colored by site.
+func (g *generator) voronoiRegions() []int {
+	index := g.newVoronoiIndex()
+	regions := make([]int, g.width*g.height)
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			regions[y*g.width+x] = index.regionAt(x, y)
+		}
+	}
+	return regions
+}
+
+// voronoiIndex buckets the voronoi sites into a grid of about one site per
+// bucket, so finding the nearest one looks at a few buckets around a cell
+// instead of every site.
+type voronoiIndex struct {
+	sites      []image.Point
+	size       int
+	cols, rows int
+	buckets    [][]int
+}
+
+func (g *generator) newVoronoiIndex() *voronoiIndex {
+	size := max(1, int(math.Sqrt(float64(g.width*g.height)/float64(max(1, len(g.voronoiSites))))))
+	v := &voronoiIndex{sites: g.voronoiSites, size: size, cols: (g.width + size - 1) / size, rows: (g.height + size - 1) / size}
+	v.buckets = make([][]int, v.cols*v.rows)
+	for i, site := range v.sites {
+		b := (site.Y/size)*v.cols + site.X/size
+		v.buckets[b] = append(v.buckets[b], i)
+	}
+	return v
+}
+
+// regionAt returns the index of the site nearest to (x, y), ties going to
+// the lower index. It searches the buckets around the cell's own ring by
+// ring until no further ring can hold a closer site.
+func (v *voronoiIndex) regionAt(x, y int) int {
+	bx, by := x/v.size, y/v.size
+	best, bestDist := 0, math.MaxInt
+	for r := 0; ; r++ {
+		for ry := by - r; ry <= by+r; ry++ {
+			if ry < 0 || ry >= v.rows {
+				continue
+			}
+			// Inner rows of the ring only have its two side buckets.
+			step := 2 * r
+			if ry == by-r || ry == by+r {
+				step = 1
+			}
+			for rx := bx - r; rx <= bx+r; rx += step {
+				if rx < 0 || rx >= v.cols {
+					continue
+				}
+				for _, i := range v.buckets[ry*v.cols+rx] {
+					dx, dy := x-v.sites[i].X, y-v.sites[i].Y
+					if d := dx*dx + dy*dy; d < bestDist || d == bestDist && i < best {
+						best, bestDist = i, d
+					}
+				}
+			}
+		}
+		// Sites beyond ring r are more than r·size away on one axis.
+		if reach := r*v.size + 1; bestDist < reach*reach || r >= max(v.cols, v.rows) {
+			return best
+		}
+	}
+}
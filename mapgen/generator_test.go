@@ -0,0 +1,193 @@
+package mapgen
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// generate normalizes req and generates it, failing the test on any error.
+func generate(t testing.TB, req Request) Result {
+	t.Helper()
+	params, err := req.Normalize()
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	result, err := Generate(params, nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	return result
+}
+
+// centerColumns returns the sorted center columns of the placements in
+// layout.
+func centerColumns(layout []Placement) []int {
+	xs := make([]int, len(layout))
+	for i, pl := range layout {
+		xs[i] = pl.X + pl.W/2
+	}
+	sort.Ints(xs)
+	return xs
+}
+
+func TestEllipseRingsSpanWideCanvas(t *testing.T) {
+	const width, height = 400, 100
+	ringEnd := 1.0
+	for _, tt := range []struct {
+		shape string
+		// minSpan and maxSpan bound the columns between the 10th and 90th
+		// percentile of placement centers as a fraction of the canvas width.
+		// The percentiles leave out most of the placements merkez scatters
+		// at random outside the rings.
+		minSpan, maxSpan float64
+	}{
+		// Circular rings are sized by the short side: 100 of 400 columns.
+		{ringShapeCircle, 0, 0.3},
+		{ringShapeEllipse, 0.55, 1},
+	} {
+		t.Run(tt.shape, func(t *testing.T) {
+			result := generate(t, Request{W: width, H: height, Tiles: "2x2*1500", Format: "json",
+				RingShape: tt.shape, RingEnd: &ringEnd, RingBias: ringBiasUniform, Seed: "ellipse"})
+			xs := centerColumns(result.Layout)
+			lo, hi := xs[len(xs)/10], xs[len(xs)*9/10]
+			span := float64(hi-lo) / width
+			if span < tt.minSpan || span > tt.maxSpan {
+				t.Errorf("placement centers span columns %d..%d (%.2f of the width), want %.2f..%.2f", lo, hi, span, tt.minSpan, tt.maxSpan)
+			}
+			for _, pl := range result.Layout {
+				if pl.X < 0 || pl.Y < 0 || pl.X+pl.W > width || pl.Y+pl.H > height {
+					t.Fatalf("placement %+v leaves the %dx%d canvas", pl, width, height)
+				}
+			}
+		})
+	}
+}
+
+func TestEllipseRingsMatchCircleOnSquareCanvas(t *testing.T) {
+	req := Request{W: 120, H: 120, Tiles: "2x2*400,1x1*200", Seed: "square"}
+	circle := generate(t, req)
+	req.RingShape = ringShapeEllipse
+	ellipse := generate(t, req)
+	if string(circle.Data) != string(ellipse.Data) {
+		t.Error("ellipse rings differ from circles on a square canvas")
+	}
+}
+
+func TestAreaCorrectAnnulusOccupancy(t *testing.T) {
+	const size, samples = 1000, 40000
+	for _, tt := range []struct {
+		areaCorrect bool
+		// wantInner is the expected share of each ring's placements in the
+		// inner half of its radius range, for rings [0, 0.5] and [0.5, 1].
+		wantInner [2]float64
+	}{
+		// Uniform radii split each ring evenly by radius.
+		{false, [2]float64{0.5, 0.5}},
+		// Area-correct radii split it by area: 0.25²/0.5² of the disc and
+		// (0.75²-0.5²)/(1-0.5²) of the annulus.
+		{true, [2]float64{0.25, 5.0 / 12}},
+	} {
+		g := newGenerator(size, size, "merkez", 2, 0.5, 1, 0, 0, rand.New(rand.NewSource(7)))
+		g.areaCorrect = tt.areaCorrect
+		var inner, total [2]int
+		for i := 0; i < samples; i++ {
+			g.lastSegment = -1
+			x, y := g.positionMerkez(1, 1)
+			segment := g.lastSegment
+			if segment < 0 {
+				continue
+			}
+			r := math.Hypot(float64(x)-size/2, float64(y)-size/2) / (size / 2)
+			lo, hi := g.ringBoundaries[segment], g.ringBoundaries[segment+1]
+			total[segment]++
+			if r < (lo+hi)/2 {
+				inner[segment]++
+			}
+		}
+		for segment := range total {
+			if total[segment] < samples/10 {
+				t.Fatalf("areaCorrect=%v: ring %d got only %d placements", tt.areaCorrect, segment, total[segment])
+			}
+			got := float64(inner[segment]) / float64(total[segment])
+			if math.Abs(got-tt.wantInner[segment]) > 0.02 {
+				t.Errorf("areaCorrect=%v: ring %d has %.3f of its placements in its inner half, want %.3f",
+					tt.areaCorrect, segment, got, tt.wantInner[segment])
+			}
+		}
+	}
+}
+
+// TestPreciseCOMIsCloser records millions of placements on a huge canvas,
+// far enough from the origin that the plain sums pass 2^53 and start
+// rounding, and checks that the compensated sums land closer to the true
+// center of mass, computed exactly in integers.
+func TestPreciseCOMIsCloser(t *testing.T) {
+	const size, placements = 1 << 22, 3_000_000
+	plain := newGenerator(size, size, "agirlik", 0, 0, 0, 0, 0, nil)
+	precise := newGenerator(size, size, "agirlik", 0, 0, 0, 0, 0, nil)
+	precise.preciseCOM = true
+
+	rnd := rand.New(rand.NewSource(1))
+	// The doubled centers times the areas are integers; their sums overflow
+	// int64 only past 2^63, far beyond these.
+	var area, twiceSumX int64
+	for i := 0; i < placements; i++ {
+		tw, th := 1+2*rnd.Intn(32), 1+2*rnd.Intn(32)
+		x, y := size-size/8+rnd.Intn(size/16), rnd.Intn(size-th)
+		plain.recordPlacement(x, y, tw, th)
+		precise.recordPlacement(x, y, tw, th)
+		a := int64(tw * th)
+		area += a
+		twiceSumX += (2*int64(x) + int64(tw)) * a
+	}
+	want := float64(twiceSumX/area)/2 + float64(twiceSumX%area)/float64(2*area)
+
+	plainX, _, _ := plain.attractors[0].centerOfMass()
+	preciseX, _, _ := precise.attractors[0].centerOfMass()
+	plainErr, preciseErr := math.Abs(plainX-want), math.Abs(preciseX-want)
+	if !(preciseErr < plainErr) {
+		t.Errorf("center of mass off by %g with preciseCOM and %g without, want preciseCOM closer", preciseErr, plainErr)
+	}
+	if preciseErr > 1e-6 {
+		t.Errorf("preciseCOM center of mass off by %g", preciseErr)
+	}
+}
+
+func TestVoronoiIndexFindsNearestSite(t *testing.T) {
+	for _, tt := range []struct {
+		width, height, sites int
+	}{{1, 1, 1}, {37, 23, 1}, {37, 23, 5}, {64, 64, 200}, {200, 3, 40}, {50, 50, maxIslands}} {
+		rnd := rand.New(rand.NewSource(int64(tt.sites)))
+		g := &generator{width: tt.width, height: tt.height}
+		for i := 0; i < tt.sites; i++ {
+			g.voronoiSites = append(g.voronoiSites, image.Point{X: rnd.Intn(tt.width), Y: rnd.Intn(tt.height)})
+		}
+		regions := g.voronoiRegions()
+		for y := 0; y < tt.height; y++ {
+			for x := 0; x < tt.width; x++ {
+				want, wantDist := 0, math.MaxInt
+				for i, site := range g.voronoiSites {
+					dx, dy := x-site.X, y-site.Y
+					if d := dx*dx + dy*dy; d < wantDist {
+						want, wantDist = i, d
+					}
+				}
+				if got := regions[y*tt.width+x]; got != want {
+					t.Fatalf("%dx%d with %d sites: cell (%d,%d) in region %d, want %d", tt.width, tt.height, tt.sites, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestIslandsBounded(t *testing.T) {
+	for _, n := range []int{maxIslands, maxIslands + 1} {
+		_, err := (&Request{W: 40, H: 40, Tiles: "1x1*10", Mode: "voronoi", Islands: &n}).Normalize()
+		if ok := n <= maxIslands; (err == nil) != ok {
+			t.Errorf("islands %d: err = %v, want accepted %v", n, err, ok)
+		}
+	}
+}
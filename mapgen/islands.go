@@ -0,0 +1,117 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Island size distributions: how mode adalar shares placements among its
+// islands.
+const (
+	islandSizeEqual = "equal"
+	islandSizePower = "power"
+)
+
+// maxIslands bounds islands: adalar reports the share of every island and
+// voronoi gives every site a region.
+const maxIslands = 1024
+
+// IslandShare reports the planned share of one adalar island and the
+// placements it received.
+type IslandShare struct {
+	Island     int     `json:"island"`
+	Share      float64 `json:"share"`
+	Placements int     `json:"placements"`
+}
+
+// normalizeIslandSizes validates islandSizeDistribution and its exponent.
+func normalizeIslandSizes(req *Request, p *Params) error {
+	p.IslandSizeDistribution = strings.ToLower(strings.TrimSpace(req.IslandSizeDistribution))
+	switch p.IslandSizeDistribution {
+	case "":
+		p.IslandSizeDistribution = islandSizeEqual
+	case islandSizeEqual:
+	case islandSizePower:
+		if !p.placesMode("adalar") {
+			return fmt.Errorf("islandSizeDistribution %q requires mode adalar", islandSizePower)
+		}
+	default:
+		return fmt.Errorf("unsupported islandSizeDistribution %q", req.IslandSizeDistribution)
+	}
+	if req.IslandSizeExponent == nil {
+		p.IslandSizeExponent = 1
+		return nil
+	}
+	if p.IslandSizeDistribution != islandSizePower {
+		return fmt.Errorf("islandSizeExponent requires islandSizeDistribution %q", islandSizePower)
+	}
+	if !(*req.IslandSizeExponent > 0) || math.IsInf(*req.IslandSizeExponent, 0) {
+		return errors.New("islandSizeExponent must be positive")
+	}
+	p.IslandSizeExponent = *req.IslandSizeExponent
+	return nil
+}
+
+// setIslandSizes plans the share of every island. Equal islands are picked
+// uniformly as before; power islands get Zipf-like shares proportional to
+// 1/(i+1)^exponent, so island 0 is the largest, and a radius growing with
+// the square root of their share so their area follows it. An island with
+// the mean share keeps the islandRFrac radius.
+func (g *generator) setIslandSizes(distribution string, exponent float64) {
+	n := len(g.islandCenters)
+	g.islandShares = make([]float64, n)
+	g.islandCounts = make([]int, n)
+	if distribution != islandSizePower {
+		for i := range g.islandShares {
+			g.islandShares[i] = 1 / float64(n)
+		}
+		return
+	}
+	total := 0.0
+	for i := range g.islandShares {
+		g.islandShares[i] = math.Pow(float64(i+1), -exponent)
+		total += g.islandShares[i]
+	}
+	g.islandCumulative = make([]float64, n)
+	g.islandScales = make([]float64, n)
+	sum := 0.0
+	for i := range g.islandShares {
+		g.islandShares[i] /= total
+		sum += g.islandShares[i]
+		g.islandCumulative[i] = sum
+		g.islandScales[i] = math.Sqrt(g.islandShares[i] * float64(n))
+	}
+}
+
+// pickIsland chooses the island of the next adalar placement by its share.
+func (g *generator) pickIsland() int {
+	if g.islandCumulative == nil {
+		return g.rnd.Intn(len(g.islandCenters))
+	}
+	r := g.rnd.Float64() * g.islandCumulative[len(g.islandCumulative)-1]
+	return min(sort.SearchFloat64s(g.islandCumulative, r), len(g.islandCumulative)-1)
+}
+
+// islandScale is the radius multiplier of island i.
+func (g *generator) islandScale(i int) float64 {
+	if g.islandScales == nil {
+		return 1
+	}
+	return g.islandScales[i]
+}
+
+// islandReport lists the share and placements of every island, nil when no
+// placement is positioned by mode adalar.
+func (g *generator) islandReport() []IslandShare {
+	if g.islandCounts == nil {
+		return nil
+	}
+	report := make([]IslandShare, len(g.islandShares))
+	for i, share := range g.islandShares {
+		report[i] = IslandShare{Island: i, Share: share, Placements: g.islandCounts[i]}
+	}
+	return report
+}
@@ -0,0 +1,112 @@
+package mapgen
+
+import (
+	"image/color"
+	"math"
+)
+
+// tileJitter is the color offset one placement applies to the cells it
+// covers: hue in degrees and a relative brightness change. The zero value
+// leaves colors untouched.
+type tileJitter struct {
+	hue   float32
+	value float32
+}
+
+// maxJitterHue is the hue swing, in degrees, at colorJitter 1.
+const maxJitterHue = 60
+
+// placementJitter derives the jitter of the index-th placement from the seed
+// alone, so it never draws from the placement random stream and enabling it
+// leaves positions unchanged.
+func placementJitter(seed int64, index int, amount float64) tileJitter {
+	h := splitmix64(uint64(seed) + uint64(index)*0x9e3779b97f4a7c15)
+	// Two independent uniforms in [-1, 1) from the high and low halves.
+	u1 := float64(h>>32)/(1<<31) - 1
+	u2 := float64(h&0xffffffff)/(1<<31) - 1
+	return tileJitter{hue: float32(u1 * amount * maxJitterHue), value: float32(u2 * amount)}
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// jitterColor shifts the hue and scales the brightness of a premultiplied
+// color, keeping its alpha.
+func jitterColor(c color.RGBA, j tileJitter) color.RGBA {
+	if j == (tileJitter{}) || c.A == 0 {
+		return c
+	}
+	a := float64(c.A) / 255
+	hue, sat, val := rgbToHSV(float64(c.R)/255/a, float64(c.G)/255/a, float64(c.B)/255/a)
+	out := hsvColor(hue+float64(j.hue), sat, clampFloat(val*(1+float64(j.value)), 0, 1))
+	return color.RGBA{
+		R: uint8(math.Round(float64(out.R) * a)),
+		G: uint8(math.Round(float64(out.G) * a)),
+		B: uint8(math.Round(float64(out.B) * a)),
+		A: c.A,
+	}
+}
+
+// rgbToHSV converts components in [0, 1] to hue in degrees [0, 360),
+// saturation and value, the inverse of hsvColor.
+func rgbToHSV(r, g, b float64) (float64, float64, float64) {
+	maxC := math.Max(r, math.Max(g, b))
+	minC := math.Min(r, math.Min(g, b))
+	delta := maxC - minC
+
+	var hue float64
+	switch {
+	case delta == 0:
+		hue = 0
+	case maxC == r:
+		hue = 60 * math.Mod((g-b)/delta, 6)
+	case maxC == g:
+		hue = 60 * ((b-r)/delta + 2)
+	default:
+		hue = 60 * ((r-g)/delta + 4)
+	}
+	if hue < 0 {
+		hue += 360
+	}
+	sat := 0.0
+	if maxC > 0 {
+		sat = delta / maxC
+	}
+	return hue, sat, maxC
+}
+
+// downsampleJitter averages the jitter of the covered cells in each 2x2 block,
+// matching downsampleCoverage.
+func downsampleJitter(jitter []tileJitter, coverage []float64, width, height int) []tileJitter {
+	outW, outH := (width+1)/2, (height+1)/2
+	out := make([]tileJitter, outW*outH)
+	for y := 0; y < outH; y++ {
+		for x := 0; x < outW; x++ {
+			var hue, value float32
+			cells := 0
+			for dy := 0; dy < 2; dy++ {
+				sy := 2*y + dy
+				if sy >= height {
+					continue
+				}
+				for dx := 0; dx < 2; dx++ {
+					sx := 2*x + dx
+					if sx >= width || coverage[sy*width+sx] <= 0 {
+						continue
+					}
+					hue += jitter[sy*width+sx].hue
+					value += jitter[sy*width+sx].value
+					cells++
+				}
+			}
+			if cells > 0 {
+				out[y*outW+x] = tileJitter{hue: hue / float32(cells), value: value / float32(cells)}
+			}
+		}
+	}
+	return out
+}
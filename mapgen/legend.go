@@ -0,0 +1,79 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+// normalizeLegend validates legend. The strip is drawn below one finished
+// image, so like the overlay it needs a single image in a raster format.
+func normalizeLegend(req *Request, p *Params) error {
+	if req.Legend == nil || !*req.Legend {
+		return nil
+	}
+	switch {
+	case p.Format != formatPNG && p.Format != formatWebP:
+		return fmt.Errorf("legend requires format %q or %q", formatPNG, formatWebP)
+	case p.Pyramid > 0 || len(p.Sizes) > 0:
+		return errors.New("legend does not support pyramid or sizes")
+	case p.Animate != "":
+		return errors.New("legend cannot be animated")
+	case p.Invert:
+		return errors.New("legend does not support invert, which paints land transparent")
+	}
+	p.Legend = true
+	return nil
+}
+
+// appendLegend returns img with a strip below it holding a labeled swatch for
+// coverage levels 1 through brownCap, colored the way cellColor colors a cell
+// of that coverage outside any region. When the levels do not all fit the
+// width, evenly spaced ones including both ends are shown.
+func appendLegend(img *image.RGBA, p Params) *image.RGBA {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	scale := min(max(width/320, 1), 8)
+	pad := 2 * scale
+	swatchHeight := 8 * scale
+	strip := pad + swatchHeight + pad + GlyphHeight*scale + pad
+
+	top := int(math.Max(1, math.Ceil(p.brownCap())))
+	slot := TextWidth(strconv.Itoa(top), scale) + 2*pad
+	count := max(1, min(top, width/slot))
+
+	out := newCanvas(width, height+strip, p)
+	draw.Draw(out, img.Bounds(), img, image.Point{}, draw.Src)
+	ink := legendInk(p)
+	for i := 0; i < count; i++ {
+		level := 1
+		if count > 1 {
+			level = 1 + int(math.Round(float64(i*(top-1))/float64(count-1)))
+		}
+		x0, x1 := i*width/count, (i+1)*width/count
+		swatch := image.Rect(x0+pad/2, height+pad, x1-pad/2, height+pad+swatchHeight)
+		c := cellColor(float64(level), -1, 0, tileJitter{}, p)
+		draw.Draw(out, swatch, &image.Uniform{C: c}, image.Point{}, draw.Src)
+
+		label := strconv.Itoa(level)
+		DrawText(out, (x0+x1-TextWidth(label, scale))/2, swatch.Max.Y+pad, label, scale, ink)
+	}
+	return out
+}
+
+// legendInk is the label color: dark over light or transparent water, light
+// over dark water.
+func legendInk(p Params) color.RGBA {
+	water := defaultWater
+	if p.WaterColor != nil {
+		water = *p.WaterColor
+	}
+	luma := 0.299*float64(water.R) + 0.587*float64(water.G) + 0.114*float64(water.B)
+	if p.BgAlpha >= 128 && luma < 128 {
+		return color.RGBA{R: 0xee, G: 0xee, B: 0xee, A: 0xff}
+	}
+	return color.RGBA{R: 0x22, G: 0x22, B: 0x22, A: 0xff}
+}
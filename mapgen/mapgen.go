@@ -0,0 +1,883 @@
+// Package mapgen places weighted tile batches on a canvas and renders the
+// resulting coverage as a PNG density map.
+package mapgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	formatPNG  = "png"
+	formatJSON = "json"
+	// formatReplay is a gzipped Replay document, see ParseReplay.
+	formatReplay = "replay"
+	// formatWebP is a VP8L WebP image, see encodeWebP.
+	formatWebP = "webp"
+	// formatCSV lists the final coverage of the covered cells, see encodeCSV.
+	formatCSV = "csv"
+	// formatASCII shades a downsampled grid with characters, see encodeASCII.
+	formatASCII = "ascii"
+	// formatMaskRLE run-length encodes the land mask, see encodeMaskRLE.
+	formatMaskRLE = "mask-rle"
+)
+
+// Result holds the encoded output and the placement statistics of a single
+// generation.
+type Result struct {
+	Data            []byte
+	ContentType     string
+	Width           int
+	Height          int
+	Mode            string
+	Batches         int
+	TotalPlacements int
+	Seed            int64
+	Scale           float64
+	Tiles           []TileScaling
+	// Layout lists every painted placement. It is only collected for formats
+	// that export coordinates.
+	Layout []Placement
+	// Palette is the land, peak and water colors the map was painted with.
+	Palette Palette
+	// ModeMix reports the placements each mode of a modeMix positioned; nil
+	// outside mode karma.
+	ModeMix []ModeCount
+	// Islands reports the planned share and placements of every island of
+	// mode adalar; nil when no placement is positioned by adalar.
+	Islands []IslandShare
+	// Preview marks an approximate render, see Request.Preview.
+	Preview bool
+	// Saturation is the fraction of covered cells whose coverage reached
+	// brownCap, averaged over frames for animations. Format "replay" paints
+	// nothing and reports 0.
+	Saturation float64
+	// Warnings lists options that could not be applied as asked, such as a
+	// tilePadding wider than a tile.
+	Warnings []string
+	// Partial marks a map on which at least one tile spec placed none of its
+	// tiles; Tiles and Warnings say which and why.
+	Partial bool
+	// LandBounds is the bounding box of the covered cells in canvas pixels,
+	// empty when nothing is covered or the map is animated.
+	LandBounds image.Rectangle
+	// Balance describes how the covered cells spread over the canvas; nil
+	// when nothing is covered, for previews and for animations.
+	Balance *LandBalance
+	// Crop is the window of the canvas that autoCrop kept; its top-left
+	// corner maps output pixels back onto the canvas. Empty without autoCrop.
+	Crop image.Rectangle
+	// RequestedArea is the cell area the batches asked for, Σ count×W×H.
+	RequestedArea int
+	// LandCells counts the cells placement painted at least once, before
+	// morphology and clipping. Previews paint fractional cells and leave it 0.
+	LandCells int
+	// OverlapFactor is RequestedArea per land cell, 1 without any overlap
+	// and 0 when nothing was counted.
+	OverlapFactor float64
+}
+
+// Placement is one painted tile rectangle in canvas pixels. GX and GY are its
+// lattice coordinates and are only set when snapping to a lattice. ID is only
+// set with tileIds, see tileID.
+type Placement struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	W    int    `json:"w"`
+	H    int    `json:"h"`
+	GX   *int   `json:"gx,omitempty"`
+	GY   *int   `json:"gy,omitempty"`
+}
+
+// WriteTo writes the encoded output to w, so a Result can be piped straight
+// into files, HTTP responses or stdout.
+func (r *Result) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(r.Data)
+	return int64(n), err
+}
+
+// metadata is the document returned for format "json".
+type metadata struct {
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	Mode       string        `json:"mode"`
+	Seed       int64         `json:"seed"`
+	Batches    int           `json:"batches"`
+	Placements int           `json:"placements"`
+	Scale      float64       `json:"scale"`
+	Tiles      []TileScaling `json:"tiles"`
+	Layout     []Placement   `json:"layout"`
+	ModeMix    []ModeCount   `json:"modeMix,omitempty"`
+	Islands    []IslandShare `json:"islands,omitempty"`
+	Warnings   []string      `json:"warnings,omitempty"`
+	Partial    bool          `json:"partial,omitempty"`
+	LandBounds *canvasRect   `json:"landBounds,omitempty"`
+	Balance    *LandBalance  `json:"balance,omitempty"`
+	Crop       *canvasRect   `json:"crop,omitempty"`
+	// The overlap statistics are omitted for mode magara, which places no
+	// tiles, and landCells for previews.
+	RequestedArea int     `json:"requestedArea,omitempty"`
+	LandCells     int     `json:"landCells,omitempty"`
+	OverlapFactor float64 `json:"overlapFactor,omitempty"`
+}
+
+func (r *Result) metadata() metadata {
+	return metadata{
+		Width:      r.Width,
+		Height:     r.Height,
+		Mode:       r.Mode,
+		Seed:       r.Seed,
+		Batches:    r.Batches,
+		Placements: r.TotalPlacements,
+		Scale:      r.Scale,
+		Tiles:      r.Tiles,
+		Layout:     r.Layout,
+		ModeMix:    r.ModeMix,
+		Islands:    r.Islands,
+		Warnings:   r.Warnings,
+		Partial:    r.Partial,
+		LandBounds: toCanvasRect(r.LandBounds),
+		Balance:    r.Balance,
+		Crop:       toCanvasRect(r.Crop),
+
+		RequestedArea: r.RequestedArea,
+		LandCells:     r.LandCells,
+		OverlapFactor: r.OverlapFactor,
+	}
+}
+
+// Generate runs tile placement and coloring for the normalized parameters.
+// Stage timings are appended to stats when it is non-nil.
+func Generate(p Params, stats *Stats) (Result, error) {
+	return GenerateContext(context.Background(), p, stats)
+}
+
+// GenerateContext is Generate stopped once ctx is done. The context is
+// checked between stages and periodically during tile placement; when it
+// stops the map, the error is a *DeadlineError. Animations, replays and mode
+// magara are only checked before placement starts.
+func GenerateContext(ctx context.Context, p Params, stats *Stats) (Result, error) {
+	stageStart := time.Now()
+	seed := paramsSeed(p)
+	batches, scaling, scale, err := planTiles(&p, seed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	palette := resolvePalette(&p, seed)
+	warnings := paddingWarnings(p, batches)
+
+	if p.Animate == animateDrift {
+		stats.track(StagePlan, stageStart)
+		if err := interrupted(ctx, StagePlan, 0); err != nil {
+			return Result{}, err
+		}
+		result, err := generateDrift(p, batches, scaling, scale, seed, stats)
+		result.Palette = palette
+		result.Warnings = warnings
+		return result, err
+	}
+
+	rnd, gen := newSeededGenerator(p, seed)
+	warnings = append(warnings, petekWarnings(gen, batches)...)
+	stats.track(StagePlan, stageStart)
+	if err := interrupted(ctx, StagePlan, 0); err != nil {
+		return Result{}, err
+	}
+	gen.ctx = ctx
+
+	if p.Format == formatReplay {
+		result, err := generateReplay(p, batches, scaling, scale, seed, rnd, gen, stats)
+		result.Palette = palette
+		result.Warnings, result.Partial = reportSkips(scaling, gen.skips, append(warnings, petekClampWarnings(gen)...))
+		if err == nil && p.Strict && result.Partial {
+			return Result{}, &PartialError{Tiles: scaling, Warnings: result.Warnings}
+		}
+		return result, err
+	}
+
+	f := renderMap(p, batches, rnd, gen, stats)
+	if gen.ctxErr != nil {
+		return Result{}, &DeadlineError{Stage: StagePlacement, Placements: gen.placed, Err: gen.ctxErr}
+	}
+	if err := interrupted(ctx, StageColoring, gen.placed); err != nil {
+		return Result{}, err
+	}
+	if p.Render == renderRadialOverlay {
+		drawRadialOverlay(f.img, p, gen)
+	}
+	if p.Antialias {
+		aaStart := time.Now()
+		antialiasCoast(f.img, f.coverage, p.AAFactor)
+		stats.track(StageColoring, aaStart)
+	}
+	if p.DrawStats {
+		drawBalance(f.img, f.balance)
+	}
+	stageStart = time.Now()
+
+	warnings, partial := reportSkips(scaling, gen.skips, append(warnings, petekClampWarnings(gen)...))
+	if p.Strict && partial {
+		return Result{}, &PartialError{Tiles: scaling, Warnings: warnings}
+	}
+	window, crop, warnings := cropFrame(&f, p, warnings)
+	if p.Legend {
+		p.brownLimit = f.brownLimit
+		f.img = appendLegend(f.img, p)
+	}
+
+	result := Result{
+		ContentType:     "image/png",
+		Width:           window.Dx(),
+		Height:          window.Dy(),
+		Mode:            p.Mode,
+		Batches:         len(batches),
+		TotalPlacements: f.placements,
+		Seed:            seed,
+		Scale:           scale,
+		Tiles:           scaling,
+		Layout:          f.layout,
+		ModeMix:         gen.modeCounts(),
+		Islands:         gen.islandReport(),
+		Palette:         palette,
+		Saturation:      f.saturation,
+		Preview:         p.Preview,
+		Warnings:        warnings,
+		Partial:         partial,
+		LandBounds:      f.bounds,
+		Balance:         f.balance,
+		Crop:            crop,
+		RequestedArea:   requestedArea(batches),
+		LandCells:       f.landCells,
+	}
+	result.OverlapFactor = overlapFactor(result.RequestedArea, result.LandCells)
+	if p.Legend {
+		result.Height = f.img.Bounds().Dy()
+	}
+
+	switch p.Format {
+	case formatJSON:
+		data, err := json.Marshal(result.metadata())
+		if err != nil {
+			return Result{}, fmt.Errorf("encode json: %w", err)
+		}
+		result.Data = data
+		result.ContentType = "application/json"
+	case formatWebP:
+		result.Data, err = encodeWebP(f.img, p.WebpLossless, p.WebpQuality)
+		if err != nil {
+			return Result{}, err
+		}
+		result.ContentType = "image/webp"
+	case formatCSV:
+		result.Data = encodeCSV(f.coverage, p.Width, window)
+		result.ContentType = "text/csv"
+	case formatASCII:
+		p.brownLimit = f.brownLimit
+		result.Data = encodeASCII(f.coverage, p.Width, window, p)
+		result.ContentType = "text/plain; charset=utf-8"
+	case formatMaskRLE:
+		result.Data, result.ContentType, err = encodeMaskRLE(f.coverage, p.Width, window, seed, p.MaskThreshold, p.MaskGzip)
+		if err != nil {
+			return Result{}, err
+		}
+	default:
+		result.Data, result.ContentType, err = encodeImage(f, p, seed)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	stats.track(StageEncoding, stageStart)
+
+	return result, nil
+}
+
+// encodeImage encodes a rendered frame as PNG, or as a packed pyramid of
+// progressively halved levels, set of sizes or set of palette variants when p
+// asks for one.
+func encodeImage(f frame, p Params, seed int64) ([]byte, string, error) {
+	if len(p.Palettes) > 0 {
+		p.brownLimit = f.brownLimit
+		files, err := renderPalettes(f, p)
+		if err != nil {
+			return nil, "", err
+		}
+		return packPyramid(files, p.PyramidFormat, seed)
+	}
+	level := png.DefaultCompression
+	if p.Preview {
+		level = png.BestSpeed
+	}
+	data, err := encodePNG(f.img, p.Dpi, level)
+	if err != nil {
+		return nil, "", err
+	}
+	p.brownLimit = f.brownLimit
+	if len(p.Sizes) > 0 {
+		files, err := renderSizes(f, p, data)
+		if err != nil {
+			return nil, "", err
+		}
+		return packPyramid(files, p.PyramidFormat, seed)
+	}
+	if p.Pyramid == 0 {
+		return data, "image/png", nil
+	}
+	levels, err := renderPyramid(f.coverage, f.ringOf, f.segments, f.jitter, p, p.Pyramid)
+	if err != nil {
+		return nil, "", err
+	}
+	levels = append([]pyramidLevel{{name: pyramidFileName(0), data: data}}, levels...)
+	return packPyramid(levels, p.PyramidFormat, seed)
+}
+
+// checkPlanned rejects specs that would finalize to more than limit
+// placements; zero is unlimited. The total is the one finalizeTileBatches
+// reaches, taken in floating point first: counts scaled by ka can exceed what
+// its integer batches hold.
+func checkPlanned(specs []tileSpec, capLimit, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	planned := 0.0
+	for _, s := range specs {
+		planned += math.Max(s.Count, 0)
+	}
+	planned = math.Round(planned)
+	if capLimit > 0 {
+		planned = math.Min(planned, float64(capLimit))
+	}
+	if planned > float64(limit) {
+		return fmt.Errorf("%.0f planned placements exceed the limit of %d", planned, limit)
+	}
+	return nil
+}
+
+// planTiles plans the tile batches of p, see planBatches. Mode magara grows
+// its landmass with a cellular automaton and has no tiles to plan.
+func planTiles(p *Params, seed int64) ([]tileBatch, []TileScaling, float64, error) {
+	if p.Mode == "magara" {
+		return nil, nil, 1, nil
+	}
+	return planBatches(p, seed)
+}
+
+// planBatches turns the tile parameters into integer batches and applies
+// autoFit to p. When nothing can be placed it explains which step emptied the
+// plan, unless p.AllowEmpty asks for a background-only map, in which case the
+// batches are empty and the scaling report is kept.
+func planBatches(p *Params, seed int64) ([]tileBatch, []TileScaling, float64, error) {
+	specs, err := buildTileSpecs(p.TileString, p.TileList)
+	if err != nil && !(errors.Is(err, errNoTileDefinitions) && p.AllowEmpty) {
+		return nil, nil, 0, err
+	}
+
+	specs = resolveDensities(specs, p.Width*p.Height)
+	specs = mergeTileSpecs(resolveCountRanges(applyLegacyTiles(specs, p.N22, p.N21, p.N11), seed))
+	if len(specs) == 0 {
+		if p.AllowEmpty {
+			return nil, nil, 1, nil
+		}
+		return nil, nil, 0, errNoTileDefinitions
+	}
+
+	requested := 0.0
+	for _, s := range specs {
+		requested += s.Count
+	}
+	activateMultiplier(specs, p.Ka)
+	if err := checkPlanned(specs, p.Cap, p.MaxPlacements); err != nil {
+		return nil, nil, 0, err
+	}
+	batches, scaling, scale := finalizeTileBatches(specs, p.Cap, p.CapPolicy)
+	if p.PlaceLargestFirst {
+		sortLargestFirst(batches)
+	}
+
+	if p.AutoFit {
+		p.Width, p.Height = fitCanvas(*p, batches)
+	}
+	if err := checkRegions(*p, batches); err != nil {
+		return nil, nil, 0, err
+	}
+
+	fits := false
+	for _, b := range batches {
+		if tileFits(*p, b) {
+			fits = true
+			break
+		}
+	}
+	if fits {
+		return batches, scaling, scale, nil
+	}
+	if p.AllowEmpty {
+		return nil, scaling, scale, nil
+	}
+
+	if len(batches) > 0 {
+		return nil, nil, 0, fmt.Errorf("no tiles to place: every tile is larger than the %dx%d canvas", p.Width, p.Height)
+	}
+	scaled := 0.0
+	for _, s := range specs {
+		scaled += s.Count
+	}
+	// The tile list itself was valid, so the counts were scaled or rounded
+	// away; name the step and the knob that brings them back.
+	if scaled != requested {
+		return nil, nil, 0, fmt.Errorf("no tiles to place: ka %g scaled %g requested tiles down to %.3g, which rounds to zero; raise ka", p.Ka, requested, scaled)
+	}
+	if scale < 1 {
+		return nil, nil, 0, fmt.Errorf("no tiles to place: cap %d is too small to place any tile (scale factor %.3g of %g requested tiles); raise cap or ka", p.Cap, scale, requested)
+	}
+	return nil, nil, 0, fmt.Errorf("no tiles to place: %g requested tiles round to zero placements; raise the counts or ka", requested)
+}
+
+// tileFits reports whether b fits on the canvas in at least one orientation it
+// may be drawn in.
+func tileFits(p Params, b tileBatch) bool {
+	if b.W <= p.Width && b.H <= p.Height {
+		return true
+	}
+	return p.Rotate && b.H <= p.Width && b.W <= p.Height
+}
+
+// newSeededGenerator returns the random source and generator for one
+// placement pass; passes built from the same seed are identical.
+func newSeededGenerator(p Params, seed int64) (*rand.Rand, *generator) {
+	rnd := rand.New(rand.NewSource(seed))
+	gen := newGenerator(p.Width, p.Height, p.Mode, p.Rings, p.RingStart, p.RingEnd, p.Islands, p.IslandRFrac, rnd)
+	gen.ringShape = p.RingShape
+	gen.areaCorrect = p.AreaCorrect
+	gen.seed = seed
+	gen.snap = p.Snap
+	gen.islandAspect, gen.islandFill = p.IslandAspect, p.IslandFill
+	gen.preview = p.Preview
+	gen.anchor, gen.anchorFrac = p.Anchor, p.AnchorFrac
+	gen.ringBias = p.RingBias
+	if len(p.AngleBias) > 0 {
+		gen.setAngleBias(p.AngleBias)
+	}
+	gen.aspectWeight = p.AgirlikAspectWeight
+	gen.preciseCOM = p.PreciseCOM
+	gen.mask, gen.maskFit = p.PolygonMask, p.PolygonMaskFit
+	if p.placesMode(modeBolge) {
+		gen.setRegionWeights(p.RegionWeights)
+	}
+	if p.placesMode(modePetek) {
+		gen.setHexLattice(p.HexPitch, p.HexFalloff, p.OneTilePerSite)
+	}
+	if p.PreferVirgin > 0 {
+		gen.virgin, gen.preferVirgin = newVirginGrid(p.Width, p.Height), p.PreferVirgin
+	}
+	if len(p.ModeMix) > 0 {
+		gen.setModeMix(p.ModeMix)
+	}
+	if len(gen.islandCenters) > 0 {
+		gen.setIslandSizes(p.IslandSizeDistribution, p.IslandSizeExponent)
+	}
+	if len(p.Targets) > 0 {
+		gen.setTargets(p.Targets)
+	}
+	if p.Density != nil {
+		gen.density = newDensityMap(p.Density, p.Width, p.Height, p.DensityStrict)
+		gen.densityStrict = p.DensityStrict
+	}
+	return rnd, gen
+}
+
+// frame is the outcome of one placement and coloring pass.
+type frame struct {
+	img      *image.RGBA
+	coverage []float64
+	// ringOf holds the region (merkez ring or voronoi site) of each cell and
+	// is nil when cells are not colored by region; segments counts regions.
+	ringOf   []int
+	segments int
+	// jitter holds the color offset of each cell, nil without colorJitter.
+	jitter []tileJitter
+	// brownLimit is the percentile-derived brownCap, 0 without brownPercentile.
+	brownLimit float64
+	// saturation is the fraction of covered cells at or above brownCap.
+	saturation float64
+	// bounds is the bounding box of the covered cells.
+	bounds image.Rectangle
+	// balance describes the spread of the covered cells; nil without land
+	// and for previews.
+	balance    *LandBalance
+	layout     []Placement
+	placements int
+	// landCells counts the cells placement turned from empty to land, before
+	// morphology and clipping change the grid.
+	landCells int
+}
+
+// placeTiles runs the placement pass and calls visit for every tile that fits
+// on the canvas, with its weight and merkez ring. It returns the number of
+// placements requested by the batches, including tiles that did not fit.
+// With a decay other than 1 each weight is scaled by its placement order, see
+// decayFactor.
+func placeTiles(p Params, batches []tileBatch, rnd *rand.Rand, gen *generator, visit func(pl Placement, weight float64, segment int)) int {
+	planned := 0
+	for _, batch := range batches {
+		planned += batch.Count
+	}
+
+	total, order := 0, 0
+	// idBase numbers the tiles of batches sharing dimensions on from each
+	// other, so tileIDs stay unique.
+	idBase := map[[2]int]int{}
+	for _, batch := range batches {
+		gen.beginBatch()
+		total += batch.Count
+		base := idBase[[2]int{batch.W, batch.H}]
+		idBase[[2]int{batch.W, batch.H}] += batch.Count
+		for i := 0; i < batch.Count; i++ {
+			if gen.stopped() {
+				return total
+			}
+			factor := decayFactor(p.Decay, order, planned)
+			order++
+			tw, th := batch.W, batch.H
+			if p.StableRotateStream {
+				// Every tile draws, so rotate and square tiles leave the
+				// rest of the stream where it was.
+				if rotated := rotateTile(rnd, p.RotateProb); p.Rotate && tw != th && rotated {
+					tw, th = th, tw
+				}
+			} else if p.Rotate && tw != th && rotateTile(rnd, p.RotateProb) {
+				tw, th = th, tw
+			}
+			if tw <= 0 || th <= 0 || tw > p.Width || th > p.Height {
+				gen.skip(batch.spec, skipOversized)
+				continue
+			}
+			var x, y int
+			if batch.Region != nil {
+				area := batch.Region.rect(p.Width, p.Height)
+				if tw > area.Dx() || th > area.Dy() {
+					// A rotation that no longer fits the region is undone;
+					// checkRegions made sure the tile fits as given.
+					tw, th = batch.W, batch.H
+				}
+				var ok bool
+				if x, y, ok = gen.positionInRegion(tw, th, area); !ok {
+					gen.skip(batch.spec, skipRegion)
+					continue
+				}
+			} else {
+				x, y = gen.positionForTile(tw, th)
+			}
+			gen.recordPlacement(x, y, tw, th)
+			pl := Placement{Name: batch.Name, X: x, Y: y, W: tw, H: th}
+			if p.TileIDs {
+				pl.ID = tileID(batch.W, batch.H, base+i)
+			}
+			if p.Snap > 1 {
+				gx, gy := x/p.Snap, y/p.Snap
+				pl.GX, pl.GY = &gx, &gy
+			}
+			visit(pl, batch.Weight*factor, gen.lastSegment)
+			gen.placed++
+		}
+	}
+	return total
+}
+
+// tileID is the stable ID of the index-th tile planned with dimensions w×h,
+// such as "2x1-17". Dimensions are the planned ones, before any rotation, and
+// tiles that were skipped keep their index, so IDs depend only on the seed and
+// the tile list.
+func tileID(w, h, index int) string {
+	return fmt.Sprintf("%dx%d-%d", w, h, index)
+}
+
+// paddingWarnings names the tiles too small for tilePadding: a side shorter
+// than twice the padding keeps only its middle cell.
+func paddingWarnings(p Params, batches []tileBatch) []string {
+	if p.TilePadding <= 0 {
+		return nil
+	}
+	var warnings []string
+	seen := map[string]bool{}
+	for _, batch := range batches {
+		if min(batch.W, batch.H) > 2*p.TilePadding {
+			continue
+		}
+		warning := fmt.Sprintf("tilePadding %d is at least half of tile %q, which is painted 1 cell across", p.TilePadding, batch.Name)
+		if !seen[warning] {
+			seen[warning] = true
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
+
+// decayFactor is the weight multiplier of the order-th of planned tiles. Below
+// 1 older tiles fade: the last tile keeps its full weight and each earlier
+// one is decay times the next. Above 1 the newest fade instead, with the first
+// tile at full weight. Anchoring the heaviest tile at 1 keeps long batches
+// from overflowing; tiles that did not fit still take their turn, so the
+// factors depend only on the plan.
+func decayFactor(decay float64, order, planned int) float64 {
+	switch {
+	case decay == 1:
+		return 1
+	case decay < 1:
+		return math.Pow(decay, float64(planned-1-order))
+	default:
+		return math.Pow(1/decay, float64(order))
+	}
+}
+
+// renderMap renders the single frame of p: the cave of mode magara, a preview
+// or the full map.
+func renderMap(p Params, batches []tileBatch, rnd *rand.Rand, gen *generator, stats *Stats) frame {
+	switch {
+	case p.Mode == "magara":
+		return renderCave(p, rnd, stats)
+	case p.Preview:
+		return renderPreview(p, batches, rnd, gen, stats)
+	default:
+		return renderFrame(p, batches, rnd, gen, stats)
+	}
+}
+
+// renderFrame places every batch with gen and colors the resulting coverage.
+func renderFrame(p Params, batches []tileBatch, rnd *rand.Rand, gen *generator, stats *Stats) frame {
+	if useSparse(p) {
+		return renderSparseFrame(p, batches, rnd, gen, stats)
+	}
+	stageStart := time.Now()
+
+	coverage := make([]float64, p.Width*p.Height)
+
+	// ringOf remembers the merkez ring of the latest placement on each cell.
+	var ringOf []int
+	colorByRing := p.ColorByRing && p.placesMode("merkez")
+	if colorByRing {
+		ringOf = make([]int, len(coverage))
+		for i := range ringOf {
+			ringOf[i] = -1
+		}
+	}
+
+	// jitter remembers the color offset of the latest placement on each cell.
+	var jitter []tileJitter
+	if p.ColorJitter > 0 {
+		jitter = make([]tileJitter, len(coverage))
+	}
+
+	var layout []Placement
+	recordLayout := p.Format == formatJSON || p.Outline
+
+	index, landCells := 0, 0
+	totalPlacements := placeTiles(p, batches, rnd, gen, func(pl Placement, weight float64, segment int) {
+		if recordLayout {
+			layout = append(layout, pl)
+		}
+		x, y, tw, th := padRect(pl.X, pl.Y, pl.W, pl.H, p.TilePadding)
+		landCells += fillCoverage(coverage, p.Width, p.Height, x, y, tw, th, weight)
+		if colorByRing {
+			fillCells(ringOf, p.Width, p.Height, x, y, tw, th, segment)
+		}
+		if jitter != nil {
+			fillCells(jitter, p.Width, p.Height, x, y, tw, th, placementJitter(gen.seed, index, p.ColorJitter))
+		}
+		index++
+	})
+
+	stats.track(StagePlacement, stageStart)
+	if gen.ctxErr != nil {
+		return frame{placements: totalPlacements}
+	}
+	stageStart = time.Now()
+
+	f := colorFrame(p, gen, frame{
+		coverage:   coverage,
+		ringOf:     ringOf,
+		jitter:     jitter,
+		layout:     layout,
+		placements: totalPlacements,
+		landCells:  landCells,
+	})
+
+	stats.track(StageColoring, stageStart)
+
+	return f
+}
+
+// colorFrame applies morphology to the filled grids of f and paints them,
+// setting img, segments, brownLimit and saturation. gen only supplies voronoi sites.
+func colorFrame(p Params, gen *generator, f frame) frame {
+	coverage, ringOf := f.coverage, f.ringOf
+	if p.Erode > 0 || p.Dilate > 0 {
+		applyMorphology(coverage, p.Width, p.Height, p.Erode, p.Dilate)
+	}
+	if p.Erosion > 0 {
+		applyErosion(coverage, p.Width, p.Height, p.Erosion, p.ErosionTalus)
+	}
+	clipOcean(coverage, p.Width, p.Height, 0, 0, p)
+	clipPolygon(coverage, p.Width, p.Height, 0, 0, p)
+
+	if p.BrownPercentile > 0 {
+		var covered []float64
+		for _, c := range coverage {
+			if c > 0 {
+				covered = append(covered, c)
+			}
+		}
+		p.brownLimit = percentileCap(covered, p.BrownPercentile)
+	}
+
+	segments := p.Rings
+	if p.Mode == "voronoi" {
+		ringOf = gen.voronoiRegions()
+		segments = len(gen.voronoiSites)
+	}
+
+	img := newCanvas(p.Width, p.Height, p)
+	colorCoverage(img, coverage, ringOf, segments, f.jitter, p)
+
+	if p.Outline {
+		drawOutlines(img, f.layout, outlineColor(p), coveredIn(coverage, p.Width))
+	}
+
+	covered, saturated := countSaturated(coverage, p.brownCap())
+	f.saturation = saturationFraction(covered, saturated)
+	f.bounds = coverageBounds(coverage, p.Width)
+	f.balance = measureLand(coverage, p.Width, p.Height)
+	f.img, f.ringOf, f.segments, f.brownLimit = img, ringOf, segments, p.brownLimit
+	return f
+}
+
+// fitCanvas grows whichever dimensions fell back to the default so the largest
+// tile fits; explicitly requested sizes are never changed.
+func fitCanvas(p Params, batches []tileBatch) (int, int) {
+	width, height := p.Width, p.Height
+	for _, b := range batches {
+		needW, needH := b.W, b.H
+		if p.Rotate && b.W != b.H {
+			// Either orientation may be drawn, so both must fit.
+			needW = max(b.W, b.H)
+			needH = needW
+		}
+		if p.defaultWidth {
+			width = max(width, needW)
+		}
+		if p.defaultHeight {
+			height = max(height, needH)
+		}
+	}
+	return width, height
+}
+
+// rotateTile decides whether a non-square tile is swapped. The default 0.5
+// keeps the original Intn(2) draw so existing seeds render unchanged.
+func rotateTile(rnd *rand.Rand, prob float64) bool {
+	if prob == 0.5 {
+		return rnd.Intn(2) == 0
+	}
+	return rnd.Float64() < prob
+}
+
+// paramsSeed is the seed p generates with: its seeds parts when given,
+// otherwise its seed string.
+func paramsSeed(p Params) int64 {
+	if len(p.Seeds) > 0 {
+		return seedFromStrings(p.Seeds)
+	}
+	return seedFromString(p.Seed)
+}
+
+func seedFromString(seed string) int64 {
+	if seed == "" {
+		return time.Now().UnixNano()
+	}
+	return foldSeed(fnvOffset, seed)
+}
+
+// seedFromStrings folds each part into one running FNV hash, separated by a
+// zero byte so ("ab", "c") and ("a", "bc") differ. A single part hashes the
+// same as seedFromString.
+func seedFromStrings(parts []string) int64 {
+	h := int64(fnvOffset)
+	for i, part := range parts {
+		if i > 0 {
+			h = foldSeed(h, "\x00")
+		}
+		h = foldSeed(h, part)
+	}
+	return h
+}
+
+const (
+	fnvOffset = 1469598103934665603
+	fnvPrime  = 1099511628211
+)
+
+func foldSeed(h int64, s string) int64 {
+	for i := 0; i < len(s); i++ {
+		h ^= int64(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+func clampInt(v, minVal, maxVal int) int {
+	if v < minVal {
+		return minVal
+	}
+	if v > maxVal {
+		return maxVal
+	}
+	return v
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clampFloat(v, minVal, maxVal float64) float64 {
+	if v < minVal {
+		return minVal
+	}
+	if v > maxVal {
+		return maxVal
+	}
+	return v
+}
+
+// encodePNG encodes img, adding a pHYs chunk when dpi is positive.
+func encodePNG(img *image.RGBA, dpi int, level png.CompressionLevel) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: level}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("encode png: %w", err)
+	}
+	if dpi > 0 {
+		return withPhysChunk(buf.Bytes(), dpi), nil
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+package mapgen
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestGenerateExplainsEmptyPlan(t *testing.T) {
+	ka := 0.001
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{"oversized", Request{W: 10, H: 10, Tiles: "20x20*3"}, "every tile is larger than the 10x10 canvas"},
+		{"ka", Request{W: 10, H: 10, Tiles: "1x1*2", Ka: &ka}, "raise ka"},
+		{"rounding", Request{W: 10, H: 10, Tiles: "1x1*0.3"}, "round to zero placements"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := tt.req.Normalize()
+			if err != nil {
+				t.Fatalf("normalize: %v", err)
+			}
+			_, err = Generate(params, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := (&Request{W: 10, H: 10, Tiles: "1x1*0"}).Normalize(); !errors.Is(err, errNoTileDefinitions) {
+		t.Errorf("zero-count tile list: err = %v, want %v", err, errNoTileDefinitions)
+	}
+}
+
+func TestAllowEmptyRendersBackground(t *testing.T) {
+	allow, ka := true, 0.001
+	for _, req := range []Request{
+		{W: 10, H: 8, Tiles: "1x1*0", AllowEmpty: &allow},
+		{W: 10, H: 8, Tiles: "20x20*3", AllowEmpty: &allow},
+		{W: 10, H: 8, Tiles: "1x1*2", Ka: &ka, AllowEmpty: &allow},
+	} {
+		result := generate(t, req)
+		if result.TotalPlacements != 0 {
+			t.Errorf("tiles %q: %d placements, want 0", req.Tiles, result.TotalPlacements)
+		}
+		img, err := png.Decode(bytes.NewReader(result.Data))
+		if err != nil {
+			t.Fatalf("tiles %q: decode: %v", req.Tiles, err)
+		}
+		if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 8 {
+			t.Fatalf("tiles %q: image is %v, want 10x8", req.Tiles, b)
+		}
+		background := img.At(0, 0)
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 10; x++ {
+				if img.At(x, y) != background {
+					t.Fatalf("tiles %q: pixel (%d, %d) = %v, want the background %v", req.Tiles, x, y, img.At(x, y), background)
+				}
+			}
+		}
+	}
+}
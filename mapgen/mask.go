@@ -0,0 +1,200 @@
+package mapgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Mask-rle content types, plain and gzipped.
+const (
+	maskRLEContentType     = "application/vnd.mapgen.mask-rle"
+	maskRLEGzipContentType = "application/vnd.mapgen.mask-rle+gzip"
+)
+
+// maskRLEMagic opens every mask-rle document, followed by maskRLEVersion.
+const (
+	maskRLEMagic   = "MRLE"
+	maskRLEVersion = 1
+)
+
+// maxMaskCells bounds the cells a decoded mask may claim, 8192×8192, and
+// maxMaskRLEBytes the size of a document once ungzipped, so a small corrupt
+// or hostile input cannot ask for an enormous allocation.
+const (
+	maxMaskCells    = 1 << 26
+	maxMaskRLEBytes = 64 << 20
+)
+
+// LandMask is a decoded mask-rle document: which cells of a width×height map
+// are land, in row-major order, and the seed of the map.
+type LandMask struct {
+	Width  int
+	Height int
+	Seed   int64
+	Land   []bool
+}
+
+// At reports whether the cell at (x, y) is land; cells off the mask are water.
+func (m *LandMask) At(x, y int) bool {
+	if x < 0 || y < 0 || x >= m.Width || y >= m.Height {
+		return false
+	}
+	return m.Land[y*m.Width+x]
+}
+
+// normalizeMask validates maskThreshold and maskGzip, which only format
+// mask-rle reads.
+func normalizeMask(req *Request, p *Params) error {
+	if p.Format != formatMaskRLE {
+		switch {
+		case req.MaskThreshold != nil:
+			return fmt.Errorf("maskThreshold requires format %q", formatMaskRLE)
+		case req.MaskGzip != nil:
+			return fmt.Errorf("maskGzip requires format %q", formatMaskRLE)
+		}
+		return nil
+	}
+	if req.MaskThreshold != nil {
+		if !(*req.MaskThreshold >= 0) || math.IsInf(*req.MaskThreshold, 0) {
+			return errors.New("maskThreshold must be a non-negative number")
+		}
+		p.MaskThreshold = *req.MaskThreshold
+	}
+	if req.MaskGzip != nil {
+		p.MaskGzip = *req.MaskGzip
+	}
+	return nil
+}
+
+// encodeMaskRLE writes the land mask of the window of the width-wide coverage
+// grid: the magic "MRLE", a version byte, the window width and height as
+// uvarints and the seed as a varint, then the number of runs and the runs
+// themselves as uvarints. Runs alternate water and land in row-major order,
+// starting with water, so a mask that starts on land opens with a zero run.
+// A cell is land when its coverage is positive and at least threshold. With
+// gz the whole document is gzipped.
+func encodeMaskRLE(coverage []float64, width int, window image.Rectangle, seed int64, threshold float64, gz bool) ([]byte, string, error) {
+	var runs []uint64
+	land, run := false, uint64(0)
+	for y := window.Min.Y; y < window.Max.Y; y++ {
+		for _, c := range coverage[y*width+window.Min.X : y*width+window.Max.X] {
+			if isLand := c > 0 && c >= threshold; isLand != land {
+				runs = append(runs, run)
+				land, run = isLand, 0
+			}
+			run++
+		}
+	}
+	runs = append(runs, run)
+
+	data := append([]byte(maskRLEMagic), maskRLEVersion)
+	data = binary.AppendUvarint(data, uint64(window.Dx()))
+	data = binary.AppendUvarint(data, uint64(window.Dy()))
+	data = binary.AppendVarint(data, seed)
+	data = binary.AppendUvarint(data, uint64(len(runs)))
+	for _, r := range runs {
+		data = binary.AppendUvarint(data, r)
+	}
+	if !gz {
+		return data, maskRLEContentType, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, "", fmt.Errorf("gzip mask: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", fmt.Errorf("gzip mask: %w", err)
+	}
+	return buf.Bytes(), maskRLEGzipContentType, nil
+}
+
+// DecodeMaskRLE decodes a mask written by format "mask-rle", gzipped or not.
+// The runs must cover the mask exactly.
+func DecodeMaskRLE(data []byte) (*LandMask, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("mask-rle: %w", err)
+		}
+		defer zr.Close()
+		if data, err = io.ReadAll(io.LimitReader(zr, maxMaskRLEBytes+1)); err != nil {
+			return nil, fmt.Errorf("mask-rle: %w", err)
+		}
+	}
+	if len(data) > maxMaskRLEBytes {
+		return nil, fmt.Errorf("mask-rle: document exceeds %d bytes", maxMaskRLEBytes)
+	}
+	if !bytes.HasPrefix(data, []byte(maskRLEMagic)) || len(data) < len(maskRLEMagic)+1 {
+		return nil, errors.New("mask-rle: missing MRLE header")
+	}
+	if v := data[len(maskRLEMagic)]; v != maskRLEVersion {
+		return nil, fmt.Errorf("mask-rle: unsupported version %d", v)
+	}
+	r := bytes.NewReader(data[len(maskRLEMagic)+1:])
+
+	width, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.New("mask-rle: truncated width")
+	}
+	height, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.New("mask-rle: truncated height")
+	}
+	if width == 0 || height == 0 || width > maxMaskCells || height > maxMaskCells/width {
+		return nil, fmt.Errorf("mask-rle: invalid size %dx%d", width, height)
+	}
+	seed, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, errors.New("mask-rle: truncated seed")
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.New("mask-rle: truncated run count")
+	}
+	cells := width * height
+	// Every run takes at least a byte, which bounds count by the input.
+	if count == 0 || count > cells+1 || count > uint64(r.Len()) {
+		return nil, fmt.Errorf("mask-rle: %d runs cannot cover %d cells", count, cells)
+	}
+
+	// The runs are read and summed before the mask is allocated, so a header
+	// claiming more cells than its runs cover costs nothing.
+	runs := make([]uint64, count)
+	pos := uint64(0)
+	for i := range runs {
+		run, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("mask-rle: truncated run %d", i)
+		}
+		if run > cells-pos {
+			return nil, fmt.Errorf("mask-rle: run %d overflows the %d cells", i, cells)
+		}
+		runs[i] = run
+		pos += run
+	}
+	if pos != cells {
+		return nil, fmt.Errorf("mask-rle: runs cover %d of %d cells", pos, cells)
+	}
+	if r.Len() > 0 {
+		return nil, errors.New("mask-rle: trailing data after the runs")
+	}
+
+	m := &LandMask{Width: int(width), Height: int(height), Seed: seed, Land: make([]bool, cells)}
+	pos = 0
+	for i, run := range runs {
+		if i%2 == 1 {
+			for j := pos; j < pos+run; j++ {
+				m.Land[j] = true
+			}
+		}
+		pos += run
+	}
+	return m, nil
+}
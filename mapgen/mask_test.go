@@ -0,0 +1,116 @@
+package mapgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"image"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// TestMaskRLERoundTrip encodes random grids, plain and gzipped, with random
+// windows and thresholds, and checks that decoding gives back every cell.
+func TestMaskRLERoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 500; trial++ {
+		width, height := 1+rnd.Intn(50), 1+rnd.Intn(50)
+		coverage := make([]float64, width*height)
+		// Mostly long runs, with some noise and the odd all-water or
+		// all-land grid.
+		fill := rnd.Float64()
+		for i := range coverage {
+			if rnd.Float64() < fill {
+				coverage[i] = float64(rnd.Intn(4))
+			}
+		}
+		x0, y0 := rnd.Intn(width), rnd.Intn(height)
+		window := image.Rect(x0, y0, x0+1+rnd.Intn(width-x0), y0+1+rnd.Intn(height-y0))
+		threshold := float64(rnd.Intn(3))
+		seed := rnd.Int63() - rnd.Int63()
+		gz := trial%2 == 1
+
+		data, _, err := encodeMaskRLE(coverage, width, window, seed, threshold, gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := DecodeMaskRLE(data)
+		if err != nil {
+			t.Fatalf("trial %d: %v", trial, err)
+		}
+		if m.Width != window.Dx() || m.Height != window.Dy() || m.Seed != seed {
+			t.Fatalf("trial %d: decoded %dx%d seed %d, want %dx%d seed %d", trial, m.Width, m.Height, m.Seed, window.Dx(), window.Dy(), seed)
+		}
+		for y := window.Min.Y; y < window.Max.Y; y++ {
+			for x := window.Min.X; x < window.Max.X; x++ {
+				c := coverage[y*width+x]
+				if want := c > 0 && c >= threshold; m.At(x-window.Min.X, y-window.Min.Y) != want {
+					t.Fatalf("trial %d: cell (%d, %d) with coverage %v decoded as land %v", trial, x, y, c, !want)
+				}
+			}
+		}
+	}
+}
+
+// maskRLEDoc builds a mask-rle document claiming count runs, of which it
+// holds runs.
+func maskRLEDoc(width, height, count uint64, runs ...uint64) []byte {
+	data := append([]byte(maskRLEMagic), maskRLEVersion)
+	data = binary.AppendUvarint(data, width)
+	data = binary.AppendUvarint(data, height)
+	data = binary.AppendVarint(data, 0)
+	data = binary.AppendUvarint(data, count)
+	for _, r := range runs {
+		data = binary.AppendUvarint(data, r)
+	}
+	return data
+}
+
+func TestDecodeMaskRLERejectsMalformed(t *testing.T) {
+	var bomb bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(maskRLEDoc(1, 1, 1, 1))
+	zw.Write(make([]byte, maxMaskRLEBytes))
+	zw.Close()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", []byte("MRLX\x01")},
+		{"bad version", []byte("MRLE\x02")},
+		{"zero width", maskRLEDoc(0, 4, 1, 0)},
+		{"too many cells", maskRLEDoc(1<<14, 1<<13, 1, 1<<27)},
+		{"runs short", maskRLEDoc(4, 4, 2, 3, 4)},
+		{"runs overflow", maskRLEDoc(4, 4, 2, 10, 10)},
+		{"trailing data", append(maskRLEDoc(2, 2, 1, 4), 0)},
+		{"more runs than bytes", maskRLEDoc(8192, 8192, 1<<20, 1)},
+		{"gzip bomb", bomb.Bytes()},
+	}
+	for _, tt := range tests {
+		if _, err := DecodeMaskRLE(tt.data); err == nil {
+			t.Errorf("%s: decoded without error", tt.name)
+		}
+	}
+}
+
+// TestDecodeMaskRLEAllocatesAfterRuns feeds headers claiming the largest mask
+// and checks that rejecting them does not allocate it.
+func TestDecodeMaskRLEAllocatesAfterRuns(t *testing.T) {
+	for _, data := range [][]byte{
+		maskRLEDoc(8192, 8192, 1, 8192*8192-1),
+		maskRLEDoc(8192, 8192, 1<<20, 1),
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := DecodeMaskRLE(data); err == nil {
+			t.Fatal("decoded a mask its runs do not cover")
+		}
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("rejecting a %d-byte document allocated %d bytes", len(data), allocated)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// modeKarma is the mode of a modeMix request: every placement samples one of
+// the mixed modes by weight and is positioned the way that mode would.
+const modeKarma = "karma"
+
+// modeMixSalt separates the mode sampling stream from the placement stream,
+// so mixing draws no numbers the mixed modes would otherwise see.
+const modeMixSalt = 0x2545f4914f6cdd1d
+
+// ModeWeight is one entry of modeMix. After normalization the weights of a
+// mix sum to 1.
+type ModeWeight struct {
+	Mode   string  `json:"mode"`
+	Weight float64 `json:"weight"`
+}
+
+// ModeCount reports how many placements one mode of a mix positioned.
+type ModeCount struct {
+	Mode       string  `json:"mode"`
+	Weight     float64 `json:"weight"`
+	Placements int     `json:"placements"`
+}
+
+// normalizeModeMix validates modeMix and applies its precedence over mode: a
+// mix switches the request to mode karma, so an explicit other mode is
+// rejected rather than silently overridden, and karma without a mix is
+// meaningless. Modes without a placement strategy of their own (magara, and
+// karma itself) cannot be mixed.
+func normalizeModeMix(req *Request, p *Params) error {
+	mode := strings.ToLower(strings.TrimSpace(req.Mode))
+	if len(req.ModeMix) == 0 {
+		if req.ModeMix != nil {
+			return errors.New("modeMix must list at least one mode")
+		}
+		if mode == modeKarma {
+			return errors.New("mode karma requires modeMix")
+		}
+		return nil
+	}
+	if mode != "" && mode != modeKarma {
+		return fmt.Errorf("mode %q cannot be combined with modeMix; omit mode or use %q", req.Mode, modeKarma)
+	}
+
+	total := 0.0
+	seen := map[string]bool{}
+	mix := make([]ModeWeight, 0, len(req.ModeMix))
+	for i, entry := range req.ModeMix {
+		m := strings.ToLower(strings.TrimSpace(entry.Mode))
+		switch m {
+		case "merkez", "agirlik", "adalar", "iki-kita", "voronoi", modeBolge, modePetek:
+		default:
+			return fmt.Errorf("modeMix[%d]: unsupported mode %q", i, entry.Mode)
+		}
+		if seen[m] {
+			return fmt.Errorf("modeMix[%d]: mode %q is listed twice", i, m)
+		}
+		seen[m] = true
+		if !(entry.Weight > 0) || math.IsInf(entry.Weight, 0) {
+			return fmt.Errorf("modeMix[%d]: weight must be positive", i)
+		}
+		total += entry.Weight
+		mix = append(mix, ModeWeight{Mode: m, Weight: entry.Weight})
+	}
+	for i := range mix {
+		mix[i].Weight /= total
+	}
+	p.Mode = modeKarma
+	p.ModeMix = mix
+	return nil
+}
+
+// placesMode reports whether placements may come from mode, either as the
+// mode itself or as part of a mix.
+func (p Params) placesMode(mode string) bool {
+	if p.Mode == mode {
+		return true
+	}
+	for _, m := range p.ModeMix {
+		if m.Mode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// setModeMix initializes the state of every mixed mode, in mix order.
+func (g *generator) setModeMix(mix []ModeWeight) {
+	g.mix = mix
+	g.mixCounts = make([]int, len(mix))
+	for _, m := range mix {
+		g.initMode(m.Mode)
+	}
+}
+
+// sampleMixMode picks the mode of the next position from the seeded mixing
+// stream and remembers it for recordPlacement.
+func (g *generator) sampleMixMode() string {
+	h := splitmix64(uint64(g.seed) ^ modeMixSalt + uint64(g.mixDraws))
+	g.mixDraws++
+	r := float64(h>>11) / (1 << 53)
+
+	g.lastMix = len(g.mix) - 1
+	cumulative := 0.0
+	for i, m := range g.mix {
+		cumulative += m.Weight
+		if r < cumulative {
+			g.lastMix = i
+			break
+		}
+	}
+	return g.mix[g.lastMix].Mode
+}
+
+// modeCounts reports the placements each mixed mode positioned, nil without a
+// mix.
+func (g *generator) modeCounts() []ModeCount {
+	if len(g.mix) == 0 {
+		return nil
+	}
+	counts := make([]ModeCount, len(g.mix))
+	for i, m := range g.mix {
+		counts[i] = ModeCount{Mode: m.Mode, Weight: m.Weight, Placements: g.mixCounts[i]}
+	}
+	return counts
+}
@@ -0,0 +1,131 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// renderRadialOverlay composites a distance-from-center gradient, and the
+// merkez ring boundaries, over the terrain.
+const renderRadialOverlay = "radial-overlay"
+
+// defaultOverlayColor is the gradient tint at the corners unless overlayColor
+// picks another.
+var defaultOverlayColor = color.RGBA{R: 220, G: 40, B: 40, A: 255}
+
+// normalizeOverlay resolves render and the overlay options. The overlay is
+// painted onto the final image, so it needs a single image to paint on.
+func normalizeOverlay(req *Request, p *Params) error {
+	p.Render = strings.ToLower(strings.TrimSpace(req.Render))
+	switch p.Render {
+	case "":
+		return nil
+	case renderRadialOverlay:
+	default:
+		return fmt.Errorf("unsupported render %q", req.Render)
+	}
+	switch {
+	case p.Format != formatPNG && p.Format != formatWebP:
+		return fmt.Errorf("render %q requires format %q or %q", p.Render, formatPNG, formatWebP)
+	case p.Pyramid > 0:
+		return fmt.Errorf("render %q does not support pyramid", p.Render)
+	case p.Animate != "":
+		return fmt.Errorf("render %q cannot be animated", p.Render)
+	}
+
+	p.OverlayColor = defaultOverlayColor
+	if strings.TrimSpace(req.OverlayColor) != "" {
+		c, err := parseHexColor(req.OverlayColor)
+		if err != nil {
+			return fmt.Errorf("overlayColor: %w", err)
+		}
+		p.OverlayColor = c
+	}
+	p.OverlayAlpha = 0.5
+	if req.OverlayAlpha != nil {
+		if !(*req.OverlayAlpha >= 0 && *req.OverlayAlpha <= 1) {
+			return errors.New("overlayAlpha must be between 0 and 1")
+		}
+		p.OverlayAlpha = *req.OverlayAlpha
+	}
+	p.OverlayRings = true
+	if req.OverlayRings != nil {
+		p.OverlayRings = *req.OverlayRings
+	}
+	return nil
+}
+
+// drawRadialOverlay blends the overlay color over img with an opacity growing
+// linearly from 0 at the canvas center to overlayAlpha at the corners, and
+// when merkez placed tiles traces every outer ring boundary gen sampled from
+// at overlayAlpha. Opacity also scales with the alpha of the overlay color. A
+// zero opacity leaves pixels untouched, so overlayAlpha 0 returns the terrain
+// unchanged.
+func drawRadialOverlay(img *image.RGBA, p Params, gen *generator) {
+	strength := p.OverlayAlpha * float64(p.OverlayColor.A) / 255
+	if strength <= 0 {
+		return
+	}
+	tint := p.OverlayColor
+	cx, cy := float64(p.Width)/2, float64(p.Height)/2
+	corner := math.Hypot(cx, cy)
+
+	// Guides sit where positionMerkez turns boundary fractions into radii.
+	var guides []float64
+	if p.OverlayRings && p.placesMode("merkez") && len(gen.ringBoundaries) > 1 {
+		guides = gen.ringBoundaries[1:]
+	}
+	rx, ry := float64(min(p.Width, p.Height))/2, float64(min(p.Width, p.Height))/2
+	if p.RingShape == ringShapeEllipse {
+		rx, ry = cx, cy
+	}
+
+	for y := 0; y < p.Height; y++ {
+		dy := float64(y) + 0.5 - cy
+		for x := 0; x < p.Width; x++ {
+			dx := float64(x) + 0.5 - cx
+			a := strength * math.Hypot(dx, dy) / corner
+			if len(guides) > 0 && onRingGuide(dx, dy, rx, ry, guides) {
+				a = strength
+			}
+			if a <= 0 {
+				continue
+			}
+			blendOver(img, x, y, tint, a)
+		}
+	}
+}
+
+// onRingGuide reports whether the pixel at (dx, dy) from the center lies
+// within half a pixel of one of the ring boundaries, given as fractions of the
+// radii rx and ry.
+func onRingGuide(dx, dy, rx, ry float64, fracs []float64) bool {
+	// e is the normalized elliptical radius and grad its change per pixel,
+	// so (e-frac)/grad approximates the distance to the boundary in pixels.
+	e := math.Hypot(dx/rx, dy/ry)
+	if e == 0 {
+		return false
+	}
+	grad := math.Hypot(dx/(rx*rx), dy/(ry*ry)) / e
+	for _, frac := range fracs {
+		if frac > 0 && math.Abs(e-frac) < 0.5*grad {
+			return true
+		}
+	}
+	return false
+}
+
+// blendOver composites c at opacity a over the premultiplied pixel at x, y.
+func blendOver(img *image.RGBA, x, y int, c color.RGBA, a float64) {
+	i := img.PixOffset(x, y)
+	px := img.Pix[i : i+4 : i+4]
+	keep := 1 - a
+	px[0] = uint8(math.Round(float64(c.R)*a + float64(px[0])*keep))
+	px[1] = uint8(math.Round(float64(c.G)*a + float64(px[1])*keep))
+	px[2] = uint8(math.Round(float64(c.B)*a + float64(px[2])*keep))
+	px[3] = uint8(math.Round(255*a + float64(px[3])*keep))
+}
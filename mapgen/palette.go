@@ -0,0 +1,174 @@
+package mapgen
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Default colors used when neither an explicit color nor autoPalette picks
+// one.
+var (
+	defaultLand  = color.RGBA{R: 34, G: 139, B: 34, A: 255}
+	defaultPeak  = color.RGBA{R: 139, G: 69, B: 19, A: 255}
+	defaultWater = color.RGBA{A: 255}
+)
+
+// paletteSalt separates the palette stream from placementJitter, which hashes
+// the same seed.
+const paletteSalt = 0x5bd1e9955bd1e995
+
+// minPaletteContrast is the smallest HSL lightness difference autoPalette
+// allows between land and water.
+const minPaletteContrast = 0.25
+
+// paletteRounding is the most the 8-bit rounding of a color can move its
+// lightness.
+const paletteRounding = 1.0 / 255
+
+// Palette is the set of colors a map is painted with: land at coverage 1,
+// peak where coverage saturates and water behind uncovered cells.
+type Palette struct {
+	Land  color.RGBA
+	Peak  color.RGBA
+	Water color.RGBA
+}
+
+// String formats the palette for the X-Palette header, in the same hex form
+// the landColor, peakColor and waterColor fields accept.
+func (pal Palette) String() string {
+	return fmt.Sprintf("land=%s,peak=%s,water=%s", hexColor(pal.Land), hexColor(pal.Peak), hexColor(pal.Water))
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Curated autoPalette ramps, each from one end of its range to the other:
+// land olive to green, peaks ochre to umber, water pale teal to blue.
+var (
+	landRamp  = [2]color.RGBA{hslColor(70, 0.55, 0.30), hslColor(150, 0.35, 0.45)}
+	peakRamp  = [2]color.RGBA{hslColor(40, 0.60, 0.22), hslColor(15, 0.40, 0.32)}
+	waterRamp = [2]color.RGBA{hslColor(185, 0.40, 0.75), hslColor(230, 0.65, 0.50)}
+)
+
+// seedPalette derives a palette from the seed. Each color is a seed-picked
+// point on its curated ramp, blended with blendColor as coverageToColor
+// blends land into peak, so no seed leaves the tasteful ranges. Water is then
+// pushed away from land until their lightness differs by at least
+// minPaletteContrast once rounded to 8 bits.
+func seedPalette(seed int64) Palette {
+	h := uint64(seed) ^ paletteSalt
+	next := func() float64 {
+		h = splitmix64(h)
+		return float64(h>>11) / (1 << 53)
+	}
+	pal := Palette{
+		Land:  blendColor(landRamp[0], landRamp[1], next()),
+		Peak:  blendColor(peakRamp[0], peakRamp[1], next()),
+		Water: blendColor(waterRamp[0], waterRamp[1], next()),
+	}
+
+	_, _, landL := rgbToHSL(pal.Land)
+	waterH, waterS, waterL := rgbToHSL(pal.Water)
+	if math.Abs(waterL-landL) < minPaletteContrast+paletteRounding {
+		pal.Water = hslColor(waterH, waterS, landL+minPaletteContrast+paletteRounding)
+	}
+	return pal
+}
+
+// hslColor converts hue in degrees, saturation and lightness in [0, 1] to an
+// opaque color.
+func hslColor(hue, sat, light float64) color.RGBA {
+	val := light + sat*math.Min(light, 1-light)
+	hsvSat := 0.0
+	if val > 0 {
+		hsvSat = 2 * (1 - light/val)
+	}
+	return hsvColor(hue, hsvSat, val)
+}
+
+// rgbToHSL converts a color to hue in degrees, saturation and lightness in
+// [0, 1], the inverse of hslColor.
+func rgbToHSL(c color.RGBA) (float64, float64, float64) {
+	hue, hsvSat, val := rgbToHSV(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+	light := val * (1 - hsvSat/2)
+	sat := 0.0
+	if m := math.Min(light, 1-light); m > 0 {
+		sat = (val - light) / m
+	}
+	return hue, sat, light
+}
+
+// normalizeHSLAdjust validates hueShift, saturationScale and lightnessScale.
+// Omitted, they are the identity.
+func normalizeHSLAdjust(req *Request, p *Params) error {
+	p.SaturationScale, p.LightnessScale = 1, 1
+	if req.HueShift != nil {
+		if math.IsNaN(*req.HueShift) || math.IsInf(*req.HueShift, 0) {
+			return fmt.Errorf("hueShift must be finite")
+		}
+		p.HueShift = *req.HueShift
+	}
+	for _, field := range []struct {
+		name  string
+		value *float64
+		dst   *float64
+	}{
+		{"saturationScale", req.SaturationScale, &p.SaturationScale},
+		{"lightnessScale", req.LightnessScale, &p.LightnessScale},
+	} {
+		if field.value == nil {
+			continue
+		}
+		if !(*field.value >= 0) || math.IsInf(*field.value, 0) {
+			return fmt.Errorf("%s must be a non-negative number", field.name)
+		}
+		*field.dst = *field.value
+	}
+	return nil
+}
+
+// adjustHSL rotates the hue of c by p.HueShift degrees and scales its
+// saturation and lightness, clamping both to [0, 1]. Alpha is kept. The
+// identity adjustment returns c untouched, skipping the lossy round trip.
+func adjustHSL(c color.RGBA, p *Params) color.RGBA {
+	if p.HueShift == 0 && p.SaturationScale == 1 && p.LightnessScale == 1 {
+		return c
+	}
+	hue, sat, light := rgbToHSL(c)
+	hue = math.Mod(hue+p.HueShift, 360)
+	if hue < 0 {
+		hue += 360
+	}
+	out := hslColor(hue, clampFloat(sat*p.SaturationScale, 0, 1), clampFloat(light*p.LightnessScale, 0, 1))
+	out.A = c.A
+	return out
+}
+
+// resolvePalette fills the colors p leaves unset: from the seed with
+// autoPalette, otherwise from the defaults. Explicit colors always win. The
+// HSL adjustments apply last, to the land and peak colors and colorOne
+// whichever way they were picked; water keeps its color.
+func resolvePalette(p *Params, seed int64) Palette {
+	pal := Palette{Land: defaultLand, Peak: defaultPeak, Water: defaultWater}
+	if p.AutoPalette {
+		pal = seedPalette(seed)
+	}
+	if p.LandColor != nil {
+		pal.Land = *p.LandColor
+	}
+	if p.PeakColor != nil {
+		pal.Peak = *p.PeakColor
+	}
+	if p.WaterColor != nil {
+		pal.Water = *p.WaterColor
+	}
+	pal.Land, pal.Peak = adjustHSL(pal.Land, p), adjustHSL(pal.Peak, p)
+	if p.ColorOne != nil {
+		one := adjustHSL(*p.ColorOne, p)
+		p.ColorOne = &one
+	}
+	p.LandColor, p.PeakColor, p.WaterColor = &pal.Land, &pal.Peak, &pal.Water
+	return pal
+}
@@ -0,0 +1,118 @@
+package mapgen
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSeedPaletteContrast(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	seeds := []int64{0, 1, -1, math.MaxInt64, math.MinInt64}
+	for i := 0; i < 5000; i++ {
+		seeds = append(seeds, rnd.Int63()-rnd.Int63())
+	}
+	for _, seed := range seeds {
+		pal := seedPalette(seed)
+		_, _, landL := rgbToHSL(pal.Land)
+		_, _, waterL := rgbToHSL(pal.Water)
+		if diff := math.Abs(waterL - landL); diff < minPaletteContrast {
+			t.Fatalf("seed %d: %v has land and water lightness %.3f apart, want at least %v", seed, pal, diff, minPaletteContrast)
+		}
+		for _, c := range []struct {
+			name string
+			rgba uint8
+		}{{"land", pal.Land.A}, {"peak", pal.Peak.A}, {"water", pal.Water.A}} {
+			if c.rgba != 255 {
+				t.Fatalf("seed %d: %s alpha %d, want opaque", seed, c.name, c.rgba)
+			}
+		}
+	}
+}
+
+func TestSeedPaletteStaysOnRamps(t *testing.T) {
+	// Blending in RGB keeps the hue between the ramp ends, up to rounding.
+	ramps := []struct {
+		name   string
+		lo, hi float64
+		pick   func(Palette) color.RGBA
+	}{
+		{"land", 70, 150, func(p Palette) color.RGBA { return p.Land }},
+		{"peak", 15, 40, func(p Palette) color.RGBA { return p.Peak }},
+		{"water", 185, 230, func(p Palette) color.RGBA { return p.Water }},
+	}
+	for seed := int64(0); seed < 1000; seed++ {
+		pal := seedPalette(seed)
+		for _, r := range ramps {
+			if hue, _, _ := rgbToHSL(r.pick(pal)); hue < r.lo-2 || hue > r.hi+2 {
+				t.Fatalf("seed %d: %s hue %.1f, want between %v and %v", seed, r.name, hue, r.lo, r.hi)
+			}
+		}
+	}
+}
+
+func TestSeedPaletteDeterministic(t *testing.T) {
+	if a, b := seedPalette(42), seedPalette(42); a != b {
+		t.Errorf("seed 42 gave %v then %v", a, b)
+	}
+	if seedPalette(42) == seedPalette(43) {
+		t.Error("seeds 42 and 43 gave the same palette")
+	}
+}
+
+// hslCases are colors whose HSL values are exact, from the CSS color tables.
+var hslCases = []struct {
+	name            string
+	c               color.RGBA
+	hue, sat, light float64
+}{
+	{"black", color.RGBA{0, 0, 0, 255}, 0, 0, 0},
+	{"white", color.RGBA{255, 255, 255, 255}, 0, 0, 1},
+	{"red", color.RGBA{255, 0, 0, 255}, 0, 1, 0.5},
+	{"lime", color.RGBA{0, 255, 0, 255}, 120, 1, 0.5},
+	{"blue", color.RGBA{0, 0, 255, 255}, 240, 1, 0.5},
+	{"yellow", color.RGBA{255, 255, 0, 255}, 60, 1, 0.5},
+	{"cyan", color.RGBA{0, 255, 255, 255}, 180, 1, 0.5},
+	{"magenta", color.RGBA{255, 0, 255, 255}, 300, 1, 0.5},
+	{"maroon", color.RGBA{128, 0, 0, 255}, 0, 1, 128.0 / 510},
+	{"olive", color.RGBA{128, 128, 0, 255}, 60, 1, 128.0 / 510},
+	{"teal", color.RGBA{0, 128, 128, 255}, 180, 1, 128.0 / 510},
+	{"gray", color.RGBA{128, 128, 128, 255}, 0, 0, 128.0 / 255},
+	{"orange", color.RGBA{255, 165, 0, 255}, 165.0 / 255 * 60, 1, 0.5},
+	{"pink", color.RGBA{255, 128, 128, 255}, 0, 1, 383.0 / 510},
+}
+
+func TestRGBToHSL(t *testing.T) {
+	for _, tt := range hslCases {
+		t.Run(tt.name, func(t *testing.T) {
+			hue, sat, light := rgbToHSL(tt.c)
+			if math.Abs(hue-tt.hue) > 1e-9 || math.Abs(sat-tt.sat) > 1e-9 || math.Abs(light-tt.light) > 1e-9 {
+				t.Errorf("rgbToHSL(%v) = (%v, %v, %v), want (%v, %v, %v)", tt.c, hue, sat, light, tt.hue, tt.sat, tt.light)
+			}
+		})
+	}
+}
+
+func TestHSLColor(t *testing.T) {
+	for _, tt := range hslCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hslColor(tt.hue, tt.sat, tt.light); got != tt.c {
+				t.Errorf("hslColor(%v, %v, %v) = %v, want %v", tt.hue, tt.sat, tt.light, got, tt.c)
+			}
+		})
+	}
+}
+
+func TestHSLRoundTrip(t *testing.T) {
+	for r := 0; r < 256; r += 5 {
+		for g := 0; g < 256; g += 5 {
+			for b := 0; b < 256; b += 5 {
+				c := color.RGBA{uint8(r), uint8(g), uint8(b), 255}
+				if got := hslColor(rgbToHSL(c)); got != c {
+					t.Fatalf("%v round-trips to %v", c, got)
+				}
+			}
+		}
+	}
+}
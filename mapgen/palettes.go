@@ -0,0 +1,119 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// maxPalettes bounds the palettes list; each variant is a full-canvas image.
+const maxPalettes = 16
+
+// PaletteEntry is one entry of palettes: Low is the land color at coverage 1
+// and High the peak color brown saturates to. Either may be omitted to keep
+// the color the map would otherwise be painted with.
+type PaletteEntry struct {
+	Low  string `json:"low"`
+	High string `json:"high"`
+}
+
+// PaletteVariant is a validated palettes entry; nil colors keep the resolved
+// land or peak color.
+type PaletteVariant struct {
+	Low  *color.RGBA
+	High *color.RGBA
+}
+
+// normalizePalettes validates palettes, the color variants of one layout.
+// Every variant is recolored from the one coverage grid, so like sizes the
+// options that paint only the final image, or keep no grid, are rejected, as
+// are the ones that color cells by region instead of by palette.
+func normalizePalettes(req *Request, p *Params) error {
+	if len(req.Palettes) == 0 {
+		return nil
+	}
+	switch {
+	case len(req.Palettes) > maxPalettes:
+		return fmt.Errorf("palettes lists more than %d palettes", maxPalettes)
+	case p.Format != formatPNG:
+		return fmt.Errorf("palettes requires format %q", formatPNG)
+	case p.Pyramid > 0 || len(p.Sizes) > 0:
+		return errors.New("palettes cannot be combined with pyramid or sizes")
+	case p.Animate != "":
+		return errors.New("palettes cannot be animated")
+	case p.Preview:
+		return errors.New("palettes does not support preview")
+	case p.AutoCrop:
+		return errors.New("palettes does not support autoCrop")
+	case p.Render != "":
+		return fmt.Errorf("palettes does not support render %q", p.Render)
+	case p.Antialias || p.Legend || p.DrawStats:
+		return errors.New("palettes does not support antialias, legend or drawStats")
+	case p.Invert:
+		return errors.New("palettes does not support invert, which paints land transparent")
+	case p.Mode == "voronoi" || (p.ColorByRing && p.placesMode("merkez")):
+		return errors.New("palettes does not apply to cells colored by region")
+	case p.Sparse != nil && *p.Sparse:
+		return errors.New("sparse does not support palettes")
+	}
+	variants := make([]PaletteVariant, len(req.Palettes))
+	for i, entry := range req.Palettes {
+		for _, field := range []struct {
+			name  string
+			value string
+			dst   **color.RGBA
+		}{
+			{"low", entry.Low, &variants[i].Low},
+			{"high", entry.High, &variants[i].High},
+		} {
+			if strings.TrimSpace(field.value) == "" {
+				continue
+			}
+			c, err := parseHexColor(field.value)
+			if err != nil {
+				return fmt.Errorf("palettes[%d].%s: %w", i, field.name, err)
+			}
+			*field.dst = &c
+		}
+	}
+	p.Palettes = variants
+	return nil
+}
+
+// paletteFileName is the part/entry name of the index-th palette variant.
+func paletteFileName(index int) string {
+	return fmt.Sprintf("palette-%d.png", index)
+}
+
+// renderPalettes recolors the coverage grid of f once per palette, in the
+// order asked for. Placement ran once, so every variant shares the layout;
+// the HSL adjustments apply to each variant's colors as they do to the base
+// palette, and water, colorOne and shallow are shared.
+func renderPalettes(f frame, p Params) ([]pyramidLevel, error) {
+	files := make([]pyramidLevel, 0, len(p.Palettes))
+	for i, variant := range p.Palettes {
+		q := p
+		if variant.Low != nil {
+			low := adjustHSL(*variant.Low, &p)
+			q.LandColor = &low
+		}
+		if variant.High != nil {
+			high := adjustHSL(*variant.High, &p)
+			q.PeakColor = &high
+		}
+
+		img := newCanvas(p.Width, p.Height, q)
+		colorCoverage(img, f.coverage, f.ringOf, f.segments, f.jitter, q)
+		if q.Outline {
+			drawOutlines(img, f.layout, outlineColor(q), coveredIn(f.coverage, p.Width))
+		}
+		data, err := encodePNG(img, p.Dpi, png.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pyramidLevel{name: paletteFileName(i), data: data})
+	}
+	return files, nil
+}
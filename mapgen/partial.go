@@ -0,0 +1,78 @@
+package mapgen
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Reasons a planned tile was not placed, as reported in
+// TileScaling.SkipReasons.
+const (
+	skipOversized = "oversized"
+	skipRegion    = "region"
+)
+
+// skipDescriptions phrase the skip reasons for warnings.
+var skipDescriptions = map[string]string{
+	skipOversized: "larger than the canvas",
+	skipRegion:    "found no position inside their region",
+}
+
+// PartialError reports a strict generation on which at least one tile spec
+// placed none of its tiles. Tiles holds the accounting of every spec and
+// Warnings names the failed ones with the reason.
+type PartialError struct {
+	Tiles    []TileScaling
+	Warnings []string
+}
+
+func (e *PartialError) Error() string {
+	var failed []string
+	for _, t := range e.Tiles {
+		if t.Final > 0 && t.Placed == 0 {
+			failed = append(failed, fmt.Sprintf("%q", t.Name))
+		}
+	}
+	return fmt.Sprintf("strict: no tile of %s was placed", strings.Join(failed, ", "))
+}
+
+// skip records that a tile of TileScaling entry spec was not placed.
+func (g *generator) skip(spec int, reason string) {
+	if g.skips == nil {
+		g.skips = map[int]map[string]int{}
+	}
+	if g.skips[spec] == nil {
+		g.skips[spec] = map[string]int{}
+	}
+	g.skips[spec][reason]++
+}
+
+// reportSkips records in scaling how many tiles of each spec were placed and
+// skipped, with a warning per spec that skipped any. partial reports whether
+// some spec placed none of its tiles.
+func reportSkips(scaling []TileScaling, skips map[int]map[string]int, warnings []string) (_ []string, partial bool) {
+	for i := range scaling {
+		t := &scaling[i]
+		t.Placed = t.Final
+		reasons := skips[i]
+		if len(reasons) == 0 {
+			continue
+		}
+		var parts []string
+		for _, reason := range slices.Sorted(maps.Keys(reasons)) {
+			t.Skipped += reasons[reason]
+			parts = append(parts, fmt.Sprintf("%d %s", reasons[reason], skipDescriptions[reason]))
+		}
+		t.Placed -= t.Skipped
+		t.SkipReasons = reasons
+		if t.Placed == 0 {
+			partial = true
+			warnings = append(warnings, fmt.Sprintf("no %q tile was placed: %s", t.Name, strings.Join(parts, ", ")))
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("%d of %d %q tiles were skipped: %s", t.Skipped, t.Final, t.Name, strings.Join(parts, ", ")))
+	}
+	return warnings, partial
+}
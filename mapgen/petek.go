@@ -0,0 +1,232 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// modePetek places every tile centered on a site of a hexagonal lattice,
+// picked with a bias toward the canvas center, for a honeycomb rhythm.
+const modePetek = "petek"
+
+// Petek defaults and bounds. maxHexSites keeps the lattice tables small next
+// to the coverage grid even at the smallest pitch.
+const (
+	defaultHexPitch   = 16
+	minHexPitch       = 2
+	defaultHexFalloff = 2.0
+	maxHexSites       = 1 << 20
+)
+
+// hexLattice is the site table of mode petek. free is a Fenwick tree over the
+// weights of the sites still open to the current batch, so oneTilePerSite
+// removes a site in O(log n); taken marks the removed sites.
+type hexLattice struct {
+	sites   [][2]float64
+	weights []float64
+	oneTile bool
+	free    []float64
+	taken   []bool
+	open    int
+	// clamped counts the tiles no drawn site could center, see
+	// positionPetek.
+	clamped int
+}
+
+// normalizePetek validates the petek options, which mode petek alone reads.
+func normalizePetek(req *Request, p *Params) error {
+	if !p.placesMode(modePetek) {
+		switch {
+		case req.HexPitch != nil:
+			return fmt.Errorf("hexPitch requires mode %q", modePetek)
+		case req.HexFalloff != nil:
+			return fmt.Errorf("hexFalloff requires mode %q", modePetek)
+		case req.OneTilePerSite != nil:
+			return fmt.Errorf("oneTilePerSite requires mode %q", modePetek)
+		}
+		return nil
+	}
+	if req.Snap != nil && *req.Snap > 1 {
+		return fmt.Errorf("snap cannot be combined with mode %q, which centers tiles on lattice sites", modePetek)
+	}
+	p.HexPitch = defaultHexPitch
+	if req.HexPitch != nil {
+		if *req.HexPitch < minHexPitch {
+			return fmt.Errorf("hexPitch must be at least %d", minHexPitch)
+		}
+		p.HexPitch = *req.HexPitch
+	}
+	if sites := float64(p.Width) * float64(p.Height) / (float64(p.HexPitch) * float64(p.HexPitch) * math.Sqrt(3) / 2); sites > maxHexSites {
+		return fmt.Errorf("hexPitch %d puts more than %d lattice sites on a %dx%d canvas", p.HexPitch, maxHexSites, p.Width, p.Height)
+	}
+	p.HexFalloff = defaultHexFalloff
+	if req.HexFalloff != nil {
+		if !(*req.HexFalloff >= 0) || math.IsInf(*req.HexFalloff, 0) {
+			return errors.New("hexFalloff must be a non-negative number")
+		}
+		p.HexFalloff = *req.HexFalloff
+	}
+	if req.OneTilePerSite != nil {
+		p.OneTilePerSite = *req.OneTilePerSite
+	}
+	return nil
+}
+
+// setHexLattice lays out the pointy-top lattice with one site on the canvas
+// center and neighbors pitch apart. Sites whose hexagon would cross the canvas
+// edge are left out; when that leaves none, the center site alone remains.
+// Each site weighs exp(-falloff·(d/R)²), d being its distance from the center
+// and R half the canvas diagonal, so falloff 0 is uniform.
+func (g *generator) setHexLattice(pitch int, falloff float64, oneTile bool) {
+	cx, cy := float64(g.width)/2, float64(g.height)/2
+	step := float64(pitch)
+	rowStep := step * math.Sqrt(3) / 2
+	halfW, halfH := step/2, step/math.Sqrt(3)
+	reach := math.Hypot(cx, cy)
+
+	lattice := &hexLattice{oneTile: oneTile}
+	rows := int(cy/rowStep) + 1
+	for r := -rows; r <= rows; r++ {
+		y := cy + float64(r)*rowStep
+		if y-halfH < 0 || y+halfH > float64(g.height) {
+			continue
+		}
+		shift := 0.0
+		if r%2 != 0 {
+			shift = step / 2
+		}
+		cols := int(cx/step) + 1
+		for q := -cols; q <= cols; q++ {
+			x := cx + float64(q)*step + shift
+			if x-halfW < 0 || x+halfW > float64(g.width) {
+				continue
+			}
+			d := math.Hypot(x-cx, y-cy) / reach
+			// Sites snap to whole pixels so even tiles center on them exactly.
+			lattice.sites = append(lattice.sites, [2]float64{math.Round(x), math.Round(y)})
+			lattice.weights = append(lattice.weights, math.Exp(-falloff*d*d))
+		}
+	}
+	if len(lattice.sites) == 0 {
+		lattice.sites, lattice.weights = [][2]float64{{math.Round(cx), math.Round(cy)}}, []float64{1}
+	}
+	lattice.reset()
+	g.petek = lattice
+}
+
+// reset reopens every site. The Fenwick tree is built in place in O(n).
+func (l *hexLattice) reset() {
+	n := len(l.weights)
+	if l.free == nil {
+		l.free, l.taken = make([]float64, n+1), make([]bool, n)
+	}
+	copy(l.free[1:], l.weights)
+	for i := 1; i <= n; i++ {
+		if parent := i + i&-i; parent <= n {
+			l.free[parent] += l.free[i]
+		}
+	}
+	clear(l.taken)
+	l.open = n
+}
+
+// pick returns the site whose cumulative open weight first exceeds u times
+// the open total, u in [0, 1).
+func (l *hexLattice) pick(u float64) int {
+	n := len(l.weights)
+	total := 0.0
+	for i := n; i > 0; i -= i & -i {
+		total += l.free[i]
+	}
+	target := u * total
+	pos := 0
+	for bit := 1 << (bits.Len(uint(n)) - 1); bit > 0; bit >>= 1 {
+		if next := pos + bit; next <= n && l.free[next] <= target {
+			pos = next
+			target -= l.free[next]
+		}
+	}
+	// Rounding in the tree can land past the end or on a removed site; step
+	// to an open one.
+	pos = min(pos, n-1)
+	for i := 0; i < n; i++ {
+		if site := (pos + i) % n; !l.taken[site] {
+			return site
+		}
+	}
+	return pos
+}
+
+// take removes site from the current batch, reopening the lattice once every
+// site holds a tile of the batch.
+func (l *hexLattice) take(site int) {
+	if l.taken[site] {
+		return
+	}
+	l.taken[site] = true
+	l.open--
+	if l.open == 0 {
+		l.reset()
+		return
+	}
+	for i := site + 1; i < len(l.free); i += i & -i {
+		l.free[i] -= l.weights[site]
+	}
+}
+
+// beginBatch starts a new batch; with oneTilePerSite the lattice reopens.
+func (g *generator) beginBatch() {
+	if g.petek != nil && g.petek.oneTile && g.petek.open < len(g.petek.weights) {
+		g.petek.reset()
+	}
+}
+
+// positionPetek centers the tile on a lattice site. A site too close to the
+// canvas edge for the tile is drawn again; when every draw lands on one, the
+// tile is clamped onto the canvas like in every anchored mode, off its site,
+// and counted for petekWarnings.
+func (g *generator) positionPetek(tw, th int) (int, int) {
+	anchor := g.anchor
+	if anchor == "" {
+		anchor = anchorCenter
+	}
+	attempts := g.attempts(12)
+	for attempt := 1; ; attempt++ {
+		site := g.petek.pick(g.rnd.Float64())
+		g.lastSite = site
+		x, y := g.anchorOffset(anchor, g.petek.sites[site][0], g.petek.sites[site][1], tw, th)
+		if x >= 0 && y >= 0 && x+tw <= g.width && y+th <= g.height {
+			return x, y
+		}
+		if attempt >= attempts {
+			g.petek.clamped++
+			return clampInt(x, 0, g.width-tw), clampInt(y, 0, g.height-th)
+		}
+	}
+}
+
+// petekWarnings names the batches that oneTilePerSite cannot spread over
+// distinct sites because they have more tiles than the lattice has sites.
+func petekWarnings(gen *generator, batches []tileBatch) []string {
+	if gen.petek == nil || !gen.petek.oneTile {
+		return nil
+	}
+	var warnings []string
+	for _, batch := range batches {
+		if batch.Count > len(gen.petek.sites) {
+			warnings = append(warnings, fmt.Sprintf("tile %q has %d placements but petek has %d lattice sites; extra tiles share sites", batch.Name, batch.Count, len(gen.petek.sites)))
+		}
+	}
+	return warnings
+}
+
+// petekClampWarnings reports, after placement, the tiles positionPetek had to
+// clamp off their site.
+func petekClampWarnings(gen *generator) []string {
+	if gen.petek == nil || gen.petek.clamped == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("%d tiles were too large to center on any drawn petek lattice site and were clamped onto the canvas", gen.petek.clamped)}
+}
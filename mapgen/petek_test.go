@@ -0,0 +1,78 @@
+package mapgen
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// petekSites lays out the lattice a petek request uses.
+func petekSites(width, height, pitch int) map[[2]float64]bool {
+	g := newGenerator(width, height, modePetek, 0, 0, 0, 0, 0, rand.New(rand.NewSource(1)))
+	g.setHexLattice(pitch, defaultHexFalloff, false)
+	sites := map[[2]float64]bool{}
+	for _, site := range g.petek.sites {
+		sites[site] = true
+	}
+	return sites
+}
+
+// offSite counts the placements of layout not centered on a site. An odd
+// side centers on the middle of its center cell, an even side on the corner
+// between the two middle cells.
+func offSite(layout []Placement, sites map[[2]float64]bool) int {
+	off := 0
+	for _, pl := range layout {
+		center := [2]float64{float64(pl.X + pl.W/2), float64(pl.Y + pl.H/2)}
+		if !sites[center] {
+			off++
+		}
+	}
+	return off
+}
+
+func TestPetekCentersOnLattice(t *testing.T) {
+	pitch := 8
+	for _, tiles := range []string{"2x2*300,1x1*200", "3x3*100,2x1*100", "12x12*20,5x3*40"} {
+		t.Run(tiles, func(t *testing.T) {
+			result := generate(t, Request{W: 120, H: 90, Tiles: tiles, Mode: modePetek, HexPitch: &pitch, Format: formatJSON, Seed: "petek"})
+			if off := offSite(result.Layout, petekSites(120, 90, pitch)); off > 0 {
+				t.Errorf("%d of %d placements are off the lattice", off, len(result.Layout))
+			}
+			if len(result.Warnings) > 0 {
+				t.Errorf("warnings %q, want none", result.Warnings)
+			}
+		})
+	}
+}
+
+func TestPetekReportsClampedTiles(t *testing.T) {
+	// Only sites within a column of the center can center a 39-wide tile on
+	// the 40-wide canvas; draws that miss them all are clamped and reported.
+	pitch := 4
+	result := generate(t, Request{W: 40, H: 40, Tiles: "39x2*200", Mode: modePetek, HexPitch: &pitch, Format: formatJSON, Seed: "petek-edge"})
+	off := offSite(result.Layout, petekSites(40, 40, pitch))
+	if off == 0 {
+		t.Fatal("no tile was clamped; the test no longer covers clamping")
+	}
+	if len(result.Warnings) != 1 {
+		t.Fatalf("warnings %q, want one", result.Warnings)
+	}
+	// A clamped tile can still happen to center on some other site, so the
+	// warning counts at least the tiles found off the lattice.
+	var clamped int
+	if _, err := fmt.Sscanf(result.Warnings[0], "%d tiles were too large", &clamped); err != nil || clamped < off {
+		t.Errorf("warning %q, want one reporting at least %d clamped tiles", result.Warnings[0], off)
+	}
+}
+
+func TestPetekRejectsSnap(t *testing.T) {
+	snap := 2
+	if _, err := (&Request{W: 40, H: 40, Tiles: "2x2*10", Mode: modePetek, Snap: &snap}).Normalize(); err == nil {
+		t.Error("petek with snap 2 was accepted")
+	}
+	snap = 1
+	if _, err := (&Request{W: 40, H: 40, Tiles: "2x2*10", Mode: modePetek, Snap: &snap}).Normalize(); err != nil {
+		t.Errorf("petek with snap 1: %v", err)
+	}
+}
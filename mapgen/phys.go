@@ -0,0 +1,42 @@
+package mapgen
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+)
+
+// pngSignatureLen and ihdrChunkLen locate the end of the IHDR chunk, which the
+// PNG spec requires to come first; pHYs may follow it anywhere before IDAT.
+const (
+	pngSignatureLen = 8
+	ihdrChunkLen    = 4 + 4 + 13 + 4
+)
+
+// maxDpi keeps the pixels-per-metre value well inside pHYs's uint32 range.
+const maxDpi = 100000
+
+// withPhysChunk inserts a pHYs chunk declaring dpi into an encoded PNG. The
+// standard encoder has no hook for ancillary chunks, so it is spliced in right
+// after IHDR.
+func withPhysChunk(data []byte, dpi int) []byte {
+	offset := pngSignatureLen + ihdrChunkLen
+	if len(data) < offset {
+		return data
+	}
+
+	// pHYs stores pixels per metre; unit byte 1 means metres.
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:4], 9)
+	copy(chunk[4:8], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:12], ppm)
+	binary.BigEndian.PutUint32(chunk[12:16], ppm)
+	chunk[16] = 1
+	binary.BigEndian.PutUint32(chunk[17:21], crc32.ChecksumIEEE(chunk[4:17]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:offset]...)
+	out = append(out, chunk...)
+	return append(out, data[offset:]...)
+}
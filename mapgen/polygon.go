@@ -0,0 +1,133 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// maxPolygonVertices bounds polygonMask; every masked row walks all edges.
+const maxPolygonVertices = 4096
+
+// Polygon mask fits: what must lie inside the polygon for a placement to be
+// accepted.
+const (
+	polygonFitCenter = "center"
+	polygonFitRect   = "rect"
+)
+
+// normalizePolygonMask validates polygonMask, which needs at least three
+// vertices enclosing some area, and polygonMaskFit.
+func normalizePolygonMask(req *Request, p *Params) error {
+	p.PolygonMaskFit = strings.ToLower(strings.TrimSpace(req.PolygonMaskFit))
+	if len(req.PolygonMask) == 0 {
+		if p.PolygonMaskFit != "" {
+			return errors.New("polygonMaskFit requires polygonMask")
+		}
+		return nil
+	}
+	switch p.PolygonMaskFit {
+	case "":
+		p.PolygonMaskFit = polygonFitCenter
+	case polygonFitCenter, polygonFitRect:
+	default:
+		return fmt.Errorf("unsupported polygonMaskFit %q", req.PolygonMaskFit)
+	}
+	if len(req.PolygonMask) < 3 {
+		return fmt.Errorf("polygonMask needs at least 3 vertices, got %d", len(req.PolygonMask))
+	}
+	if len(req.PolygonMask) > maxPolygonVertices {
+		return fmt.Errorf("polygonMask has more than %d vertices", maxPolygonVertices)
+	}
+	// Twice the signed area by the shoelace formula.
+	area := 0.0
+	for i, a := range req.PolygonMask {
+		b := req.PolygonMask[(i+1)%len(req.PolygonMask)]
+		area += float64(a[0])*float64(b[1]) - float64(b[0])*float64(a[1])
+	}
+	if area == 0 {
+		return errors.New("polygonMask must enclose an area")
+	}
+	p.PolygonMask = req.PolygonMask
+	return nil
+}
+
+// polygonCrossings returns, sorted, the x coordinates at which the horizontal
+// line at y crosses the edges of poly. A point on that line is inside by the
+// even-odd rule when an odd number of crossings lie to its right, the count a
+// ray cast towards +x would meet.
+func polygonCrossings(poly [][2]int, y float64, xs []float64) []float64 {
+	xs = xs[:0]
+	for i := range poly {
+		ax, ay := float64(poly[i][0]), float64(poly[i][1])
+		bx, by := float64(poly[(i+1)%len(poly)][0]), float64(poly[(i+1)%len(poly)][1])
+		if (ay > y) != (by > y) {
+			xs = append(xs, ax+(y-ay)*(bx-ax)/(by-ay))
+		}
+	}
+	sort.Float64s(xs)
+	return xs
+}
+
+// insideCrossings reports whether x is inside given the sorted crossings of
+// its row.
+func insideCrossings(xs []float64, x float64) bool {
+	right := len(xs) - sort.Search(len(xs), func(i int) bool { return xs[i] > x })
+	return right%2 == 1
+}
+
+// maskAccepts reports whether the tw×th tile at (x, y) passes the polygon
+// mask: its center must lie inside, or with fit rect the center of every cell
+// it covers.
+func (g *generator) maskAccepts(x, y, tw, th int) bool {
+	if g.maskFit != polygonFitRect {
+		g.crossings = polygonCrossings(g.mask, float64(y)+float64(th)/2, g.crossings)
+		return insideCrossings(g.crossings, float64(x)+float64(tw)/2)
+	}
+	left, right := float64(x)+0.5, float64(x+tw)-0.5
+	for row := y; row < y+th; row++ {
+		g.crossings = polygonCrossings(g.mask, float64(row)+0.5, g.crossings)
+		if !insideCrossings(g.crossings, left) {
+			return false
+		}
+		// No edge may cross the row between its first and last cell.
+		i := sort.Search(len(g.crossings), func(i int) bool { return g.crossings[i] > left })
+		if i < len(g.crossings) && g.crossings[i] <= right {
+			return false
+		}
+	}
+	return true
+}
+
+// clipPolygon clears the coverage of cells whose center lies outside
+// polygonMask, so they stay background. The width×height grid starts at
+// canvas cell (x0, y0).
+func clipPolygon(coverage []float64, width, height, x0, y0 int, p Params) {
+	if len(p.PolygonMask) == 0 {
+		return
+	}
+	var xs []float64
+	for y := 0; y < height; y++ {
+		xs = polygonCrossings(p.PolygonMask, float64(y0+y)+0.5, xs)
+		row := coverage[y*width : (y+1)*width]
+		for x := range row {
+			if row[x] != 0 && !insideCrossings(xs, float64(x0+x)+0.5) {
+				row[x] = 0
+			}
+		}
+	}
+}
+
+// scalePolygon maps poly onto a grid scaled by sx, sy, for previews.
+func scalePolygon(poly [][2]int, sx, sy float64) [][2]int {
+	if len(poly) == 0 {
+		return nil
+	}
+	scaled := make([][2]int, len(poly))
+	for i, v := range poly {
+		scaled[i] = [2]int{int(math.Round(float64(v[0]) * sx)), int(math.Round(float64(v[1]) * sy))}
+	}
+	return scaled
+}
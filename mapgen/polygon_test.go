@@ -0,0 +1,19 @@
+package mapgen
+
+import "testing"
+
+func TestMaskAcceptsReusesCrossings(t *testing.T) {
+	square := [][2]int{{10, 10}, {50, 10}, {50, 50}, {10, 50}}
+	for _, fit := range []string{polygonFitCenter, polygonFitRect} {
+		g := &generator{width: 60, height: 60, mask: square, maskFit: fit}
+		if !g.maskAccepts(20, 20, 4, 4) {
+			t.Errorf("%s: tile inside the mask rejected", fit)
+		}
+		if g.maskAccepts(0, 0, 4, 4) {
+			t.Errorf("%s: tile outside the mask accepted", fit)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { g.maskAccepts(20, 20, 4, 4) }); allocs != 0 {
+			t.Errorf("%s: maskAccepts allocates %v times per call, want 0", fit, allocs)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package mapgen
+
+import (
+	"errors"
+	"image"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Preview limits: the long edge of the grid a preview is painted on, and the
+// retry budget of every placement strategy.
+const (
+	previewMaxSide  = 256
+	previewAttempts = 2
+)
+
+// normalizePreview rejects the options a preview cannot approximate: it
+// always renders a single PNG from tiles.
+func normalizePreview(p *Params) error {
+	switch {
+	case p.Format != formatPNG:
+		return errors.New("preview only renders format png")
+	case p.Pyramid > 0:
+		return errors.New("preview does not support pyramid")
+	case p.Animate != "":
+		return errors.New("preview cannot be animated")
+	case p.Mode == "magara":
+		return errors.New("preview does not support mode magara, whose automaton depends on the resolution")
+	case p.Sparse != nil && *p.Sparse:
+		return errors.New("preview does not support sparse")
+	}
+	return nil
+}
+
+// renderPreview places every batch with the cut-down budgets of gen, but
+// paints coverage onto a grid of at most previewMaxSide on the long edge and
+// scales the picture back up with nearest-neighbor sampling. Each placement
+// adds its weight to a preview cell in proportion to the area it covers, so
+// the cells carry the mean coverage of the pixels they stand for. Positions
+// are chosen on the full canvas from the same seed, so islands, rings and
+// sites sit where the full render puts them.
+func renderPreview(p Params, batches []tileBatch, rnd *rand.Rand, gen *generator, stats *Stats) frame {
+	stageStart := time.Now()
+
+	scale := math.Min(1, previewMaxSide/float64(max(p.Width, p.Height)))
+	q := p
+	q.Width = max(1, int(math.Round(float64(p.Width)*scale)))
+	q.Height = max(1, int(math.Round(float64(p.Height)*scale)))
+	q.Erode = int(math.Round(float64(p.Erode) * scale))
+	q.Dilate = int(math.Round(float64(p.Dilate) * scale))
+	q.Erosion = int(math.Round(float64(p.Erosion) * scale))
+	sx := float64(q.Width) / float64(p.Width)
+	sy := float64(q.Height) / float64(p.Height)
+	q.PolygonMask = scalePolygon(p.PolygonMask, sx, sy)
+
+	coverage := make([]float64, q.Width*q.Height)
+	var ringOf []int
+	if p.ColorByRing && p.placesMode("merkez") {
+		ringOf = make([]int, len(coverage))
+		for i := range ringOf {
+			ringOf[i] = -1
+		}
+	}
+	var jitter []tileJitter
+	if p.ColorJitter > 0 {
+		jitter = make([]tileJitter, len(coverage))
+	}
+	var layout []Placement
+
+	index := 0
+	total := placeTiles(p, batches, rnd, gen, func(pl Placement, weight float64, segment int) {
+		x, y, tw, th := padRect(pl.X, pl.Y, pl.W, pl.H, p.TilePadding)
+		fx0, fx1 := float64(x)*sx, float64(x+tw)*sx
+		fy0, fy1 := float64(y)*sy, float64(y+th)*sy
+		fillCoverageArea(coverage, q.Width, q.Height, fx0, fy0, fx1, fy1, weight)
+
+		// Labels go to every cell the tile touches.
+		x0, y0 := int(fx0), int(fy0)
+		w := max(1, int(math.Ceil(fx1))-x0)
+		h := max(1, int(math.Ceil(fy1))-y0)
+		if ringOf != nil {
+			fillCells(ringOf, q.Width, q.Height, x0, y0, w, h, segment)
+		}
+		if jitter != nil {
+			fillCells(jitter, q.Width, q.Height, x0, y0, w, h, placementJitter(gen.seed, index, p.ColorJitter))
+		}
+		if p.Outline {
+			layout = append(layout, Placement{Name: pl.Name, X: x0, Y: y0, W: w, H: h})
+		}
+		index++
+	})
+
+	stats.track(StagePlacement, stageStart)
+	stageStart = time.Now()
+
+	// Regions are assigned on the preview grid, so the sites shrink with it.
+	grid := &generator{width: q.Width, height: q.Height}
+	for _, site := range gen.voronoiSites {
+		grid.voronoiSites = append(grid.voronoiSites, image.Point{
+			X: min(int(float64(site.X)*sx), q.Width-1),
+			Y: min(int(float64(site.Y)*sy), q.Height-1),
+		})
+	}
+
+	f := colorFrame(q, grid, frame{
+		coverage:   coverage,
+		ringOf:     ringOf,
+		jitter:     jitter,
+		layout:     layout,
+		placements: total,
+	})
+	f.img = upscaleNearest(f.img, p.Width, p.Height)
+	// The statistics of the coarse grid would only approximate the map's.
+	f.balance = nil
+	if !f.bounds.Empty() {
+		// Every pixel a covered preview cell stands for counts as covered.
+		f.bounds = image.Rect(
+			int(float64(f.bounds.Min.X)/sx), int(float64(f.bounds.Min.Y)/sy),
+			int(math.Ceil(float64(f.bounds.Max.X)/sx)), int(math.Ceil(float64(f.bounds.Max.Y)/sy)),
+		).Intersect(image.Rect(0, 0, p.Width, p.Height))
+	}
+
+	stats.track(StageColoring, stageStart)
+	return f
+}
+
+// fillCoverageArea adds weight to the cells under the rectangle [x0, x1) ×
+// [y0, y1), given in fractional cells, scaled by the fraction of each cell the
+// rectangle covers.
+func fillCoverageArea(coverage []float64, width, height int, x0, y0, x1, y1, weight float64) {
+	x0, x1 = math.Max(x0, 0), math.Min(x1, float64(width))
+	y0, y1 = math.Max(y0, 0), math.Min(y1, float64(height))
+	if x0 >= x1 || y0 >= y1 {
+		return
+	}
+	for row := int(y0); row < height && float64(row) < y1; row++ {
+		dy := math.Min(y1, float64(row+1)) - math.Max(y0, float64(row))
+		for col := int(x0); col < width && float64(col) < x1; col++ {
+			dx := math.Min(x1, float64(col+1)) - math.Max(x0, float64(col))
+			coverage[row*width+col] += weight * dx * dy
+		}
+	}
+}
+
+// upscaleNearest scales src to width × height by nearest-neighbor sampling,
+// returning src itself when it already has that size.
+func upscaleNearest(src *image.RGBA, width, height int) *image.RGBA {
+	b := src.Bounds()
+	if b.Dx() == width && b.Dy() == height {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := y * b.Dy() / height
+		srcRow := src.Pix[sy*src.Stride : sy*src.Stride+b.Dx()*4]
+		dstRow := dst.Pix[y*dst.Stride : y*dst.Stride+width*4]
+		for x := 0; x < width; x++ {
+			sx := x * b.Dx() / width * 4
+			copy(dstRow[x*4:x*4+4], srcRow[sx:sx+4])
+		}
+	}
+	return dst
+}
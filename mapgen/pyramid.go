@@ -0,0 +1,174 @@
+package mapgen
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image/png"
+	"mime/multipart"
+	"net/textproto"
+)
+
+const (
+	pyramidMultipart = "multipart"
+	pyramidZip       = "zip"
+
+	// maxPyramid bounds the extra levels; a few halvings already reach the
+	// sizes slippy-map UIs use for overviews.
+	maxPyramid = 10
+)
+
+// pyramidLevel is one rendered zoom level of the coverage grid.
+type pyramidLevel struct {
+	name string
+	data []byte
+}
+
+// pyramidFileName is the predictable part/entry name of a level; level 0 is
+// the full-resolution image and each following level halves it.
+func pyramidFileName(level int) string {
+	return fmt.Sprintf("level-%d.png", level)
+}
+
+// downsampleCoverage halves the grid by averaging each 2x2 block. Blocks on an
+// odd right or bottom edge average only the cells that exist, so the area
+// weighting stays exact. Cells are summed in a fixed order, which keeps the
+// result bit-identical across runs.
+func downsampleCoverage(coverage []float64, width, height int) ([]float64, int, int) {
+	outW, outH := (width+1)/2, (height+1)/2
+	out := make([]float64, outW*outH)
+	for y := 0; y < outH; y++ {
+		for x := 0; x < outW; x++ {
+			sum, cells := 0.0, 0
+			for dy := 0; dy < 2; dy++ {
+				sy := 2*y + dy
+				if sy >= height {
+					continue
+				}
+				for dx := 0; dx < 2; dx++ {
+					sx := 2*x + dx
+					if sx >= width {
+						continue
+					}
+					sum += coverage[sy*width+sx]
+					cells++
+				}
+			}
+			out[y*outW+x] = sum / float64(cells)
+		}
+	}
+	return out, outW, outH
+}
+
+// downsampleRings picks, for each 2x2 block, the ring of its most covered cell
+// so ring colors follow the coverage they are drawn over. Ties go to the first
+// cell in row-major order.
+func downsampleRings(ringOf []int, coverage []float64, width, height int) []int {
+	outW, outH := (width+1)/2, (height+1)/2
+	out := make([]int, outW*outH)
+	for y := 0; y < outH; y++ {
+		for x := 0; x < outW; x++ {
+			best, bestCov := -1, 0.0
+			for dy := 0; dy < 2; dy++ {
+				sy := 2*y + dy
+				if sy >= height {
+					continue
+				}
+				for dx := 0; dx < 2; dx++ {
+					sx := 2*x + dx
+					if sx >= width {
+						continue
+					}
+					idx := sy*width + sx
+					if ringOf[idx] >= 0 && coverage[idx] > bestCov {
+						best, bestCov = ringOf[idx], coverage[idx]
+					}
+				}
+			}
+			out[y*outW+x] = best
+		}
+	}
+	return out
+}
+
+// pyramidDpi halves dpi once per level so every level prints at the size of
+// the base image, stopping at 1: a dpi of 0 would drop the pHYs chunk and
+// leave deep levels without any density. An unset dpi stays unset.
+func pyramidDpi(dpi, level int) int {
+	if dpi <= 0 {
+		return 0
+	}
+	return max(dpi>>level, 1)
+}
+
+// renderPyramid recolors count successively halved copies of the coverage
+// grid. Averaging happens on coverage rather than RGBA so every level uses the
+// same color ramp as the base image. Outlines are a full-resolution feature
+// and are not drawn on the smaller levels.
+func renderPyramid(coverage []float64, ringOf []int, segments int, jitter []tileJitter, p Params, count int) ([]pyramidLevel, error) {
+	levels := make([]pyramidLevel, 0, count)
+	width, height := p.Width, p.Height
+	for level := 1; level <= count; level++ {
+		if ringOf != nil {
+			ringOf = downsampleRings(ringOf, coverage, width, height)
+		}
+		if jitter != nil {
+			jitter = downsampleJitter(jitter, coverage, width, height)
+		}
+		coverage, width, height = downsampleCoverage(coverage, width, height)
+
+		img := newCanvas(width, height, p)
+		colorCoverage(img, coverage, ringOf, segments, jitter, p)
+		data, err := encodePNG(img, pyramidDpi(p.Dpi, level), png.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, pyramidLevel{name: pyramidFileName(level), data: data})
+	}
+	return levels, nil
+}
+
+// packPyramid bundles the levels, or the copies of sizes, into a zip archive
+// or a multipart/mixed body and returns it with its content type. Both use
+// fixed metadata (no zip timestamps, a seed-derived boundary) so identical
+// requests produce identical bytes.
+func packPyramid(levels []pyramidLevel, format string, seed int64) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if format == pyramidZip {
+		zw := zip.NewWriter(&buf)
+		for _, level := range levels {
+			entry, err := zw.Create(level.name)
+			if err != nil {
+				return nil, "", fmt.Errorf("encode zip: %w", err)
+			}
+			if _, err := entry.Write(level.data); err != nil {
+				return nil, "", fmt.Errorf("encode zip: %w", err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return nil, "", fmt.Errorf("encode zip: %w", err)
+		}
+		return buf.Bytes(), "application/zip", nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(fmt.Sprintf("mapgen-pyramid-%016x", uint64(seed))); err != nil {
+		return nil, "", err
+	}
+	for _, level := range levels {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "image/png")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", level.name))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, "", fmt.Errorf("encode multipart: %w", err)
+		}
+		if _, err := part.Write(level.data); err != nil {
+			return nil, "", fmt.Errorf("encode multipart: %w", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", fmt.Errorf("encode multipart: %w", err)
+	}
+	return buf.Bytes(), "multipart/mixed; boundary=" + mw.Boundary(), nil
+}
@@ -0,0 +1,138 @@
+package mapgen
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDownsampleCoverage(t *testing.T) {
+	tests := []struct {
+		name          string
+		coverage      []float64
+		width, height int
+		want          []float64
+		wantW, wantH  int
+	}{
+		{
+			name: "even grid",
+			coverage: []float64{
+				1, 3, 0, 0,
+				5, 7, 2, 2,
+				4, 4, 1, 0,
+				4, 4, 0, 0,
+			},
+			width: 4, height: 4,
+			want:  []float64{4, 1, 4, 0.25},
+			wantW: 2, wantH: 2,
+		},
+		{
+			// The right column and bottom row average only the cells that
+			// exist: 2 cells on the edges, 1 in the corner.
+			name: "odd grid",
+			coverage: []float64{
+				1, 1, 6,
+				1, 1, 2,
+				3, 5, 9,
+			},
+			width: 3, height: 3,
+			want:  []float64{1, 4, 4, 9},
+			wantW: 2, wantH: 2,
+		},
+		{
+			name:     "single row",
+			coverage: []float64{2, 4, 8},
+			width:    3, height: 1,
+			want:  []float64{3, 8},
+			wantW: 2, wantH: 1,
+		},
+		{
+			name:     "single cell",
+			coverage: []float64{5},
+			width:    1, height: 1,
+			want:  []float64{5},
+			wantW: 1, wantH: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, w, h := downsampleCoverage(tt.coverage, tt.width, tt.height)
+			if w != tt.wantW || h != tt.wantH {
+				t.Fatalf("size = %dx%d, want %dx%d", w, h, tt.wantW, tt.wantH)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("coverage = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestDownsampleCoverageRounding checks that a block whose sum is inexact in
+// floating point rounds the same way every run, the one a fixed row-major
+// summation order gives.
+func TestDownsampleCoverageRounding(t *testing.T) {
+	coverage := []float64{0.1, 0.2, 0.3, 0.4}
+	want := (((0.1 + 0.2) + 0.3) + 0.4) / 4
+	for run := 0; run < 100; run++ {
+		got, _, _ := downsampleCoverage(coverage, 2, 2)
+		if math.Float64bits(got[0]) != math.Float64bits(want) {
+			t.Fatalf("run %d: got %v (%#x), want %v (%#x)", run, got[0], math.Float64bits(got[0]), want, math.Float64bits(want))
+		}
+	}
+}
+
+func TestDownsampleCoverageKeepsMass(t *testing.T) {
+	const width, height = 64, 48
+	coverage := make([]float64, width*height)
+	for i := range coverage {
+		coverage[i] = float64((i*7919)%5) / 4
+	}
+	total := func(c []float64) float64 {
+		sum := 0.0
+		for _, v := range c {
+			sum += v
+		}
+		return sum
+	}
+	got, _, _ := downsampleCoverage(coverage, width, height)
+	// Every block of an even grid has 4 cells, so each level keeps a quarter
+	// of the mass.
+	if diff := math.Abs(total(got)*4 - total(coverage)); diff > 1e-9 {
+		t.Errorf("level mass %v x4 differs from %v by %v", total(got), total(coverage), diff)
+	}
+}
+
+func TestDownsampleRings(t *testing.T) {
+	coverage := []float64{
+		1, 3, 0, 0,
+		2, 3, 0, 1,
+	}
+	ringOf := []int{
+		0, 1, -1, -1,
+		2, 3, -1, 4,
+	}
+	got := downsampleRings(ringOf, coverage, 4, 2)
+	// The first block ties at coverage 3; the earlier cell wins. The second
+	// block has a single covered cell.
+	want := []int{1, 4}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("rings = %v, want %v", got, want)
+	}
+}
+
+func TestPyramidDpi(t *testing.T) {
+	tests := []struct{ dpi, level, want int }{
+		{0, 3, 0},
+		{300, 0, 300},
+		{300, 1, 150},
+		{300, 8, 1},
+		{300, 10, 1},
+		{1, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := pyramidDpi(tt.dpi, tt.level); got != tt.want {
+			t.Errorf("pyramidDpi(%d, %d) = %d, want %d", tt.dpi, tt.level, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// modeBolge places every tile in a cell of the regionWeights grid, picked by
+// weight, at a uniformly random point inside that cell.
+const modeBolge = "bolge"
+
+// maxRegionCells bounds the regionWeights grid; finer control is what density
+// maps are for.
+const maxRegionCells = 65536
+
+// regionGrid is the cumulative weight table of regionWeights, row-major.
+type regionGrid struct {
+	rows, cols int
+	weights    []float64
+	cumulative []float64
+}
+
+// normalizeRegionWeights validates regionWeights: rows of one length, weights
+// non-negative with at least one positive. Only mode bolge reads the grid, so
+// it is rejected elsewhere; an empty grid leaves bolge uniform.
+func normalizeRegionWeights(req *Request, p *Params) error {
+	if len(req.RegionWeights) == 0 {
+		return nil
+	}
+	if !p.placesMode(modeBolge) {
+		return fmt.Errorf("regionWeights requires mode %q", modeBolge)
+	}
+	cols := len(req.RegionWeights[0])
+	if cols == 0 {
+		return errors.New("regionWeights rows must not be empty")
+	}
+	if len(req.RegionWeights)*cols > maxRegionCells {
+		return fmt.Errorf("regionWeights has more than %d cells", maxRegionCells)
+	}
+	total := 0.0
+	for row, weights := range req.RegionWeights {
+		if len(weights) != cols {
+			return fmt.Errorf("regionWeights[%d] has %d columns, want %d like row 0", row, len(weights), cols)
+		}
+		for col, w := range weights {
+			if !(w >= 0) || math.IsInf(w, 0) {
+				return fmt.Errorf("regionWeights[%d][%d] must be a non-negative number", row, col)
+			}
+			total += w
+		}
+	}
+	if total <= 0 {
+		return errors.New("regionWeights must have at least one positive weight")
+	}
+	p.RegionWeights = req.RegionWeights
+	return nil
+}
+
+// setRegionWeights builds the sampling table of weights; without weights the
+// whole canvas is one cell.
+func (g *generator) setRegionWeights(weights [][]float64) {
+	if len(weights) == 0 {
+		weights = [][]float64{{1}}
+	}
+	grid := &regionGrid{rows: len(weights), cols: len(weights[0])}
+	sum := 0.0
+	for _, row := range weights {
+		for _, w := range row {
+			sum += w
+			grid.weights = append(grid.weights, w)
+			grid.cumulative = append(grid.cumulative, sum)
+		}
+	}
+	g.regions = grid
+}
+
+// positionRegion picks a grid cell by weight and a uniformly random point in
+// it, then anchors the tile there. Cells cover the canvas in equal shares, so
+// with uneven divisions some cells are a pixel wider than others.
+func (g *generator) positionRegion(tw, th int) (int, int) {
+	grid := g.regions
+	r := g.rnd.Float64() * grid.cumulative[len(grid.cumulative)-1]
+	idx := sort.SearchFloat64s(grid.cumulative, r)
+	if idx >= len(grid.cumulative) {
+		idx = len(grid.cumulative) - 1
+	}
+	// Step past zero-weight cells when r lands exactly on a shared boundary.
+	for idx < len(grid.cumulative)-1 && grid.weights[idx] <= 0 {
+		idx++
+	}
+	row, col := idx/grid.cols, idx%grid.cols
+
+	x0 := float64(col*g.width) / float64(grid.cols)
+	x1 := float64((col+1)*g.width) / float64(grid.cols)
+	y0 := float64(row*g.height) / float64(grid.rows)
+	y1 := float64((row+1)*g.height) / float64(grid.rows)
+	px := x0 + g.rnd.Float64()*(x1-x0)
+	py := y0 + g.rnd.Float64()*(y1-y0)
+	return g.anchorTile(px, py, tw, th)
+}
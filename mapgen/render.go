@@ -0,0 +1,390 @@
+package mapgen
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxMorphology bounds erode and dilate; each iteration is a full-grid sweep.
+const maxMorphology = 256
+
+// applyMorphology runs erode iterations followed by dilate iterations over the
+// binary land mask (coverage > 0) using a 3x3 neighborhood. Cells removed by
+// erosion drop to zero coverage, cells grown by dilation become single coverage
+// and surviving cells keep their original overlap count.
+func applyMorphology(coverage []float64, width, height, erode, dilate int) {
+	mask := make([]bool, len(coverage))
+	for i, c := range coverage {
+		mask[i] = c > 0
+	}
+	next := make([]bool, len(mask))
+
+	step := func(keep bool) {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				idx := y*width + x
+				if mask[idx] != keep {
+					next[idx] = mask[idx]
+					continue
+				}
+				flip := false
+				for dy := -1; dy <= 1 && !flip; dy++ {
+					ny := y + dy
+					if ny < 0 || ny >= height {
+						continue
+					}
+					for dx := -1; dx <= 1; dx++ {
+						nx := x + dx
+						if nx < 0 || nx >= width {
+							continue
+						}
+						if mask[ny*width+nx] != keep {
+							flip = true
+							break
+						}
+					}
+				}
+				next[idx] = keep != flip
+			}
+		}
+		mask, next = next, mask
+	}
+
+	for i := 0; i < erode; i++ {
+		step(true)
+	}
+	for i := 0; i < dilate; i++ {
+		step(false)
+	}
+
+	for i := range coverage {
+		switch {
+		case !mask[i]:
+			coverage[i] = 0
+		case coverage[i] <= 0:
+			coverage[i] = 1
+		}
+	}
+}
+
+// clipOcean clears the coverage of cells farther than OceanRadiusFrac × half
+// the shorter canvas side from the canvas center, so they stay background no
+// matter what was placed there. The width×height grid starts at canvas cell
+// (x0, y0).
+func clipOcean(coverage []float64, width, height, x0, y0 int, p Params) {
+	if p.OceanRadiusFrac <= 0 {
+		return
+	}
+	radius := p.OceanRadiusFrac * float64(min(p.Width, p.Height)) / 2
+	cx, cy := float64(p.Width)/2, float64(p.Height)/2
+	for y := 0; y < height; y++ {
+		dy := float64(y0+y) + 0.5 - cy
+		for x := 0; x < width; x++ {
+			dx := float64(x0+x) + 0.5 - cx
+			if dx*dx+dy*dy > radius*radius {
+				coverage[y*width+x] = 0
+			}
+		}
+	}
+}
+
+// newCanvas returns a canvas filled with the water color (black by default)
+// at the requested background alpha, or opaque when the map is inverted.
+func newCanvas(width, height int, p Params) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	water := defaultWater
+	if p.WaterColor != nil {
+		water = *p.WaterColor
+	}
+	alpha := clampInt(p.BgAlpha, 0, 255)
+	if p.Invert {
+		alpha = 255
+	}
+	bg := color.RGBA{
+		R: uint8(int(water.R) * alpha / 255),
+		G: uint8(int(water.G) * alpha / 255),
+		B: uint8(int(water.B) * alpha / 255),
+		A: uint8(alpha),
+	}
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: bg}, image.Point{}, draw.Src)
+	return img
+}
+
+// colorCoverage paints every covered cell of the grid onto img, which must
+// match the grid size. ringOf is nil unless cells are colored by region (merkez
+// ring or voronoi site), in which case segments is the number of regions, and
+// jitter is nil unless tiles perturb their color. With bevel, coast cells are
+// shaded by their water neighbors in this grid, see bevelLight.
+func colorCoverage(img *image.RGBA, coverage []float64, ringOf []int, segments int, jitter []tileJitter, p Params) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			idx := y*width + x
+			c := coverage[idx]
+			if c <= 0 {
+				continue
+			}
+			ring := -1
+			if ringOf != nil {
+				ring = ringOf[idx]
+			}
+			var j tileJitter
+			if jitter != nil {
+				j = jitter[idx]
+			}
+			col := cellColor(c, ring, segments, j, p)
+			if p.Bevel {
+				col = bevelColor(col, bevelLight(coverage, width, height, x, y), p.BevelStrength)
+			}
+			img.SetRGBA(x, y, col)
+		}
+	}
+}
+
+// cellColor is the color of one covered cell. ring is its region, or -1 when
+// cells are not colored by region. Inverted maps leave covered cells
+// transparent.
+func cellColor(c float64, ring, segments int, j tileJitter, p Params) color.RGBA {
+	if p.Invert {
+		return color.RGBA{}
+	}
+	low, high := defaultLand, defaultPeak
+	if p.LandColor != nil {
+		low = *p.LandColor
+	}
+	if p.PeakColor != nil {
+		high = *p.PeakColor
+	}
+	if ring >= 0 {
+		low = ringColor(ring, segments)
+		high = blendColor(low, color.RGBA{A: 255}, 0.5)
+	}
+	col := coverageToColor(c, p.brownCap(), p.LogTone, low, high)
+	switch {
+	case c < 1 && p.Shallow != nil:
+		col = blendColor(*p.Shallow, low, c)
+	case c == 1 && p.ColorOne != nil:
+		// colorOne replaces only the cells covered exactly once; the ramp
+		// above still starts from low.
+		col = *p.ColorOne
+	}
+	return jitterColor(col, j)
+}
+
+// brownCap is the coverage scale used for coloring: the percentile-derived
+// cap when brownPercentile resolved one, otherwise BrownCap.
+func (p Params) brownCap() float64 {
+	if p.brownLimit > 0 {
+		return p.brownLimit
+	}
+	return float64(p.BrownCap)
+}
+
+// percentileCap returns the brownCap that saturates brown at the given
+// percentile (nearest rank) of the covered cells' coverage. Like BrownCap it
+// is never below 1; without covered cells it returns 0, leaving BrownCap in
+// effect.
+func percentileCap(covered []float64, percentile float64) float64 {
+	if len(covered) == 0 {
+		return 0
+	}
+	slices.Sort(covered)
+	rank := int(math.Ceil(percentile/100*float64(len(covered)))) - 1
+	rank = min(max(rank, 0), len(covered)-1)
+	// Brown saturates at coverage brownCap+1.
+	return math.Max(covered[rank]-1, 1)
+}
+
+// countSaturated counts the covered cells and those whose coverage is at
+// least brownCap.
+func countSaturated(coverage []float64, brownCap float64) (covered, saturated int) {
+	for _, c := range coverage {
+		if c <= 0 {
+			continue
+		}
+		covered++
+		if c >= brownCap {
+			saturated++
+		}
+	}
+	return covered, saturated
+}
+
+func saturationFraction(covered, saturated int) float64 {
+	if covered == 0 {
+		return 0
+	}
+	return float64(saturated) / float64(covered)
+}
+
+// coverageToColor maps accumulated tile weight to a color. Whole-number
+// coverage ramps from green to brown as before; coverage below 1, produced by
+// light tile weights, renders as green at proportionally reduced alpha.
+func coverageToColor(coverage float64, brownCap float64, logTone bool, green, brown color.RGBA) color.RGBA {
+	if coverage <= 0 {
+		return color.RGBA{R: 0, G: 0, B: 0, A: 0}
+	}
+	if coverage < 1 {
+		light := green
+		light.A = uint8(math.Round(float64(green.A) * coverage))
+		return light
+	}
+	if coverage == 1 {
+		return green
+	}
+	return blendColor(green, brown, toneRatio(coverage, brownCap, logTone))
+}
+
+// toneRatio is how far coverage of at least 1 has climbed the green-to-brown
+// ramp, from 0 at coverage 1 to 1 at brownCap+1 and above.
+func toneRatio(coverage float64, brownCap float64, logTone bool) float64 {
+	if brownCap <= 0 {
+		brownCap = 1
+	}
+
+	var ratio float64
+	if logTone {
+		ratio = math.Log(coverage) / math.Log(brownCap+1)
+	} else {
+		ratio = (coverage - 1) / brownCap
+	}
+	if ratio < 0 {
+		ratio = 0
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	return ratio
+}
+
+func blendColor(a, b color.RGBA, t float64) color.RGBA {
+	clamp := func(v float64) uint8 {
+		if v < 0 {
+			return 0
+		}
+		if v > 255 {
+			return 255
+		}
+		return uint8(math.Round(v))
+	}
+
+	ai := float64(a.A)
+	bi := float64(b.A)
+	alpha := (1-t)*ai + t*bi
+	if alpha == 0 {
+		alpha = 1
+	}
+
+	return color.RGBA{
+		R: clamp((1-t)*float64(a.R) + t*float64(b.R)),
+		G: clamp((1-t)*float64(a.G) + t*float64(b.G)),
+		B: clamp((1-t)*float64(a.B) + t*float64(b.B)),
+		A: clamp(alpha),
+	}
+}
+
+// ringColor picks an evenly spaced hue for ring index out of segments so each
+// merkez ring renders in its own color.
+func ringColor(index, segments int) color.RGBA {
+	if segments <= 0 {
+		segments = 1
+	}
+	return hsvColor(float64(index)*360/float64(segments), 0.65, 0.85)
+}
+
+func hsvColor(hue, sat, val float64) color.RGBA {
+	hue = math.Mod(hue, 360)
+	if hue < 0 {
+		hue += 360
+	}
+	c := val * sat
+	x := c * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := val - c
+
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g, b = c, x, 0
+	case hue < 120:
+		r, g, b = x, c, 0
+	case hue < 180:
+		r, g, b = 0, c, x
+	case hue < 240:
+		r, g, b = 0, x, c
+	case hue < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return color.RGBA{
+		R: uint8(math.Round((r + m) * 255)),
+		G: uint8(math.Round((g + m) * 255)),
+		B: uint8(math.Round((b + m) * 255)),
+		A: 255,
+	}
+}
+
+// outlineColor is the tile border color: the request's outlineColor when set,
+// otherwise a dark green.
+func outlineColor(p Params) color.RGBA {
+	if p.OutlineColor != nil {
+		return *p.OutlineColor
+	}
+	return color.RGBA{R: 17, G: 70, B: 17, A: 255}
+}
+
+// drawOutlines strokes the 1px perimeter of every placement rectangle so
+// individual tiles stay legible on top of the overlap ramp. Only pixels land
+// reports covered are stroked, so borders do not cross cells that erode,
+// oceanRadiusFrac or polygonMask cleared after placement.
+func drawOutlines(img *image.RGBA, layout []Placement, border color.RGBA, land func(x, y int) bool) {
+	bounds := img.Bounds()
+	set := func(x, y int) {
+		if image.Pt(x, y).In(bounds) && land(x, y) {
+			img.SetRGBA(x, y, border)
+		}
+	}
+	for _, pl := range layout {
+		x1, y1 := pl.X+pl.W-1, pl.Y+pl.H-1
+		for x := pl.X; x <= x1; x++ {
+			set(x, pl.Y)
+			set(x, y1)
+		}
+		for y := pl.Y; y <= y1; y++ {
+			set(pl.X, y)
+			set(x1, y)
+		}
+	}
+}
+
+// coveredIn reports whether a cell of the width-wide coverage grid is land,
+// for drawOutlines.
+func coveredIn(coverage []float64, width int) func(x, y int) bool {
+	return func(x, y int) bool { return coverage[y*width+x] > 0 }
+}
+
+// parseHexColor accepts #rgb, #rrggbb or #rrggbbaa with an optional leading #.
+func parseHexColor(input string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(input), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: use #rrggbb or #rrggbbaa", input)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: %w", input, err)
+	}
+	return color.RGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
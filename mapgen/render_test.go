@@ -0,0 +1,140 @@
+package mapgen
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// coverageCells generates req as csv and returns the coverage of every
+// covered cell by position.
+func coverageCells(t *testing.T, req Request) map[image.Point]float64 {
+	t.Helper()
+	req.Format, req.Sparse = formatCSV, nil
+	cells := map[image.Point]float64{}
+	scanner := bufio.NewScanner(bytes.NewReader(generate(t, req).Data))
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		x, _ := strconv.Atoi(fields[0])
+		y, _ := strconv.Atoi(fields[1])
+		c, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			t.Fatalf("csv line %q: %v", scanner.Text(), err)
+		}
+		cells[image.Point{X: x, Y: y}] = c
+	}
+	return cells
+}
+
+func decodePNG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+	return img
+}
+
+func TestColorOnePropagates(t *testing.T) {
+	yes, no := true, false
+	shift := 180.0
+	magenta := color.NRGBA{R: 255, B: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 255}
+	tests := []struct {
+		name string
+		req  Request
+		want color.NRGBA
+	}{
+		{"dense", Request{Sparse: &no}, magenta},
+		{"sparse", Request{Sparse: &yes}, magenta},
+		{"colorByRing", Request{ColorByRing: &yes}, magenta},
+		{"autoPalette", Request{AutoPalette: &yes}, magenta},
+		// The HSL adjustments recolor colorOne like the rest of the land.
+		{"hueShift", Request{HueShift: &shift}, green},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.W, req.H, req.Tiles, req.Seed = 80, 60, "2x2*300,1x1*300", "color-one"
+			cells := coverageCells(t, req)
+			req.ColorOne = "#ff00ff"
+			img := decodePNG(t, generate(t, req).Data)
+
+			once := 0
+			for pt, c := range cells {
+				got := color.NRGBAModel.Convert(img.At(pt.X, pt.Y)).(color.NRGBA)
+				if c == 1 {
+					once++
+					if got != tt.want {
+						t.Fatalf("cell %v covered once is %v, want %v", pt, got, tt.want)
+					}
+				} else if got == tt.want {
+					t.Fatalf("cell %v covered %v times is painted colorOne", pt, c)
+				}
+			}
+			if once == 0 {
+				t.Fatal("no cell is covered exactly once")
+			}
+		})
+	}
+}
+
+func TestMorphologyBounds(t *testing.T) {
+	for _, n := range []int{-1, 0, maxMorphology, maxMorphology + 1} {
+		ok := n >= 0 && n <= maxMorphology
+		for name, req := range map[string]Request{
+			"erode":  {W: 20, H: 20, Tiles: "1x1*10", Erode: &n},
+			"dilate": {W: 20, H: 20, Tiles: "1x1*10", Dilate: &n},
+		} {
+			if _, err := req.Normalize(); (err == nil) != ok {
+				t.Errorf("%s %d: err = %v, want accepted %v", name, n, err, ok)
+			}
+		}
+	}
+}
+
+func TestOutlinesStayOnLand(t *testing.T) {
+	yes, no := true, false
+	ocean, erode := 0.6, 2
+	magenta := color.NRGBA{R: 255, B: 255, A: 255}
+	tests := []struct {
+		name string
+		req  Request
+	}{
+		{"oceanRadiusFrac", Request{OceanRadiusFrac: &ocean, Sparse: &no}},
+		{"sparse oceanRadiusFrac", Request{OceanRadiusFrac: &ocean, Sparse: &yes}},
+		{"polygonMask", Request{PolygonMask: [][2]int{{0, 0}, {80, 0}, {0, 60}}}},
+		{"erode", Request{Erode: &erode}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.W, req.H, req.Tiles, req.Seed = 80, 60, "6x6*60,3x3*80", "outline-clip"
+			req.Outline, req.OutlineColor = &yes, "#ff00ff"
+			cells := coverageCells(t, req)
+			img := decodePNG(t, generate(t, req).Data)
+
+			stroked := 0
+			for y := 0; y < req.H; y++ {
+				for x := 0; x < req.W; x++ {
+					if color.NRGBAModel.Convert(img.At(x, y)) != magenta {
+						continue
+					}
+					stroked++
+					if _, ok := cells[image.Point{X: x, Y: y}]; !ok {
+						t.Fatalf("outline drawn on uncovered cell (%d,%d)", x, y)
+					}
+				}
+			}
+			if stroked == 0 {
+				t.Fatal("no outline drawn")
+			}
+		})
+	}
+}
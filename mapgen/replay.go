@@ -0,0 +1,253 @@
+package mapgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// replayVersion is the replay format this package writes. Readers accept every
+// version up to their own and ignore fields they do not know, so later
+// versions may add optional fields freely; a change older readers would
+// misinterpret must bump the version instead, and such replays are rejected.
+const replayVersion = 1
+
+// Replay is the recorded outcome of a placement pass: everything needed to
+// paint the map again without drawing a single random number. It is written
+// by format "replay" and painted by RenderReplay.
+type Replay struct {
+	Version int    `json:"version"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Mode    string `json:"mode"`
+	// Seed feeds the seed-derived render options, colorJitter and autoPalette.
+	Seed  int64 `json:"seed"`
+	Rings int   `json:"rings"`
+	// Sites are the voronoi region sites, only set in voronoi mode.
+	Sites      [][2]int          `json:"sites,omitempty"`
+	Placements []ReplayPlacement `json:"placements"`
+}
+
+// ReplayPlacement is one placement in paint order with the coverage weight it
+// added and its merkez ring, -1 outside merkez mode.
+type ReplayPlacement struct {
+	Placement
+	Weight  float64 `json:"weight"`
+	Segment int     `json:"segment"`
+}
+
+// generateReplay runs the placement pass and encodes it as a replay instead of
+// painting it.
+func generateReplay(p Params, batches []tileBatch, scaling []TileScaling, scale float64, seed int64, rnd *rand.Rand, gen *generator, stats *Stats) (Result, error) {
+	stageStart := time.Now()
+	replay := Replay{
+		Version: replayVersion,
+		Width:   p.Width,
+		Height:  p.Height,
+		Mode:    p.Mode,
+		Seed:    seed,
+		Rings:   p.Rings,
+	}
+	for _, site := range gen.voronoiSites {
+		replay.Sites = append(replay.Sites, [2]int{site.X, site.Y})
+	}
+	var layout []Placement
+	total := placeTiles(p, batches, rnd, gen, func(pl Placement, weight float64, segment int) {
+		replay.Placements = append(replay.Placements, ReplayPlacement{Placement: pl, Weight: weight, Segment: segment})
+		layout = append(layout, pl)
+	})
+	stats.track(StagePlacement, stageStart)
+	stageStart = time.Now()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(replay); err != nil {
+		return Result{}, fmt.Errorf("encode replay: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return Result{}, fmt.Errorf("encode replay: %w", err)
+	}
+	stats.track(StageEncoding, stageStart)
+
+	return Result{
+		Data:            buf.Bytes(),
+		ContentType:     "application/gzip",
+		Width:           p.Width,
+		Height:          p.Height,
+		Mode:            p.Mode,
+		Batches:         len(batches),
+		TotalPlacements: total,
+		Seed:            seed,
+		Scale:           scale,
+		Tiles:           scaling,
+		Layout:          layout,
+		ModeMix:         gen.modeCounts(),
+		Islands:         gen.islandReport(),
+	}, nil
+}
+
+// ParseReplay decodes a replay written by format "replay", gzipped or not.
+func ParseReplay(data []byte) (*Replay, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("replay: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	var replay Replay
+	if err := json.NewDecoder(r).Decode(&replay); err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	switch {
+	case replay.Version < 1:
+		return nil, errors.New("replay: missing version")
+	case replay.Version > replayVersion:
+		return nil, fmt.Errorf("replay: version %d is newer than the supported version %d", replay.Version, replayVersion)
+	case replay.Width <= 0 || replay.Height <= 0:
+		return nil, errors.New("replay: width and height must be positive")
+	case replay.Mode == "voronoi" && len(replay.Sites) == 0:
+		return nil, errors.New("replay: voronoi mode requires sites")
+	}
+	for i, pl := range replay.Placements {
+		if pl.W <= 0 || pl.H <= 0 {
+			return nil, fmt.Errorf("replay: placement %d has a non-positive size", i)
+		}
+	}
+	return &replay, nil
+}
+
+// LayoutPlacement is one rectangle of a layout to paint: a Placement as
+// exported in the "layout" array of format "json", with an optional coverage
+// weight that defaults to 1.
+type LayoutPlacement struct {
+	Placement
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+// LayoutReplay builds a replay from an exported layout so it can be painted
+// by RenderReplay. The canvas size, mode, rings and seed come from p. A layout
+// carries no merkez rings, so colorByRing leaves its cells uncolored by ring,
+// and no voronoi sites, so voronoi mode is rejected.
+func LayoutReplay(p Params, layout []LayoutPlacement) (*Replay, error) {
+	if p.Mode == "voronoi" {
+		return nil, errors.New("layout: voronoi mode needs region sites; record a replay instead")
+	}
+	seed := paramsSeed(p)
+	replay := &Replay{
+		Version: replayVersion,
+		Width:   p.Width,
+		Height:  p.Height,
+		Mode:    p.Mode,
+		Seed:    seed,
+		Rings:   p.Rings,
+	}
+	for i, pl := range layout {
+		if pl.W <= 0 || pl.H <= 0 {
+			return nil, fmt.Errorf("layout: placement %d has a non-positive size", i)
+		}
+		weight := 1.0
+		if pl.Weight != nil {
+			if *pl.Weight <= 0 {
+				return nil, fmt.Errorf("layout: placement %d must have a positive weight", i)
+			}
+			weight = *pl.Weight
+		}
+		replay.Placements = append(replay.Placements, ReplayPlacement{Placement: pl.Placement, Weight: weight, Segment: -1})
+	}
+	return replay, nil
+}
+
+// RenderReplay paints a replay with the render options of p: colors, tones,
+// morphology, outlines, jitter, dpi and pyramid. Canvas size, mode, rings and
+// seed come from the replay, and placement settings in p are ignored. With the
+// options used to record it, the output is byte-identical to the original
+// render.
+func RenderReplay(replay *Replay, p Params, stats *Stats) (Result, error) {
+	if p.Format != formatPNG {
+		return Result{}, fmt.Errorf("replays only render to format %q", formatPNG)
+	}
+	if p.Animate != "" {
+		return Result{}, errors.New("replays cannot be animated")
+	}
+	if p.Render != "" {
+		return Result{}, fmt.Errorf("replays do not record the ring boundaries render %q traces", p.Render)
+	}
+	stageStart := time.Now()
+
+	p.Width, p.Height = replay.Width, replay.Height
+	p.Mode, p.Rings = replay.Mode, replay.Rings
+	palette := resolvePalette(&p, replay.Seed)
+
+	gen := &generator{width: p.Width, height: p.Height}
+	for _, site := range replay.Sites {
+		gen.voronoiSites = append(gen.voronoiSites, image.Point{X: site[0], Y: site[1]})
+	}
+
+	f := frame{
+		coverage:   make([]float64, p.Width*p.Height),
+		placements: len(replay.Placements),
+	}
+	if p.ColorByRing && (p.Mode == "merkez" || p.Mode == modeKarma) {
+		f.ringOf = make([]int, len(f.coverage))
+		for i := range f.ringOf {
+			f.ringOf[i] = -1
+		}
+	}
+	if p.ColorJitter > 0 {
+		f.jitter = make([]tileJitter, len(f.coverage))
+	}
+	requested := 0
+	for index, pl := range replay.Placements {
+		if p.Outline {
+			f.layout = append(f.layout, pl.Placement)
+		}
+		x, y, tw, th := padRect(pl.X, pl.Y, pl.W, pl.H, p.TilePadding)
+		f.landCells += fillCoverage(f.coverage, p.Width, p.Height, x, y, tw, th, pl.Weight)
+		requested += pl.W * pl.H
+		if f.ringOf != nil {
+			fillCells(f.ringOf, p.Width, p.Height, x, y, tw, th, pl.Segment)
+		}
+		if f.jitter != nil {
+			fillCells(f.jitter, p.Width, p.Height, x, y, tw, th, placementJitter(replay.Seed, index, p.ColorJitter))
+		}
+	}
+	f = colorFrame(p, gen, f)
+	window, crop, warnings := cropFrame(&f, p, nil)
+	stats.track(StageColoring, stageStart)
+	stageStart = time.Now()
+
+	data, contentType, err := encodeImage(f, p, replay.Seed)
+	if err != nil {
+		return Result{}, err
+	}
+	stats.track(StageEncoding, stageStart)
+
+	return Result{
+		Data:            data,
+		ContentType:     contentType,
+		Width:           window.Dx(),
+		Height:          window.Dy(),
+		Mode:            p.Mode,
+		TotalPlacements: f.placements,
+		Seed:            replay.Seed,
+		Scale:           1,
+		Palette:         palette,
+		Saturation:      f.saturation,
+		Warnings:        warnings,
+		LandBounds:      f.bounds,
+		Balance:         f.balance,
+		Crop:            crop,
+		RequestedArea:   requested,
+		LandCells:       f.landCells,
+		OverlapFactor:   overlapFactor(requested, f.landCells),
+	}, nil
+}
@@ -0,0 +1,61 @@
+package mapgen
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReplayRoundTrip(t *testing.T) {
+	yes, jitter := true, 0.2
+	tests := []struct {
+		name string
+		req  Request
+	}{
+		{"merkez", Request{W: 120, H: 90, Tiles: "3x3*60,2x2*300,1x1*200"}},
+		{"merkez colorByRing", Request{W: 120, H: 90, Tiles: "2x2*300", ColorByRing: &yes}},
+		{"adalar outline", Request{W: 150, H: 100, Tiles: "4x2*80,1x1*300", Mode: "adalar", Outline: &yes}},
+		{"voronoi", Request{W: 120, H: 120, Tiles: "2x2*400", Mode: "voronoi"}},
+		{"agirlik colorJitter", Request{W: 100, H: 100, Tiles: "2x2*300", Mode: "agirlik", ColorJitter: &jitter}},
+		{"tilePadding", Request{W: 100, H: 100, Tiles: "3x3*200", TilePadding: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.Seed = "replay-" + tt.name
+			params, err := req.Normalize()
+			if err != nil {
+				t.Fatalf("normalize: %v", err)
+			}
+			original, err := Generate(params, nil)
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+
+			req.Format = formatReplay
+			recorded := generate(t, req)
+			replay, err := ParseReplay(recorded.Data)
+			if err != nil {
+				t.Fatalf("parse replay: %v", err)
+			}
+			if len(replay.Placements) != original.TotalPlacements {
+				t.Errorf("replay holds %d placements, the original placed %d", len(replay.Placements), original.TotalPlacements)
+			}
+
+			rendered, err := RenderReplay(replay, params, nil)
+			if err != nil {
+				t.Fatalf("render replay: %v", err)
+			}
+			if !bytes.Equal(rendered.Data, original.Data) {
+				t.Error("replayed image differs from the original")
+			}
+		})
+	}
+}
+
+func TestParseReplayRejectsGarbage(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("{"), []byte("not a replay")} {
+		if _, err := ParseReplay(data); err == nil {
+			t.Errorf("ParseReplay(%q) succeeded", data)
+		}
+	}
+}
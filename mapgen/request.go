@@ -0,0 +1,840 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Request is the JSON payload accepted by the generator. Pointer fields are
+// optional and fall back to defaults in Normalize.
+type Request struct {
+	W                      int            `json:"w"`
+	H                      int            `json:"h"`
+	Tiles                  string         `json:"tiles"`
+	Ka                     *float64       `json:"ka"`
+	Cap                    *int           `json:"cap"`
+	Mode                   string         `json:"mode"`
+	Rings                  *int           `json:"rings"`
+	RingStart              *float64       `json:"ringStart"`
+	RingEnd                *float64       `json:"ringEnd"`
+	Seed                   string         `json:"seed"`
+	LogTone                *int           `json:"logTone"`
+	BrownCap               *int           `json:"brownCap"`
+	BgAlpha                *int           `json:"bgA"`
+	Islands                *int           `json:"islands"`
+	IslandRFrac            *float64       `json:"islandRFrac"`
+	Rotate                 *int           `json:"rot"`
+	N22                    *int           `json:"n22"`
+	N21                    *int           `json:"n21"`
+	N11                    *int           `json:"n11"`
+	Density                string         `json:"density"`
+	DensityStrict          *bool          `json:"densityStrict"`
+	Erode                  *int           `json:"erode"`
+	Dilate                 *int           `json:"dilate"`
+	RotateProb             *float64       `json:"rotateProb"`
+	CapPolicy              string         `json:"capPolicy"`
+	Format                 string         `json:"format"`
+	ColorByRing            *bool          `json:"colorByRing"`
+	TileList               []TileEntry    `json:"tileList"`
+	Shallow                string         `json:"shallow"`
+	Seeds                  []string       `json:"seeds"`
+	RingShape              string         `json:"ringShape"`
+	AreaCorrect            *bool          `json:"areaCorrect"`
+	Outline                *bool          `json:"outline"`
+	OutlineColor           string         `json:"outlineColor"`
+	AutoFit                *bool          `json:"autoFit"`
+	Dpi                    *int           `json:"dpi"`
+	Pyramid                *int           `json:"pyramid"`
+	PyramidFormat          string         `json:"pyramidFormat"`
+	Animate                string         `json:"animate"`
+	Frames                 *int           `json:"frames"`
+	DriftPerFrame          *float64       `json:"driftPerFrame"`
+	AllowEmpty             *bool          `json:"allowEmpty"`
+	Targets                [][2]float64   `json:"targets"`
+	Snap                   *int           `json:"snap"`
+	SnapStrict             *bool          `json:"snapStrict"`
+	ColorJitter            *float64       `json:"colorJitter"`
+	Sparse                 *bool          `json:"sparse"`
+	BrownPercentile        *float64       `json:"brownPercentile"`
+	AutoPalette            *bool          `json:"autoPalette"`
+	LandColor              string         `json:"landColor"`
+	PeakColor              string         `json:"peakColor"`
+	ColorOne               string         `json:"colorOne"`
+	WaterColor             string         `json:"waterColor"`
+	PlaceLargestFirst      *bool          `json:"placeLargestFirst"`
+	CaFill                 *float64       `json:"caFill"`
+	CaIterations           *int           `json:"caIterations"`
+	CaBirth                *int           `json:"caBirth"`
+	CaSurvive              *int           `json:"caSurvive"`
+	CaDepth                *bool          `json:"caDepth"`
+	OceanRadiusFrac        *float64       `json:"oceanRadiusFrac"`
+	ModeMix                []ModeWeight   `json:"modeMix"`
+	IslandAspect           *float64       `json:"islandAspect"`
+	Preview                *bool          `json:"preview"`
+	WebpLossless           *bool          `json:"webpLossless"`
+	WebpQuality            *int           `json:"webpQuality"`
+	Anchor                 string         `json:"anchor"`
+	Decay                  *float64       `json:"decay"`
+	RegionWeights          [][]float64    `json:"regionWeights"`
+	Render                 string         `json:"render"`
+	OverlayColor           string         `json:"overlayColor"`
+	OverlayAlpha           *float64       `json:"overlayAlpha"`
+	OverlayRings           *bool          `json:"overlayRings"`
+	RingBias               string         `json:"ringBias"`
+	TilePadding            int            `json:"tilePadding"`
+	AutoCrop               *bool          `json:"autoCrop"`
+	CropPadding            *int           `json:"cropPadding"`
+	PolygonMask            [][2]int       `json:"polygonMask"`
+	PolygonMaskFit         string         `json:"polygonMaskFit"`
+	IslandSizeDistribution string         `json:"islandSizeDistribution"`
+	IslandSizeExponent     *float64       `json:"islandSizeExponent"`
+	HexPitch               *int           `json:"hexPitch"`
+	HexFalloff             *float64       `json:"hexFalloff"`
+	OneTilePerSite         *bool          `json:"oneTilePerSite"`
+	Sizes                  []int          `json:"sizes"`
+	Erosion                *int           `json:"erosion"`
+	ErosionTalus           *float64       `json:"erosionTalus"`
+	StrictRings            *bool          `json:"strictRings"`
+	HueShift               *float64       `json:"hueShift"`
+	SaturationScale        *float64       `json:"saturationScale"`
+	LightnessScale         *float64       `json:"lightnessScale"`
+	PreferVirgin           *float64       `json:"preferVirgin"`
+	StableRotateStream     *bool          `json:"stableRotateStream"`
+	AsciiWidth             *int           `json:"asciiWidth"`
+	AgirlikAspectWeight    *float64       `json:"agirlikAspectWeight"`
+	MaskThreshold          *float64       `json:"maskThreshold"`
+	MaskGzip               *bool          `json:"maskGzip"`
+	Invert                 *bool          `json:"invert"`
+	Antialias              *bool          `json:"antialias"`
+	AAFactor               *int           `json:"aaFactor"`
+	Legend                 *bool          `json:"legend"`
+	IslandFill             string         `json:"islandFill"`
+	Strict                 *bool          `json:"strict"`
+	PreciseCOM             *bool          `json:"preciseCOM"`
+	DrawStats              *bool          `json:"drawStats"`
+	Palettes               []PaletteEntry `json:"palettes"`
+	Bevel                  *bool          `json:"bevel"`
+	BevelStrength          *float64       `json:"bevelStrength"`
+	AngleBias              []float64      `json:"angleBias"`
+	TileIDs                *bool          `json:"tileIds"`
+}
+
+// Params is the fully resolved configuration consumed by Generate.
+type Params struct {
+	Width                  int
+	Height                 int
+	TileString             string
+	Ka                     float64
+	Cap                    int
+	Mode                   string
+	Rings                  int
+	RingStart              float64
+	RingEnd                float64
+	Seed                   string
+	LogTone                bool
+	BrownCap               int
+	BgAlpha                int
+	Islands                int
+	IslandRFrac            float64
+	Rotate                 bool
+	N22                    int
+	N21                    int
+	N11                    int
+	Density                image.Image
+	DensityStrict          bool
+	Erode                  int
+	Dilate                 int
+	RotateProb             float64
+	CapPolicy              string
+	Format                 string
+	ColorByRing            bool
+	TileList               []TileEntry
+	Shallow                *color.RGBA
+	Seeds                  []string
+	RingShape              string
+	AreaCorrect            bool
+	Outline                bool
+	OutlineColor           *color.RGBA
+	AutoFit                bool
+	Dpi                    int
+	Pyramid                int
+	PyramidFormat          string
+	Animate                string
+	Frames                 int
+	DriftPerFrame          float64
+	AllowEmpty             bool
+	Targets                [][2]float64
+	Snap                   int
+	ColorJitter            float64
+	Sparse                 *bool
+	BrownPercentile        float64
+	AutoPalette            bool
+	LandColor              *color.RGBA
+	PeakColor              *color.RGBA
+	ColorOne               *color.RGBA
+	WaterColor             *color.RGBA
+	PlaceLargestFirst      bool
+	CaFill                 float64
+	CaIterations           int
+	CaBirth                int
+	CaSurvive              int
+	CaDepth                bool
+	OceanRadiusFrac        float64
+	ModeMix                []ModeWeight
+	IslandAspect           float64
+	Preview                bool
+	WebpLossless           bool
+	WebpQuality            int
+	Anchor                 string
+	AnchorFrac             [2]float64
+	Decay                  float64
+	RegionWeights          [][]float64
+	Render                 string
+	OverlayColor           color.RGBA
+	OverlayAlpha           float64
+	OverlayRings           bool
+	RingBias               string
+	TilePadding            int
+	AutoCrop               bool
+	CropPadding            int
+	PolygonMask            [][2]int
+	PolygonMaskFit         string
+	IslandSizeDistribution string
+	IslandSizeExponent     float64
+	HexPitch               int
+	HexFalloff             float64
+	OneTilePerSite         bool
+	Sizes                  []int
+	Erosion                int
+	ErosionTalus           float64
+	HueShift               float64
+	SaturationScale        float64
+	LightnessScale         float64
+	PreferVirgin           float64
+	StableRotateStream     bool
+	AsciiWidth             int
+	AgirlikAspectWeight    float64
+	MaskThreshold          float64
+	MaskGzip               bool
+	Invert                 bool
+	Antialias              bool
+	AAFactor               int
+	Legend                 bool
+	IslandFill             string
+	Strict                 bool
+	PreciseCOM             bool
+	DrawStats              bool
+	Palettes               []PaletteVariant
+	Bevel                  bool
+	BevelStrength          float64
+	AngleBias              []float64
+	TileIDs                bool
+
+	// MaxPlacements rejects plans of more placements after cap and ka are
+	// applied; zero is unlimited. Requests cannot set it, callers serving
+	// untrusted requests do.
+	MaxPlacements int
+
+	// defaultWidth and defaultHeight record dimensions that fell back to the
+	// default, which autoFit may grow.
+	defaultWidth  bool
+	defaultHeight bool
+
+	// brownLimit is the brownCap resolved from BrownPercentile for the frame
+	// being colored.
+	brownLimit float64
+}
+
+// Normalize validates the request and fills in defaults for omitted fields.
+func (req *Request) Normalize() (Params, error) {
+	p := Params{
+		Width:      req.W,
+		Height:     req.H,
+		TileString: req.Tiles,
+		Mode:       req.Mode,
+		Seed:       req.Seed,
+		Seeds:      req.Seeds,
+	}
+
+	if p.Width <= 0 {
+		if req.W == 0 {
+			p.Width = 100
+			p.defaultWidth = true
+		} else {
+			return Params{}, fmt.Errorf("width must be positive")
+		}
+	}
+	if p.Height <= 0 {
+		if req.H == 0 {
+			p.Height = 100
+			p.defaultHeight = true
+		} else {
+			return Params{}, fmt.Errorf("height must be positive")
+		}
+	}
+
+	if req.AutoFit != nil {
+		p.AutoFit = *req.AutoFit
+	}
+
+	if req.Ka != nil {
+		p.Ka = *req.Ka
+	} else {
+		p.Ka = 1.0
+	}
+
+	if req.Cap != nil {
+		p.Cap = *req.Cap
+		if p.Cap < 0 {
+			p.Cap = 0
+		}
+	} else {
+		p.Cap = 0
+	}
+
+	p.CapPolicy = strings.ToLower(strings.TrimSpace(req.CapPolicy))
+	switch p.CapPolicy {
+	case "":
+		p.CapPolicy = capPolicyProportional
+	case capPolicyProportional, capPolicyPreserveAll:
+	default:
+		return Params{}, fmt.Errorf("unsupported capPolicy %q", req.CapPolicy)
+	}
+
+	p.Format = strings.ToLower(strings.TrimSpace(req.Format))
+	switch p.Format {
+	case "":
+		p.Format = formatPNG
+	case formatPNG, formatJSON, formatReplay, formatWebP, formatCSV, formatASCII, formatMaskRLE:
+	default:
+		return Params{}, fmt.Errorf("unsupported format %q", req.Format)
+	}
+	if err := normalizeASCII(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizeMask(req, &p); err != nil {
+		return Params{}, err
+	}
+
+	p.WebpLossless = true
+	if req.WebpLossless != nil {
+		p.WebpLossless = *req.WebpLossless
+	}
+	p.WebpQuality = defaultWebPQuality
+	if req.WebpQuality != nil {
+		if *req.WebpQuality < 1 || *req.WebpQuality > 100 {
+			return Params{}, errors.New("webpQuality must be between 1 and 100")
+		}
+		if p.WebpLossless {
+			return Params{}, errors.New("webpQuality requires webpLossless false")
+		}
+		p.WebpQuality = *req.WebpQuality
+	}
+	if p.Format == formatWebP && (p.Width > maxWebPSide || p.Height > maxWebPSide) {
+		return Params{}, fmt.Errorf("format webp is limited to %d pixels per side", maxWebPSide)
+	}
+
+	if req.Dpi != nil {
+		if *req.Dpi <= 0 || *req.Dpi > maxDpi {
+			return Params{}, fmt.Errorf("dpi must be between 1 and %d", maxDpi)
+		}
+		p.Dpi = *req.Dpi
+	}
+
+	if req.Pyramid != nil {
+		if *req.Pyramid < 0 || *req.Pyramid > maxPyramid {
+			return Params{}, fmt.Errorf("pyramid must be between 0 and %d", maxPyramid)
+		}
+		p.Pyramid = *req.Pyramid
+	}
+	if p.Pyramid > 0 && p.Format != formatPNG {
+		return Params{}, fmt.Errorf("pyramid requires format %q", formatPNG)
+	}
+	p.PyramidFormat = strings.ToLower(strings.TrimSpace(req.PyramidFormat))
+	switch p.PyramidFormat {
+	case "":
+		p.PyramidFormat = pyramidMultipart
+	case pyramidMultipart, pyramidZip:
+	default:
+		return Params{}, fmt.Errorf("unsupported pyramidFormat %q", req.PyramidFormat)
+	}
+
+	if err := normalizeModeMix(req, &p); err != nil {
+		return Params{}, err
+	}
+	if strings.TrimSpace(p.Mode) == "" {
+		p.Mode = "merkez"
+	}
+	p.Mode = strings.ToLower(p.Mode)
+	switch p.Mode {
+	case "merkez", "agirlik", "adalar", "iki-kita", "voronoi", "magara", modeKarma, modeBolge, modePetek:
+	default:
+		return Params{}, fmt.Errorf("unsupported mode %q", p.Mode)
+	}
+	if err := normalizeRegionWeights(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizePolygonMask(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizeIslandSizes(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizePetek(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizeAntialias(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizePreferVirgin(req, &p); err != nil {
+		return Params{}, err
+	}
+
+	p.Animate = strings.ToLower(strings.TrimSpace(req.Animate))
+	switch p.Animate {
+	case "":
+	case animateDrift:
+		if p.Mode != "adalar" && p.Mode != "iki-kita" {
+			return Params{}, fmt.Errorf("animate %q requires mode adalar or iki-kita", animateDrift)
+		}
+		if p.Format != formatPNG || p.Pyramid > 0 {
+			return Params{}, fmt.Errorf("animate produces a GIF and cannot be combined with format %q or pyramid", p.Format)
+		}
+		p.Frames = 10
+		if req.Frames != nil {
+			p.Frames = *req.Frames
+		}
+		if p.Frames < 1 || p.Frames > maxAnimationFrames {
+			return Params{}, fmt.Errorf("frames must be between 1 and %d", maxAnimationFrames)
+		}
+		p.DriftPerFrame = 1
+		if req.DriftPerFrame != nil {
+			p.DriftPerFrame = *req.DriftPerFrame
+		}
+		if math.IsNaN(p.DriftPerFrame) || math.IsInf(p.DriftPerFrame, 0) {
+			return Params{}, fmt.Errorf("driftPerFrame must be finite")
+		}
+	default:
+		return Params{}, fmt.Errorf("unsupported animate %q", req.Animate)
+	}
+
+	if req.StrictRings != nil && *req.StrictRings {
+		if err := checkStrictRings(req); err != nil {
+			return Params{}, err
+		}
+	}
+	if req.Rings != nil {
+		p.Rings = *req.Rings
+	} else {
+		p.Rings = 10
+	}
+	if p.Rings <= 0 {
+		p.Rings = 10
+	}
+
+	p.RingShape = strings.ToLower(strings.TrimSpace(req.RingShape))
+	switch p.RingShape {
+	case "":
+		p.RingShape = ringShapeCircle
+	case ringShapeCircle, ringShapeEllipse:
+	default:
+		return Params{}, fmt.Errorf("unsupported ringShape %q", req.RingShape)
+	}
+
+	if req.AreaCorrect != nil {
+		p.AreaCorrect = *req.AreaCorrect
+	}
+
+	if req.RingStart != nil {
+		p.RingStart = clampFloat(*req.RingStart, 0, 1)
+	} else {
+		p.RingStart = 0.1
+	}
+	if req.RingEnd != nil {
+		p.RingEnd = clampFloat(*req.RingEnd, 0, 1)
+	} else {
+		p.RingEnd = 0.8
+	}
+	if p.RingEnd <= p.RingStart {
+		adjustedEnd := clampFloat(p.RingStart+0.05, p.RingStart, 1)
+		if adjustedEnd == p.RingStart {
+			return Params{}, fmt.Errorf("ringEnd must be greater than ringStart")
+		}
+		p.RingEnd = adjustedEnd
+	}
+
+	if req.LogTone != nil {
+		p.LogTone = *req.LogTone != 0
+	} else {
+		p.LogTone = true
+	}
+
+	if req.BrownCap != nil {
+		p.BrownCap = *req.BrownCap
+	} else {
+		p.BrownCap = 8
+	}
+	if p.BrownCap < 1 {
+		p.BrownCap = 1
+	}
+	if req.BrownPercentile != nil {
+		if !(*req.BrownPercentile > 0 && *req.BrownPercentile <= 100) {
+			return Params{}, fmt.Errorf("brownPercentile must be greater than 0 and at most 100")
+		}
+		p.BrownPercentile = *req.BrownPercentile
+	}
+
+	if req.BgAlpha != nil {
+		p.BgAlpha = *req.BgAlpha
+	} else {
+		p.BgAlpha = 0
+	}
+
+	for i, t := range req.Targets {
+		if !(t[0] >= 0 && t[0] <= 1 && t[1] >= 0 && t[1] <= 1) {
+			return Params{}, fmt.Errorf("targets[%d] must be [x, y] fractions between 0 and 1", i)
+		}
+	}
+	if len(req.Targets) > maxTargets {
+		return Params{}, fmt.Errorf("at most %d targets are supported", maxTargets)
+	}
+	p.Targets = req.Targets
+	if req.AgirlikAspectWeight != nil {
+		if !(*req.AgirlikAspectWeight >= 0) || math.IsInf(*req.AgirlikAspectWeight, 0) {
+			return Params{}, errors.New("agirlikAspectWeight must be a non-negative number")
+		}
+		p.AgirlikAspectWeight = *req.AgirlikAspectWeight
+	}
+
+	if req.Islands != nil {
+		p.Islands = *req.Islands
+	} else {
+		p.Islands = 4
+	}
+	if p.Islands > maxIslands {
+		return Params{}, fmt.Errorf("islands must be at most %d", maxIslands)
+	}
+	if p.Mode == "voronoi" && p.Islands < 1 {
+		return Params{}, fmt.Errorf("islands must be at least 1 in voronoi mode")
+	}
+
+	if req.IslandRFrac != nil {
+		p.IslandRFrac = *req.IslandRFrac
+	} else {
+		p.IslandRFrac = 0.25
+	}
+	if p.IslandRFrac <= 0 {
+		p.IslandRFrac = 0.25
+	}
+
+	p.RingBias = strings.ToLower(strings.TrimSpace(req.RingBias))
+	switch p.RingBias {
+	case "":
+		p.RingBias = ringBiasInner
+	case ringBiasInner, ringBiasOuter, ringBiasUniform:
+	default:
+		return Params{}, fmt.Errorf("unsupported ringBias %q", req.RingBias)
+	}
+	if err := normalizeAngleBias(req, &p); err != nil {
+		return Params{}, err
+	}
+
+	p.Anchor = strings.ToLower(strings.TrimSpace(req.Anchor))
+	switch p.Anchor {
+	case "", anchorCenter, anchorTopLeft, anchorRandom:
+	default:
+		frac, err := parseAnchorFraction(p.Anchor)
+		if err != nil {
+			return Params{}, fmt.Errorf("unsupported anchor %q: %w", req.Anchor, err)
+		}
+		p.Anchor, p.AnchorFrac = anchorFraction, frac
+	}
+
+	if req.TilePadding < -maxTilePadding || req.TilePadding > maxTilePadding {
+		return Params{}, fmt.Errorf("tilePadding must be between %d and %d", -maxTilePadding, maxTilePadding)
+	}
+	p.TilePadding = req.TilePadding
+
+	p.Decay = 1
+	if req.Decay != nil {
+		if !(*req.Decay > 0) || math.IsInf(*req.Decay, 0) {
+			return Params{}, errors.New("decay must be positive")
+		}
+		p.Decay = *req.Decay
+	}
+
+	p.IslandAspect = 1
+	if req.IslandAspect != nil {
+		if !(*req.IslandAspect > 0) || math.IsInf(*req.IslandAspect, 0) {
+			return Params{}, errors.New("islandAspect must be positive")
+		}
+		p.IslandAspect = *req.IslandAspect
+	}
+
+	p.IslandFill = strings.ToLower(strings.TrimSpace(req.IslandFill))
+	switch p.IslandFill {
+	case "":
+		p.IslandFill = islandFillRadial
+	case islandFillRadial, islandFillUniform:
+	default:
+		return Params{}, fmt.Errorf("unsupported islandFill %q", req.IslandFill)
+	}
+
+	if req.Rotate != nil {
+		p.Rotate = *req.Rotate != 0
+	} else {
+		p.Rotate = true
+	}
+
+	if req.StableRotateStream != nil {
+		p.StableRotateStream = *req.StableRotateStream
+	}
+
+	if req.RotateProb != nil {
+		if *req.RotateProb < 0 || *req.RotateProb > 1 {
+			return Params{}, fmt.Errorf("rotateProb must be between 0 and 1")
+		}
+		p.RotateProb = *req.RotateProb
+	} else {
+		p.RotateProb = 0.5
+	}
+
+	if req.ColorByRing != nil {
+		p.ColorByRing = *req.ColorByRing
+	}
+
+	if req.N22 != nil {
+		p.N22 = *req.N22
+	}
+	if req.N21 != nil {
+		p.N21 = *req.N21
+	}
+	if req.N11 != nil {
+		p.N11 = *req.N11
+	}
+
+	if strings.TrimSpace(req.Density) != "" {
+		img, err := decodeDensityImage(req.Density)
+		if err != nil {
+			return Params{}, err
+		}
+		p.Density = img
+	}
+	if req.DensityStrict != nil {
+		p.DensityStrict = *req.DensityStrict
+	}
+
+	p.TileList = req.TileList
+	if req.AllowEmpty != nil {
+		p.AllowEmpty = *req.AllowEmpty
+	}
+	var specs []tileSpec
+	if p.Mode == "magara" {
+		if err := normalizeCave(req, &p); err != nil {
+			return Params{}, err
+		}
+	} else {
+		var err error
+		specs, err = buildTileSpecs(p.TileString, p.TileList)
+		if err != nil && !(errors.Is(err, errNoTileDefinitions) && p.AllowEmpty) {
+			return Params{}, err
+		}
+		if err := checkTileNames(specs); err != nil {
+			return Params{}, err
+		}
+	}
+
+	p.Snap = 1
+	if req.Snap != nil {
+		if *req.Snap < 1 {
+			return Params{}, fmt.Errorf("snap must be at least 1")
+		}
+		p.Snap = *req.Snap
+	}
+	if req.SnapStrict != nil && *req.SnapStrict && p.Snap > 1 {
+		for _, s := range applyLegacyTiles(specs, p.N22, p.N21, p.N11) {
+			if s.W%p.Snap != 0 || s.H%p.Snap != 0 {
+				return Params{}, fmt.Errorf("tile %s is not a multiple of snap %d", tileName(s.Name, s.W, s.H), p.Snap)
+			}
+		}
+	}
+
+	if req.Outline != nil {
+		p.Outline = *req.Outline
+	}
+	if req.Invert != nil {
+		p.Invert = *req.Invert
+	}
+	if req.Strict != nil {
+		p.Strict = *req.Strict
+	}
+	if req.PreciseCOM != nil {
+		p.PreciseCOM = *req.PreciseCOM
+	}
+	if req.TileIDs != nil && *req.TileIDs {
+		if p.Format != formatJSON {
+			return Params{}, fmt.Errorf("tileIds requires format %q", formatJSON)
+		}
+		p.TileIDs = true
+	}
+	if strings.TrimSpace(req.OutlineColor) != "" {
+		c, err := parseHexColor(req.OutlineColor)
+		if err != nil {
+			return Params{}, fmt.Errorf("outlineColor: %w", err)
+		}
+		p.OutlineColor = &c
+	}
+
+	if req.OceanRadiusFrac != nil {
+		if !(*req.OceanRadiusFrac > 0) || math.IsInf(*req.OceanRadiusFrac, 0) {
+			return Params{}, fmt.Errorf("oceanRadiusFrac must be positive")
+		}
+		p.OceanRadiusFrac = *req.OceanRadiusFrac
+	}
+
+	if req.PlaceLargestFirst != nil {
+		p.PlaceLargestFirst = *req.PlaceLargestFirst
+	}
+
+	if req.AutoPalette != nil {
+		p.AutoPalette = *req.AutoPalette
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		dst   **color.RGBA
+	}{
+		{"landColor", req.LandColor, &p.LandColor},
+		{"peakColor", req.PeakColor, &p.PeakColor},
+		{"colorOne", req.ColorOne, &p.ColorOne},
+		{"waterColor", req.WaterColor, &p.WaterColor},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			continue
+		}
+		c, err := parseHexColor(field.value)
+		if err != nil {
+			return Params{}, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.dst = &c
+	}
+	if err := normalizeHSLAdjust(req, &p); err != nil {
+		return Params{}, err
+	}
+
+	if req.ColorJitter != nil {
+		if !(*req.ColorJitter >= 0 && *req.ColorJitter <= 1) {
+			return Params{}, fmt.Errorf("colorJitter must be between 0 and 1")
+		}
+		p.ColorJitter = *req.ColorJitter
+	}
+
+	if strings.TrimSpace(req.Shallow) != "" {
+		c, err := parseHexColor(req.Shallow)
+		if err != nil {
+			return Params{}, fmt.Errorf("shallow: %w", err)
+		}
+		p.Shallow = &c
+	}
+
+	if req.Erode != nil {
+		if *req.Erode < 0 || *req.Erode > maxMorphology {
+			return Params{}, fmt.Errorf("erode must be between 0 and %d", maxMorphology)
+		}
+		p.Erode = *req.Erode
+	}
+	if req.Dilate != nil {
+		if *req.Dilate < 0 || *req.Dilate > maxMorphology {
+			return Params{}, fmt.Errorf("dilate must be between 0 and %d", maxMorphology)
+		}
+		p.Dilate = *req.Dilate
+	}
+	if err := normalizeErosion(req, &p); err != nil {
+		return Params{}, err
+	}
+
+	if req.Sparse != nil && *req.Sparse {
+		switch {
+		case p.Erode > 0 || p.Dilate > 0:
+			return Params{}, fmt.Errorf("sparse does not support erode or dilate")
+		case p.Erosion > 0:
+			return Params{}, fmt.Errorf("sparse does not support erosion")
+		case p.Pyramid > 0:
+			return Params{}, fmt.Errorf("sparse does not support pyramid")
+		}
+	}
+	p.Sparse = req.Sparse
+	if p.Format == formatCSV || p.Format == formatASCII || p.Format == formatMaskRLE {
+		// Only the dense path keeps the coverage grid the rows come from.
+		if p.Sparse != nil && *p.Sparse {
+			return Params{}, fmt.Errorf("sparse does not support format %q", p.Format)
+		}
+		dense := false
+		p.Sparse = &dense
+	}
+
+	if req.Preview != nil && *req.Preview {
+		p.Preview = true
+		if err := normalizePreview(&p); err != nil {
+			return Params{}, err
+		}
+	}
+
+	if err := normalizeOverlay(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizeAutoCrop(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizeSizes(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizeLegend(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizeDrawStats(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizePalettes(req, &p); err != nil {
+		return Params{}, err
+	}
+	if err := normalizeBevel(req, &p); err != nil {
+		return Params{}, err
+	}
+
+	return p, nil
+}
+
+// checkStrictRings rejects the ring options Normalize would otherwise correct
+// silently: non-positive rings fall back to 10, bounds outside [0, 1] are
+// clamped and a ringEnd at or below ringStart is moved past it.
+func checkStrictRings(req *Request) error {
+	if req.Rings != nil && *req.Rings <= 0 {
+		return fmt.Errorf("strictRings: rings must be positive, got %d", *req.Rings)
+	}
+	start, end := 0.1, 0.8
+	for _, bound := range []struct {
+		name  string
+		value *float64
+		dst   *float64
+	}{
+		{"ringStart", req.RingStart, &start},
+		{"ringEnd", req.RingEnd, &end},
+	} {
+		if bound.value == nil {
+			continue
+		}
+		if !(*bound.value >= 0 && *bound.value <= 1) {
+			return fmt.Errorf("strictRings: %s must be between 0 and 1, got %g", bound.name, *bound.value)
+		}
+		*bound.dst = *bound.value
+	}
+	if end <= start {
+		return fmt.Errorf("strictRings: ringEnd %g must be greater than ringStart %g", end, start)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package mapgen
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ContentSHA256 is the hex SHA-256 of encoded output, as sent in the
+// X-Content-SHA256 header.
+func ContentSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign returns the hex HMAC-SHA256 under key of the content hash of data, a
+// newline and params, the canonical parameters the output was made with. The
+// signature thus ties the bytes to the parameters: changing either breaks it.
+func Sign(key, data, params []byte) string {
+	return hex.EncodeToString(signatureOf(key, data, params))
+}
+
+// VerifySignature reports whether signature, as returned by Sign, matches
+// data and params under key. The comparison takes constant time.
+func VerifySignature(key, data, params []byte, signature string) bool {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, signatureOf(key, data, params))
+}
+
+func signatureOf(key, data, params []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ContentSHA256(data)))
+	mac.Write([]byte{'\n'})
+	mac.Write(params)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,160 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+	"math"
+)
+
+// maxSizes bounds the sizes list; responsive image sets rarely need more.
+const maxSizes = 16
+
+// normalizeSizes validates sizes, the longest sides of extra downsampled
+// copies of the map. Every copy is recolored from the one coverage grid, so
+// the options that paint only the final image, or keep no grid, are rejected.
+func normalizeSizes(req *Request, p *Params) error {
+	if len(req.Sizes) == 0 {
+		return nil
+	}
+	switch {
+	case len(req.Sizes) > maxSizes:
+		return fmt.Errorf("sizes lists more than %d sizes", maxSizes)
+	case p.Format != formatPNG:
+		return fmt.Errorf("sizes requires format %q", formatPNG)
+	case p.Pyramid > 0:
+		return errors.New("sizes cannot be combined with pyramid")
+	case p.Animate != "":
+		return errors.New("sizes cannot be animated")
+	case p.Preview:
+		return errors.New("sizes does not support preview; its coverage is approximate")
+	case p.AutoCrop:
+		return errors.New("sizes does not support autoCrop")
+	case p.Render != "":
+		return fmt.Errorf("sizes does not support render %q", p.Render)
+	case p.Sparse != nil && *p.Sparse:
+		return errors.New("sparse does not support sizes")
+	}
+	longest := max(p.Width, p.Height)
+	seen := map[int]bool{}
+	for i, size := range req.Sizes {
+		if size < 1 || size > longest {
+			return fmt.Errorf("sizes[%d] must be between 1 and the longest canvas side %d", i, longest)
+		}
+		if seen[size] {
+			return fmt.Errorf("sizes[%d]: size %d is listed twice", i, size)
+		}
+		seen[size] = true
+	}
+	p.Sizes = req.Sizes
+	return nil
+}
+
+// sizeFileName is the part/entry name of the copy whose longest side is size.
+func sizeFileName(size int) string {
+	return fmt.Sprintf("size-%d.png", size)
+}
+
+// sizeDimensions scales the canvas so its longest side is size, keeping the
+// aspect ratio and at least one pixel on the short side.
+func sizeDimensions(width, height, size int) (int, int) {
+	longest := max(width, height)
+	return max(1, int(math.Round(float64(width*size)/float64(longest)))),
+		max(1, int(math.Round(float64(height*size)/float64(longest))))
+}
+
+// renderSizes recolors a box-averaged copy of the coverage grid for every
+// size, in the order asked for; a size matching the canvas reuses base, the
+// full-resolution PNG. Like pyramid levels, averaging happens on coverage so
+// every copy uses the base color ramp, region and jitter labels come from the
+// source cell under each output cell's center, outlines are only drawn at full
+// resolution and dpi scales with the copy.
+func renderSizes(f frame, p Params, base []byte) ([]pyramidLevel, error) {
+	files := make([]pyramidLevel, 0, len(p.Sizes))
+	for _, size := range p.Sizes {
+		outW, outH := sizeDimensions(p.Width, p.Height, size)
+		if outW == p.Width && outH == p.Height {
+			files = append(files, pyramidLevel{name: sizeFileName(size), data: base})
+			continue
+		}
+		coverage := resampleCoverage(f.coverage, p.Width, p.Height, outW, outH)
+		var ringOf []int
+		if f.ringOf != nil {
+			ringOf = sampleCells(f.ringOf, p.Width, p.Height, outW, outH)
+		}
+		var jitter []tileJitter
+		if f.jitter != nil {
+			jitter = sampleCells(f.jitter, p.Width, p.Height, outW, outH)
+		}
+
+		img := newCanvas(outW, outH, p)
+		colorCoverage(img, coverage, ringOf, f.segments, jitter, p)
+		dpi := 0
+		if p.Dpi > 0 {
+			dpi = max(1, p.Dpi*size/max(p.Width, p.Height))
+		}
+		data, err := encodePNG(img, dpi, png.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pyramidLevel{name: sizeFileName(size), data: data})
+	}
+	return files, nil
+}
+
+// boxTap is one source cell of a box filter with its share of the output cell.
+type boxTap struct {
+	index  int
+	weight float64
+}
+
+// boxTaps maps each of outN output cells onto the n source cells it spans,
+// weighting partly covered cells by the fraction they overlap. The weights of
+// every output cell sum to 1.
+func boxTaps(n, outN int) [][]boxTap {
+	taps := make([][]boxTap, outN)
+	scale := float64(n) / float64(outN)
+	for i := range taps {
+		lo, hi := float64(i)*scale, float64(i+1)*scale
+		for s := int(lo); s < n && float64(s) < hi; s++ {
+			if w := math.Min(hi, float64(s+1)) - math.Max(lo, float64(s)); w > 0 {
+				taps[i] = append(taps[i], boxTap{index: s, weight: w / scale})
+			}
+		}
+	}
+	return taps
+}
+
+// resampleCoverage box-averages the grid down to outW×outH, the
+// arbitrary-ratio counterpart of downsampleCoverage. Cells are summed in a
+// fixed order, which keeps the result bit-identical across runs.
+func resampleCoverage(coverage []float64, width, height, outW, outH int) []float64 {
+	cols, rows := boxTaps(width, outW), boxTaps(height, outH)
+	out := make([]float64, outW*outH)
+	for y, ty := range rows {
+		for x, tx := range cols {
+			sum := 0.0
+			for _, r := range ty {
+				row := coverage[r.index*width:]
+				for _, c := range tx {
+					sum += row[c.index] * c.weight * r.weight
+				}
+			}
+			out[y*outW+x] = sum
+		}
+	}
+	return out
+}
+
+// sampleCells picks, for each output cell, the source cell under its center.
+func sampleCells[T any](cells []T, width, height, outW, outH int) []T {
+	out := make([]T, outW*outH)
+	for y := 0; y < outH; y++ {
+		sy := min(int((float64(y)+0.5)*float64(height)/float64(outH)), height-1)
+		for x := 0; x < outW; x++ {
+			sx := min(int((float64(x)+0.5)*float64(width)/float64(outW)), width-1)
+			out[y*outW+x] = cells[sy*width+sx]
+		}
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+package mapgen
+
+import (
+	"image"
+	"math/rand"
+	"time"
+)
+
+const (
+	// pageSize is the side of one sparse page; a page is only allocated once
+	// a tile touches it.
+	pageSize = 256
+
+	// sparseThreshold is the canvas area above which coverage is kept in
+	// pages unless the request says otherwise.
+	sparseThreshold = 4096 * 4096
+)
+
+// pagedGrid is a width×height grid stored as pageSize×pageSize pages that are
+// allocated on first write. Unwritten cells read as init.
+type pagedGrid[T any] struct {
+	width  int
+	height int
+	cols   int
+	init   T
+	pages  [][]T
+}
+
+func newPagedGrid[T any](width, height int, init T) *pagedGrid[T] {
+	cols := (width + pageSize - 1) / pageSize
+	rows := (height + pageSize - 1) / pageSize
+	return &pagedGrid[T]{width: width, height: height, cols: cols, init: init, pages: make([][]T, cols*rows)}
+}
+
+func (g *pagedGrid[T]) page(index int) []T {
+	if g.pages[index] == nil {
+		page := make([]T, pageSize*pageSize)
+		for i := range page {
+			page[i] = g.init
+		}
+		g.pages[index] = page
+	}
+	return g.pages[index]
+}
+
+// spans calls fn with every row span of the rectangle clipped to the grid,
+// allocating the pages it crosses.
+func (g *pagedGrid[T]) spans(x, y, tw, th int, fn func(span []T)) {
+	x0, x1 := max(x, 0), min(x+tw, g.width)
+	y0, y1 := max(y, 0), min(y+th, g.height)
+	for row := y0; row < y1; row++ {
+		py, oy := row/pageSize, row%pageSize
+		for col := x0; col < x1; {
+			px, ox := col/pageSize, col%pageSize
+			end := min(x1, (px+1)*pageSize)
+			page := g.page(py*g.cols + px)
+			fn(page[oy*pageSize+ox : oy*pageSize+ox+end-col])
+			col = end
+		}
+	}
+}
+
+func (g *pagedGrid[T]) fill(x, y, tw, th int, v T) {
+	g.spans(x, y, tw, th, func(span []T) {
+		for i := range span {
+			span[i] = v
+		}
+	})
+}
+
+func (g *pagedGrid[T]) at(x, y int) T {
+	page := g.pages[(y/pageSize)*g.cols+x/pageSize]
+	if page == nil {
+		return g.init
+	}
+	return page[(y%pageSize)*pageSize+x%pageSize]
+}
+
+// addCoverage adds weight to every cell of the rectangle, the paged
+// counterpart of fillCoverage, and returns how many cells were empty before.
+func addCoverage(g *pagedGrid[float64], x, y, tw, th int, weight float64) int {
+	if weight == 0 {
+		return 0
+	}
+	fresh := 0
+	g.spans(x, y, tw, th, func(span []float64) {
+		fresh += addSpan(span, weight)
+	})
+	return fresh
+}
+
+// useSparse reports whether p renders from paged coverage: when asked to, or
+// automatically for large canvases that need no whole-grid pass.
+func useSparse(p Params) bool {
+	if p.Sparse != nil {
+		return *p.Sparse
+	}
+	return p.Width*p.Height > sparseThreshold && p.Erode == 0 && p.Dilate == 0 && p.Erosion == 0 && p.Pyramid == 0 && len(p.Sizes) == 0 && len(p.Palettes) == 0 && p.PreferVirgin == 0 && !p.Antialias && !p.Bevel
+}
+
+// renderSparseFrame is renderFrame over paged grids. Only pages touched by a
+// tile are allocated and painted, so mostly-empty canvases cost little more
+// than their image. The output is identical to the dense path.
+func renderSparseFrame(p Params, batches []tileBatch, rnd *rand.Rand, gen *generator, stats *Stats) frame {
+	stageStart := time.Now()
+
+	coverage := newPagedGrid(p.Width, p.Height, 0.0)
+	var ringOf *pagedGrid[int]
+	colorByRing := p.ColorByRing && p.placesMode("merkez")
+	if colorByRing {
+		ringOf = newPagedGrid(p.Width, p.Height, -1)
+	}
+	var jitter *pagedGrid[tileJitter]
+	if p.ColorJitter > 0 {
+		jitter = newPagedGrid(p.Width, p.Height, tileJitter{})
+	}
+
+	var layout []Placement
+	recordLayout := p.Format == formatJSON || p.Outline
+
+	index, landCells := 0, 0
+	totalPlacements := placeTiles(p, batches, rnd, gen, func(pl Placement, weight float64, segment int) {
+		if recordLayout {
+			layout = append(layout, pl)
+		}
+		x, y, tw, th := padRect(pl.X, pl.Y, pl.W, pl.H, p.TilePadding)
+		landCells += addCoverage(coverage, x, y, tw, th, weight)
+		if ringOf != nil {
+			ringOf.fill(x, y, tw, th, segment)
+		}
+		if jitter != nil {
+			jitter.fill(x, y, tw, th, placementJitter(gen.seed, index, p.ColorJitter))
+		}
+		index++
+	})
+
+	stats.track(StagePlacement, stageStart)
+	if gen.ctxErr != nil {
+		return frame{placements: totalPlacements}
+	}
+	stageStart = time.Now()
+
+	for index, page := range coverage.pages {
+		if page != nil {
+			clipOcean(page, pageSize, pageSize, (index%coverage.cols)*pageSize, (index/coverage.cols)*pageSize, p)
+			clipPolygon(page, pageSize, pageSize, (index%coverage.cols)*pageSize, (index/coverage.cols)*pageSize, p)
+		}
+	}
+
+	if p.BrownPercentile > 0 {
+		var covered []float64
+		for _, page := range coverage.pages {
+			for _, c := range page {
+				if c > 0 {
+					covered = append(covered, c)
+				}
+			}
+		}
+		p.brownLimit = percentileCap(covered, p.BrownPercentile)
+	}
+
+	segments := p.Rings
+	var voronoi *voronoiIndex
+	if p.Mode == "voronoi" {
+		segments = len(gen.voronoiSites)
+		voronoi = gen.newVoronoiIndex()
+	}
+
+	img := newCanvas(p.Width, p.Height, p)
+	var bounds image.Rectangle
+	moments := newLandMoments(p.Width, p.Height)
+	for index, page := range coverage.pages {
+		if page == nil {
+			continue
+		}
+		px, py := (index%coverage.cols)*pageSize, (index/coverage.cols)*pageSize
+		for i, c := range page {
+			x, y := px+i%pageSize, py+i/pageSize
+			if c <= 0 || x >= p.Width || y >= p.Height {
+				continue
+			}
+			bounds = growBounds(bounds, x, y)
+			moments.add(x, y)
+			ring := -1
+			switch {
+			case voronoi != nil:
+				ring = voronoi.regionAt(x, y)
+			case ringOf != nil:
+				ring = ringOf.at(x, y)
+			}
+			var j tileJitter
+			if jitter != nil {
+				j = jitter.at(x, y)
+			}
+			img.SetRGBA(x, y, cellColor(c, ring, segments, j, p))
+		}
+	}
+
+	if p.Outline {
+		drawOutlines(img, layout, outlineColor(p), func(x, y int) bool { return coverage.at(x, y) > 0 })
+	}
+
+	stats.track(StageColoring, stageStart)
+
+	covered, saturated := 0, 0
+	for _, page := range coverage.pages {
+		c, s := countSaturated(page, p.brownCap())
+		covered, saturated = covered+c, saturated+s
+	}
+
+	return frame{
+		img:        img,
+		brownLimit: p.brownLimit,
+		saturation: saturationFraction(covered, saturated),
+		bounds:     bounds,
+		balance:    moments.balance(),
+		layout:     layout,
+		placements: totalPlacements,
+		landCells:  landCells,
+	}
+}
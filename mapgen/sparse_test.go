@@ -0,0 +1,69 @@
+package mapgen
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSparseMatchesDense(t *testing.T) {
+	yes, jitter, percentile := true, 0.3, 90.0
+	// The canvases are not multiples of pageSize, so the last row and column
+	// of pages are partly off the canvas.
+	tests := []struct {
+		name string
+		req  Request
+	}{
+		{"merkez", Request{W: 300, H: 270, Tiles: "4x4*200,2x2*900,1x1*400"}},
+		{"merkez colorByRing", Request{W: 300, H: 270, Tiles: "2x2*900,1x1*400", ColorByRing: &yes}},
+		{"adalar", Request{W: 520, H: 300, Tiles: "3x2*600,1x1*800", Mode: "adalar"}},
+		{"voronoi", Request{W: 300, H: 300, Tiles: "2x2*900", Mode: "voronoi"}},
+		{"agirlik outline", Request{W: 280, H: 260, Tiles: "6x3*60,2x2*300", Mode: "agirlik", Outline: &yes}},
+		{"colorJitter", Request{W: 300, H: 270, Tiles: "2x2*900", ColorJitter: &jitter}},
+		{"brownPercentile", Request{W: 300, H: 270, Tiles: "2x2*900,1x1*900", BrownPercentile: &percentile}},
+		{"tilePadding", Request{W: 300, H: 270, Tiles: "3x3*400", TilePadding: 1}},
+		{"polygonMask", Request{W: 300, H: 270, Tiles: "2x2*900", PolygonMask: [][2]int{{20, 20}, {280, 40}, {150, 250}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dense, sparse := false, true
+			req := tt.req
+			req.Seed = "sparse-" + tt.name
+			req.Sparse = &dense
+			want := generate(t, req)
+			req.Sparse = &sparse
+			got := generate(t, req)
+			if !bytes.Equal(got.Data, want.Data) {
+				t.Error("sparse output differs from dense")
+			}
+			if got.TotalPlacements != want.TotalPlacements {
+				t.Errorf("sparse placed %d tiles, dense %d", got.TotalPlacements, want.TotalPlacements)
+			}
+		})
+	}
+}
+
+// benchmarkSparseMemory generates a 12000x12000 canvas with about 5% land in
+// four islands, the case paged coverage is for, and reports the bytes
+// allocated per map next to the land share. Both paths still allocate the
+// full RGBA image; the difference is the coverage grid.
+func benchmarkSparseMemory(b *testing.B, sparse bool) {
+	radius := 0.08
+	params, err := (&Request{W: 12000, H: 12000, Tiles: "16x16*55000,4x4*260000", Mode: "adalar", IslandRFrac: &radius, Seed: "memory", Sparse: &sparse}).Normalize()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	var land int
+	for i := 0; i < b.N; i++ {
+		result, err := Generate(params, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		land = result.LandCells
+	}
+	b.ReportMetric(float64(land)/float64(params.Width*params.Height), "land")
+}
+
+func BenchmarkDenseMemory(b *testing.B) { benchmarkSparseMemory(b, false) }
+
+func BenchmarkSparseMemory(b *testing.B) { benchmarkSparseMemory(b, true) }
@@ -0,0 +1,68 @@
+package mapgen
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stage names recorded by Generate.
+const (
+	StagePlan      = "plan"
+	StagePlacement = "placement"
+	StageColoring  = "coloring"
+	StageEncoding  = "encoding"
+)
+
+// StageTiming is the wall-clock duration of one generation stage.
+type StageTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Stats collects per-stage timings while Generate runs. A nil *Stats is valid
+// and records nothing.
+type Stats struct {
+	Stages []StageTiming
+}
+
+// track records the time since start under name. A stage that runs more than
+// once, as with animation frames, accumulates into its first entry.
+func (s *Stats) track(name string, start time.Time) {
+	if s == nil {
+		return
+	}
+	for i := range s.Stages {
+		if s.Stages[i].Name == name {
+			s.Stages[i].Duration += time.Since(start)
+			return
+		}
+	}
+	s.Stages = append(s.Stages, StageTiming{Name: name, Duration: time.Since(start)})
+}
+
+// Total is the sum of all recorded stage durations.
+func (s *Stats) Total() time.Duration {
+	if s == nil {
+		return 0
+	}
+	var total time.Duration
+	for _, st := range s.Stages {
+		total += st.Duration
+	}
+	return total
+}
+
+// Header formats the stages as comma-separated stage=milliseconds pairs for
+// the X-Timing response header.
+func (s *Stats) Header() string {
+	if s == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(s.Stages))
+	for _, st := range s.Stages {
+		ms := float64(st.Duration.Microseconds()) / 1000
+		parts = append(parts, st.Name+"="+strconv.FormatFloat(ms, 'f', 3, 64))
+	}
+	return strings.Join(parts, ",")
+}
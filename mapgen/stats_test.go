@@ -0,0 +1,63 @@
+package mapgen
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsHeaderParsesAndSumsToTotal(t *testing.T) {
+	params, err := (&Request{W: 200, H: 150, Tiles: "2x2*800,1x1*400", Seed: "timing"}).Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats Stats
+	start := time.Now()
+	if _, err := Generate(params, &stats); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	header := stats.Header()
+	seen := map[string]bool{}
+	sum := 0.0
+	for _, pair := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			t.Fatalf("X-Timing %q: pair %q is not stage=ms", header, pair)
+		}
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil || ms < 0 {
+			t.Fatalf("X-Timing %q: stage %s has bad duration %q", header, name, value)
+		}
+		if seen[name] {
+			t.Fatalf("X-Timing %q: stage %s repeated", header, name)
+		}
+		seen[name] = true
+		sum += ms
+	}
+	for _, stage := range []string{StagePlan, StagePlacement, StageColoring, StageEncoding} {
+		if !seen[stage] {
+			t.Errorf("X-Timing %q: missing stage %s", header, stage)
+		}
+	}
+
+	// Each stage is truncated to the microsecond before formatting.
+	total := float64(stats.Total().Nanoseconds()) / 1e6
+	if tolerance := 0.001 * float64(len(seen)); math.Abs(sum-total) > tolerance {
+		t.Errorf("stages sum to %.3fms, Total is %.3fms", sum, total)
+	}
+	if stats.Total() > elapsed {
+		t.Errorf("Total %v exceeds the %v Generate took", stats.Total(), elapsed)
+	}
+}
+
+func TestStatsNil(t *testing.T) {
+	var stats *Stats
+	stats.track(StagePlan, time.Now())
+	if stats.Total() != 0 || stats.Header() != "" {
+		t.Errorf("nil Stats recorded %v, header %q", stats.Total(), stats.Header())
+	}
+}
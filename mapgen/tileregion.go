@@ -0,0 +1,79 @@
+package mapgen
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// regionAttempts is how many candidates a tile with a region draws before it
+// is skipped.
+const regionAttempts = 64
+
+// TileRegion confines the placements of a tileList entry to a rectangle given
+// in fractions of the canvas, so {"x":0,"y":0.66,"w":1,"h":0.34} is the bottom
+// third. Tiles are positioned by the mode as usual, and candidates that do not
+// lie fully inside the region are drawn again.
+type TileRegion struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+// clampRegion clips r to the unit square. It fails when nothing of r is left.
+func clampRegion(r TileRegion) (*TileRegion, error) {
+	for _, v := range []float64{r.X, r.Y, r.W, r.H} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("region must be finite")
+		}
+	}
+	x0, y0 := math.Max(r.X, 0), math.Max(r.Y, 0)
+	x1, y1 := math.Min(r.X+r.W, 1), math.Min(r.Y+r.H, 1)
+	if x1 <= x0 || y1 <= y0 {
+		return nil, fmt.Errorf("region %g,%g %gx%g lies outside the canvas", r.X, r.Y, r.W, r.H)
+	}
+	return &TileRegion{X: x0, Y: y0, W: x1 - x0, H: y1 - y0}, nil
+}
+
+// rect is the region in pixels of a width×height canvas.
+func (r *TileRegion) rect(width, height int) image.Rectangle {
+	return image.Rect(
+		int(math.Round(r.X*float64(width))), int(math.Round(r.Y*float64(height))),
+		int(math.Round((r.X+r.W)*float64(width))), int(math.Round((r.Y+r.H)*float64(height))),
+	)
+}
+
+func sameRegion(a, b *TileRegion) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// checkRegions rejects batches whose tile does not fit inside its region on
+// the final canvas.
+func checkRegions(p Params, batches []tileBatch) error {
+	for _, b := range batches {
+		if b.Region == nil {
+			continue
+		}
+		area := b.Region.rect(p.Width, p.Height)
+		if b.W > area.Dx() || b.H > area.Dy() {
+			return fmt.Errorf("tile %q does not fit its region, %dx%d pixels of the %dx%d canvas", b.Name, area.Dx(), area.Dy(), p.Width, p.Height)
+		}
+	}
+	return nil
+}
+
+// positionInRegion draws positions for a tw×th tile until one lies inside
+// area, giving up after regionAttempts.
+func (g *generator) positionInRegion(tw, th int, area image.Rectangle) (int, int, bool) {
+	for attempt := 0; attempt < g.attempts(regionAttempts); attempt++ {
+		x, y := g.positionForTile(tw, th)
+		if image.Rect(x, y, x+tw, y+th).In(area) {
+			return x, y, true
+		}
+	}
+	return 0, 0, false
+}
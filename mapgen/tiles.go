@@ -0,0 +1,627 @@
+package mapgen
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errNoTileDefinitions reports a tile list whose every entry has a zero count.
+var errNoTileDefinitions = errors.New("no valid tile definitions found")
+
+// tileSpec is one parsed tile definition. A count range sets MaxCount, and
+// Count holds its lower bound until resolveCountRanges draws the count.
+// PerArea marks a count given per densityArea pixels of canvas, which
+// resolveDensities turns into an absolute one.
+type tileSpec struct {
+	W        int
+	H        int
+	Count    float64
+	MaxCount float64
+	PerArea  bool
+	Weight   float64
+	Name     string
+	Region   *TileRegion
+}
+
+// densityArea is the canvas area a "d" count is given per: "2x2*5d" places 5
+// tiles per 1000 pixels.
+const densityArea = 1000
+
+type tileBatch struct {
+	W      int
+	H      int
+	Count  int
+	Weight float64
+	Name   string
+	Region *TileRegion
+	// spec indexes the batch's TileScaling entry.
+	spec int
+}
+
+// tileName is the name echoed in placement output: the spec's own name, or
+// "WxH" for unnamed specs.
+func tileName(name string, w, h int) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprintf("%dx%d", w, h)
+}
+
+// TileEntry is the structured JSON alternative to the tiles string. Count
+// defaults to 1 and Weight, the coverage each placement adds per cell, to 1.0.
+// CountMax turns Count into the lower bound of a seeded count range. Name
+// labels the tile type in placement output. Region, clamped to the canvas,
+// keeps the entry's placements inside it.
+type TileEntry struct {
+	Name     string      `json:"name"`
+	W        int         `json:"w"`
+	H        int         `json:"h"`
+	Count    *float64    `json:"count"`
+	CountMax *float64    `json:"countMax"`
+	Weight   *float64    `json:"weight"`
+	Region   *TileRegion `json:"region"`
+}
+
+// buildTileSpecs combines the tiles string with the structured tile list. The
+// default tile set only applies when neither is given.
+func buildTileSpecs(input string, list []TileEntry) ([]tileSpec, error) {
+	if len(list) == 0 {
+		return parseTileList(input)
+	}
+
+	var specs []tileSpec
+	if strings.TrimSpace(input) != "" {
+		parsed, err := parseTileList(input)
+		if err != nil {
+			return nil, err
+		}
+		specs = parsed
+	}
+
+	for i, entry := range list {
+		if entry.W <= 0 || entry.H <= 0 {
+			return nil, fmt.Errorf("tileList[%d]: tile dimensions must be positive", i)
+		}
+		count := 1.0
+		if entry.Count != nil {
+			count = *entry.Count
+		}
+		weight := 1.0
+		if entry.Weight != nil {
+			weight = *entry.Weight
+		}
+		if !(weight > 0) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("tileList[%d]: tile weight must be a positive number", i)
+		}
+		maxCount := 0.0
+		if entry.CountMax != nil {
+			if err := checkCountRange(count, *entry.CountMax); err != nil {
+				return nil, fmt.Errorf("tileList[%d]: %w", i, err)
+			}
+			if *entry.CountMax > count {
+				maxCount = *entry.CountMax
+			}
+		}
+		var region *TileRegion
+		if entry.Region != nil {
+			clamped, err := clampRegion(*entry.Region)
+			if err != nil {
+				return nil, fmt.Errorf("tileList[%d]: %w", i, err)
+			}
+			region = clamped
+		}
+		if count <= 0 && maxCount <= 0 {
+			continue
+		}
+		specs = append(specs, tileSpec{W: entry.W, H: entry.H, Count: count, MaxCount: maxCount, Weight: weight, Name: strings.TrimSpace(entry.Name), Region: region})
+	}
+
+	if len(specs) == 0 {
+		return nil, errNoTileDefinitions
+	}
+
+	return specs, nil
+}
+
+// checkTileNames rejects a name reused for tiles of different dimensions, which
+// would make placement output ambiguous.
+func checkTileNames(specs []tileSpec) error {
+	dims := map[string][2]int{}
+	for _, s := range specs {
+		if s.Name == "" {
+			continue
+		}
+		if prev, ok := dims[s.Name]; ok && prev != [2]int{s.W, s.H} {
+			return fmt.Errorf("tile name %q is used for both %dx%d and %dx%d", s.Name, prev[0], prev[1], s.W, s.H)
+		}
+		dims[s.Name] = [2]int{s.W, s.H}
+	}
+	return nil
+}
+
+func parseTileList(input string) ([]tileSpec, error) {
+	if strings.TrimSpace(input) == "" {
+		return []tileSpec{
+			{W: 2, H: 2, Count: 400, Weight: 1},
+			{W: 2, H: 1, Count: 300, Weight: 1},
+			{W: 1, H: 1, Count: 100, Weight: 1},
+		}, nil
+	}
+
+	raw := strings.Split(input, ",")
+	specs := make([]tileSpec, 0, len(raw))
+	var errs []TileSegmentError
+
+	for index, part := range raw {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		spec, err := parseTileSegment(part)
+		if err != nil {
+			err.Index, err.Segment = index, part
+			errs = append(errs, *err)
+			continue
+		}
+		if spec.Count <= 0 && spec.MaxCount <= 0 {
+			continue
+		}
+		specs = append(specs, spec)
+	}
+
+	if len(errs) > 0 {
+		return nil, &TileListError{Segments: errs}
+	}
+	if len(specs) == 0 {
+		return nil, errNoTileDefinitions
+	}
+
+	return specs, nil
+}
+
+// Components of a tiles segment a TileSegmentError can point at.
+const (
+	TileComponentWidth      = "width"
+	TileComponentHeight     = "height"
+	TileComponentCount      = "count"
+	TileComponentDimensions = "dimensions"
+	TileComponentWeight     = "weight"
+)
+
+// TileSegmentError is one rejected comma-separated segment of a tiles string:
+// its zero-based position, its text and the component that failed.
+type TileSegmentError struct {
+	Index     int    `json:"index"`
+	Segment   string `json:"segment"`
+	Component string `json:"component"`
+	Message   string `json:"message"`
+}
+
+func (e *TileSegmentError) Error() string { return e.Message }
+
+// TileListError reports every rejected segment of a tiles string, so they can
+// all be fixed in one pass.
+type TileListError struct {
+	Segments []TileSegmentError
+}
+
+func (e *TileListError) Error() string {
+	msgs := make([]string, len(e.Segments))
+	for i, seg := range e.Segments {
+		msgs[i] = seg.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// parseTileSegment parses one "WxH[*count][^weight]" segment. A count ending
+// in "d" is a density per densityArea pixels. Errors leave Index and Segment
+// to the caller.
+func parseTileSegment(part string) (tileSpec, *TileSegmentError) {
+	fail := func(component string, format string, args ...any) (tileSpec, *TileSegmentError) {
+		return tileSpec{}, &TileSegmentError{Component: component, Message: fmt.Sprintf(format, args...)}
+	}
+
+	body := part
+	weight := 1.0
+	if head, raw, ok := strings.Cut(part, "^"); ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fail(TileComponentWeight, "invalid tile weight in %q: %v", part, err)
+		}
+		if !(v > 0) || math.IsInf(v, 0) {
+			return fail(TileComponentWeight, "tile weight must be a positive number in %q", part)
+		}
+		body = head
+		weight = v
+	}
+
+	dimCount := strings.SplitN(body, "*", 2)
+	dims := dimCount[0]
+	count, maxCount := 1.0, 0.0
+	perArea := false
+	if len(dimCount) == 2 {
+		clean := strings.TrimSpace(dimCount[1])
+		if trimmed, ok := strings.CutSuffix(clean, "d"); ok {
+			clean, perArea = strings.TrimSpace(trimmed), true
+		}
+		// A '-' after the first character separates a count range, unless
+		// the whole count is a number such as 1e-1.
+		_, numErr := strconv.ParseFloat(clean, 64)
+		if lo, hi, ok := strings.Cut(clean, "-"); ok && lo != "" && numErr != nil {
+			if perArea {
+				return fail(TileComponentCount, "density counts cannot be ranges in %q", part)
+			}
+			minV, err := strconv.ParseFloat(strings.TrimSpace(lo), 64)
+			if err != nil {
+				return fail(TileComponentCount, "invalid tile count in %q: %v", part, err)
+			}
+			maxV, err := strconv.ParseFloat(strings.TrimSpace(hi), 64)
+			if err != nil {
+				return fail(TileComponentCount, "invalid tile count in %q: %v", part, err)
+			}
+			if err := checkCountRange(minV, maxV); err != nil {
+				return fail(TileComponentCount, "%v in %q", err, part)
+			}
+			count = minV
+			if maxV > minV {
+				maxCount = maxV
+			}
+		} else if clean != "" {
+			v, err := strconv.ParseFloat(clean, 64)
+			if err != nil {
+				return fail(TileComponentCount, "invalid tile count in %q: %v", part, err)
+			}
+			if perArea && !(v >= 0 && !math.IsInf(v, 0)) {
+				return fail(TileComponentCount, "tile density must be a non-negative number in %q", part)
+			}
+			count = v
+		}
+	}
+
+	dParts := strings.SplitN(dims, "x", 2)
+	if len(dParts) != 2 {
+		return fail(TileComponentDimensions, "invalid tile dimensions in %q", part)
+	}
+
+	w, err := strconv.Atoi(strings.TrimSpace(dParts[0]))
+	if err != nil {
+		return fail(TileComponentWidth, "invalid tile width in %q: %v", part, err)
+	}
+	h, err := strconv.Atoi(strings.TrimSpace(dParts[1]))
+	if err != nil {
+		return fail(TileComponentHeight, "invalid tile height in %q: %v", part, err)
+	}
+	if w <= 0 || h <= 0 {
+		return fail(TileComponentDimensions, "tile dimensions must be positive in %q", part)
+	}
+
+	return tileSpec{W: w, H: h, Count: count, MaxCount: maxCount, PerArea: perArea, Weight: weight}, nil
+}
+
+// resolveDensities turns the count of every PerArea spec into the absolute
+// count for a canvas of area pixels. The counts stay fractional until
+// finalizeTileBatches rounds them with the rest.
+func resolveDensities(specs []tileSpec, area int) []tileSpec {
+	for i := range specs {
+		if specs[i].PerArea {
+			specs[i].Count *= float64(area) / densityArea
+			specs[i].PerArea = false
+		}
+	}
+	return specs
+}
+
+// checkCountRange validates the bounds of a count range: finite, whole,
+// non-negative and in order.
+func checkCountRange(lo, hi float64) error {
+	if lo < 0 || math.IsInf(lo, 0) || math.IsInf(hi, 0) || lo != math.Trunc(lo) || hi != math.Trunc(hi) {
+		return errors.New("tile count range bounds must be non-negative whole numbers")
+	}
+	if hi < lo {
+		return errors.New("tile count range maximum is below its minimum")
+	}
+	return nil
+}
+
+// countRangeSalt separates the count stream from the other seed-derived
+// streams.
+const countRangeSalt = 0xd1b54a32d192ed03
+
+// resolveCountRanges draws the count of every ranged spec uniformly from its
+// whole-number range. The draw hashes the seed instead of using the placement
+// stream, so specs with a single count keep today's layouts. Specs that
+// resolve to zero are dropped.
+func resolveCountRanges(specs []tileSpec, seed int64) []tileSpec {
+	out := specs[:0]
+	for i, s := range specs {
+		if s.MaxCount > 0 {
+			span := uint64(s.MaxCount-s.Count) + 1
+			s.Count += float64(splitmix64(uint64(seed)^countRangeSalt+uint64(i)) % span)
+			s.MaxCount = 0
+		}
+		if s.Count > 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// mergeTileSpecs folds specs of the same size, weight and name into the first
+// of them by summing their counts, so "2x2*100,2x2*50" is planned as one
+// batch of 150. It runs after resolveCountRanges, which draws every range on
+// its own, so merging leaves the drawn counts as they were.
+func mergeTileSpecs(specs []tileSpec) []tileSpec {
+	out := specs[:0]
+	for _, s := range specs {
+		merged := false
+		for i := range out {
+			o := &out[i]
+			if o.W == s.W && o.H == s.H && o.Weight == s.Weight && sameRegion(o.Region, s.Region) && tileName(o.Name, o.W, o.H) == tileName(s.Name, s.W, s.H) {
+				o.Count += s.Count
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// applyLegacyTiles adds the n22/n21/n11 counts. They merge into an unnamed
+// same-size spec when one exists, otherwise into a spec named "legacy-WxH".
+func applyLegacyTiles(specs []tileSpec, n22, n21, n11 int) []tileSpec {
+	legacy := []struct {
+		W, H int
+		N    int
+	}{
+		{2, 2, n22},
+		{2, 1, n21},
+		{1, 1, n11},
+	}
+
+	for _, entry := range legacy {
+		if entry.N <= 0 {
+			continue
+		}
+		name := fmt.Sprintf("legacy-%dx%d", entry.W, entry.H)
+		target := -1
+		for i := range specs {
+			if specs[i].W != entry.W || specs[i].H != entry.H || specs[i].Weight != 1 {
+				continue
+			}
+			if specs[i].Name == "" {
+				target = i
+				break
+			}
+			if specs[i].Name == name && target < 0 {
+				target = i
+			}
+		}
+		if target >= 0 {
+			specs[target].Count += float64(entry.N)
+			if specs[target].MaxCount > 0 {
+				specs[target].MaxCount += float64(entry.N)
+			}
+			continue
+		}
+		specs = append(specs, tileSpec{
+			W:      entry.W,
+			H:      entry.H,
+			Count:  float64(entry.N),
+			Weight: 1,
+			Name:   name,
+		})
+	}
+
+	return specs
+}
+
+func activateMultiplier(specs []tileSpec, ka float64) {
+	if ka == 0 {
+		return
+	}
+	if ka < 0 {
+		ka = 0
+	}
+	if ka == 1 {
+		return
+	}
+	for i := range specs {
+		specs[i].Count *= ka
+	}
+}
+
+const (
+	capPolicyProportional = "proportional"
+	capPolicyPreserveAll  = "preserve-all"
+)
+
+// TileScaling reports how cap scaling changed the count of one tile spec.
+type TileScaling struct {
+	Name      string  `json:"name"`
+	W         int     `json:"w"`
+	H         int     `json:"h"`
+	Requested float64 `json:"requested"`
+	Final     int     `json:"final"`
+	// Placed counts the final tiles that were painted and Skipped those that
+	// were not, by reason in SkipReasons: "oversized" for a tile larger than
+	// the canvas, "region" for one that found no position inside the spec's
+	// region.
+	Placed      int            `json:"placed"`
+	Skipped     int            `json:"skipped,omitempty"`
+	SkipReasons map[string]int `json:"skipReasons,omitempty"`
+}
+
+// sortLargestFirst orders batches by descending tile area so large tiles are
+// placed before small ones fill the gaps. Equal areas keep their parse order.
+func sortLargestFirst(batches []tileBatch) {
+	sort.SliceStable(batches, func(i, j int) bool {
+		return batches[i].W*batches[i].H > batches[j].W*batches[j].H
+	})
+}
+
+// finalizeTileBatches converts fractional spec counts into integer batches that
+// respect capLimit. Alongside the batches it returns the requested-vs-final
+// count of every spec and the scale factor applied by the cap.
+func finalizeTileBatches(specs []tileSpec, capLimit int, policy string) ([]tileBatch, []TileScaling, float64) {
+	type fractional struct {
+		index int
+		frac  float64
+	}
+
+	sumCounts := 0.0
+	for _, s := range specs {
+		sumCounts += s.Count
+	}
+
+	if sumCounts == 0 {
+		return nil, nil, 1
+	}
+
+	scale := 1.0
+	if capLimit > 0 && sumCounts > float64(capLimit) {
+		scale = float64(capLimit) / sumCounts
+	}
+
+	scaledTotals := make([]float64, len(specs))
+	floors := make([]int, len(specs))
+	fractions := make([]fractional, 0, len(specs))
+	totalFloors := 0
+
+	for i, s := range specs {
+		adjusted := s.Count * scale
+		if adjusted <= 0 {
+			continue
+		}
+		scaledTotals[i] = adjusted
+		base := int(math.Floor(adjusted))
+		floors[i] = base
+		totalFloors += base
+
+		f := adjusted - float64(base)
+		if f > 0 {
+			fractions = append(fractions, fractional{index: i, frac: f})
+		}
+	}
+
+	targetTotal := 0
+	sumScaled := 0.0
+	for _, v := range scaledTotals {
+		sumScaled += v
+	}
+
+	if capLimit > 0 {
+		if scale < 1 {
+			targetTotal = capLimit
+		} else {
+			targetTotal = min(capLimit, int(math.Round(sumScaled)))
+		}
+	} else {
+		targetTotal = int(math.Round(sumScaled))
+	}
+
+	if targetTotal < totalFloors {
+		// Reduce counts from smallest fractional values first
+		sort.Slice(fractions, func(i, j int) bool {
+			if fractions[i].frac == fractions[j].frac {
+				return fractions[i].index < fractions[j].index
+			}
+			return fractions[i].frac < fractions[j].frac
+		})
+		diff := totalFloors - targetTotal
+		for k := 0; k < diff && k < len(fractions); k++ {
+			idx := fractions[k].index
+			if floors[idx] > 0 {
+				floors[idx]--
+			}
+		}
+		totalFloors -= min(diff, len(fractions))
+	}
+
+	if totalFloors < targetTotal {
+		remaining := targetTotal - totalFloors
+		sort.Slice(fractions, func(i, j int) bool {
+			if fractions[i].frac == fractions[j].frac {
+				return fractions[i].index < fractions[j].index
+			}
+			return fractions[i].frac > fractions[j].frac
+		})
+		for k := 0; k < remaining && k < len(fractions); k++ {
+			floors[fractions[k].index]++
+		}
+	}
+
+	if policy == capPolicyPreserveAll && capLimit > 0 && scale < 1 {
+		preserveAllSpecs(specs, floors, capLimit)
+	}
+
+	batches := make([]tileBatch, 0, len(specs))
+	scaling := make([]TileScaling, 0, len(specs))
+	for i, s := range specs {
+		count := max(floors[i], 0)
+		scaling = append(scaling, TileScaling{
+			Name:      tileName(s.Name, s.W, s.H),
+			W:         s.W,
+			H:         s.H,
+			Requested: s.Count,
+			Final:     count,
+		})
+		if count <= 0 {
+			continue
+		}
+		batches = append(batches, tileBatch{
+			W:      s.W,
+			H:      s.H,
+			Count:  count,
+			Weight: s.Weight,
+			Name:   tileName(s.Name, s.W, s.H),
+			Region: s.Region,
+			spec:   i,
+		})
+	}
+
+	return batches, scaling, scale
+}
+
+// preserveAllSpecs gives every spec with a positive requested count at least
+// one placement, taking the difference from the largest batches so the total
+// stays at capLimit. It is a no-op when the cap is smaller than the number of
+// requested specs.
+func preserveAllSpecs(specs []tileSpec, floors []int, capLimit int) {
+	requested := 0
+	for _, s := range specs {
+		if s.Count > 0 {
+			requested++
+		}
+	}
+	if requested == 0 || capLimit < requested {
+		return
+	}
+
+	deficit := 0
+	for i, s := range specs {
+		if s.Count > 0 && floors[i] == 0 {
+			floors[i] = 1
+			deficit++
+		}
+	}
+
+	for ; deficit > 0; deficit-- {
+		largest := -1
+		for i, f := range floors {
+			if f > 1 && (largest < 0 || f > floors[largest]) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			return
+		}
+		floors[largest]--
+	}
+}
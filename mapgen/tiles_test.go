@@ -0,0 +1,160 @@
+package mapgen
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestFinalizeTileBatchesCapPolicy(t *testing.T) {
+	specs := []tileSpec{
+		{W: 8, H: 8, Count: 2, Weight: 1},
+		{W: 2, H: 2, Count: 400, Weight: 1},
+		{W: 1, H: 1, Count: 100, Weight: 1},
+	}
+	tests := []struct {
+		name   string
+		cap    int
+		policy string
+		// wantAll reports whether every spec must keep at least one tile.
+		wantAll bool
+	}{
+		{"proportional below spec count", 2, capPolicyProportional, false},
+		{"proportional at spec count", 3, capPolicyProportional, false},
+		{"proportional above spec count", 4, capPolicyProportional, false},
+		{"preserve-all below spec count", 2, capPolicyPreserveAll, false},
+		{"preserve-all at spec count", 3, capPolicyPreserveAll, true},
+		{"preserve-all above spec count", 4, capPolicyPreserveAll, true},
+		{"preserve-all well above spec count", 50, capPolicyPreserveAll, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, scaling, scale := finalizeTileBatches(specs, tt.cap, tt.policy)
+			if scale >= 1 {
+				t.Fatalf("scale = %v, want < 1 for cap %d", scale, tt.cap)
+			}
+			if len(scaling) != len(specs) {
+				t.Fatalf("got %d scaling entries, want %d", len(scaling), len(specs))
+			}
+			total := 0
+			for _, s := range scaling {
+				total += s.Final
+				if tt.wantAll && s.Final < 1 {
+					t.Errorf("%s: final %d, want at least 1", s.Name, s.Final)
+				}
+			}
+			if total != tt.cap {
+				t.Errorf("total = %d, want cap %d", total, tt.cap)
+			}
+		})
+	}
+}
+
+func TestFinalizeTileBatchesProportionalDropsSmallSpecs(t *testing.T) {
+	specs := []tileSpec{
+		{W: 8, H: 8, Count: 2, Weight: 1},
+		{W: 2, H: 2, Count: 400, Weight: 1},
+		{W: 1, H: 1, Count: 100, Weight: 1},
+	}
+	_, scaling, _ := finalizeTileBatches(specs, 4, capPolicyProportional)
+	if scaling[0].Final != 0 {
+		t.Errorf("8x8 final = %d under proportional, want 0", scaling[0].Final)
+	}
+	_, scaling, _ = finalizeTileBatches(specs, 4, capPolicyPreserveAll)
+	if scaling[0].Final != 1 {
+		t.Errorf("8x8 final = %d under preserve-all, want 1", scaling[0].Final)
+	}
+}
+
+func TestFinalizeTileBatchesUnderCap(t *testing.T) {
+	specs := []tileSpec{{W: 2, H: 2, Count: 3, Weight: 1}, {W: 1, H: 1, Count: 5, Weight: 1}}
+	for _, policy := range []string{capPolicyProportional, capPolicyPreserveAll} {
+		batches, _, scale := finalizeTileBatches(specs, 100, policy)
+		if scale != 1 {
+			t.Errorf("%s: scale = %v, want 1", policy, scale)
+		}
+		if len(batches) != 2 || batches[0].Count != 3 || batches[1].Count != 5 {
+			t.Errorf("%s: batches = %+v, want counts 3 and 5", policy, batches)
+		}
+	}
+}
+
+func TestMergeTileSpecs(t *testing.T) {
+	left := &TileRegion{W: 0.5, H: 1}
+	specs := []tileSpec{
+		{W: 2, H: 2, Count: 100, Weight: 1},
+		{W: 2, H: 1, Count: 10, Weight: 1},
+		{W: 2, H: 2, Count: 50, Weight: 1},
+		// A different weight, name or region keeps its own batch.
+		{W: 2, H: 2, Count: 7, Weight: 0.5},
+		{W: 2, H: 2, Count: 3, Weight: 1, Name: "hill"},
+		{W: 2, H: 2, Count: 4, Weight: 1, Region: left},
+		{W: 2, H: 2, Count: 5, Weight: 1, Region: &TileRegion{W: 0.5, H: 1}},
+		// An explicit name equal to the default one is the same spec.
+		{W: 2, H: 1, Count: 1, Weight: 1, Name: "2x1"},
+	}
+	got := mergeTileSpecs(specs)
+	want := []tileSpec{
+		{W: 2, H: 2, Count: 150, Weight: 1},
+		{W: 2, H: 1, Count: 11, Weight: 1},
+		{W: 2, H: 2, Count: 7, Weight: 0.5},
+		{W: 2, H: 2, Count: 3, Weight: 1, Name: "hill"},
+		{W: 2, H: 2, Count: 9, Weight: 1, Region: left},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d specs %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.W != w.W || g.H != w.H || g.Count != w.Count || g.Weight != w.Weight || g.Name != w.Name || !sameRegion(g.Region, w.Region) {
+			t.Errorf("spec %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestBuildTileSpecsRejectsNonFiniteWeights(t *testing.T) {
+	for _, weight := range []float64{0, -1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		raw := strconv.FormatFloat(weight, 'g', -1, 64)
+		if _, err := buildTileSpecs("2x2*100^"+raw, nil); err == nil {
+			t.Errorf("tiles weight %s accepted", raw)
+		}
+		w := weight
+		if _, err := buildTileSpecs("", []TileEntry{{W: 2, H: 2, Weight: &w}}); err == nil {
+			t.Errorf("tileList weight %s accepted", raw)
+		}
+	}
+	if _, err := buildTileSpecs("2x2*100^0.5", nil); err != nil {
+		t.Errorf("tiles weight 0.5: %v", err)
+	}
+}
+
+func TestParseTileSegmentCounts(t *testing.T) {
+	tests := []struct {
+		segment       string
+		count, maxCnt float64
+		ok            bool
+	}{
+		{"2x2*1e-1", 0.1, 0, true},
+		{"2x2*2.5e+1", 25, 0, true},
+		{"2x2*3-7", 3, 7, true},
+		{"2x2*3 - 7", 3, 7, true},
+		{"2x2*1-Inf", 0, 0, false},
+		{"2x2*Inf-Inf", 0, 0, false},
+		{"2x2*7-3", 0, 0, false},
+		{"2x2*1.5-3", 0, 0, false},
+	}
+	for _, tt := range tests {
+		spec, err := parseTileSegment(tt.segment)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want accepted %v", tt.segment, err, tt.ok)
+			continue
+		}
+		if tt.ok && (spec.Count != tt.count || spec.MaxCount != tt.maxCnt) {
+			t.Errorf("%s: count %v max %v, want %v max %v", tt.segment, spec.Count, spec.MaxCount, tt.count, tt.maxCnt)
+		}
+	}
+	inf := math.Inf(1)
+	if _, err := buildTileSpecs("", []TileEntry{{W: 2, H: 2, CountMax: &inf}}); err == nil {
+		t.Error("tileList countMax +Inf accepted")
+	}
+}
@@ -0,0 +1,97 @@
+package mapgen
+
+import "errors"
+
+// virginGrid tracks the cells placed tiles cover, for preferVirgin. Each row
+// keeps its covered cells in a Fenwick tree, so the covered area of a tile
+// rectangle is exact after every placement and costs O(th·log width) to query;
+// every cell enters its tree once, when it is first covered.
+type virginGrid struct {
+	width, height int
+	covered       []bool
+	rows          []int32
+}
+
+// normalizePreferVirgin validates preferVirgin, the strength with which
+// candidates over already covered ground are resampled. It keeps a grid of
+// the whole canvas, so the sparse path does not support it.
+func normalizePreferVirgin(req *Request, p *Params) error {
+	if req.PreferVirgin == nil {
+		return nil
+	}
+	if !(*req.PreferVirgin >= 0 && *req.PreferVirgin <= 1) {
+		return errors.New("preferVirgin must be between 0 and 1")
+	}
+	if *req.PreferVirgin > 0 && req.Sparse != nil && *req.Sparse {
+		return errors.New("sparse does not support preferVirgin")
+	}
+	p.PreferVirgin = *req.PreferVirgin
+	return nil
+}
+
+func newVirginGrid(width, height int) *virginGrid {
+	return &virginGrid{
+		width:   width,
+		height:  height,
+		covered: make([]bool, width*height),
+		rows:    make([]int32, (width+1)*height),
+	}
+}
+
+// cover marks the cells of the tw×th rectangle at (x, y) clipped to the grid.
+func (v *virginGrid) cover(x, y, tw, th int) {
+	x0, y0 := max(x, 0), max(y, 0)
+	x1, y1 := min(x+tw, v.width), min(y+th, v.height)
+	for row := y0; row < y1; row++ {
+		cells := v.covered[row*v.width:]
+		tree := v.rows[row*(v.width+1):]
+		for col := x0; col < x1; col++ {
+			if cells[col] {
+				continue
+			}
+			cells[col] = true
+			for i := col + 1; i <= v.width; i += i & -i {
+				tree[i]++
+			}
+		}
+	}
+}
+
+// coveredFraction is the share of the tw×th rectangle at (x, y) that is
+// already covered; cells off the grid count as uncovered.
+func (v *virginGrid) coveredFraction(x, y, tw, th int) float64 {
+	if tw <= 0 || th <= 0 {
+		return 0
+	}
+	x0, y0 := max(x, 0), max(y, 0)
+	x1, y1 := min(x+tw, v.width), min(y+th, v.height)
+	if x0 >= x1 {
+		return 0
+	}
+	covered := 0
+	for row := y0; row < y1; row++ {
+		tree := v.rows[row*(v.width+1):]
+		covered += int(prefixSum(tree, x1) - prefixSum(tree, x0))
+	}
+	return float64(covered) / float64(tw*th)
+}
+
+// prefixSum is the count of covered cells in the first n columns of a row.
+func prefixSum(tree []int32, n int) int32 {
+	sum := int32(0)
+	for i := n; i > 0; i -= i & -i {
+		sum += tree[i]
+	}
+	return sum
+}
+
+// virginAccepts keeps a candidate with probability 1 - preferVirgin times its
+// covered fraction, so candidates on fresh ground always stand. Only
+// candidates over covered cells draw a number.
+func (g *generator) virginAccepts(x, y, tw, th int) bool {
+	if g.virgin == nil {
+		return true
+	}
+	fraction := g.virgin.coveredFraction(x, y, tw, th)
+	return fraction == 0 || g.rnd.Float64() >= g.preferVirgin*fraction
+}
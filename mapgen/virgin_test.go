@@ -0,0 +1,71 @@
+package mapgen
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// TestPreferVirginGrowsLand places the same tiles with increasing
+// preferVirgin and checks that the land area grows with it.
+func TestPreferVirginGrowsLand(t *testing.T) {
+	for _, mode := range []string{"merkez", "adalar"} {
+		t.Run(fmt.Sprintf("mode %q", mode), func(t *testing.T) {
+			land := func(strength float64) int {
+				total := 0
+				for seed := 0; seed < 4; seed++ {
+					total += len(coverageCells(t, Request{
+						W: 120, H: 80, Tiles: "4x4*200,2x2*300", Mode: mode,
+						Seed: fmt.Sprint("virgin-", seed), PreferVirgin: &strength,
+					}))
+				}
+				return total
+			}
+			off, half, full := land(0), land(0.5), land(1)
+			if !(off < half && half < full) {
+				t.Errorf("land area %d, %d, %d at preferVirgin 0, 0.5, 1, want it to grow", off, half, full)
+			}
+		})
+	}
+}
+
+func TestPreferVirginZeroMatchesOmitted(t *testing.T) {
+	zero := 0.0
+	req := Request{W: 60, H: 40, Tiles: "3x3*80", Seed: "virgin"}
+	omitted := generate(t, req).Data
+	req.PreferVirgin = &zero
+	if string(generate(t, req).Data) != string(omitted) {
+		t.Error("preferVirgin 0 changed the output")
+	}
+}
+
+func TestVirginGridCoveredFraction(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	const width, height = 23, 17
+	v := newVirginGrid(width, height)
+	covered := make([]bool, width*height)
+	for i := 0; i < 60; i++ {
+		x, y := rnd.Intn(width+6)-3, rnd.Intn(height+6)-3
+		tw, th := 1+rnd.Intn(6), 1+rnd.Intn(6)
+		v.cover(x, y, tw, th)
+		for row := max(y, 0); row < min(y+th, height); row++ {
+			for col := max(x, 0); col < min(x+tw, width); col++ {
+				covered[row*width+col] = true
+			}
+		}
+
+		qx, qy := rnd.Intn(width+6)-3, rnd.Intn(height+6)-3
+		qw, qh := 1+rnd.Intn(8), 1+rnd.Intn(8)
+		want := 0
+		for row := max(qy, 0); row < min(qy+qh, height); row++ {
+			for col := max(qx, 0); col < min(qx+qw, width); col++ {
+				if covered[row*width+col] {
+					want++
+				}
+			}
+		}
+		if got := v.coveredFraction(qx, qy, qw, qh); got != float64(want)/float64(qw*qh) {
+			t.Fatalf("coveredFraction(%d, %d, %d, %d) = %v, want %d/%d", qx, qy, qw, qh, got, want, qw*qh)
+		}
+	}
+}
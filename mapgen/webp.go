@@ -0,0 +1,495 @@
+package mapgen
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"sort"
+)
+
+// WebP output is a lossless VP8L bitstream in a RIFF container, written
+// without dependencies: no transforms, a single prefix code group, a color
+// cache and backward references to the pixel on the left and the one above,
+// which is where the long flat runs of a map repeat. Lossy output quantizes
+// the colors first and encodes the result losslessly, so it trades exactness
+// for smaller prefix codes rather than using the VP8 codec.
+const (
+	// maxWebPSide is the largest side VP8L can describe.
+	maxWebPSide = 16384
+
+	defaultWebPQuality = 90
+
+	webpCacheBits    = 10
+	webpMinMatch     = 3
+	webpMaxMatch     = 4096
+	webpLengthCodes  = 24
+	webpDistCodes    = 40
+	webpMaxCodeLen   = 15
+	webpMaxCLCodeLen = 7
+)
+
+// webpCodeLengthOrder is the order code length code lengths are written in.
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpToken is one entry of the entropy-coded image: a literal ARGB pixel, a
+// color cache hit or a backward reference of length pixels.
+type webpToken struct {
+	argb     uint32
+	cache    int // cache index, or -1
+	length   int // > 0 for a backward reference
+	distCode int
+}
+
+// encodeWebP encodes img as a WebP file. Without lossless, quality (1-100)
+// chooses how many low bits of every channel are rounded away first.
+func encodeWebP(img *image.RGBA, lossless bool, quality int) ([]byte, error) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width > maxWebPSide || height > maxWebPSide {
+		return nil, errors.New("encode webp: canvas sides are limited to 16384")
+	}
+
+	argb := make([]uint32, width*height)
+	alpha := false
+	drop := uint(0)
+	if !lossless {
+		drop = uint((100 - quality + 12) / 25)
+	}
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width*4]
+		for x := 0; x < width; x++ {
+			p := row[x*4 : x*4+4]
+			a := uint32(p[3])
+			if a == 0 {
+				alpha = true
+				continue
+			}
+			r, g, bl := uint32(p[0]), uint32(p[1]), uint32(p[2])
+			if a != 255 {
+				// VP8L stores straight alpha.
+				alpha = true
+				r, g, bl = r*255/a, g*255/a, bl*255/a
+			}
+			r, g, bl = quantizeChannel(r, drop), quantizeChannel(g, drop), quantizeChannel(bl, drop)
+			argb[y*width+x] = a<<24 | r<<16 | g<<8 | bl
+		}
+	}
+
+	var w webpBitWriter
+	w.write(0x2f, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if alpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3) // version
+	w.write(0, 1) // no transforms
+	w.write(1, 1) // color cache
+	w.write(webpCacheBits, 4)
+	w.write(0, 1) // a single prefix code group
+
+	tokens := webpTokens(argb, width)
+
+	greenSize := 256 + webpLengthCodes + 1<<webpCacheBits
+	freqs := [5][]int{make([]int, greenSize), make([]int, 256), make([]int, 256), make([]int, 256), make([]int, webpDistCodes)}
+	for _, t := range tokens {
+		switch {
+		case t.length > 0:
+			code, _, _ := webpPrefix(t.length)
+			freqs[0][256+code]++
+			code, _, _ = webpPrefix(t.distCode)
+			freqs[4][code]++
+		case t.cache >= 0:
+			freqs[0][256+webpLengthCodes+t.cache]++
+		default:
+			freqs[0][t.argb>>8&0xff]++
+			freqs[1][t.argb>>16&0xff]++
+			freqs[2][t.argb&0xff]++
+			freqs[3][t.argb>>24]++
+		}
+	}
+
+	var codes [5]webpCode
+	for i, freq := range freqs {
+		codes[i] = writeWebPCode(&w, freq)
+	}
+
+	for _, t := range tokens {
+		switch {
+		case t.length > 0:
+			code, bits, extra := webpPrefix(t.length)
+			codes[0].put(&w, 256+code)
+			w.write(extra, bits)
+			code, bits, extra = webpPrefix(t.distCode)
+			codes[4].put(&w, code)
+			w.write(extra, bits)
+		case t.cache >= 0:
+			codes[0].put(&w, 256+webpLengthCodes+t.cache)
+		default:
+			codes[0].put(&w, int(t.argb>>8&0xff))
+			codes[1].put(&w, int(t.argb>>16&0xff))
+			codes[2].put(&w, int(t.argb&0xff))
+			codes[3].put(&w, int(t.argb>>24))
+		}
+	}
+
+	return webpContainer(w.bytes()), nil
+}
+
+// quantizeChannel rounds v to the nearest multiple of 1<<drop, clamped to 255.
+func quantizeChannel(v uint32, drop uint) uint32 {
+	if drop == 0 {
+		return v
+	}
+	step := uint32(1) << drop
+	if v = (v + step/2) / step * step; v > 255 {
+		return 255
+	}
+	return v
+}
+
+// webpTokens greedily turns the pixels into literals, cache hits and
+// references to the left or upper neighbor, whichever copies more pixels.
+func webpTokens(argb []uint32, width int) []webpToken {
+	var cache [1 << webpCacheBits]uint32
+	cached := func(c uint32) int { return int((0x1e35a7bd * c) >> (32 - webpCacheBits)) }
+
+	var tokens []webpToken
+	for i := 0; i < len(argb); {
+		best, bestDist, bestCode := 0, 0, 0
+		// Plane codes 2 and 1 stand for the left and the upper neighbor.
+		for _, cand := range [2][2]int{{1, 2}, {width, 1}} {
+			dist := cand[0]
+			if i < dist {
+				continue
+			}
+			n := 0
+			for n < webpMaxMatch && i+n < len(argb) && argb[i+n] == argb[i+n-dist] {
+				n++
+			}
+			if n > best {
+				best, bestDist, bestCode = n, dist, cand[1]
+			}
+		}
+
+		if best >= webpMinMatch {
+			tokens = append(tokens, webpToken{length: best, distCode: bestCode, cache: -1})
+			for k := 0; k < best; k++ {
+				c := argb[i+k-bestDist]
+				cache[cached(c)] = c
+			}
+			i += best
+			continue
+		}
+
+		c := argb[i]
+		// The decoder's cache starts zeroed too, so even early hits on
+		// transparent black are valid.
+		if idx := cached(c); cache[idx] == c {
+			tokens = append(tokens, webpToken{cache: idx})
+		} else {
+			tokens = append(tokens, webpToken{argb: c, cache: -1})
+		}
+		cache[cached(c)] = c
+		i++
+	}
+	return tokens
+}
+
+// webpPrefix splits a length or distance code value into its prefix symbol
+// and the extra bits that follow it.
+func webpPrefix(v int) (code int, bits uint, extra uint32) {
+	x := v - 1
+	if x < 4 {
+		return x, 0, 0
+	}
+	h := 31
+	for x>>h == 0 {
+		h--
+	}
+	second := x >> (h - 1) & 1
+	return 2*h + second, uint(h - 1), uint32(x & (1<<(h-1) - 1))
+}
+
+// webpCode is a canonical prefix code with its codes bit-reversed for the
+// LSB-first stream. A code with a single symbol takes no bits.
+type webpCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func (c webpCode) put(w *webpBitWriter, symbol int) {
+	w.write(uint32(c.codes[symbol]), uint(c.lengths[symbol]))
+}
+
+// writeWebPCode writes the prefix code for freq and returns it for coding.
+// One or two symbols below 256 use the compact simple code.
+func writeWebPCode(w *webpBitWriter, freq []int) webpCode {
+	var used []int
+	for s, f := range freq {
+		if f > 0 {
+			used = append(used, s)
+		}
+	}
+	lengths := make([]uint8, len(freq))
+	if len(used) <= 1 {
+		symbol := 0
+		if len(used) == 1 {
+			symbol = used[0]
+		}
+		if symbol < 256 {
+			w.write(1, 1) // simple code
+			w.write(0, 1) // one symbol
+			writeSimpleSymbol(w, symbol)
+			return webpCode{lengths: lengths, codes: make([]uint16, len(freq))}
+		}
+		// A lone symbol beyond the simple code's reach is written as a
+		// normal code; decoders read it as taking no bits.
+		lengths[symbol] = 1
+		writeCodeLengths(w, lengths)
+		return webpCode{lengths: make([]uint8, len(freq)), codes: make([]uint16, len(freq))}
+	}
+	if len(used) == 2 && used[1] < 256 {
+		w.write(1, 1)
+		w.write(1, 1) // two symbols
+		writeSimpleSymbol(w, used[0])
+		w.write(uint32(used[1]), 8)
+		lengths[used[0]], lengths[used[1]] = 1, 1
+		return webpCode{lengths: lengths, codes: canonicalCodes(lengths)}
+	}
+
+	lengths = huffmanLengths(freq, webpMaxCodeLen)
+	writeCodeLengths(w, lengths)
+	return webpCode{lengths: lengths, codes: canonicalCodes(lengths)}
+}
+
+func writeSimpleSymbol(w *webpBitWriter, symbol int) {
+	if symbol < 2 {
+		w.write(0, 1)
+		w.write(uint32(symbol), 1)
+		return
+	}
+	w.write(1, 1)
+	w.write(uint32(symbol), 8)
+}
+
+// writeCodeLengths writes a normal prefix code: the code lengths run-length
+// coded with symbols 16-18 and compressed by the code length code.
+func writeCodeLengths(w *webpBitWriter, lengths []uint8) {
+	type clToken struct {
+		symbol int
+		extra  uint32
+		bits   uint
+	}
+	var tokens []clToken
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				tokens = append(tokens, clToken{18, uint32(n - 11), 7})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, clToken{17, uint32(run - 3), 3})
+				run = 0
+			}
+			for ; run > 0; run-- {
+				tokens = append(tokens, clToken{0, 0, 0})
+			}
+			continue
+		}
+		tokens = append(tokens, clToken{int(l), 0, 0})
+		run--
+		for run >= 3 {
+			n := min(run, 6)
+			tokens = append(tokens, clToken{16, uint32(n - 3), 2})
+			run -= n
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, clToken{int(l), 0, 0})
+		}
+	}
+
+	freq := make([]int, len(webpCodeLengthOrder))
+	for _, t := range tokens {
+		freq[t.symbol]++
+	}
+	clLengths := huffmanLengths(freq, webpMaxCLCodeLen)
+	clCodes := canonicalCodes(clLengths)
+	used := 0
+	for _, l := range clLengths {
+		if l > 0 {
+			used++
+		}
+	}
+	if used == 1 {
+		// A single code length symbol takes no bits per token.
+		clCodes = make([]uint16, len(clCodes))
+	}
+
+	count := len(webpCodeLengthOrder)
+	for count > 4 && clLengths[webpCodeLengthOrder[count-1]] == 0 {
+		count--
+	}
+	w.write(0, 1) // normal code
+	w.write(uint32(count-4), 4)
+	for _, s := range webpCodeLengthOrder[:count] {
+		w.write(uint32(clLengths[s]), 3)
+	}
+	w.write(0, 1) // lengths run to the end of the alphabet
+	for _, t := range tokens {
+		if used > 1 {
+			w.write(uint32(clCodes[t.symbol]), uint(clLengths[t.symbol]))
+		}
+		w.write(t.extra, t.bits)
+	}
+}
+
+// huffmanLengths builds Huffman code lengths for freq no longer than limit,
+// halving the frequencies until the tree is shallow enough. A single used
+// symbol gets length 1.
+func huffmanLengths(freq []int, limit int) []uint8 {
+	f := append([]int(nil), freq...)
+	for {
+		lengths, depth := huffmanDepths(f)
+		if depth <= limit {
+			return lengths
+		}
+		for i, v := range f {
+			if v > 0 {
+				f[i] = max(1, v/2)
+			}
+		}
+	}
+}
+
+func huffmanDepths(freq []int) ([]uint8, int) {
+	type node struct {
+		weight      int
+		left, right int // child nodes, -1 for leaves
+		symbol      int
+	}
+	var nodes []node
+	for s, f := range freq {
+		if f > 0 {
+			nodes = append(nodes, node{weight: f, left: -1, right: -1, symbol: s})
+		}
+	}
+	lengths := make([]uint8, len(freq))
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbol] = 1
+		return lengths, 1
+	}
+	if len(nodes) == 0 {
+		return lengths, 0
+	}
+
+	// Two-queue construction over the leaves sorted by weight.
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+	leaves := len(nodes)
+	li, qi := 0, leaves
+	pick := func() int {
+		if li < leaves && (qi >= len(nodes) || nodes[li].weight <= nodes[qi].weight) {
+			li++
+			return li - 1
+		}
+		qi++
+		return qi - 1
+	}
+	for len(nodes)-leaves < leaves-1 {
+		a, b := pick(), pick()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, left: a, right: b})
+	}
+
+	depth := 0
+	var walk func(n, d int)
+	walk = func(n, d int) {
+		if nodes[n].left < 0 {
+			lengths[nodes[n].symbol] = uint8(d)
+			depth = max(depth, d)
+			return
+		}
+		walk(nodes[n].left, d+1)
+		walk(nodes[n].right, d+1)
+	}
+	walk(len(nodes)-1, 0)
+	return lengths, depth
+}
+
+// canonicalCodes assigns canonical codes to lengths, shorter codes and lower
+// symbols first, bit-reversed for writing LSB first.
+func canonicalCodes(lengths []uint8) []uint16 {
+	var count [webpMaxCodeLen + 1]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [webpMaxCodeLen + 2]int
+	code := 0
+	for l := 1; l <= webpMaxCodeLen; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		rev := 0
+		for k := 0; k < int(l); k++ {
+			rev = rev<<1 | c>>k&1
+		}
+		codes[s] = uint16(rev)
+	}
+	return codes
+}
+
+// webpContainer wraps a VP8L bitstream in a RIFF WEBP file.
+func webpContainer(payload []byte) []byte {
+	size := len(payload)
+	padded := size + size&1
+	out := make([]byte, 0, 20+padded)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(12+padded))
+	out = append(out, "WEBPVP8L"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(size))
+	out = append(out, payload...)
+	if size&1 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// webpBitWriter packs values LSB first, as VP8L reads them.
+type webpBitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (w *webpBitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.bits
+	w.bits += n
+	for w.bits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.bits -= 8
+	}
+}
+
+func (w *webpBitWriter) bytes() []byte {
+	if w.bits > 0 {
+		return append(w.buf, byte(w.acc))
+	}
+	return w.buf
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"map-generator/mapgen"
+)
+
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+var (
+	errPresetExists = errors.New("preset already exists")
+	errPresetLimit  = errors.New("preset limit reached")
+)
+
+// generateRequest is the /generate body: a map request that may name a saved
+// preset to start from. Fields present in the body override the preset.
+type generateRequest struct {
+	mapgen.Request
+	Preset string `json:"preset"`
+	// Filename and Inline shape the Content-Disposition of the response.
+	Filename string `json:"filename"`
+	Inline   bool   `json:"inline"`
+	// DeadlineMs is the caller's budget for the generation, like the
+	// X-Deadline-Ms header.
+	DeadlineMs int `json:"deadlineMs,omitempty"`
+}
+
+// presetStore keeps named partial requests in memory and, when path is set,
+// mirrors them to a JSON file so they survive restarts.
+type presetStore struct {
+	mu      sync.Mutex
+	path    string
+	limit   int
+	presets map[string]json.RawMessage
+}
+
+type presetEntry struct {
+	Name    string          `json:"name"`
+	Request json.RawMessage `json:"request"`
+}
+
+func newPresetStore(path string, limit int) (*presetStore, error) {
+	s := &presetStore{path: path, limit: limit, presets: map[string]json.RawMessage{}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read presets: %w", err)
+	}
+	var entries []presetEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse presets %s: %w", path, err)
+	}
+	for _, e := range entries {
+		s.presets[e.Name] = e.Request
+	}
+	return s, nil
+}
+
+func (s *presetStore) get(name string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.presets[name]
+	return raw, ok
+}
+
+func (s *presetStore) list() []presetEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entriesLocked()
+}
+
+func (s *presetStore) entriesLocked() []presetEntry {
+	entries := make([]presetEntry, 0, len(s.presets))
+	for name, raw := range s.presets {
+		entries = append(entries, presetEntry{Name: name, Request: raw})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+func (s *presetStore) save(name string, raw json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.presets[name]; ok {
+		return errPresetExists
+	}
+	if s.limit > 0 && len(s.presets) >= s.limit {
+		return errPresetLimit
+	}
+	s.presets[name] = raw
+	if err := s.persistLocked(); err != nil {
+		delete(s.presets, name)
+		return err
+	}
+	return nil
+}
+
+// persistLocked rewrites the preset file so a crash never leaves a truncated
+// store behind.
+func (s *presetStore) persistLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.entriesLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(s.path, data); err != nil {
+		return fmt.Errorf("write presets: %w", err)
+	}
+	return nil
+}
+
+// replaceFile writes data to path via a temp file in the same directory and a
+// rename, so readers see either the old content or the new.
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// decodeStrict decodes data into v rejecting unknown fields; an empty body
+// leaves v untouched.
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// resolve decodes a /generate body and, when it names a preset, layers the
+// body over the stored preset. Lenient decoding skips unknown fields and
+// returns a warning for each, see decodeRequest.
+func (s *presetStore) resolve(body []byte, lenient bool) (generateRequest, []string, error) {
+	var req generateRequest
+	warnings, err := decodeRequest(body, &req, lenient)
+	if err != nil {
+		return generateRequest{}, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if req.Preset == "" {
+		return req, warnings, nil
+	}
+
+	raw, ok := s.get(req.Preset)
+	if !ok {
+		return generateRequest{}, nil, fmt.Errorf("unknown preset %q", req.Preset)
+	}
+	var merged generateRequest
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return generateRequest{}, nil, fmt.Errorf("preset %q is corrupt: %w", req.Preset, err)
+	}
+	// Decoding onto the preset only overwrites fields present in the body.
+	if _, err := decodeRequest(body, &merged, lenient); err != nil {
+		return generateRequest{}, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return merged, warnings, nil
+}
+
+func (s *presetStore) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"presets": s.list()})
+	case http.MethodPost:
+		s.handleSave(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET to list or POST to save presets"})
+	}
+}
+
+func (s *presetStore) handleSave(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var payload presetEntry
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid JSON: %v", err)})
+		return
+	}
+	if !presetNamePattern.MatchString(payload.Name) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "preset name must be 1-64 lowercase letters, digits, '-' or '_'"})
+		return
+	}
+	if len(payload.Request) == 0 {
+		payload.Request = json.RawMessage("{}")
+	}
+
+	var req generateRequest
+	if _, err := decodeRequest(payload.Request, &req, false); err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(fmt.Errorf("invalid preset request: %w", err)))
+		return
+	}
+	if req.Preset != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "presets may not reference other presets"})
+		return
+	}
+	if _, err := req.Normalize(); err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, payload.Request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid preset request: %v", err)})
+		return
+	}
+
+	switch err := s.save(payload.Name, compact.Bytes()); {
+	case errors.Is(err, errPresetExists):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": fmt.Sprintf("preset %q already exists", payload.Name)})
+	case errors.Is(err, errPresetLimit):
+		writeJSON(w, http.StatusInsufficientStorage, map[string]string{"error": fmt.Sprintf("preset limit of %d reached", s.limit)})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusCreated, presetEntry{Name: payload.Name, Request: compact.Bytes()})
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"map-generator/mapgen"
+)
+
+// maxSeedTags bounds the tags of one catalog entry.
+const maxSeedTags = 16
+
+var errSeedLimit = errors.New("seed limit reached")
+
+// seedCatalog holds the curated seeds behind /seeds.
+var seedCatalog *seedStore
+
+// seedEntry is a curated seed together with the request that renders it. ID
+// is the stats ETag of that request, so recording the same seed and
+// parameters twice finds the first entry.
+type seedEntry struct {
+	ID      string          `json:"id"`
+	Seed    string          `json:"seed"`
+	Tags    []string        `json:"tags"`
+	Request json.RawMessage `json:"request"`
+	Created time.Time       `json:"created"`
+}
+
+// seedStore keeps the seed catalog in memory and, when path is set, mirrors
+// it to a JSON file like the presets. With adminToken set, recording and
+// deleting seeds take it as a bearer token; listing and rendering stay open.
+type seedStore struct {
+	mu         sync.Mutex
+	path       string
+	limit      int
+	adminToken string
+	entries    map[string]*seedEntry
+}
+
+func newSeedStore(path string, limit int) (*seedStore, error) {
+	s := &seedStore{path: path, limit: limit, entries: map[string]*seedEntry{}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read seeds: %w", err)
+	}
+	var entries []*seedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse seeds %s: %w", path, err)
+	}
+	for _, e := range entries {
+		s.entries[e.ID] = e
+	}
+	return s, nil
+}
+
+// list returns the entries carrying every tag in tags, oldest first.
+func (s *seedStore) list(tags []string) []seedEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []seedEntry{}
+	for _, e := range s.sortedLocked() {
+		if !hasTags(e.Tags, tags) {
+			continue
+		}
+		out = append(out, *e)
+	}
+	return out
+}
+
+func (s *seedStore) sortedLocked() []*seedEntry {
+	entries := make([]*seedEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Created.Equal(entries[j].Created) {
+			return entries[i].Created.Before(entries[j].Created)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+func hasTags(have, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(have, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *seedStore) get(id string) (seedEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return seedEntry{}, false
+	}
+	return *e, true
+}
+
+// save records entry, or adds its tags to the entry already recorded under
+// its ID. created reports whether the entry is new.
+func (s *seedStore) save(entry seedEntry) (_ seedEntry, created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.entries[entry.ID]; ok {
+		before := prev.Tags
+		merged := slices.Clone(prev.Tags)
+		for _, tag := range entry.Tags {
+			if !slices.Contains(merged, tag) {
+				merged = append(merged, tag)
+			}
+		}
+		if len(merged) > maxSeedTags {
+			return seedEntry{}, false, fmt.Errorf("a seed takes at most %d tags", maxSeedTags)
+		}
+		prev.Tags = merged
+		if err := s.persistLocked(); err != nil {
+			prev.Tags = before
+			return seedEntry{}, false, err
+		}
+		return *prev, false, nil
+	}
+	if s.limit > 0 && len(s.entries) >= s.limit {
+		return seedEntry{}, false, errSeedLimit
+	}
+	s.entries[entry.ID] = &entry
+	if err := s.persistLocked(); err != nil {
+		delete(s.entries, entry.ID)
+		return seedEntry{}, false, err
+	}
+	return entry, true, nil
+}
+
+// remove deletes the entry id and reports whether there was one.
+func (s *seedStore) remove(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.entries, id)
+	if err := s.persistLocked(); err != nil {
+		s.entries[id] = e
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *seedStore) persistLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.sortedLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(s.path, data); err != nil {
+		return fmt.Errorf("write seeds: %w", err)
+	}
+	return nil
+}
+
+// handle serves GET /seeds?tag=... and POST /seeds.
+func (s *seedStore) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"seeds": s.list(r.URL.Query()["tag"])})
+	case http.MethodPost:
+		if s.adminToken != "" && !adminAuthorized(w, r, s.adminToken) {
+			return
+		}
+		s.handleSave(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET to list or POST to record seeds"})
+	}
+}
+
+// seedPayload is the POST /seeds body.
+type seedPayload struct {
+	Seed    string          `json:"seed"`
+	Tags    []string        `json:"tags"`
+	Request json.RawMessage `json:"request"`
+}
+
+func (s *seedStore) handleSave(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var payload seedPayload
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid JSON: %v", err)})
+		return
+	}
+	if len(payload.Request) == 0 {
+		payload.Request = json.RawMessage("{}")
+	}
+	if len(payload.Tags) > maxSeedTags {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("a seed takes at most %d tags", maxSeedTags)})
+		return
+	}
+	var tags []string
+	for _, tag := range payload.Tags {
+		if !presetNamePattern.MatchString(tag) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "tags must be 1-64 lowercase letters, digits, '-' or '_'"})
+			return
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	var req generateRequest
+	if _, err := decodeRequest(payload.Request, &req, false); err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(fmt.Errorf("invalid seed request: %w", err)))
+		return
+	}
+	switch {
+	case req.Preset != "":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "seed requests may not reference presets, which can change"})
+		return
+	case len(req.Seeds) > 0:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "seed requests take the single seed field, not seeds"})
+		return
+	case payload.Seed == "" && req.Seed == "":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "seed is required"})
+		return
+	case payload.Seed != "" && req.Seed != "" && payload.Seed != req.Seed:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("seed %q does not match the request seed %q", payload.Seed, req.Seed)})
+		return
+	}
+	if req.Seed == "" {
+		req.Seed = payload.Seed
+	}
+	// Only the map request is kept; download and deadline options belong to
+	// the call that renders it.
+	stored := generateRequest{Request: req.Request}
+
+	cfg := requestConfig(r)
+	probe := stored
+	cfg.applyDefaults(&probe.Request)
+	params, err := probe.Normalize()
+	if err == nil {
+		err = cfg.applyLimits(&params)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, payload.Request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid seed request: %v", err)})
+		return
+	}
+	entry := seedEntry{
+		ID:      statsETag(stored),
+		Seed:    req.Seed,
+		Tags:    tags,
+		Request: compact.Bytes(),
+		Created: time.Now().UTC(),
+	}
+	if entry.Tags == nil {
+		entry.Tags = []string{}
+	}
+
+	saved, created, err := s.save(entry)
+	switch {
+	case errors.Is(err, errSeedLimit):
+		writeJSON(w, http.StatusInsufficientStorage, map[string]string{"error": fmt.Sprintf("seed limit of %d reached", s.limit)})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	case created:
+		writeJSON(w, http.StatusCreated, saved)
+	default:
+		writeJSON(w, http.StatusOK, saved)
+	}
+}
+
+// handleEntry serves GET and DELETE /seeds/{id} and GET /seeds/{id}/image.
+func (s *seedStore) handleEntry(w http.ResponseWriter, r *http.Request) {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/seeds/"), "/")
+	switch rest {
+	case "":
+	case "image":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET"})
+			return
+		}
+		s.handleImage(w, r, id)
+		return
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entry, ok := s.get(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown seed %q", id)})
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+	case http.MethodDelete:
+		if s.adminToken != "" && !adminAuthorized(w, r, s.adminToken) {
+			return
+		}
+		removed, err := s.remove(id)
+		switch {
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		case !removed:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown seed %q", id)})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET or DELETE"})
+	}
+}
+
+// handleImage regenerates the map of a catalog entry. Generation is
+// deterministic, so the image matches the one the seed was recorded from as
+// long as the server defaults it falls back on are unchanged.
+func (s *seedStore) handleImage(w http.ResponseWriter, r *http.Request, id string) {
+	entry, ok := s.get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown seed %q", id)})
+		return
+	}
+	var req generateRequest
+	if _, err := decodeRequest(entry.Request, &req, false); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("seed %q is corrupt: %v", id, err)})
+		return
+	}
+	req.Seed = entry.Seed
+
+	cfg := requestConfig(r)
+	cfg.applyDefaults(&req.Request)
+	params, err := req.Normalize()
+	if err == nil {
+		err = cfg.applyLimits(&params)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
+	}
+	if !admit(w, cfg) {
+		return
+	}
+
+	start := time.Now()
+	var stats mapgen.Stats
+	result, err := mapgen.Generate(params, &stats)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	setWarnings(w, result.Warnings)
+	signResult(w, &result, req.Request)
+	writeResult(w, cfg, &result, &stats, "", false)
+	log.Printf("regenerated seed %s %dx%d mode=%s placements=%d duration=%s%s",
+		id, result.Width, result.Height, params.Mode, result.TotalPlacements, time.Since(start), cfg.tenantTag())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSeedWritesNeedAdminToken(t *testing.T) {
+	setupServer(t, Config{})
+	store, err := newSeedStore("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.adminToken = "secret"
+	admin := http.Header{"Authorization": {"Bearer secret"}}
+	wrong := http.Header{"Authorization": {"Bearer guess"}}
+	body := `{"seed": "abc", "request": {"w": 20, "h": 20, "tiles": "1x1*10"}}`
+
+	for _, header := range []http.Header{nil, wrong} {
+		if w := postJSON(http.HandlerFunc(store.handle), "/seeds", body, header); w.Code != http.StatusUnauthorized {
+			t.Errorf("POST with %v: status %d, want 401", header, w.Code)
+		}
+	}
+	w := postJSON(http.HandlerFunc(store.handle), "/seeds", body, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST with the token: status %d %s", w.Code, w.Body)
+	}
+	var entry seedEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method string, header http.Header) int {
+		r := httptest.NewRequest(method, "/seeds/"+entry.ID, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		store.handleEntry(w, r)
+		return w.Code
+	}
+	if code := request(http.MethodGet, nil); code != http.StatusOK {
+		t.Errorf("GET without the token: status %d, want 200", code)
+	}
+	if code := request(http.MethodDelete, nil); code != http.StatusUnauthorized {
+		t.Errorf("DELETE without the token: status %d, want 401", code)
+	}
+	if code := request(http.MethodDelete, admin); code != http.StatusNoContent {
+		t.Errorf("DELETE with the token: status %d, want 204", code)
+	}
+}
+
+func TestSeedWritesOpenWithoutAdminToken(t *testing.T) {
+	setupServer(t, Config{})
+	store, err := newSeedStore("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := postJSON(http.HandlerFunc(store.handle), "/seeds", `{"seed": "abc"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Errorf("POST: status %d %s, want 201", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+//go:generate go run . -check-write selftest.sha256
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"map-generator/mapgen"
+)
+
+// selfTestHashes holds the expected SHA-256 of every selfTestCases output, one
+// "name hash" line per case. Regenerate it with go generate after a change
+// that is meant to alter output.
+//
+//go:embed selftest.sha256
+var selfTestHashes []byte
+
+// selfTestCase is one fixed generation of the self-test battery.
+type selfTestCase struct {
+	name string
+	req  mapgen.Request
+}
+
+// selfTestCases covers every mode, every output format and both ends of the
+// canvas size range, each with a fixed seed so its output is reproducible.
+func selfTestCases() []selfTestCase {
+	tiles := "2x2*150,2x1*100,1x1*50"
+	cases := []selfTestCase{}
+	for _, mode := range []string{"merkez", "agirlik", "adalar", "iki-kita", "voronoi", "magara", "bolge", "petek"} {
+		req := mapgen.Request{W: 64, H: 64, Tiles: tiles, Mode: mode, Seed: "selftest-" + mode}
+		if mode == "magara" {
+			// magara grows its landmass without tiles.
+			req.Tiles = ""
+		}
+		cases = append(cases, selfTestCase{name: "mode-" + mode, req: req})
+	}
+	cases = append(cases, selfTestCase{name: "mode-karma", req: mapgen.Request{W: 64, H: 64, Tiles: tiles, Seed: "selftest-karma",
+		ModeMix: []mapgen.ModeWeight{{Mode: "merkez", Weight: 2}, {Mode: "adalar", Weight: 1}}}})
+	for _, format := range []string{"png", "json", "replay", "webp", "csv", "ascii", "mask-rle"} {
+		cases = append(cases, selfTestCase{name: "format-" + format, req: mapgen.Request{W: 48, H: 32, Tiles: tiles, Format: format, Seed: "selftest-" + format}})
+	}
+	cases = append(cases,
+		selfTestCase{name: "canvas-tiny", req: mapgen.Request{W: 4, H: 3, Tiles: "1x1*6", Seed: "selftest-tiny"}},
+		selfTestCase{name: "canvas-large", req: mapgen.Request{W: 1024, H: 768, Tiles: "8x8*4000,4x2*8000,1x1*20000", Seed: "selftest-large"}},
+	)
+	return cases
+}
+
+// selfTestOutcome is the result of one case, as reported by -check and
+// POST /admin/selftest.
+type selfTestOutcome struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	SHA256     string  `json:"sha256,omitempty"`
+	Want       string  `json:"want,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// selfTestReport summarizes a run of the battery.
+type selfTestReport struct {
+	Passed int               `json:"passed"`
+	Failed int               `json:"failed"`
+	Cases  []selfTestOutcome `json:"cases"`
+}
+
+// parseSelfTestHashes reads "name hash" lines; blank lines and lines starting
+// with # are skipped.
+func parseSelfTestHashes(data []byte) (map[string]string, error) {
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(text, " ")
+		if !ok {
+			return nil, fmt.Errorf("selftest hashes line %d: want \"name hash\"", line)
+		}
+		hashes[name] = strings.TrimSpace(hash)
+	}
+	return hashes, scanner.Err()
+}
+
+// runSelfTest generates every case and compares its output with the embedded
+// hashes. A case without an embedded hash fails.
+func runSelfTest() selfTestReport {
+	want, err := parseSelfTestHashes(selfTestHashes)
+	if err != nil {
+		// The file is embedded at build time; a malformed one fails every
+		// case rather than the process.
+		want = map[string]string{}
+		log.Printf("selftest: %v", err)
+	}
+	var report selfTestReport
+	for _, c := range selfTestCases() {
+		outcome := selfTestOutcome{Name: c.name, Want: want[c.name]}
+		start := time.Now()
+		sum, err := selfTestHash(c.req)
+		outcome.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		switch {
+		case err != nil:
+			outcome.Error = err.Error()
+		case outcome.Want == "":
+			outcome.SHA256, outcome.Error = sum, "no embedded hash; run go generate"
+		default:
+			outcome.SHA256 = sum
+			outcome.OK = sum == outcome.Want
+			if !outcome.OK {
+				outcome.Error = "output hash differs from the embedded hash"
+			}
+		}
+		if outcome.OK {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Cases = append(report.Cases, outcome)
+	}
+	return report
+}
+
+// selfTestHash generates req and returns the SHA-256 of its output.
+func selfTestHash(req mapgen.Request) (string, error) {
+	params, err := req.Normalize()
+	if err != nil {
+		return "", err
+	}
+	result, err := mapgen.Generate(params, nil)
+	if err != nil {
+		return "", err
+	}
+	return mapgen.ContentSHA256(result.Data), nil
+}
+
+// runCheck serves -check: it runs the battery, prints a line per case and a
+// summary to w and returns the process exit code.
+func runCheck(w io.Writer) int {
+	report := runSelfTest()
+	for _, c := range report.Cases {
+		if c.OK {
+			fmt.Fprintf(w, "ok   %-16s %s (%.1fms)\n", c.Name, c.SHA256, c.DurationMs)
+		} else {
+			fmt.Fprintf(w, "FAIL %-16s %s\n", c.Name, c.Error)
+		}
+	}
+	fmt.Fprintf(w, "selftest: %d passed, %d failed\n", report.Passed, report.Failed)
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// writeSelfTestHashes serves -check-write: it regenerates every case and
+// writes the hashes file the next build embeds.
+func writeSelfTestHashes(path string) error {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by go generate (go run . -check-write selftest.sha256); do not edit.\n")
+	for _, c := range selfTestCases() {
+		sum, err := selfTestHash(c.req)
+		if err != nil {
+			return fmt.Errorf("selftest case %s: %w", c.name, err)
+		}
+		fmt.Fprintf(&buf, "%s %s\n", c.name, sum)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// handleSelfTest serves POST /admin/selftest for callers presenting the admin
+// token, answering with the report: 200 when every case passes, 503 otherwise.
+func handleSelfTest(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		if !adminAuthorized(w, r, token) {
+			return
+		}
+		report := runSelfTest()
+		status := http.StatusOK
+		if report.Failed > 0 {
+			status = http.StatusServiceUnavailable
+			log.Printf("selftest via /admin/selftest: %d of %d cases failed", report.Failed, len(report.Cases))
+		}
+		writeJSON(w, status, report)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestSelfTestGolden runs the -check battery as a test: each case must hash
+// to its line in selftest.sha256, so output drift fails go test as well as
+// -check. After a change meant to alter output, run go generate.
+func TestSelfTestGolden(t *testing.T) {
+	want, err := parseSelfTestHashes(selfTestHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, c := range selfTestCases() {
+		seen[c.name] = true
+		t.Run(c.name, func(t *testing.T) {
+			hash, ok := want[c.name]
+			if !ok {
+				t.Fatal("no hash in selftest.sha256; run go generate")
+			}
+			got, err := selfTestHash(c.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != hash {
+				t.Errorf("output hash %s, want %s", got, hash)
+			}
+		})
+	}
+	for name := range want {
+		if !seen[name] {
+			t.Errorf("selftest.sha256 holds %s, which is no longer a case", name)
+		}
+	}
+}
+
+func TestParseSelfTestHashes(t *testing.T) {
+	hashes, err := parseSelfTestHashes([]byte("# comment\n\nmode-merkez abc\n  format-png   def  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 || hashes["mode-merkez"] != "abc" || hashes["format-png"] != "def" {
+		t.Errorf("hashes = %v", hashes)
+	}
+	if _, err := parseSelfTestHashes([]byte("mode-merkez\n")); err == nil {
+		t.Error("accepted a line without a hash")
+	}
+}
+
+func TestHandleSelfTest(t *testing.T) {
+	handler := handleSelfTest("secret")
+	if w := postJSON(handler, "/admin/selftest", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", w.Code)
+	}
+	if w := postJSON(handler, "/admin/selftest", "", http.Header{"Authorization": {"Bearer secret"}}); w.Code != http.StatusOK {
+		t.Errorf("with token: status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"map-generator/mapgen"
+)
+
+// signingKeyEnv names the environment variable holding the signing key when
+// -signing-key-file is not given.
+const signingKeyEnv = "MAPGEN_SIGNING_KEY"
+
+// signingKey signs generated and rendered maps when set, see signResult.
+var signingKey []byte
+
+// loadSigningKey reads the signing key from path, or from signingKeyEnv when
+// path is empty. Trailing newlines of a key file are dropped. No key at all
+// leaves signing off.
+func loadSigningKey(path string) ([]byte, error) {
+	if path == "" {
+		return []byte(os.Getenv(signingKeyEnv)), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	key := bytes.TrimRight(data, "\r\n")
+	if len(key) == 0 {
+		return nil, fmt.Errorf("signing key: %s is empty", path)
+	}
+	return key, nil
+}
+
+// canonicalJSON encodes v without the fields it leaves unset, with keys in
+// sorted order, so equal requests always encode to the same bytes and a line
+// or signature holds what the caller, a preset or the config actually chose.
+func canonicalJSON(v any) (json.RawMessage, error) {
+	full, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(full, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		switch string(value) {
+		case "null", `""`:
+			delete(fields, name)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// signResult signs the map and the request it was made from when the server
+// has a signing key: X-Signature is mapgen.Sign over the encoded bytes and
+// the canonical request, which X-Signed-Params carries base64-encoded so a
+// verifier can check both with mapgen.VerifySignature. A request that cannot
+// be encoded goes out unsigned.
+func signResult(w http.ResponseWriter, result *mapgen.Result, req mapgen.Request) {
+	if len(signingKey) == 0 {
+		return
+	}
+	params, err := canonicalJSON(req)
+	if err != nil {
+		log.Printf("sign: encode request: %v", err)
+		return
+	}
+	w.Header().Set("X-Signature", mapgen.Sign(signingKey, result.Data, params))
+	w.Header().Set("X-Signed-Params", base64.StdEncoding.EncodeToString(params))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"map-generator/mapgen"
+)
+
+func TestSignatureRejectsTampering(t *testing.T) {
+	setupServer(t, Config{})
+	signingKey = []byte("test key")
+	t.Cleanup(func() { signingKey = nil })
+
+	w := postJSON(http.HandlerFunc(handleGenerate), "/generate", `{"w": 40, "h": 30, "tiles": "2x2*40", "seed": "signed"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	data, signature := w.Body.Bytes(), w.Header().Get("X-Signature")
+	params, err := base64.StdEncoding.DecodeString(w.Header().Get("X-Signed-Params"))
+	if err != nil {
+		t.Fatalf("X-Signed-Params: %v", err)
+	}
+	if !mapgen.VerifySignature(signingKey, data, params, signature) {
+		t.Fatal("the untouched response does not verify")
+	}
+
+	image := bytes.Clone(data)
+	image[len(image)/2] ^= 1
+	tamperedParams := bytes.Replace(params, []byte(`"signed"`), []byte(`"forged"`), 1)
+	if bytes.Equal(tamperedParams, params) {
+		t.Fatalf("signed params %s do not hold the seed", params)
+	}
+	altered := "0" + signature[1:]
+	if signature[0] == '0' {
+		altered = "1" + signature[1:]
+	}
+	tests := []struct {
+		name      string
+		key       []byte
+		data      []byte
+		params    []byte
+		signature string
+	}{
+		{"tampered image", signingKey, image, params, signature},
+		{"truncated image", signingKey, data[:len(data)-1], params, signature},
+		{"tampered params", signingKey, data, tamperedParams, signature},
+		{"dropped params", signingKey, data, nil, signature},
+		{"other key", []byte("other key"), data, params, signature},
+		{"altered signature", signingKey, data, params, altered},
+		{"not hex", signingKey, data, params, "zz" + signature[2:]},
+		{"empty signature", signingKey, data, params, ""},
+	}
+	for _, tt := range tests {
+		if mapgen.VerifySignature(tt.key, tt.data, tt.params, tt.signature) {
+			t.Errorf("%s: signature still verifies", tt.name)
+		}
+	}
+}
+
+func TestUnsignedWithoutKey(t *testing.T) {
+	setupServer(t, Config{})
+	w := postJSON(http.HandlerFunc(handleGenerate), "/generate", `{"w": 20, "h": 20, "tiles": "1x1*10"}`, nil)
+	if got := w.Header().Get("X-Signature"); got != "" {
+		t.Errorf("X-Signature = %q without a signing key", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"map-generator/mapgen"
+)
+
+// generationStats is the sidecar store behind GET /stats/{etag}; its capacity
+// is the StatsCache of the current config, and zero disables it.
+var generationStats *statsStore
+
+// mapStats is the JSON document served for a recent seeded generation, so a
+// client that fetched the image through <img> can still read its metadata.
+type mapStats struct {
+	Width      int                  `json:"width"`
+	Height     int                  `json:"height"`
+	Mode       string               `json:"mode"`
+	Seed       int64                `json:"seed"`
+	Batches    int                  `json:"batches"`
+	Placements int                  `json:"placements"`
+	Scale      float64              `json:"scale"`
+	Tiles      []mapgen.TileScaling `json:"tiles"`
+	ModeMix    []mapgen.ModeCount   `json:"modeMix,omitempty"`
+	Islands    []mapgen.IslandShare `json:"islands,omitempty"`
+	Palette    string               `json:"palette"`
+	Saturation float64              `json:"saturation"`
+	Timing     string               `json:"timing"`
+
+	RequestedArea int     `json:"requestedArea,omitempty"`
+	LandCells     int     `json:"landCells,omitempty"`
+	OverlapFactor float64 `json:"overlapFactor,omitempty"`
+}
+
+// statsStore keeps the stats of the most recent seeded generations, evicting
+// the least recently used entries beyond the capacity passed to put.
+type statsStore struct {
+	mu      sync.Mutex
+	order   *list.List // of *statsEntry, most recent first
+	entries map[string]*list.Element
+}
+
+type statsEntry struct {
+	etag string
+	data []byte
+}
+
+func newStatsStore() *statsStore {
+	return &statsStore{order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// statsETag identifies a generation by its resolved request. Only seeded
+// requests are deterministic, so unseeded ones get no tag.
+func statsETag(req generateRequest) string {
+	if req.Seed == "" && len(req.Seeds) == 0 {
+		return ""
+	}
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:16])
+}
+
+// put stores the stats of a generation and trims the store to capacity, which
+// may have shrunk since the last put.
+func (s *statsStore) put(etag string, result *mapgen.Result, stats *mapgen.Stats, capacity int) {
+	data, err := json.Marshal(mapStats{
+		Width:      result.Width,
+		Height:     result.Height,
+		Mode:       result.Mode,
+		Seed:       result.Seed,
+		Batches:    result.Batches,
+		Placements: result.TotalPlacements,
+		Scale:      result.Scale,
+		Tiles:      result.Tiles,
+		ModeMix:    result.ModeMix,
+		Islands:    result.Islands,
+		Palette:    result.Palette.String(),
+		Saturation: result.Saturation,
+		Timing:     stats.Header(),
+
+		RequestedArea: result.RequestedArea,
+		LandCells:     result.LandCells,
+		OverlapFactor: result.OverlapFactor,
+	})
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[etag]; ok {
+		el.Value.(*statsEntry).data = data
+		s.order.MoveToFront(el)
+	} else {
+		s.entries[etag] = s.order.PushFront(&statsEntry{etag: etag, data: data})
+	}
+	for s.order.Len() > capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*statsEntry).etag)
+	}
+}
+
+func (s *statsStore) get(etag string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[etag]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*statsEntry).data, true
+}
+
+func (s *statsStore) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET"})
+		return
+	}
+	if currentConfig().StatsCache == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "stats are disabled on this server"})
+		return
+	}
+	data, ok := s.get(strings.TrimPrefix(r.URL.Path, "/stats/"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no stats for this tag; it was never generated or has been evicted"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"map-generator/mapgen"
+)
+
+// Sweep limits: variants per sheet, pixels generated across all variants and
+// the side of one contact-sheet cell.
+const (
+	maxSweepVariants = 64
+	maxSweepPixels   = 64 << 20
+	defaultSweepCell = 160
+	maxSweepCell     = 512
+)
+
+// sweepRequest is a /sweep body: a base request and one parameter, or two for
+// a grid, with the values to try. Values replace the field in the base request
+// verbatim, so they take the same JSON types the field does.
+type sweepRequest struct {
+	Request  json.RawMessage   `json:"request"`
+	Param    string            `json:"param"`
+	Values   []json.RawMessage `json:"values"`
+	Param2   string            `json:"param2"`
+	Values2  []json.RawMessage `json:"values2"`
+	CellSize int               `json:"cellSize"`
+}
+
+// sweepCell is one generated variant and the label drawn under it.
+type sweepCell struct {
+	img   image.Image
+	label []string
+}
+
+// handleSweep renders every combination of the swept values with the same
+// seed and composites the thumbnails into one labeled contact-sheet PNG.
+// Columns follow values and rows follow values2.
+func handleSweep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST with JSON body"})
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("read body: %v", err)})
+		return
+	}
+
+	cfg := requestConfig(r)
+	lenient, err := lenientRequest(r, cfg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	var req sweepRequest
+	warnings, err := decodeRequest(body, &req, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(fmt.Errorf("invalid JSON: %w", err)))
+		return
+	}
+	if !admit(w, cfg) {
+		return
+	}
+
+	start := time.Now()
+	cells, cols, seed, variantWarnings, err := runSweep(cfg, req, lenient)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, requestError(err))
+		return
+	}
+	cellSize := req.CellSize
+	if cellSize == 0 {
+		cellSize = defaultSweepCell
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, contactSheet(cells, cols, cellSize)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("encode png: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Seed", strconv.FormatInt(seed, 10))
+	w.Header().Set("X-Sweep-Grid", fmt.Sprintf("%dx%d", cols, len(cells)/cols))
+	setWarnings(w, appendWarnings(warnings, variantWarnings...))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("write response: %v", err)
+	}
+
+	log.Printf("swept %d variants of %s duration=%s%s", len(cells), req.Param, time.Since(start), cfg.tenantTag())
+}
+
+// runSweep validates the sweep and generates every variant, returning the
+// cells in row-major order, the column count, the shared seed and the
+// lenient-mode warnings of all variants, each reported once. Every variant is
+// checked against the canvas limits of cfg.
+func runSweep(cfg *Config, req sweepRequest, lenient bool) ([]sweepCell, int, int64, []string, error) {
+	switch {
+	case req.Param == "" || len(req.Values) == 0:
+		return nil, 0, 0, nil, errors.New("param and values are required")
+	case (req.Param2 == "") != (len(req.Values2) == 0):
+		return nil, 0, 0, nil, errors.New("param2 and values2 must be given together")
+	case req.Param2 != "" && req.Param2 == req.Param:
+		return nil, 0, 0, nil, errors.New("param2 must differ from param")
+	case req.CellSize < 0 || req.CellSize > maxSweepCell:
+		return nil, 0, 0, nil, fmt.Errorf("cellSize must be between 1 and %d", maxSweepCell)
+	}
+	rows := max(len(req.Values2), 1)
+	if variants := len(req.Values) * rows; variants > maxSweepVariants {
+		return nil, 0, 0, nil, fmt.Errorf("sweep of %d variants exceeds the limit of %d", variants, maxSweepVariants)
+	}
+
+	base := map[string]json.RawMessage{}
+	if len(req.Request) > 0 {
+		if err := json.Unmarshal(req.Request, &base); err != nil {
+			return nil, 0, 0, nil, fmt.Errorf("request: %v", err)
+		}
+	}
+	// Every variant must share one seed; without one the server would pick a
+	// time-based seed per variant.
+	if resolved, _, err := presets.resolve(req.Request, true); err == nil && resolved.Seed == "" && len(resolved.Seeds) == 0 {
+		base["seed"], _ = json.Marshal(strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+
+	var cells []sweepCell
+	var seed int64
+	var warnings []string
+	pixels := 0
+	for row := 0; row < rows; row++ {
+		for _, value := range req.Values {
+			variant := map[string]json.RawMessage{}
+			for k, v := range base {
+				variant[k] = v
+			}
+			variant[req.Param] = value
+			label := []string{req.Param + "=" + sweepLabel(value)}
+			if req.Param2 != "" {
+				variant[req.Param2] = req.Values2[row]
+				label = append(label, req.Param2+"="+sweepLabel(req.Values2[row]))
+			}
+
+			img, result, variantWarnings, err := generateVariant(cfg, variant, lenient, &pixels)
+			if err != nil {
+				return nil, 0, 0, nil, fmt.Errorf("variant %v: %w", label, err)
+			}
+			warnings = appendWarnings(warnings, variantWarnings...)
+			seed = result.Seed
+			cells = append(cells, sweepCell{img: img, label: label})
+		}
+	}
+	return cells, len(req.Values), seed, warnings, nil
+}
+
+// generateVariant generates one variant request as a PNG and decodes it,
+// charging its canvas against the sweep pixel budget.
+func generateVariant(cfg *Config, variant map[string]json.RawMessage, lenient bool, pixels *int) (image.Image, mapgen.Result, []string, error) {
+	raw, err := json.Marshal(variant)
+	if err != nil {
+		return nil, mapgen.Result{}, nil, err
+	}
+	req, warnings, err := presets.resolve(raw, lenient)
+	if err != nil {
+		return nil, mapgen.Result{}, nil, err
+	}
+	cfg.applyDefaults(&req.Request)
+	params, err := req.Normalize()
+	if err != nil {
+		return nil, mapgen.Result{}, nil, err
+	}
+	if err := cfg.applyLimits(&params); err != nil {
+		return nil, mapgen.Result{}, nil, err
+	}
+	if *pixels += params.RenderedPixels(); *pixels > maxSweepPixels {
+		return nil, mapgen.Result{}, nil, fmt.Errorf("sweep exceeds the %d pixel budget", maxSweepPixels)
+	}
+	result, err := mapgen.Generate(params, nil)
+	if err != nil {
+		return nil, mapgen.Result{}, nil, err
+	}
+	if result.ContentType != "image/png" {
+		return nil, mapgen.Result{}, nil, fmt.Errorf("sweep variants must render to a single PNG, got %s", result.ContentType)
+	}
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		return nil, mapgen.Result{}, nil, fmt.Errorf("decode png: %w", err)
+	}
+	return img, result, appendWarnings(warnings, result.Warnings...), nil
+}
+
+// sweepLabel renders a swept JSON value for a label, strings without quotes.
+func sweepLabel(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		return s
+	}
+	var compact bytes.Buffer
+	if json.Compact(&compact, value) == nil {
+		return compact.String()
+	}
+	return string(value)
+}
+
+// contactSheet lays the cells out on a white sheet, each thumbnail centered in
+// a cellSize square with its label lines underneath. A short last row is left
+// open on the right.
+func contactSheet(cells []sweepCell, cols, cellSize int) *image.RGBA {
+	const pad = 6
+	lines := len(cells[0].label)
+	scale := 2
+	for _, c := range cells {
+		for _, line := range c.label {
+			if mapgen.TextWidth(line, scale) > cellSize {
+				scale = 1
+			}
+		}
+	}
+	lineHeight := mapgen.GlyphHeight*scale + 3
+	labelHeight := lines*lineHeight + 2
+	rows := (len(cells) + cols - 1) / cols
+
+	sheet := image.NewRGBA(image.Rect(0, 0, pad+cols*(cellSize+pad), pad+rows*(cellSize+labelHeight+pad)))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+	ink := color.RGBA{R: 40, G: 40, B: 40, A: 255}
+
+	for i, c := range cells {
+		x, y := pad+(i%cols)*(cellSize+pad), pad+(i/cols)*(cellSize+labelHeight+pad)
+		thumb := thumbnail(c.img, cellSize)
+		tb := thumb.Bounds()
+		at := image.Pt(x+(cellSize-tb.Dx())/2, y+(cellSize-tb.Dy())/2)
+		draw.Draw(sheet, tb.Add(at), thumb, image.Point{}, draw.Over)
+
+		for n, line := range c.label {
+			runes := []rune(line)
+			for len(runes) > 0 && mapgen.TextWidth(string(runes), scale) > cellSize {
+				runes = runes[:len(runes)-1]
+			}
+			mapgen.DrawText(sheet, x, y+cellSize+3+n*lineHeight, string(runes), scale, ink)
+		}
+	}
+	return sheet
+}
+
+// thumbnail box-filters src down so its longer side is at most maxSide.
+// Smaller images are copied unscaled.
+func thumbnail(src image.Image, maxSide int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	scale := math.Min(1, float64(maxSide)/float64(max(sw, sh)))
+	tw := max(1, int(math.Round(float64(sw)*scale)))
+	th := max(1, int(math.Round(float64(sh)*scale)))
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := ty*sh/th, max((ty+1)*sh/th, ty*sh/th+1)
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := tx*sw/tw, max((tx+1)*sw/tw, tx*sw/tw+1)
+			var r, g, bl, a uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := src.At(b.Min.X+x, b.Min.Y+y).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			dst.SetRGBA(tx, ty, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
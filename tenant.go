@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"map-generator/mapgen"
+)
+
+// tenantHeader names the tenant of a request; /t/{tenant}/ path prefixes do
+// the same for clients that cannot set headers.
+const tenantHeader = "X-Tenant"
+
+// maxTenantName bounds tenant names, which are echoed into logs and audit
+// records.
+const maxTenantName = 64
+
+// TenantProfile is the set of limits a tenant runs under, in addition to the
+// server-wide ones. Zero values leave a limit to the server.
+type TenantProfile struct {
+	// MaxWidth and MaxHeight cap the canvas; MaxPixels caps its area.
+	MaxWidth  int `json:"maxWidth,omitempty"`
+	MaxHeight int `json:"maxHeight,omitempty"`
+	MaxPixels int `json:"maxPixels,omitempty"`
+	// MaxPlacements caps the placements of one generation after cap and ka.
+	MaxPlacements int `json:"maxPlacements,omitempty"`
+	// RateLimit and RateBurst are the tenant's own token bucket, taken
+	// before the server-wide one.
+	RateLimit float64 `json:"rateLimit,omitempty"`
+	RateBurst int     `json:"rateBurst,omitempty"`
+	// Formats lists the output formats the tenant may request; empty allows
+	// every format.
+	Formats []string `json:"formats,omitempty"`
+}
+
+// validate rejects limits the handlers could not apply and rewrites formats
+// in the normalized form check compares requests against.
+func (t *TenantProfile) validate() error {
+	switch {
+	case t.MaxWidth < 0 || t.MaxHeight < 0 || t.MaxPixels < 0:
+		return errors.New("maxWidth, maxHeight and maxPixels must not be negative")
+	case t.MaxPlacements < 0:
+		return errors.New("maxPlacements must not be negative")
+	case !(t.RateLimit >= 0) || math.IsInf(t.RateLimit, 0):
+		return errors.New("rateLimit must be a non-negative number")
+	case t.RateBurst < 0:
+		return errors.New("rateBurst must not be negative")
+	}
+	// Formats are checked by the same parser requests go through.
+	formats := make([]string, len(t.Formats))
+	for i, format := range t.Formats {
+		probe := mapgen.Request{W: 1, H: 1, Tiles: "1x1*1", Format: format}
+		p, err := probe.Normalize()
+		if err != nil {
+			return fmt.Errorf("formats: %w", err)
+		}
+		formats[i] = p.Format
+	}
+	if len(formats) > 0 {
+		t.Formats = formats
+	}
+	return nil
+}
+
+// tenant is the tenant a request was resolved to. profile is the name of the
+// tenants entry it runs under: its own, or TenantFallback for tenants the
+// config does not list.
+type tenant struct {
+	name    string
+	profile string
+	limits  TenantProfile
+}
+
+// resolveTenant looks name up in the config's tenants, falling back to
+// TenantFallback when it is set.
+func (c *Config) resolveTenant(name string) (*tenant, error) {
+	if limits, ok := c.Tenants[name]; ok {
+		return &tenant{name: name, profile: name, limits: limits}, nil
+	}
+	if c.TenantFallback != "" {
+		return &tenant{name: name, profile: c.TenantFallback, limits: c.Tenants[c.TenantFallback]}, nil
+	}
+	return nil, fmt.Errorf("unknown tenant %q", name)
+}
+
+// configKey is the request context key of the config snapshot withTenants
+// resolved the request against.
+type configKey struct{}
+
+// withTenants resolves the tenant of every request from the X-Tenant header
+// or a /t/{tenant}/ path prefix, which is stripped before routing. The
+// request's config snapshot, carrying the tenant, goes into its context for
+// requestConfig. Once tenants are configured, requests naming none run as
+// TenantFallback, or are turned away by admit without one; otherwise they
+// run under the server limits alone.
+func withTenants(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(tenantHeader)
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/t/"); ok {
+			prefix, path, _ := strings.Cut(rest, "/")
+			if name != "" && name != prefix {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s %q does not match the path tenant %q", tenantHeader, name, prefix)})
+				return
+			}
+			name = prefix
+			r = r.Clone(r.Context())
+			r.URL.Path, r.URL.RawPath = "/"+path, ""
+		}
+
+		cfg := currentConfig()
+		if name != "" {
+			if !validTenantName(name) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("tenant names are 1 to %d letters, digits, '.', '_' or '-'", maxTenantName)})
+				return
+			}
+			t, err := cfg.resolveTenant(name)
+			if err != nil {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+				return
+			}
+			tenanted := *cfg
+			tenanted.tenant = t
+			cfg = &tenanted
+		} else if len(cfg.Tenants) > 0 && cfg.TenantFallback != "" {
+			tenanted := *cfg
+			tenanted.tenant = &tenant{name: cfg.TenantFallback, profile: cfg.TenantFallback, limits: cfg.Tenants[cfg.TenantFallback]}
+			cfg = &tenanted
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), configKey{}, cfg)))
+	})
+}
+
+// requestConfig is the config snapshot withTenants resolved r against, or the
+// live one for requests that did not pass through it.
+func requestConfig(r *http.Request) *Config {
+	if cfg, ok := r.Context().Value(configKey{}).(*Config); ok {
+		return cfg
+	}
+	return currentConfig()
+}
+
+func validTenantName(name string) bool {
+	if len(name) > maxTenantName {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// tenantName is the tenant of cfg's request, or "" without one.
+func (c *Config) tenantName() string {
+	if c.tenant == nil {
+		return ""
+	}
+	return c.tenant.name
+}
+
+// tenantTag is the " tenant=name" suffix of log lines about cfg's request, or
+// "" without a tenant.
+func (c *Config) tenantTag() string {
+	if c.tenant == nil {
+		return ""
+	}
+	return " tenant=" + c.tenant.name
+}
+
+// check enforces the tenant's canvas and format limits on a normalized
+// request and hands its placement limit to the generator.
+func (t *tenant) check(p *mapgen.Params) error {
+	if t == nil {
+		return nil
+	}
+	l := t.limits
+	switch {
+	case l.MaxWidth > 0 && p.Width > l.MaxWidth:
+		return fmt.Errorf("width %d exceeds the limit of %d for tenant %q", p.Width, l.MaxWidth, t.name)
+	case l.MaxHeight > 0 && p.Height > l.MaxHeight:
+		return fmt.Errorf("height %d exceeds the limit of %d for tenant %q", p.Height, l.MaxHeight, t.name)
+	case l.MaxPixels > 0 && p.RenderedPixels() > l.MaxPixels:
+		return fmt.Errorf("rendering %dx%d takes %d pixels, over the limit of %d for tenant %q", p.Width, p.Height, p.RenderedPixels(), l.MaxPixels, t.name)
+	case len(l.Formats) > 0 && !slices.Contains(l.Formats, p.Format):
+		return fmt.Errorf("format %q is not allowed for tenant %q", p.Format, t.name)
+	}
+	if l.MaxPlacements > 0 && (p.MaxPlacements == 0 || l.MaxPlacements < p.MaxPlacements) {
+		p.MaxPlacements = l.MaxPlacements
+	}
+	return nil
+}
+
+// tenantLimiters holds a token bucket per tenant profile, so tenants the
+// config does not list share the bucket of the fallback profile.
+var tenantLimiters = struct {
+	mu      sync.Mutex
+	buckets map[string]*rateLimiter
+}{buckets: map[string]*rateLimiter{}}
+
+// tenantLimiter returns the bucket of a tenant profile.
+func tenantLimiter(profile string) *rateLimiter {
+	tenantLimiters.mu.Lock()
+	defer tenantLimiters.mu.Unlock()
+	l, ok := tenantLimiters.buckets[profile]
+	if !ok {
+		l = &rateLimiter{}
+		tenantLimiters.buckets[profile] = l
+	}
+	return l
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// resetTenantLimiters empties the per-profile buckets between tests.
+func resetTenantLimiters() {
+	tenantLimiters.mu.Lock()
+	defer tenantLimiters.mu.Unlock()
+	tenantLimiters.buckets = map[string]*rateLimiter{}
+}
+
+func TestUntaggedRequestsUnderTenants(t *testing.T) {
+	tenants := map[string]TenantProfile{
+		"small": {MaxWidth: 32},
+		"big":   {MaxWidth: 256},
+	}
+	handler := withTenants(http.HandlerFunc(handleGenerate))
+	body := `{"w": 64, "h": 16, "tiles": "1x1*10"}`
+	tests := []struct {
+		name     string
+		cfg      Config
+		path     string
+		header   http.Header
+		want     int
+		wantBody string
+	}{
+		{"no tenants", Config{}, "/generate", nil, http.StatusOK, ""},
+		{"untagged without fallback", Config{Tenants: tenants}, "/generate", nil, http.StatusForbidden, "name a tenant"},
+		{"untagged with fallback", Config{Tenants: tenants, TenantFallback: "small"}, "/generate", nil, http.StatusBadRequest, `tenant \"small\"`},
+		{"header", Config{Tenants: tenants}, "/generate", http.Header{tenantHeader: {"big"}}, http.StatusOK, ""},
+		{"path", Config{Tenants: tenants}, "/t/big/generate", nil, http.StatusOK, ""},
+		{"unknown tenant", Config{Tenants: tenants}, "/t/nobody/generate", nil, http.StatusForbidden, "unknown tenant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupServer(t, tt.cfg)
+			resetTenantLimiters()
+			w := postJSON(handler, tt.path, body, tt.header)
+			if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("status %d %s, want %d containing %q", w.Code, w.Body, tt.want, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUntaggedHealthUnderTenants(t *testing.T) {
+	setupServer(t, Config{Tenants: map[string]TenantProfile{"a": {}}})
+	handler := withTenants(http.HandlerFunc(handleHealth))
+	if w := postJSON(handler, "/healthz", "", nil); w.Code != http.StatusOK {
+		t.Errorf("/healthz without a tenant: status %d", w.Code)
+	}
+}
+
+// TestServerRejectionKeepsTenantToken exhausts the server bucket and checks
+// that the requests it turns away do not drain the tenant's.
+func TestServerRejectionKeepsTenantToken(t *testing.T) {
+	setupServer(t, Config{RateLimit: 0.001, RateBurst: 1, Tenants: map[string]TenantProfile{"a": {RateLimit: 0.001, RateBurst: 2}}})
+	resetTenantLimiters()
+	handler := withTenants(http.HandlerFunc(handleGenerate))
+	body := `{"w": 16, "h": 16, "tiles": "1x1*10"}`
+	header := http.Header{tenantHeader: {"a"}}
+
+	if w := postJSON(handler, "/generate", body, header); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d %s", w.Code, w.Body)
+	}
+	for i := 0; i < 3; i++ {
+		w := postJSON(handler, "/generate", body, header)
+		if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "generation rate limit") {
+			t.Fatalf("request %d: status %d %s, want the server's 429", i, w.Code, w.Body)
+		}
+	}
+	// The tenant still holds its second token once the server refills.
+	generationLimiter = rateLimiter{}
+	if w := postJSON(handler, "/generate", body, header); w.Code != http.StatusOK {
+		t.Errorf("after the server refilled: status %d %s, want the tenant's token kept", w.Code, w.Body)
+	}
+}
+
+func TestTenantFormatsMatchNormalized(t *testing.T) {
+	setupServer(t, Config{Tenants: map[string]TenantProfile{"a": {Formats: []string{"PNG", " json"}}}})
+	resetTenantLimiters()
+	handler := withTenants(http.HandlerFunc(handleGenerate))
+	header := http.Header{tenantHeader: {"a"}}
+	for format, want := range map[string]int{"png": http.StatusOK, "Json": http.StatusOK, "csv": http.StatusBadRequest} {
+		body := `{"w": 16, "h": 16, "tiles": "1x1*10", "format": "` + format + `"}`
+		if w := postJSON(handler, "/generate", body, header); w.Code != want {
+			t.Errorf("format %s: status %d %s, want %d", format, w.Code, w.Body, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// certReloader serves the certificate of -tls-cert and -tls-key, re-reading
+// both files on SIGHUP so renewed certificates apply without a restart.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+}
+
+// load reads the key pair, keeping the previous one when it fails.
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+// getCertificate is the tls.Config hook handing out the current certificate.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+func (c *certReloader) reloadOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		if err := c.load(); err != nil {
+			log.Printf("certificate reload rejected, keeping the running certificate: %v", err)
+			continue
+		}
+		log.Printf("certificate reloaded from %s", c.certFile)
+	}
+}
+
+// Client certificate policies of -tls-client-auth.
+const (
+	clientAuthRequire  = "require"
+	clientAuthOptional = "optional"
+)
+
+// newTLSConfig builds the listener TLS config from the -tls-* flags, or
+// returns nil when certFile and keyFile are both empty. With clientCA, client
+// certificates are verified against it: always with clientAuth require, and
+// only when presented with optional.
+func newTLSConfig(certFile, keyFile, clientCA, clientAuth string) (*tls.Config, *certReloader, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCA != "" {
+			return nil, nil, errors.New("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil, nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, nil, errors.New("-tls-cert and -tls-key must be given together")
+	}
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := certs.load(); err != nil {
+		return nil, nil, err
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.getCertificate}
+	if clientCA == "" {
+		return cfg, certs, nil
+	}
+
+	pem, err := os.ReadFile(clientCA)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tls client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, nil, fmt.Errorf("tls client CA: no PEM certificates in %s", clientCA)
+	}
+	cfg.ClientCAs = pool
+	switch clientAuth {
+	case clientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case clientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, nil, fmt.Errorf("-tls-client-auth must be %q or %q, got %q", clientAuthRequire, clientAuthOptional, clientAuth)
+	}
+	return cfg, certs, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signed by parent or self-signed
+// when parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, serial int64, parent *testCert, template x509.Certificate) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := &template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write stores the certificate and key as PEM files in dir, returning their
+// paths.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, nil, x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	server := newTestCert(t, 2, ca, x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	client := newTestCert(t, 3, ca, x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	stranger := newTestCert(t, 4, nil, x509.Certificate{
+		Subject:     pkix.Name{CommonName: "stranger"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	caPath, _ := ca.write(t, dir, "ca")
+	certPath, keyPath := server.write(t, dir, "server")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		auth   string
+		client *testCert
+		ok     bool
+	}{
+		{clientAuthRequire, client, true},
+		{clientAuthRequire, nil, false},
+		{clientAuthRequire, stranger, false},
+		{clientAuthOptional, client, true},
+		{clientAuthOptional, nil, true},
+		{clientAuthOptional, stranger, false},
+	}
+	for _, tt := range tests {
+		name := tt.auth + " without certificate"
+		if tt.client != nil {
+			name = tt.auth + " with " + tt.client.cert.Subject.CommonName
+		}
+		t.Run(name, func(t *testing.T) {
+			cfg, _, err := newTLSConfig(certPath, keyPath, caPath, tt.auth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				name := ""
+				if len(r.TLS.PeerCertificates) > 0 {
+					name = r.TLS.PeerCertificates[0].Subject.CommonName
+				}
+				w.Header().Set("X-Client", name)
+			}))
+			// StartTLS would install its own certificate; serve the
+			// configuration as main does instead.
+			srv.Listener = tls.NewListener(srv.Listener, cfg)
+			srv.Start()
+			defer srv.Close()
+
+			clientTLS := &tls.Config{RootCAs: roots}
+			if tt.client != nil {
+				// Present the certificate even when its issuer is not one the
+				// server asks for, as a misconfigured client would.
+				cert := tt.client.tlsCertificate()
+				clientTLS.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				}
+			}
+			httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+			resp, err := httpClient.Get("https://" + srv.Listener.Addr().String())
+			if !tt.ok {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("handshake succeeded, want it rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			want := ""
+			if tt.client != nil {
+				want = tt.client.cert.Subject.CommonName
+			}
+			if got := resp.Header.Get("X-Client"); got != want {
+				t.Errorf("server saw client %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCertReloaderKeepsCertificateOnError(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, 1, nil, x509.Certificate{Subject: pkix.Name{CommonName: "first"}})
+	certPath, keyPath := first.write(t, dir, "server")
+	_, certs, err := newTLSConfig(certPath, keyPath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := newTestCert(t, 2, nil, x509.Certificate{Subject: pkix.Name{CommonName: "second"}})
+	second.write(t, dir, "server")
+	if err := certs.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := certs.getCertificate(nil); string(got.Certificate[0]) != string(second.der) {
+		t.Error("reload did not pick up the renewed certificate")
+	}
+
+	if err := os.WriteFile(certPath, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := certs.load(); err == nil {
+		t.Error("load accepted a broken certificate file")
+	}
+	if got, _ := certs.getCertificate(nil); string(got.Certificate[0]) != string(second.der) {
+		t.Error("a failed reload replaced the running certificate")
+	}
+}
+
+func TestNewTLSConfigRejectsPartialFlags(t *testing.T) {
+	for _, args := range [][4]string{
+		{"", "", "ca.pem", clientAuthRequire},
+		{"cert.pem", "", "", ""},
+		{"", "key.pem", "", ""},
+	} {
+		if _, _, err := newTLSConfig(args[0], args[1], args[2], args[3]); err == nil {
+			t.Errorf("newTLSConfig%q succeeded", args)
+		}
+	}
+}